@@ -0,0 +1,421 @@
+// animation - keyframed animation clips, poses and pose blending
+//
+// A Track animates one thing about one target - a joint or scene node,
+// by name - through a list of keys: its translation, rotation, scale, or
+// a list of plain floats such as morph target weights.  Keys are stepped
+// between, interpolated linearly (rotations by slerp) or by cubic Hermite
+// spline, with tangents stored around each value as glTF does.  An
+// AnimationClip is a set of tracks played together, and sampling it at a
+// time gives a Pose: a Transform per target plus the float channels.
+//
+// Poses blend - crossfading one into another, adding the difference
+// between two poses on top of a third, each limited to the targets in a
+// PoseMask if wanted - and are applied to a Skeleton or anything else
+// that implements PoseTarget.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Transform - translation, rotation and scale, applied scale first
+type Transform struct {
+	Translation Vec3
+	Rotation    Quat
+	Scale       Vec3
+}
+
+// IdentTransform - the transform that does nothing
+func IdentTransform() Transform {
+	return Transform{Rotation: IdentQuat(), Scale: Vec3{1, 1, 1}}
+}
+
+// TransformFromMat4 - splits a matrix with no shear into translation,
+// rotation and scale
+func TransformFromMat4(m *Mat4) Transform {
+	t := Transform{Translation: Vec3{m[3].X, m[3].Y, m[3].Z}}
+	var axes [3]Vec3
+	var scale [3]gl.Float
+	for c := range axes {
+		axes[c] = Vec3{m[c].X, m[c].Y, m[c].Z}
+		if scale[c] = axes[c].Length(); scale[c] != 0 {
+			axes[c] = *axes[c].MulS(1 / scale[c])
+		}
+	}
+	if axes[0].Dot(axes[1].Cross(&axes[2])) < 0 {
+		// Mirrored - put the mirroring in the scale
+		scale[0], axes[0] = -scale[0], *axes[0].MulS(-1)
+	}
+	t.Scale = Vec3{scale[0], scale[1], scale[2]}
+	r := IdentMat4()
+	for c, a := range axes {
+		r[c] = Vec4{a.X, a.Y, a.Z, 0}
+	}
+	t.Rotation = QuatFromMat4(r)
+	return t
+}
+
+// Mat4 - the transform as a matrix
+func (t Transform) Mat4() *Mat4 {
+	m := t.Rotation.Mat4()
+	for c, s := range [3]gl.Float{t.Scale.X, t.Scale.Y, t.Scale.Z} {
+		m[c] = Vec4{m[c].X * s, m[c].Y * s, m[c].Z * s, 0}
+	}
+	m[3] = Vec4{t.Translation.X, t.Translation.Y, t.Translation.Z, 1}
+	return m
+}
+
+func lerpVec3(a, b Vec3, w gl.Float) Vec3 {
+	return Vec3{LerpGL(a.X, b.X, w), LerpGL(a.Y, b.Y, w), LerpGL(a.Z, b.Z, w)}
+}
+
+// Lerp - w of the way from t to o, slerping the rotation
+func (t Transform) Lerp(o Transform, w gl.Float) Transform {
+	return Transform{
+		lerpVec3(t.Translation, o.Translation, w),
+		t.Rotation.Slerp(o.Rotation, w),
+		lerpVec3(t.Scale, o.Scale, w),
+	}
+}
+
+// What to animate
+type TrackPath int
+
+const (
+	TrackTranslation TrackPath = iota
+	TrackRotation              // Quaternions, as X Y Z W
+	TrackScale
+	TrackFloats // Any number of floats, e.g. morph target weights
+)
+
+// How to get from one key to the next
+type Interpolation int
+
+const (
+	InterpLinear Interpolation = iota
+	InterpStep
+	// Cubic Hermite spline.  Each key has an in tangent, the value and an
+	// out tangent.
+	InterpCubicSpline
+)
+
+// Track - keyframes for one path of one target
+type Track struct {
+	Target        string
+	Path          TrackPath
+	Interpolation Interpolation
+	Times         []gl.Float // Seconds, increasing
+	Values        []gl.Float
+	Components    int // Floats in each value
+}
+
+// NewTrack - a track, after checking the keys fit the path
+func NewTrack(target string, path TrackPath, interp Interpolation, times, values []gl.Float) (*Track, error) {
+	if len(times) == 0 {
+		return nil, errors.New(fmt.Sprintf("Track: Track for %s has no keys", target))
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i] < times[i-1] {
+			return nil, errors.New(fmt.Sprintf("Track: Track for %s has key %d at %v before the one before it", target, i, times[i]))
+		}
+	}
+	perKey := 1
+	if interp == InterpCubicSpline {
+		perKey = 3
+	}
+	components := 3
+	switch path {
+	case TrackRotation:
+		components = 4
+	case TrackFloats:
+		components = len(values) / (len(times) * perKey)
+	}
+	if components == 0 || len(values) != len(times)*perKey*components {
+		return nil, errors.New(fmt.Sprintf("Track: Track for %s has %d values for %d keys", target, len(values), len(times)))
+	}
+	return &Track{target, path, interp, times, values, components}, nil
+}
+
+// Duration - time of the last key
+func (tr *Track) Duration() gl.Float {
+	return tr.Times[len(tr.Times)-1]
+}
+
+// Sample - the track's value at time t into out, which needs Components
+// floats.  Times before the first key or after the last are held.
+func (tr *Track) Sample(t gl.Float, out []gl.Float) {
+	n := tr.Components
+	perKey := 1
+	if tr.Interpolation == InterpCubicSpline {
+		perKey = 3
+	}
+	value := func(k int) []gl.Float {
+		start := (k*perKey + perKey/2) * n // the value sits between the tangents
+		return tr.Values[start : start+n]
+	}
+	// The key at or before t
+	k := sort.Search(len(tr.Times), func(i int) bool { return tr.Times[i] > t }) - 1
+	if k < 0 {
+		copy(out, value(0))
+		return
+	}
+	if k >= len(tr.Times)-1 || tr.Interpolation == InterpStep {
+		copy(out, value(k))
+		return
+	}
+	dt := tr.Times[k+1] - tr.Times[k]
+	s := gl.Float(0)
+	if dt > 0 {
+		s = (t - tr.Times[k]) / dt
+	}
+	v0, v1 := value(k), value(k+1)
+	switch tr.Interpolation {
+	case InterpCubicSpline:
+		out0 := tr.Values[(k*3+2)*n : (k*3+3)*n]
+		in1 := tr.Values[(k+1)*3*n : ((k+1)*3+1)*n]
+		s2, s3 := s*s, s*s*s
+		for c := 0; c < n; c++ {
+			out[c] = (2*s3-3*s2+1)*v0[c] + (s3-2*s2+s)*dt*out0[c] + (-2*s3+3*s2)*v1[c] + (s3-s2)*dt*in1[c]
+		}
+		if tr.Path == TrackRotation {
+			q := Quat{out[0], out[1], out[2], out[3]}.Normalize()
+			out[0], out[1], out[2], out[3] = q.X, q.Y, q.Z, q.W
+		}
+	default:
+		if tr.Path == TrackRotation {
+			q := Quat{v0[0], v0[1], v0[2], v0[3]}.Slerp(Quat{v1[0], v1[1], v1[2], v1[3]}, s)
+			out[0], out[1], out[2], out[3] = q.X, q.Y, q.Z, q.W
+			return
+		}
+		for c := 0; c < n; c++ {
+			out[c] = LerpGL(v0[c], v1[c], s)
+		}
+	}
+}
+
+// AnimationClip - tracks played together
+type AnimationClip struct {
+	Name     string
+	Duration gl.Float
+	Tracks   []*Track
+}
+
+// NewAnimationClip - an empty clip
+func NewAnimationClip(name string) *AnimationClip {
+	return &AnimationClip{Name: name}
+}
+
+// AddTrack - adds a track, stretching the clip to fit it
+func (c *AnimationClip) AddTrack(tr *Track) {
+	c.Tracks = append(c.Tracks, tr)
+	if d := tr.Duration(); d > c.Duration {
+		c.Duration = d
+	}
+}
+
+// Sample - the pose at time t, starting from rest (which may be nil) for
+// anything the clip doesn't animate.  If loop is set, times past the end
+// wrap round to the start.
+func (c *AnimationClip) Sample(t gl.Float, loop bool, rest *Pose) *Pose {
+	if loop && c.Duration > 0 {
+		t = gl.Float(math.Mod(float64(t), float64(c.Duration)))
+		if t < 0 {
+			t += c.Duration
+		}
+	}
+	p := rest.Copy()
+	var buf [4]gl.Float
+	for _, tr := range c.Tracks {
+		if tr.Path == TrackFloats {
+			out := make([]gl.Float, tr.Components)
+			tr.Sample(t, out)
+			p.Floats[tr.Target] = out
+			continue
+		}
+		tr.Sample(t, buf[:])
+		x := p.Transform(tr.Target)
+		switch tr.Path {
+		case TrackTranslation:
+			x.Translation = Vec3{buf[0], buf[1], buf[2]}
+		case TrackRotation:
+			x.Rotation = Quat{buf[0], buf[1], buf[2], buf[3]}
+		case TrackScale:
+			x.Scale = Vec3{buf[0], buf[1], buf[2]}
+		}
+		p.Transforms[tr.Target] = x
+	}
+	return p
+}
+
+// Pose - a transform for each target, and float channels
+type Pose struct {
+	Transforms map[string]Transform
+	Floats     map[string][]gl.Float
+}
+
+// NewPose - an empty pose
+func NewPose() *Pose {
+	return &Pose{make(map[string]Transform), make(map[string][]gl.Float)}
+}
+
+// Copy - a copy of the pose; a nil pose copies to an empty one
+func (p *Pose) Copy() *Pose {
+	c := NewPose()
+	if p == nil {
+		return c
+	}
+	for name, t := range p.Transforms {
+		c.Transforms[name] = t
+	}
+	for name, f := range p.Floats {
+		c.Floats[name] = append([]gl.Float(nil), f...)
+	}
+	return c
+}
+
+// Transform - the target's transform, or the identity if the pose doesn't
+// have it
+func (p *Pose) Transform(target string) Transform {
+	if t, ok := p.Transforms[target]; ok {
+		return t
+	}
+	return IdentTransform()
+}
+
+// PoseMask - how much of a blend each target takes, 0 to 1.  Targets not
+// in the mask take none of it; a nil mask means every target takes all.
+type PoseMask map[string]gl.Float
+
+func (m PoseMask) weight(target string, w gl.Float) gl.Float {
+	if m == nil {
+		return w
+	}
+	return m[target] * w
+}
+
+// BlendPoses - w of the way from a to b, for crossfading.  A target only
+// one pose has keeps its value from that pose.
+func BlendPoses(a, b *Pose, w gl.Float, mask PoseMask) *Pose {
+	p := a.Copy()
+	for name, tb := range b.Transforms {
+		ta, ok := a.Transforms[name]
+		if !ok {
+			p.Transforms[name] = tb
+			continue
+		}
+		p.Transforms[name] = ta.Lerp(tb, mask.weight(name, w))
+	}
+	for name, fb := range b.Floats {
+		fa, ok := a.Floats[name]
+		if !ok || len(fa) != len(fb) {
+			p.Floats[name] = append([]gl.Float(nil), fb...)
+			continue
+		}
+		mw := mask.weight(name, w)
+		for i := range fb {
+			p.Floats[name][i] = LerpGL(fa[i], fb[i], mw)
+		}
+	}
+	return p
+}
+
+// AdditivePose - the difference between pose and reference, to add onto
+// other poses with AddPose
+func AdditivePose(pose, reference *Pose) *Pose {
+	d := NewPose()
+	for name, t := range pose.Transforms {
+		r := reference.Transform(name)
+		d.Transforms[name] = Transform{
+			*t.Translation.Sub(&r.Translation),
+			r.Rotation.Conjugate().Mul(t.Rotation),
+			Vec3{divOr1(t.Scale.X, r.Scale.X), divOr1(t.Scale.Y, r.Scale.Y), divOr1(t.Scale.Z, r.Scale.Z)},
+		}
+	}
+	for name, f := range pose.Floats {
+		out := append([]gl.Float(nil), f...)
+		if rf := reference.Floats[name]; len(rf) == len(f) {
+			for i := range out {
+				out[i] -= rf[i]
+			}
+		}
+		d.Floats[name] = out
+	}
+	return d
+}
+
+func divOr1(a, b gl.Float) gl.Float {
+	if b == 0 {
+		return 1
+	}
+	return a / b
+}
+
+// AddPose - base with w of an additive pose (from AdditivePose) on top
+func AddPose(base, additive *Pose, w gl.Float, mask PoseMask) *Pose {
+	p := base.Copy()
+	for name, d := range additive.Transforms {
+		mw := mask.weight(name, w)
+		t := base.Transform(name)
+		t.Translation = *t.Translation.Add(d.Translation.MulS(mw))
+		t.Rotation = t.Rotation.Mul(IdentQuat().Slerp(d.Rotation, mw))
+		s := lerpVec3(Vec3{1, 1, 1}, d.Scale, mw)
+		t.Scale = Vec3{t.Scale.X * s.X, t.Scale.Y * s.Y, t.Scale.Z * s.Z}
+		p.Transforms[name] = t
+	}
+	for name, d := range additive.Floats {
+		mw := mask.weight(name, w)
+		out := p.Floats[name]
+		if len(out) != len(d) {
+			out = make([]gl.Float, len(d))
+		}
+		for i := range d {
+			out[i] += d[i] * mw
+		}
+		p.Floats[name] = out
+	}
+	return p
+}
+
+// PoseTarget - something a pose can be applied to, such as a Skeleton or
+// the nodes of a scene
+type PoseTarget interface {
+	SetTransform(target string, t Transform)
+}
+
+// PoseTargetFunc - a function as a PoseTarget
+type PoseTargetFunc func(target string, t Transform)
+
+func (f PoseTargetFunc) SetTransform(target string, t Transform) {
+	f(target, t)
+}
+
+// Apply - sets each target's transform from the pose
+func (p *Pose) Apply(to PoseTarget) {
+	for name, t := range p.Transforms {
+		to.SetTransform(name, t)
+	}
+}
+
+// SetTransform - sets the pose of the named joint, if there is one
+func (s *Skeleton) SetTransform(joint string, t Transform) {
+	if j := s.JointIndex(joint); j >= 0 {
+		s.SetPose(j, t.Mat4())
+	}
+}
+
+// RestPose - the skeleton's current pose as a Pose, to sample clips over
+func (s *Skeleton) RestPose() *Pose {
+	p := NewPose()
+	for j := range s.Joints {
+		if name := s.Joints[j].Name; name != "" {
+			p.Transforms[name] = TransformFromMat4(&s.Joints[j].Pose)
+		}
+	}
+	return p
+}
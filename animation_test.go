@@ -0,0 +1,190 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func nearly(a, b gl.Float) bool {
+	return math.Abs(float64(a-b)) < 1e-5
+}
+
+func TestTrackInterpolation(t *testing.T) {
+	times := []gl.Float{0, 2}
+	linear, _ := NewTrack("a", TrackTranslation, InterpLinear, times, []gl.Float{0, 0, 0, 2, 4, 0})
+	step, _ := NewTrack("a", TrackTranslation, InterpStep, times, []gl.Float{0, 0, 0, 2, 4, 0})
+	// Flat tangents make a smoothstep
+	cubic, err := NewTrack("a", TrackTranslation, InterpCubicSpline, times, []gl.Float{
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 2, 4, 0, 0, 0, 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]gl.Float, 3)
+	for _, c := range []struct {
+		track *Track
+		t     gl.Float
+		x     gl.Float
+	}{
+		{linear, 1, 1}, {linear, 0.5, 0.5}, {linear, -1, 0}, {linear, 5, 2},
+		{step, 1.9, 0}, {step, 2, 2},
+		{cubic, 1, 1}, {cubic, 0.5, 0.3125}, {cubic, 2, 2},
+	} {
+		c.track.Sample(c.t, out)
+		if !nearly(out[0], c.x) || !nearly(out[1], 2*c.x) {
+			t.Errorf("Interpolation %d at %v gives %v, want x = %v", c.track.Interpolation, c.t, out, c.x)
+		}
+	}
+
+	q0, q1 := IdentQuat(), QuatFromAxisAngle(Vec3{0, 0, 1}, 90)
+	rot, _ := NewTrack("a", TrackRotation, InterpLinear, times, []gl.Float{q0.X, q0.Y, q0.Z, q0.W, q1.X, q1.Y, q1.Z, q1.W})
+	out = make([]gl.Float, 4)
+	rot.Sample(1, out)
+	if q := (Quat{out[0], out[1], out[2], out[3]}); q.Dot(QuatFromAxisAngle(Vec3{0, 0, 1}, 45)) < 0.99999 {
+		t.Errorf("Rotation half way is %v, want 45 degrees", q)
+	}
+
+	if _, err := NewTrack("a", TrackRotation, InterpLinear, times, []gl.Float{0, 0, 0, 1}); err == nil {
+		t.Errorf("NewTrack should fail with too few values")
+	}
+	if _, err := NewTrack("a", TrackScale, InterpLinear, []gl.Float{1, 0}, make([]gl.Float, 6)); err == nil {
+		t.Errorf("NewTrack should fail with keys out of order")
+	}
+	if w, _ := NewTrack("w", TrackFloats, InterpLinear, times, []gl.Float{0, 1, 0, 1, 0, 1}); w == nil || w.Components != 3 {
+		t.Errorf("Float track should have 3 components")
+	}
+}
+
+// Moves target along X from 0 to 2 over 2 seconds
+func slideClip(name, target string) *AnimationClip {
+	c := NewAnimationClip(name)
+	tr, _ := NewTrack(target, TrackTranslation, InterpLinear, []gl.Float{0, 2}, []gl.Float{0, 0, 0, 2, 0, 0})
+	c.AddTrack(tr)
+	return c
+}
+
+func TestClipSample(t *testing.T) {
+	c := slideClip("slide", "hip")
+	w, _ := NewTrack("face", TrackFloats, InterpLinear, []gl.Float{0, 2}, []gl.Float{0, 1, 1, 0})
+	c.AddTrack(w)
+	rest := NewPose()
+	rest.Transforms["hip"] = Transform{Vec3{0, 5, 0}, IdentQuat(), Vec3{2, 2, 2}}
+	rest.Transforms["head"] = Transform{Vec3{0, 7, 0}, IdentQuat(), Vec3{1, 1, 1}}
+
+	p := c.Sample(3, true, rest)
+	hip := p.Transform("hip")
+	if !nearly(hip.Translation.X, 1) || hip.Scale != (Vec3{2, 2, 2}) {
+		t.Errorf("Looped sample has hip at %v", hip)
+	}
+	if p.Transform("head") != rest.Transforms["head"] {
+		t.Errorf("Joint the clip doesn't animate should stay at rest")
+	}
+	if f := p.Floats["face"]; len(f) != 2 || !nearly(f[0], 0.5) || !nearly(f[1], 0.5) {
+		t.Errorf("Float channel is %v", f)
+	}
+	if p := c.Sample(3, false, rest); !nearly(p.Transform("hip").Translation.X, 2) {
+		t.Errorf("Sample past the end of a clip that doesn't loop should hold the last key")
+	}
+}
+
+func TestTransformFromMat4(t *testing.T) {
+	want := Transform{Vec3{1, 2, 3}, QuatFromAxisAngle(Vec3{1, 1, 0}, 60), Vec3{2, 3, -1}}
+	got := TransformFromMat4(want.Mat4())
+	p := Vec3{0.5, -1, 2}
+	if a, b := got.Mat4().MulPoint(&p), want.Mat4().MulPoint(&p); !closeVec3(*a, *b) {
+		t.Errorf("Split transform %v moves points differently from %v", got, want)
+	}
+}
+
+func TestBlendPoses(t *testing.T) {
+	a, b := NewPose(), NewPose()
+	a.Transforms["arm"] = Transform{Vec3{0, 0, 0}, IdentQuat(), Vec3{1, 1, 1}}
+	a.Transforms["leg"] = Transform{Vec3{0, 0, 0}, IdentQuat(), Vec3{1, 1, 1}}
+	b.Transforms["arm"] = Transform{Vec3{4, 0, 0}, QuatFromAxisAngle(Vec3{0, 1, 0}, 90), Vec3{1, 1, 1}}
+	b.Transforms["leg"] = Transform{Vec3{4, 0, 0}, IdentQuat(), Vec3{3, 3, 3}}
+
+	p := BlendPoses(a, b, 0.25, nil)
+	if arm := p.Transform("arm"); !nearly(arm.Translation.X, 1) || arm.Rotation.Dot(QuatFromAxisAngle(Vec3{0, 1, 0}, 22.5)) < 0.99999 {
+		t.Errorf("Quarter blend gives arm %v", arm)
+	}
+	p = BlendPoses(a, b, 1, PoseMask{"arm": 0.5})
+	if !nearly(p.Transform("arm").Translation.X, 2) || p.Transform("leg").Translation.X != 0 {
+		t.Errorf("Masked blend gives arm %v, leg %v", p.Transform("arm"), p.Transform("leg"))
+	}
+
+	// The difference between b and a, added onto b at half weight
+	d := AdditivePose(b, a)
+	p = AddPose(b, d, 0.5, nil)
+	if leg := p.Transform("leg"); !nearly(leg.Translation.X, 6) || !nearly(leg.Scale.X, 6) {
+		t.Errorf("Additive blend gives leg %v", leg)
+	}
+	if arm := p.Transform("arm"); arm.Rotation.Dot(QuatFromAxisAngle(Vec3{0, 1, 0}, 135)) < 0.99999 {
+		t.Errorf("Additive blend gives arm rotation %v", arm.Rotation)
+	}
+}
+
+func TestPoseDrivesSkeleton(t *testing.T) {
+	s, m := armSkeleton(t)
+	p := s.RestPose()
+	p.Transforms["elbow"] = Transform{Vec3{1, 0, 0}, QuatFromAxisAngle(Vec3{0, 0, 1}, 90), Vec3{1, 1, 1}}
+	p.Apply(s)
+	pos := NewMeshAttribute("skinned", make([]gl.Float, 15), 3)
+	if err := s.Skin(m, LinearBlendSkinning, pos, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := pos.vec3(2); !closeVec3(v, Vec3{1, 1, 0}) {
+		t.Errorf("Posed elbow moves vertex to %v, want {1 1 0}", v)
+	}
+
+	// And anything else through a function
+	seen := map[string]Transform{}
+	p.Apply(PoseTargetFunc(func(name string, t Transform) { seen[name] = t }))
+	if len(seen) != 2 {
+		t.Errorf("PoseTargetFunc saw %d targets, want 2", len(seen))
+	}
+}
+
+func TestAnimationStateMachine(t *testing.T) {
+	walking := false
+	sm := NewAnimationStateMachine(nil)
+	sm.AddState("idle", slideClip("idle", "hip"), 1, true)
+	sm.AddState("walk", slideClip("walk", "hip"), 1, true)
+	jump, _ := sm.AddState("jump", slideClip("jump", "hip"), 2, false)
+	sm.AddTransition(AnimationTransition{From: "idle", To: "walk", Duration: 1, Condition: func() bool { return walking }})
+	sm.AddTransition(AnimationTransition{From: "jump", To: "idle"})
+	if err := sm.AddTransition(AnimationTransition{From: "idle", To: "run"}); err == nil {
+		t.Errorf("AddTransition should fail with a state that doesn't exist")
+	}
+
+	sm.Update(0.5)
+	if sm.Current().Name != "idle" {
+		t.Fatalf("Machine should start in the first state, is in %s", sm.Current().Name)
+	}
+	walking = true
+	sm.Update(0.5)
+	if sm.Current().Name != "walk" {
+		t.Fatalf("Condition should move machine to walk, is in %s", sm.Current().Name)
+	}
+	// Half way through the fade, idle has hip at 1.5 and walk at 0.5
+	sm.Update(0.5)
+	if x := sm.Pose().Transform("hip").Translation.X; !nearly(x, 1) {
+		t.Errorf("Crossfade has hip at %v, want 1", x)
+	}
+	sm.Update(0.5)
+	if x := sm.Pose().Transform("hip").Translation.X; !nearly(x, 1) {
+		t.Errorf("Faded in walk has hip at %v, want 1", x)
+	}
+
+	sm.CrossFade("jump", 0)
+	sm.Update(0.5)
+	if x := sm.Pose().Transform("hip").Translation.X; !nearly(x, 1) || sm.Current() != jump {
+		t.Errorf("Jump at double speed has hip at %v after half a second", x)
+	}
+	sm.Update(0.5)
+	if sm.Current().Name != "idle" {
+		t.Errorf("Finished jump should go back to idle, is in %s", sm.Current().Name)
+	}
+}
@@ -0,0 +1,156 @@
+// animationstate - a state machine choosing which clip plays
+//
+// Each state plays a clip.  Transitions move from one state to another
+// when their condition holds - or, with no condition, when a clip that
+// doesn't loop reaches its end - crossfading over the transition's
+// duration.  Update() moves the machine on by a time step and Pose()
+// gives the blended pose to apply.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// AnimationState - a clip and how to play it
+type AnimationState struct {
+	Name  string
+	Clip  *AnimationClip
+	Speed gl.Float // 1 is normal speed
+	Loop  bool
+	Time  gl.Float // How far into the clip it is
+}
+
+// Finished - has a clip that doesn't loop reached its end?
+func (s *AnimationState) Finished() bool {
+	return !s.Loop && s.Time >= s.Clip.Duration
+}
+
+// AnimationTransition - a way from one state to another
+type AnimationTransition struct {
+	From, To string   // From "" is from any state
+	Duration gl.Float // Crossfade time
+	// When to go.  If nil, goes when the From state's clip finishes.
+	Condition func() bool
+}
+
+// AnimationStateMachine - states, the transitions between them and which
+// is playing
+type AnimationStateMachine struct {
+	Rest        *Pose // What targets the clips don't animate are left at
+	states      map[string]*AnimationState
+	transitions []AnimationTransition
+	current     *AnimationState
+	previous    *AnimationState // Fading out, or nil
+	fade        gl.Float        // Time into the crossfade
+	fadeTime    gl.Float        // Length of the crossfade
+}
+
+// NewAnimationStateMachine - a machine with no states, leaving anything
+// not animated at rest (which may be nil)
+func NewAnimationStateMachine(rest *Pose) *AnimationStateMachine {
+	return &AnimationStateMachine{Rest: rest, states: make(map[string]*AnimationState)}
+}
+
+// AddState - adds a state playing clip.  The first state added is where
+// the machine starts.
+func (sm *AnimationStateMachine) AddState(name string, clip *AnimationClip, speed gl.Float, loop bool) (*AnimationState, error) {
+	if _, ok := sm.states[name]; ok {
+		return nil, errors.New(fmt.Sprintf("AnimationStateMachine:AddState: Already have a state called %s", name))
+	}
+	if clip == nil {
+		return nil, errors.New(fmt.Sprintf("AnimationStateMachine:AddState: State %s has no clip", name))
+	}
+	s := &AnimationState{Name: name, Clip: clip, Speed: speed, Loop: loop}
+	sm.states[name] = s
+	if sm.current == nil {
+		sm.current = s
+	}
+	return s, nil
+}
+
+// AddTransition - adds a transition.  Transitions are tried in the order
+// they were added.
+func (sm *AnimationStateMachine) AddTransition(t AnimationTransition) error {
+	if _, ok := sm.states[t.From]; !ok && t.From != "" {
+		return errors.New(fmt.Sprintf("AnimationStateMachine:AddTransition: No state called %s", t.From))
+	}
+	if _, ok := sm.states[t.To]; !ok {
+		return errors.New(fmt.Sprintf("AnimationStateMachine:AddTransition: No state called %s", t.To))
+	}
+	sm.transitions = append(sm.transitions, t)
+	return nil
+}
+
+// State - the named state, or nil
+func (sm *AnimationStateMachine) State(name string) *AnimationState {
+	return sm.states[name]
+}
+
+// Current - the state playing, or nil if there are none
+func (sm *AnimationStateMachine) Current() *AnimationState {
+	return sm.current
+}
+
+// CrossFade - starts the named state from the beginning, fading from the
+// current one over duration (0 to cut straight to it)
+func (sm *AnimationStateMachine) CrossFade(name string, duration gl.Float) error {
+	s, ok := sm.states[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("AnimationStateMachine:CrossFade: No state called %s", name))
+	}
+	if s == sm.current {
+		return nil
+	}
+	sm.previous, sm.current = sm.current, s
+	s.Time, sm.fade, sm.fadeTime = 0, 0, duration
+	if duration <= 0 {
+		sm.previous = nil
+	}
+	return nil
+}
+
+// Update - moves the clips and any crossfade on by dt seconds, then takes
+// the first transition out of the current state that's ready
+func (sm *AnimationStateMachine) Update(dt gl.Float) {
+	if sm.current == nil {
+		return
+	}
+	sm.current.Time += dt * sm.current.Speed
+	if sm.previous != nil {
+		sm.previous.Time += dt * sm.previous.Speed
+		if sm.fade += dt; sm.fade >= sm.fadeTime {
+			sm.previous = nil
+		}
+	}
+	for _, t := range sm.transitions {
+		if t.From != sm.current.Name && (t.From != "" || t.To == sm.current.Name) {
+			continue
+		}
+		ready := sm.current.Finished()
+		if t.Condition != nil {
+			ready = t.Condition()
+		}
+		if ready {
+			sm.CrossFade(t.To, t.Duration)
+			return
+		}
+	}
+}
+
+// Pose - the current pose, crossfaded from the previous state if it's
+// still fading out
+func (sm *AnimationStateMachine) Pose() *Pose {
+	if sm.current == nil {
+		return sm.Rest.Copy()
+	}
+	p := sm.current.Clip.Sample(sm.current.Time, sm.current.Loop, sm.Rest)
+	if sm.previous == nil {
+		return p
+	}
+	from := sm.previous.Clip.Sample(sm.previous.Time, sm.previous.Loop, sm.Rest)
+	return BlendPoses(from, p, sm.fade/sm.fadeTime, nil)
+}
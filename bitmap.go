@@ -100,3 +100,29 @@ func (b *Bitmap) Info() {
 		}
 	}
 }
+
+// Width of the bitmap in pixels
+func (b *Bitmap) Width() int {
+	return int(b.width)
+}
+
+// Height of the bitmap in pixels
+func (b *Bitmap) Height() int {
+	return int(b.height)
+}
+
+// Pixel returns the colour of the pixel at x, y, counting y from the top
+// of the image.  Pixels outside the image, or missing from the data, are
+// black.
+func (b *Bitmap) Pixel(x, y int) (r, g, bl byte) {
+	if x < 0 || y < 0 || x >= int(b.width) || y >= int(b.height) {
+		return 0, 0, 0
+	}
+	// Rows are stored bottom up, each padded out to 4 bytes, as BGR
+	rowSize := (int(b.width)*3 + 3) &^ 3
+	offset := (int(b.height)-1-y)*rowSize + x*3
+	if offset+3 > len(b.data) {
+		return 0, 0, 0
+	}
+	return b.data[offset+2], b.data[offset+1], b.data[offset]
+}
@@ -0,0 +1,565 @@
+// bounds - bounding volumes for culling, framing and physics
+//
+// Axis-aligned boxes (AABB), spheres and oriented boxes (OBB), built from
+// a list of points or from a Mesh's positions.  A Mesh caches its bounds
+// and works them out again only once its position attribute has changed -
+// through SetData, MarkDirty or any of the mesh operations.  Data changed
+// in place without MarkDirty isn't noticed.
+
+package goglutils
+
+import (
+	"math"
+	"math/rand"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// An axis-aligned box.  The empty box has Min greater than Max.
+type AABB struct {
+	Min, Max Vec3
+}
+
+// The empty box, which contains nothing and grows to fit whatever is added
+func EmptyAABB() AABB {
+	inf := gl.Float(math.Inf(1))
+	return AABB{Vec3{inf, inf, inf}, Vec3{-inf, -inf, -inf}}
+}
+
+// The smallest box around the points
+func AABBFromPoints(points []Vec3) AABB {
+	b := EmptyAABB()
+	for _, p := range points {
+		b = b.AddPoint(p)
+	}
+	return b
+}
+
+// Does the box contain nothing at all?
+func (b AABB) IsEmpty() bool {
+	return b.Min.X > b.Max.X || b.Min.Y > b.Max.Y || b.Min.Z > b.Max.Z
+}
+
+// The centre of the box
+func (b AABB) Center() Vec3 {
+	return Vec3{(b.Min.X + b.Max.X) / 2, (b.Min.Y + b.Max.Y) / 2, (b.Min.Z + b.Max.Z) / 2}
+}
+
+// Half the size of the box along each axis
+func (b AABB) HalfExtents() Vec3 {
+	return Vec3{(b.Max.X - b.Min.X) / 2, (b.Max.Y - b.Min.Y) / 2, (b.Max.Z - b.Min.Z) / 2}
+}
+
+// Surface area of the box, 0 if it is empty
+func (b AABB) SurfaceArea() gl.Float {
+	if b.IsEmpty() {
+		return 0
+	}
+	d := b.Max.Sub(&b.Min)
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
+
+// The box grown to take in p
+func (b AABB) AddPoint(p Vec3) AABB {
+	return AABB{
+		Vec3{min32(b.Min.X, p.X), min32(b.Min.Y, p.Y), min32(b.Min.Z, p.Z)},
+		Vec3{max32(b.Max.X, p.X), max32(b.Max.Y, p.Y), max32(b.Max.Z, p.Z)},
+	}
+}
+
+// The smallest box around both boxes
+func (b AABB) Union(o AABB) AABB {
+	return AABB{
+		Vec3{min32(b.Min.X, o.Min.X), min32(b.Min.Y, o.Min.Y), min32(b.Min.Z, o.Min.Z)},
+		Vec3{max32(b.Max.X, o.Max.X), max32(b.Max.Y, o.Max.Y), max32(b.Max.Z, o.Max.Z)},
+	}
+}
+
+// The box where both boxes overlap, empty if they don't
+func (b AABB) Intersect(o AABB) AABB {
+	return AABB{
+		Vec3{max32(b.Min.X, o.Min.X), max32(b.Min.Y, o.Min.Y), max32(b.Min.Z, o.Min.Z)},
+		Vec3{min32(b.Max.X, o.Max.X), min32(b.Max.Y, o.Max.Y), min32(b.Max.Z, o.Max.Z)},
+	}
+}
+
+// The box grown by d on every side
+func (b AABB) Grow(d gl.Float) AABB {
+	return AABB{
+		Vec3{b.Min.X - d, b.Min.Y - d, b.Min.Z - d},
+		Vec3{b.Max.X + d, b.Max.Y + d, b.Max.Z + d},
+	}
+}
+
+// Is p inside the box (or on its surface)?
+func (b AABB) Contains(p Vec3) bool {
+	return p.X >= b.Min.X && p.X <= b.Max.X &&
+		p.Y >= b.Min.Y && p.Y <= b.Max.Y &&
+		p.Z >= b.Min.Z && p.Z <= b.Max.Z
+}
+
+// Is o entirely inside the box?
+func (b AABB) ContainsAABB(o AABB) bool {
+	return b.Contains(o.Min) && b.Contains(o.Max)
+}
+
+// Do the boxes touch?
+func (b AABB) Overlaps(o AABB) bool {
+	return b.Min.X <= o.Max.X && o.Min.X <= b.Max.X &&
+		b.Min.Y <= o.Max.Y && o.Min.Y <= b.Max.Y &&
+		b.Min.Z <= o.Max.Z && o.Min.Z <= b.Max.Z
+}
+
+// The point in the box closest to p
+func (b AABB) ClosestPoint(p Vec3) Vec3 {
+	return Vec3{Clamp(p.X, b.Min.X, b.Max.X), Clamp(p.Y, b.Min.Y, b.Max.Y), Clamp(p.Z, b.Min.Z, b.Max.Z)}
+}
+
+// The box around the transformed box.  Each output axis takes the larger
+// or smaller end of each input axis, so there's no need to transform all
+// eight corners.
+func (b AABB) Transform(m *Mat4) AABB {
+	if b.IsEmpty() {
+		return b
+	}
+	min := [3]gl.Float{m[3].X, m[3].Y, m[3].Z}
+	max := min
+	lo := [3]gl.Float{b.Min.X, b.Min.Y, b.Min.Z}
+	hi := [3]gl.Float{b.Max.X, b.Max.Y, b.Max.Z}
+	for col := 0; col < 3; col++ {
+		c := [3]gl.Float{m[col].X, m[col].Y, m[col].Z}
+		for row := 0; row < 3; row++ {
+			e, f := c[row]*lo[col], c[row]*hi[col]
+			if e > f {
+				e, f = f, e
+			}
+			min[row] += e
+			max[row] += f
+		}
+	}
+	return AABB{Vec3{min[0], min[1], min[2]}, Vec3{max[0], max[1], max[2]}}
+}
+
+// A bounding sphere
+type Sphere struct {
+	Center Vec3
+	Radius gl.Float
+}
+
+// Is p inside the sphere?
+func (s Sphere) Contains(p Vec3) bool {
+	d := p.Sub(&s.Center)
+	return d.Dot(d) <= s.Radius*s.Radius
+}
+
+// The sphere around the transformed sphere.  Non-uniform scales take the
+// largest of the three.
+func (s Sphere) Transform(m *Mat4) Sphere {
+	scale := math.Max(toVec3d(Vec3{m[0].X, m[0].Y, m[0].Z}).length(),
+		math.Max(toVec3d(Vec3{m[1].X, m[1].Y, m[1].Z}).length(), toVec3d(Vec3{m[2].X, m[2].Y, m[2].Z}).length()))
+	return Sphere{*m.MulPoint(&s.Center), s.Radius * gl.Float(scale)}
+}
+
+// RitterSphere - a quick bounding sphere, usually within a few percent of
+// the smallest.  Starts from two far apart points and grows to take in
+// any point left outside.
+func RitterSphere(points []Vec3) Sphere {
+	if len(points) == 0 {
+		return Sphere{}
+	}
+	pts := toVec3ds(points)
+	farthest := func(from vec3d) vec3d {
+		best, far := pts[0], -1.0
+		for _, p := range pts {
+			if d := p.sub(from).length(); d > far {
+				best, far = p, d
+			}
+		}
+		return best
+	}
+	a := farthest(pts[0])
+	b := farthest(a)
+	center := a.add(b).scale(0.5)
+	radius := b.sub(a).length() / 2
+	for _, p := range pts {
+		if d := p.sub(center).length(); d > radius {
+			// Grow just enough to reach p, keeping the far side in place
+			radius = (radius + d) / 2
+			center = p.add(center.sub(p).scale(radius / d))
+		}
+	}
+	return Sphere{center.vec3(), gl.Float(radius)}
+}
+
+// WelzlSphere - the smallest bounding sphere, found with Welzl's
+// algorithm.  Slower than RitterSphere, though still linear on average.
+func WelzlSphere(points []Vec3) Sphere {
+	if len(points) == 0 {
+		return Sphere{}
+	}
+	pts := toVec3ds(points)
+	// Welzl is only quick for points in random order
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(pts), func(i, j int) { pts[i], pts[j] = pts[j], pts[i] })
+
+	s := ball{pts[0], 0}
+	for i := 1; i < len(pts); i++ {
+		if s.contains(pts[i]) {
+			continue
+		}
+		s = ball{pts[i], 0}
+		for j := 0; j < i; j++ {
+			if s.contains(pts[j]) {
+				continue
+			}
+			s = ballThrough(pts[i], pts[j])
+			for k := 0; k < j; k++ {
+				if s.contains(pts[k]) {
+					continue
+				}
+				s = ballThrough(pts[i], pts[j], pts[k])
+				for l := 0; l < k; l++ {
+					if !s.contains(pts[l]) {
+						s = ballThrough(pts[i], pts[j], pts[k], pts[l])
+					}
+				}
+			}
+		}
+	}
+	return Sphere{s.center.vec3(), gl.Float(s.radius)}
+}
+
+// A float64 sphere for WelzlSphere
+type ball struct {
+	center vec3d
+	radius float64
+}
+
+func (b ball) contains(p vec3d) bool {
+	return p.sub(b.center).length() <= b.radius*(1+1e-9)+1e-9
+}
+
+// The smallest sphere with all of 1 to 4 points on its surface.  Points
+// that are collinear or coplanar fall back on the best sphere through
+// fewer of them that still takes them all in.
+func ballThrough(p ...vec3d) ball {
+	a := p[0]
+	switch len(p) {
+	case 1:
+		return ball{a, 0}
+	case 2:
+		return ball{a.add(p[1]).scale(0.5), p[1].sub(a).length() / 2}
+	case 3:
+		ab, ac := p[1].sub(a), p[2].sub(a)
+		n := ab.cross(ac)
+		if d := 2 * n.dot(n); d > 1e-18 {
+			offset := n.cross(ab).scale(ac.dot(ac)).add(ac.cross(n).scale(ab.dot(ab))).scale(1 / d)
+			return ball{a.add(offset), offset.length()}
+		}
+	case 4:
+		ab, ac, ad := p[1].sub(a), p[2].sub(a), p[3].sub(a)
+		if d := 2 * ab.dot(ac.cross(ad)); math.Abs(d) > 1e-18 {
+			offset := ac.cross(ad).scale(ab.dot(ab)).
+				add(ad.cross(ab).scale(ac.dot(ac))).
+				add(ab.cross(ac).scale(ad.dot(ad))).scale(1 / d)
+			return ball{a.add(offset), offset.length()}
+		}
+	}
+	// Degenerate - try every smaller subset
+	best := ball{radius: math.Inf(1)}
+	for skip := range p {
+		var sub []vec3d
+		for i, q := range p {
+			if i != skip {
+				sub = append(sub, q)
+			}
+		}
+		b := ballThrough(sub...)
+		if b.radius < best.radius && b.contains(p[skip]) {
+			best = b
+		}
+	}
+	return best
+}
+
+// An oriented box - a centre, three unit axes and the half size along each
+type OBB struct {
+	Center      Vec3
+	Axes        [3]Vec3
+	HalfExtents Vec3
+}
+
+// PCAOBB - an oriented box lined up with the principal axes of the
+// points.  If an axis-aligned box would be smaller, that is returned
+// instead, as PCA does badly on shapes like cubes.
+func PCAOBB(points []Vec3) OBB {
+	if len(points) == 0 {
+		return OBB{Axes: [3]Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+	}
+	pts := toVec3ds(points)
+	var mean vec3d
+	for _, p := range pts {
+		mean = mean.add(p)
+	}
+	mean = mean.scale(1 / float64(len(pts)))
+	var cov [3][3]float64
+	for _, p := range pts {
+		d := p.sub(mean)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				cov[i][j] += d[i] * d[j]
+			}
+		}
+	}
+	_, axes := symmetricEigen(cov)
+	// Keep the axes right-handed
+	if axes[0].cross(axes[1]).dot(axes[2]) < 0 {
+		axes[2] = axes[2].scale(-1)
+	}
+
+	pca := obbAlong(pts, axes)
+	aligned := obbAlong(pts, [3]vec3d{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}})
+	if aligned.Volume() <= pca.Volume() {
+		return aligned
+	}
+	return pca
+}
+
+// The box around the points along the given axes
+func obbAlong(pts []vec3d, axes [3]vec3d) OBB {
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range pts {
+		for k := 0; k < 3; k++ {
+			d := p.dot(axes[k])
+			lo[k] = math.Min(lo[k], d)
+			hi[k] = math.Max(hi[k], d)
+		}
+	}
+	var o OBB
+	var center vec3d
+	for k := 0; k < 3; k++ {
+		o.Axes[k] = axes[k].vec3()
+		center = center.add(axes[k].scale((lo[k] + hi[k]) / 2))
+	}
+	o.Center = center.vec3()
+	o.HalfExtents = Vec3{gl.Float(hi[0]-lo[0]) / 2, gl.Float(hi[1]-lo[1]) / 2, gl.Float(hi[2]-lo[2]) / 2}
+	return o
+}
+
+// Volume of the box
+func (o OBB) Volume() gl.Float {
+	return 8 * o.HalfExtents.X * o.HalfExtents.Y * o.HalfExtents.Z
+}
+
+// The eight corners of the box
+func (o OBB) Corners() [8]Vec3 {
+	var out [8]Vec3
+	h := [3]gl.Float{o.HalfExtents.X, o.HalfExtents.Y, o.HalfExtents.Z}
+	for i := range out {
+		p := o.Center
+		for k := 0; k < 3; k++ {
+			s := h[k]
+			if i&(1<<uint(k)) == 0 {
+				s = -s
+			}
+			p = *p.Add(o.Axes[k].MulS(s))
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// Is p inside the box?
+func (o OBB) Contains(p Vec3) bool {
+	d := p.Sub(&o.Center)
+	h := [3]gl.Float{o.HalfExtents.X, o.HalfExtents.Y, o.HalfExtents.Z}
+	for k := 0; k < 3; k++ {
+		if e := d.Dot(&o.Axes[k]); e < -h[k] || e > h[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// The transformed box.  Scales end up in the half extents, so the axes
+// stay unit length; a shear leaves the result a little loose.
+func (o OBB) Transform(m *Mat4) OBB {
+	out := OBB{Center: *m.MulPoint(&o.Center)}
+	h := [3]gl.Float{o.HalfExtents.X, o.HalfExtents.Y, o.HalfExtents.Z}
+	for k := 0; k < 3; k++ {
+		a := toVec3d(*m.MulDir(&o.Axes[k]))
+		l := a.length()
+		out.Axes[k] = a.normalize().vec3()
+		h[k] *= gl.Float(l)
+	}
+	out.HalfExtents = Vec3{h[0], h[1], h[2]}
+	return out
+}
+
+// The axis-aligned box around the oriented box
+func (o OBB) AABB() AABB {
+	c := o.Corners()
+	return AABBFromPoints(c[:])
+}
+
+// Eigenvalues and unit eigenvectors of a symmetric 3x3 matrix, by Jacobi
+// rotations.  Largest eigenvalue first.
+func symmetricEigen(a [3][3]float64) ([3]float64, [3]vec3d) {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-30 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+				// Rotate in the p-q plane to zero a[p][q]
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	values := [3]float64{a[0][0], a[1][1], a[2][2]}
+	var vectors [3]vec3d
+	for k := 0; k < 3; k++ {
+		vectors[k] = vec3d{v[0][k], v[1][k], v[2][k]}
+	}
+	// Sort, largest first
+	for i := 0; i < 3; i++ {
+		for j := i + 1; j < 3; j++ {
+			if values[j] > values[i] {
+				values[i], values[j] = values[j], values[i]
+				vectors[i], vectors[j] = vectors[j], vectors[i]
+			}
+		}
+	}
+	return values, vectors
+}
+
+func toVec3ds(points []Vec3) []vec3d {
+	out := make([]vec3d, len(points))
+	for i, p := range points {
+		out[i] = toVec3d(p)
+	}
+	return out
+}
+
+func min32(a, b gl.Float) gl.Float {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+func max32(a, b gl.Float) gl.Float {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// Bounds cached on a Mesh, along with what they were worked out from
+type meshBounds struct {
+	attr    *MeshAttribute
+	version int
+	box     AABB
+	ritter  *Sphere
+	welzl   *Sphere
+	obb     *OBB
+}
+
+// The mesh's cached bounds, starting afresh if the positions have changed
+func (m *Mesh) cachedBounds() *meshBounds {
+	pos := m.positionAttribute()
+	if b := m.bounds; b != nil && b.attr == pos && (pos == nil || b.version == pos.version) {
+		return b
+	}
+	b := &meshBounds{attr: pos, box: EmptyAABB()}
+	if pos != nil {
+		b.version = pos.version
+		for v := 0; v < pos.VertexCount(); v++ {
+			b.box = b.box.AddPoint(pos.vec3(v))
+		}
+	}
+	m.bounds = b
+	return b
+}
+
+// The vertex positions, for the bounding functions
+func (m *Mesh) positions() []Vec3 {
+	pos := m.positionAttribute()
+	if pos == nil {
+		return nil
+	}
+	out := make([]Vec3, pos.VertexCount())
+	for v := range out {
+		out[v] = pos.vec3(v)
+	}
+	return out
+}
+
+// Bounds - the axis-aligned box around the mesh's positions, empty if it
+// has none
+func (m *Mesh) Bounds() AABB {
+	return m.cachedBounds().box
+}
+
+// BoundingSphere - a quick bounding sphere (see RitterSphere)
+func (m *Mesh) BoundingSphere() Sphere {
+	b := m.cachedBounds()
+	if b.ritter == nil {
+		s := RitterSphere(m.positions())
+		b.ritter = &s
+	}
+	return *b.ritter
+}
+
+// MinimalBoundingSphere - the smallest bounding sphere (see WelzlSphere)
+func (m *Mesh) MinimalBoundingSphere() Sphere {
+	b := m.cachedBounds()
+	if b.welzl == nil {
+		s := WelzlSphere(m.positions())
+		b.welzl = &s
+	}
+	return *b.welzl
+}
+
+// OrientedBounds - an oriented box around the mesh (see PCAOBB)
+func (m *Mesh) OrientedBounds() OBB {
+	b := m.cachedBounds()
+	if b.obb == nil {
+		o := PCAOBB(m.positions())
+		b.obb = &o
+	}
+	return *b.obb
+}
+
+// WorldBounds - the axis-aligned box around the mesh once transformed by
+// the model matrix.  Both the box and the oriented box are transformed
+// and the overlap taken, which is tighter than either for rotated
+// meshes.  A nil matrix means the identity.
+func (m *Mesh) WorldBounds(model *Mat4) AABB {
+	box := m.Bounds()
+	if model == nil || box.IsEmpty() {
+		return box
+	}
+	return box.Transform(model).Intersect(m.OrientedBounds().Transform(model).AABB())
+}
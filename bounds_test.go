@@ -0,0 +1,94 @@
+package goglutils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func TestMeshBounds(t *testing.T) {
+	m := NewCubeMesh(2, 1)
+	b := m.Bounds()
+	if b.Min != (Vec3{-1, -1, -1}) || b.Max != (Vec3{1, 1, 1}) {
+		t.Errorf("Cube bounds %v", b)
+	}
+
+	// Moving the positions has to show up in the bounds
+	pos := m.AttributeByDesc(AttribPosition)
+	data := pos.Data()
+	for i := range data {
+		data[i] *= 2
+	}
+	pos.MarkDirty()
+	if b := m.Bounds(); b.Max != (Vec3{2, 2, 2}) {
+		t.Errorf("Bounds %v weren't updated after MarkDirty", b)
+	}
+
+	if b := NewMesh("empty").Bounds(); !b.IsEmpty() {
+		t.Errorf("Mesh with no positions has bounds %v", b)
+	}
+}
+
+func TestBoundingSpheres(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	points := make([]Vec3, 500)
+	for i := range points {
+		// Points in a ball of radius 1, plus the two ends of a diameter
+		for {
+			p := Vec3{gl.Float(r.Float64()*2 - 1), gl.Float(r.Float64()*2 - 1), gl.Float(r.Float64()*2 - 1)}
+			if p.Length() <= 1 {
+				points[i] = p
+				break
+			}
+		}
+	}
+	points[0], points[1] = Vec3{0, 0, 2}, Vec3{0, 0, -2}
+
+	for name, s := range map[string]Sphere{"Ritter": RitterSphere(points), "Welzl": WelzlSphere(points)} {
+		for _, p := range points {
+			if d := p.Sub(&s.Center).Length(); d > s.Radius*1.0001 {
+				t.Errorf("%s sphere %v leaves out %v", name, s, p)
+				break
+			}
+		}
+	}
+	if s := WelzlSphere(points); math.Abs(float64(s.Radius)-2) > 1e-4 {
+		t.Errorf("Smallest sphere has radius %v, want 2", s.Radius)
+	}
+}
+
+func TestOrientedBounds(t *testing.T) {
+	// A long thin box, rotated 45 degrees about z
+	m := NewCubeMesh(1, 1)
+	pos := m.AttributeByDesc(AttribPosition)
+	data := pos.Data()
+	rot := RotateZ(45)
+	for v := 0; v < pos.VertexCount(); v++ {
+		p := Vec3{data[v*3] * 10, data[v*3+1], data[v*3+2]}
+		q := rot.MulPoint(&p)
+		data[v*3], data[v*3+1], data[v*3+2] = q.X, q.Y, q.Z
+	}
+	pos.MarkDirty()
+
+	o := m.OrientedBounds()
+	if v := o.Volume(); math.Abs(float64(v)-10) > 1e-3 {
+		t.Errorf("Oriented box has volume %v, want 10", v)
+	}
+	for v := 0; v < pos.VertexCount(); v++ {
+		p := pos.vec3(v)
+		loose := o
+		loose.HalfExtents = *o.HalfExtents.Add(&Vec3{1e-4, 1e-4, 1e-4})
+		if !loose.Contains(p) {
+			t.Errorf("Oriented box leaves out %v", p)
+		}
+	}
+
+	// Rotating back gives an axis-aligned box again
+	back := RotateZ(-45)
+	w := m.WorldBounds(back)
+	if math.Abs(float64(w.Max.X)-5) > 1e-3 || math.Abs(float64(w.Max.Y)-0.5) > 1e-3 {
+		t.Errorf("World bounds %v, want +-5 x +-0.5", w)
+	}
+}
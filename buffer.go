@@ -2,10 +2,699 @@
 
 Functions to make it easier to buffer data into and out of OpenGL.
 
+A Buffer owns a single GL buffer object along with its target, usage and
+size, and has typed Upload/Update/Map calls for []gl.Float, []gl.Uint,
+raw bytes and packed blocks.  All of its GL calls go through the
+BufferAPI interface, so it can be driven by something other than a live
+context (a fake in tests, for instance).
+
+Uniform and shader storage blocks have to be laid out following the
+std140 or std430 rules, which pad and align things quite differently
+from how Go lays out the same struct.  ComputeBlockLayout works out where
+each member of a Go struct goes, and PackBlock produces the bytes to
+upload.  Structs may contain gl.Float, gl.Int, gl.Uint, bool, Vec2, Vec3,
+Vec4, Mat3, Mat4, nested structs and fixed-size arrays of any of those.
+Members are named after their Go field unless they have a `glsl:"name"`
+tag; `glsl:"-"` skips a field.
+
 */
 
 package goglutils
 
 import (
-//gl "github.com/chsc/gogl/gl33"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	gl43 "github.com/chsc/gogl/gl43"
+	"math"
+	"reflect"
+	"unsafe"
 )
+
+// Target for shader storage buffers, which gl33 doesn't know about
+const ShaderStorageBuffer gl.Enum = gl43.SHADER_STORAGE_BUFFER
+
+// BufferAPI - the GL calls a Buffer needs.  Sizes and offsets are in bytes.
+type BufferAPI interface {
+	GenBuffer() gl.Uint
+	DeleteBuffer(id gl.Uint)
+	BindBuffer(target gl.Enum, id gl.Uint)
+	BindBufferBase(target gl.Enum, index gl.Uint, id gl.Uint)
+	BufferData(target gl.Enum, size int, data unsafe.Pointer, usage gl.Enum)
+	BufferSubData(target gl.Enum, offset, size int, data unsafe.Pointer)
+	MapBufferRange(target gl.Enum, offset, length int, access gl.Bitfield) unsafe.Pointer
+	UnmapBuffer(target gl.Enum) bool
+}
+
+// BufferAPI that talks to the current GL context
+type glBufferAPI struct{}
+
+func (glBufferAPI) GenBuffer() gl.Uint {
+	var id gl.Uint
+	gl.GenBuffers(1, &id)
+	return id
+}
+
+func (glBufferAPI) DeleteBuffer(id gl.Uint) {
+	gl.DeleteBuffers(1, &id)
+}
+
+func (glBufferAPI) BindBuffer(target gl.Enum, id gl.Uint) {
+	gl.BindBuffer(target, id)
+}
+
+func (glBufferAPI) BindBufferBase(target gl.Enum, index gl.Uint, id gl.Uint) {
+	gl.BindBufferBase(target, index, id)
+}
+
+func (glBufferAPI) BufferData(target gl.Enum, size int, data unsafe.Pointer, usage gl.Enum) {
+	gl.BufferData(target, gl.Sizeiptr(size), gl.Pointer(data), usage)
+}
+
+func (glBufferAPI) BufferSubData(target gl.Enum, offset, size int, data unsafe.Pointer) {
+	gl.BufferSubData(target, gl.Intptr(offset), gl.Sizeiptr(size), gl.Pointer(data))
+}
+
+func (glBufferAPI) MapBufferRange(target gl.Enum, offset, length int, access gl.Bitfield) unsafe.Pointer {
+	return unsafe.Pointer(gl.MapBufferRange(target, gl.Intptr(offset), gl.Sizeiptr(length), access))
+}
+
+func (glBufferAPI) UnmapBuffer(target gl.Enum) bool {
+	return gl.UnmapBuffer(target) == gl.TRUE
+}
+
+// ******************************* //
+// *    Buffer - a GL buffer     * //
+// ******************************* //
+
+// Buffer - a GL buffer object that knows its target, usage and size
+type Buffer struct {
+	api    BufferAPI
+	id     gl.Uint
+	target gl.Enum
+	usage  gl.Enum
+	size   int
+	mapped bool
+}
+
+// Creates a new, empty buffer for the given target (gl.ARRAY_BUFFER,
+// gl.ELEMENT_ARRAY_BUFFER, gl.UNIFORM_BUFFER, ShaderStorageBuffer, ...)
+// and usage (gl.STATIC_DRAW, gl.STREAM_DRAW, ...).
+func NewBuffer(target, usage gl.Enum) *Buffer {
+	return NewBufferWithAPI(glBufferAPI{}, target, usage)
+}
+
+// Like NewBuffer, but the buffer makes its GL calls through api.
+func NewBufferWithAPI(api BufferAPI, target, usage gl.Enum) *Buffer {
+	b := new(Buffer)
+	b.api = api
+	b.target = target
+	b.usage = usage
+	b.id = api.GenBuffer()
+	return b
+}
+
+// The buffer's GL name
+func (b *Buffer) Id() gl.Uint {
+	return b.id
+}
+
+// The target the buffer binds to
+func (b *Buffer) Target() gl.Enum {
+	return b.target
+}
+
+// The usage hint the buffer's storage is created with
+func (b *Buffer) Usage() gl.Enum {
+	return b.usage
+}
+
+// Size of the buffer's storage, in bytes
+func (b *Buffer) Size() int {
+	return b.size
+}
+
+// Binds the buffer to its target
+func (b *Buffer) Bind() {
+	b.api.BindBuffer(b.target, b.id)
+}
+
+// Unbinds whatever is bound to the buffer's target
+func (b *Buffer) Unbind() {
+	b.api.BindBuffer(b.target, 0)
+}
+
+// Binds the buffer to an indexed binding point of its target - for uniform
+// and shader storage buffers.
+func (b *Buffer) BindBase(index gl.Uint) {
+	b.api.BindBufferBase(b.target, index, b.id)
+}
+
+// Allocate - (re)creates the buffer's storage with size bytes of undefined
+// contents.
+func (b *Buffer) Allocate(size int) error {
+	return b.upload(size, nil)
+}
+
+func (b *Buffer) upload(size int, data unsafe.Pointer) error {
+	if b.id == 0 {
+		return errors.New("Buffer:Upload: Buffer has been deleted")
+	}
+	if b.mapped {
+		return errors.New("Buffer:Upload: Buffer is mapped")
+	}
+	b.Bind()
+	b.api.BufferData(b.target, size, data, b.usage)
+	b.size = size
+	return nil
+}
+
+func (b *Buffer) update(offset, size int, data unsafe.Pointer) error {
+	if b.id == 0 {
+		return errors.New("Buffer:Update: Buffer has been deleted")
+	}
+	if b.mapped {
+		return errors.New("Buffer:Update: Buffer is mapped")
+	}
+	if offset < 0 || offset+size > b.size {
+		return errors.New(fmt.Sprintf("Buffer:Update: %d bytes at offset %d overruns buffer of %d bytes", size, offset, b.size))
+	}
+	if size == 0 {
+		return nil
+	}
+	b.Bind()
+	b.api.BufferSubData(b.target, offset, size, data)
+	return nil
+}
+
+// Replaces the buffer's storage with a copy of data
+func (b *Buffer) UploadBytes(data []byte) error {
+	if len(data) == 0 {
+		return b.upload(0, nil)
+	}
+	return b.upload(len(data), unsafe.Pointer(&data[0]))
+}
+
+// Replaces the buffer's storage with a copy of data
+func (b *Buffer) UploadFloats(data []gl.Float) error {
+	if len(data) == 0 {
+		return b.upload(0, nil)
+	}
+	return b.upload(len(data)*int(unsafe.Sizeof(data[0])), unsafe.Pointer(&data[0]))
+}
+
+// Replaces the buffer's storage with a copy of data
+func (b *Buffer) UploadUints(data []gl.Uint) error {
+	if len(data) == 0 {
+		return b.upload(0, nil)
+	}
+	return b.upload(len(data)*int(unsafe.Sizeof(data[0])), unsafe.Pointer(&data[0]))
+}
+
+// Replaces the buffer's storage with the struct v, packed following rules
+func (b *Buffer) UploadBlock(rules BlockLayoutRules, v interface{}) error {
+	data, err := PackBlock(rules, v)
+	if err != nil {
+		return err
+	}
+	return b.UploadBytes(data)
+}
+
+// Overwrites part of the buffer, starting offset bytes in.  The buffer
+// isn't resized; writing past its end is an error.
+func (b *Buffer) UpdateBytes(offset int, data []byte) error {
+	if len(data) == 0 {
+		return b.update(offset, 0, nil)
+	}
+	return b.update(offset, len(data), unsafe.Pointer(&data[0]))
+}
+
+// Overwrites part of the buffer, starting offset bytes in.
+func (b *Buffer) UpdateFloats(offset int, data []gl.Float) error {
+	if len(data) == 0 {
+		return b.update(offset, 0, nil)
+	}
+	return b.update(offset, len(data)*int(unsafe.Sizeof(data[0])), unsafe.Pointer(&data[0]))
+}
+
+// Overwrites part of the buffer, starting offset bytes in.
+func (b *Buffer) UpdateUints(offset int, data []gl.Uint) error {
+	if len(data) == 0 {
+		return b.update(offset, 0, nil)
+	}
+	return b.update(offset, len(data)*int(unsafe.Sizeof(data[0])), unsafe.Pointer(&data[0]))
+}
+
+// Overwrites the buffer with the struct v, packed following rules
+func (b *Buffer) UpdateBlock(rules BlockLayoutRules, v interface{}) error {
+	data, err := PackBlock(rules, v)
+	if err != nil {
+		return err
+	}
+	return b.UpdateBytes(0, data)
+}
+
+// Orphan - re-specifies the buffer's storage at the same size, so the
+// driver can hand us fresh memory instead of waiting on draws that still
+// use the old contents.  Call before refilling a streamed buffer each frame.
+func (b *Buffer) Orphan() error {
+	return b.upload(b.size, nil)
+}
+
+// Map - maps length bytes of the buffer, starting at offset, into memory.
+// access is a combination of gl.MAP_READ_BIT, gl.MAP_WRITE_BIT and friends.
+// The slice is only valid until Unmap.
+func (b *Buffer) Map(offset, length int, access gl.Bitfield) ([]byte, error) {
+	if b.id == 0 {
+		return nil, errors.New("Buffer:Map: Buffer has been deleted")
+	}
+	if b.mapped {
+		return nil, errors.New("Buffer:Map: Buffer is already mapped")
+	}
+	if offset < 0 || length <= 0 || offset+length > b.size {
+		return nil, errors.New(fmt.Sprintf("Buffer:Map: Can't map %d bytes at offset %d of buffer of %d bytes", length, offset, b.size))
+	}
+	b.Bind()
+	ptr := b.api.MapBufferRange(b.target, offset, length, access)
+	if ptr == nil {
+		return nil, errors.New("Buffer:Map: Driver could not map buffer")
+	}
+	b.mapped = true
+	return unsafe.Slice((*byte)(ptr), length), nil
+}
+
+// Maps the whole buffer as a []gl.Float
+func (b *Buffer) MapFloats(access gl.Bitfield) ([]gl.Float, error) {
+	data, err := b.Map(0, b.size, access)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*gl.Float)(unsafe.Pointer(&data[0])), len(data)/int(unsafe.Sizeof(gl.Float(0)))), nil
+}
+
+// Maps the whole buffer as a []gl.Uint
+func (b *Buffer) MapUints(access gl.Bitfield) ([]gl.Uint, error) {
+	data, err := b.Map(0, b.size, access)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*gl.Uint)(unsafe.Pointer(&data[0])), len(data)/int(unsafe.Sizeof(gl.Uint(0)))), nil
+}
+
+// Unmap - unmaps the buffer.  Returns an error if the driver reports the
+// contents were lost while mapped, in which case they need uploading again.
+func (b *Buffer) Unmap() error {
+	if !b.mapped {
+		return errors.New("Buffer:Unmap: Buffer is not mapped")
+	}
+	b.Bind()
+	b.mapped = false
+	if !b.api.UnmapBuffer(b.target) {
+		return errors.New("Buffer:Unmap: Buffer contents were lost while mapped")
+	}
+	return nil
+}
+
+// Deletes the GL buffer.  The Buffer can't be used afterwards.
+func (b *Buffer) Delete() {
+	if b.id != 0 {
+		b.api.DeleteBuffer(b.id)
+		b.id = 0
+		b.size = 0
+		b.mapped = false
+	}
+}
+
+// ******************************* //
+// *     std140/std430 packing   * //
+// ******************************* //
+
+// The block layout rules to pack with
+type BlockLayoutRules int
+
+const (
+	Std140 BlockLayoutRules = iota
+	Std430
+)
+
+// LayoutField - where a single member of a block ends up.  Names follow
+// GL's reflection names, e.g. "color", "light.position", "weights[0]",
+// "lights[2].color".  Arrays of non-struct types get a single entry for
+// element 0 with ArrayStride set, as GL reports them.
+type LayoutField struct {
+	Name         string
+	Offset       int
+	Size         int
+	ArrayStride  int
+	MatrixStride int
+}
+
+// BlockLayout - the packed layout of a Go struct
+type BlockLayout struct {
+	Rules  BlockLayoutRules
+	Size   int
+	Fields []LayoutField
+}
+
+var (
+	vec2Type = reflect.TypeOf(Vec2{})
+	vec3Type = reflect.TypeOf(Vec3{})
+	vec4Type = reflect.TypeOf(Vec4{})
+	mat3Type = reflect.TypeOf(Mat3{})
+	mat4Type = reflect.TypeOf(Mat4{})
+)
+
+func roundUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// Base alignment and size of a type under the given rules
+func blockTypeLayout(rules BlockLayoutRules, t reflect.Type) (int, int, error) {
+	switch t {
+	case vec2Type:
+		return 8, 8, nil
+	case vec3Type:
+		return 16, 12, nil
+	case vec4Type:
+		return 16, 16, nil
+	case mat3Type:
+		// Three vec3 columns, each padded out to a vec4
+		return 16, 48, nil
+	case mat4Type:
+		return 16, 64, nil
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Int32, reflect.Uint32, reflect.Bool:
+		return 4, 4, nil
+	case reflect.Array:
+		align, stride, err := blockArrayLayout(rules, t)
+		return align, stride * t.Len(), err
+	case reflect.Struct:
+		align, offset := 0, 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get("glsl") == "-" {
+				continue
+			}
+			fAlign, fSize, err := blockTypeLayout(rules, f.Type)
+			if err != nil {
+				return 0, 0, err
+			}
+			offset = roundUp(offset, fAlign) + fSize
+			if fAlign > align {
+				align = fAlign
+			}
+		}
+		if align == 0 {
+			return 0, 0, errors.New(fmt.Sprintf("Buffer:Layout: Struct %s has no members", t))
+		}
+		if rules == Std140 {
+			align = roundUp(align, 16)
+		}
+		return align, roundUp(offset, align), nil
+	}
+	return 0, 0, errors.New(fmt.Sprintf("Buffer:Layout: Can't lay out type %s", t))
+}
+
+// Alignment and element stride of an array type
+func blockArrayLayout(rules BlockLayoutRules, t reflect.Type) (int, int, error) {
+	align, size, err := blockTypeLayout(rules, t.Elem())
+	if err != nil {
+		return 0, 0, err
+	}
+	if rules == Std140 {
+		align = roundUp(align, 16)
+	}
+	return align, roundUp(size, align), nil
+}
+
+// Walks a type, recording field positions and, if buf isn't nil, writing
+// the value v into it.
+type blockPacker struct {
+	rules  BlockLayoutRules
+	fields []LayoutField
+	buf    []byte
+}
+
+func (p *blockPacker) putFloat(offset int, f gl.Float) {
+	binary.LittleEndian.PutUint32(p.buf[offset:], math.Float32bits(float32(f)))
+}
+
+func (p *blockPacker) walkStruct(t reflect.Type, v reflect.Value, offset int, prefix string) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("glsl")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		align, _, err := blockTypeLayout(p.rules, f.Type)
+		if err != nil {
+			return err
+		}
+		offset = roundUp(offset, align)
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+		if err := p.walk(f.Type, fv, offset, prefix+name); err != nil {
+			return err
+		}
+		_, size, _ := blockTypeLayout(p.rules, f.Type)
+		offset += size
+	}
+	return nil
+}
+
+func (p *blockPacker) walk(t reflect.Type, v reflect.Value, offset int, name string) error {
+	_, size, err := blockTypeLayout(p.rules, t)
+	if err != nil {
+		return err
+	}
+	switch {
+	case isBlockBasicType(t):
+		// Vectors and matrices are leaves, handled below
+
+	case t.Kind() == reflect.Struct:
+		return p.walkStruct(t, v, offset, name+".")
+
+	case t.Kind() == reflect.Array:
+		_, stride, _ := blockArrayLayout(p.rules, t)
+		if t.Elem().Kind() == reflect.Struct && !isBlockBasicType(t.Elem()) {
+			for i := 0; i < t.Len(); i++ {
+				var ev reflect.Value
+				if v.IsValid() {
+					ev = v.Index(i)
+				}
+				if err := p.walkStruct(t.Elem(), ev, offset+i*stride, fmt.Sprintf("%s[%d].", name, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Array && !isBlockBasicType(t.Elem()) {
+			return errors.New(fmt.Sprintf("Buffer:Layout: Arrays of arrays aren't supported (%s)", name))
+		}
+		_, elemSize, _ := blockTypeLayout(p.rules, t.Elem())
+		p.fields = append(p.fields, LayoutField{
+			Name:         name + "[0]",
+			Offset:       offset,
+			Size:         elemSize,
+			ArrayStride:  stride,
+			MatrixStride: blockMatrixStride(t.Elem()),
+		})
+		if v.IsValid() {
+			for i := 0; i < t.Len(); i++ {
+				p.write(v.Index(i), offset+i*stride)
+			}
+		}
+		return nil
+	}
+
+	p.fields = append(p.fields, LayoutField{
+		Name:         name,
+		Offset:       offset,
+		Size:         size,
+		MatrixStride: blockMatrixStride(t),
+	})
+	if v.IsValid() {
+		p.write(v, offset)
+	}
+	return nil
+}
+
+func isBlockBasicType(t reflect.Type) bool {
+	return t == vec2Type || t == vec3Type || t == vec4Type || t == mat3Type || t == mat4Type
+}
+
+func blockMatrixStride(t reflect.Type) int {
+	if t == mat3Type || t == mat4Type {
+		return 16
+	}
+	return 0
+}
+
+// Writes a single scalar, vector or matrix
+func (p *blockPacker) write(v reflect.Value, offset int) {
+	if p.buf == nil {
+		return
+	}
+	switch v.Type() {
+	case vec2Type, vec3Type, vec4Type:
+		for i := 0; i < v.NumField(); i++ {
+			p.putFloat(offset+i*4, gl.Float(v.Field(i).Float()))
+		}
+		return
+	case mat3Type, mat4Type:
+		for c := 0; c < v.Len(); c++ {
+			col := v.Index(c)
+			for i := 0; i < col.NumField(); i++ {
+				p.putFloat(offset+c*16+i*4, gl.Float(col.Field(i).Float()))
+			}
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Float32:
+		p.putFloat(offset, gl.Float(v.Float()))
+	case reflect.Int32:
+		binary.LittleEndian.PutUint32(p.buf[offset:], uint32(int32(v.Int())))
+	case reflect.Uint32:
+		binary.LittleEndian.PutUint32(p.buf[offset:], uint32(v.Uint()))
+	case reflect.Bool:
+		var b uint32
+		if v.Bool() {
+			b = 1
+		}
+		binary.LittleEndian.PutUint32(p.buf[offset:], b)
+	}
+}
+
+func blockStructType(v interface{}) (reflect.Type, reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || isBlockBasicType(rv.Type()) {
+		return nil, rv, errors.New(fmt.Sprintf("Buffer:Layout: Need a struct, got %T", v))
+	}
+	return rv.Type(), rv, nil
+}
+
+// ComputeBlockLayout - works out the offset of every member of the struct
+// v (or pointer to one) under the given rules.
+func ComputeBlockLayout(rules BlockLayoutRules, v interface{}) (*BlockLayout, error) {
+	t, _, err := blockStructType(v)
+	if err != nil {
+		return nil, err
+	}
+	_, size, err := blockTypeLayout(rules, t)
+	if err != nil {
+		return nil, err
+	}
+	p := &blockPacker{rules: rules}
+	if err := p.walkStruct(t, reflect.Value{}, 0, ""); err != nil {
+		return nil, err
+	}
+	return &BlockLayout{Rules: rules, Size: size, Fields: p.fields}, nil
+}
+
+// PackBlock - packs the struct v (or pointer to one) into a byte slice laid
+// out following the given rules, ready to hand to glBufferData.  Padding
+// bytes are zero.
+func PackBlock(rules BlockLayoutRules, v interface{}) ([]byte, error) {
+	t, rv, err := blockStructType(v)
+	if err != nil {
+		return nil, err
+	}
+	_, size, err := blockTypeLayout(rules, t)
+	if err != nil {
+		return nil, err
+	}
+	p := &blockPacker{rules: rules, buf: make([]byte, size)}
+	if err := p.walkStruct(t, rv, 0, ""); err != nil {
+		return nil, err
+	}
+	return p.buf, nil
+}
+
+// Field - look up a member of the layout by its reflection name
+func (b *BlockLayout) Field(name string) (LayoutField, bool) {
+	for _, f := range b.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return LayoutField{}, false
+}
+
+// Validate - compares the layout against offsets reported by the driver,
+// keyed by member name.  Members the driver doesn't report (it is free to
+// optimise unused ones away) are ignored.
+func (b *BlockLayout) Validate(offsets map[string]int) error {
+	for _, f := range b.Fields {
+		offset, ok := offsets[f.Name]
+		if ok && offset != f.Offset {
+			return errors.New(fmt.Sprintf("Buffer:Validate: %s is at offset %d, driver says %d", f.Name, f.Offset, offset))
+		}
+	}
+	return nil
+}
+
+// UniformBlockOffsets - asks the driver where the members of layout ended
+// up in the named uniform block of program.  Members are looked up both
+// bare and prefixed with the block name, to cover blocks declared with an
+// instance name.  Members the driver doesn't know are left out.  Also
+// returns the block's data size.
+func UniformBlockOffsets(program gl.Uint, blockName string, layout *BlockLayout) (map[string]int, int, error) {
+	glName := gl.GLString(blockName)
+	defer gl.GLStringFree(glName)
+	blockIndex := gl.GetUniformBlockIndex(program, glName)
+	if blockIndex == gl.INVALID_INDEX {
+		return nil, 0, errors.New(fmt.Sprintf("Buffer:UniformBlockOffsets: No uniform block %s", blockName))
+	}
+	var dataSize gl.Int
+	gl.GetActiveUniformBlockiv(program, blockIndex, gl.UNIFORM_BLOCK_DATA_SIZE, &dataSize)
+
+	offsets := make(map[string]int)
+	for _, prefix := range []string{"", blockName + "."} {
+		names := make([]string, len(layout.Fields))
+		for i, f := range layout.Fields {
+			names[i] = prefix + f.Name
+		}
+		glNames := gl.GLStringArray(names...)
+		indices := make([]gl.Uint, len(names))
+		gl.GetUniformIndices(program, gl.Sizei(len(names)), &glNames[0], &indices[0])
+		gl.GLStringArrayFree(glNames)
+
+		for i, index := range indices {
+			if index == gl.INVALID_INDEX {
+				continue
+			}
+			var offset gl.Int
+			gl.GetActiveUniformsiv(program, 1, &index, gl.UNIFORM_OFFSET, &offset)
+			offsets[layout.Fields[i].Name] = int(offset)
+		}
+	}
+	return offsets, int(dataSize), nil
+}
+
+// ValidateUniformBlock - checks the layout of v against what the driver
+// reports for the named uniform block of program.
+func ValidateUniformBlock(program gl.Uint, blockName string, v interface{}) error {
+	layout, err := ComputeBlockLayout(Std140, v)
+	if err != nil {
+		return err
+	}
+	offsets, dataSize, err := UniformBlockOffsets(program, blockName, layout)
+	if err != nil {
+		return err
+	}
+	if dataSize < layout.Size {
+		return errors.New(fmt.Sprintf("Buffer:ValidateUniformBlock: Block %s is %d bytes, driver says %d", blockName, layout.Size, dataSize))
+	}
+	return layout.Validate(offsets)
+}
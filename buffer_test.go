@@ -0,0 +1,291 @@
+package goglutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"unsafe"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// The example block from the GL spec's std140 discussion
+type testBlock struct {
+	A gl.Float    `glsl:"a"`
+	B Vec2        `glsl:"b"`
+	C Vec3        `glsl:"c"`
+	D gl.Float    `glsl:"d"`
+	E [2]Vec4     `glsl:"e"`
+	F Mat3        `glsl:"f"`
+	G [3]gl.Float `glsl:"g"`
+}
+
+type testLight struct {
+	Position Vec3     `glsl:"position"`
+	Radius   gl.Float `glsl:"radius"`
+	Color    Vec3     `glsl:"color"`
+}
+
+type testLights struct {
+	Count   gl.Int       `glsl:"count"`
+	Lights  [2]testLight `glsl:"lights"`
+	Ambient Vec3         `glsl:"ambient"`
+	Skip    gl.Float     `glsl:"-"`
+	Enabled bool         `glsl:"enabled"`
+}
+
+func checkLayout(t *testing.T, rules BlockLayoutRules, v interface{}, size int, want map[string]int) {
+	layout, err := ComputeBlockLayout(rules, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Size != size {
+		t.Errorf("Block size is %d, want %d", layout.Size, size)
+	}
+	if len(layout.Fields) != len(want) {
+		t.Errorf("Layout has %d fields, want %d", len(layout.Fields), len(want))
+	}
+	for name, offset := range want {
+		f, ok := layout.Field(name)
+		if !ok {
+			t.Errorf("Layout has no field %s", name)
+		} else if f.Offset != offset {
+			t.Errorf("%s is at offset %d, want %d", name, f.Offset, offset)
+		}
+	}
+	if err := layout.Validate(want); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStd140Layout(t *testing.T) {
+	checkLayout(t, Std140, testBlock{}, 160, map[string]int{
+		"a": 0, "b": 8, "c": 16, "d": 28, "e[0]": 32, "f": 64, "g[0]": 112,
+	})
+	checkLayout(t, Std140, &testLights{}, 96, map[string]int{
+		"count":              0,
+		"lights[0].position": 16, "lights[0].radius": 28, "lights[0].color": 32,
+		"lights[1].position": 48, "lights[1].radius": 60, "lights[1].color": 64,
+		"ambient": 80, "enabled": 92,
+	})
+}
+
+func TestStd430Layout(t *testing.T) {
+	checkLayout(t, Std430, testBlock{}, 128, map[string]int{
+		"a": 0, "b": 8, "c": 16, "d": 28, "e[0]": 32, "f": 64, "g[0]": 112,
+	})
+	layout, _ := ComputeBlockLayout(Std430, testBlock{})
+	if g, _ := layout.Field("g[0]"); g.ArrayStride != 4 {
+		t.Errorf("std430 float array stride is %d, want 4", g.ArrayStride)
+	}
+	layout, _ = ComputeBlockLayout(Std140, testBlock{})
+	if g, _ := layout.Field("g[0]"); g.ArrayStride != 16 {
+		t.Errorf("std140 float array stride is %d, want 16", g.ArrayStride)
+	}
+}
+
+func TestPackBlock(t *testing.T) {
+	block := testBlock{A: 1, B: Vec2{2, 3}, C: Vec3{4, 5, 6}, D: 7}
+	block.F = *IdentMat3()
+	block.G = [3]gl.Float{8, 9, 10}
+	data, err := PackBlock(Std140, &block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	float := func(offset int) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+	}
+	want := map[int]float32{0: 1, 4: 0, 8: 2, 12: 3, 16: 4, 20: 5, 24: 6, 28: 7,
+		64: 1, 68: 0, 76: 0, 84: 1, 104: 1, 112: 8, 128: 9, 144: 10}
+	for offset, value := range want {
+		if got := float(offset); got != value {
+			t.Errorf("Float at %d is %v, want %v", offset, got, value)
+		}
+	}
+
+	// std430 packs the same values tighter
+	data, _ = PackBlock(Std430, &block)
+	if len(data) != 128 {
+		t.Errorf("std430 block is %d bytes, want 128", len(data))
+	}
+	g := make([]byte, 12)
+	binary.LittleEndian.PutUint32(g[0:], math.Float32bits(8))
+	binary.LittleEndian.PutUint32(g[4:], math.Float32bits(9))
+	binary.LittleEndian.PutUint32(g[8:], math.Float32bits(10))
+	if !bytes.Equal(data[112:124], g) {
+		t.Errorf("std430 float array packs to % x, want % x", data[112:124], g)
+	}
+}
+
+func TestBlockLayoutValidate(t *testing.T) {
+	layout, _ := ComputeBlockLayout(Std140, testBlock{})
+	if err := layout.Validate(map[string]int{"c": 16, "unknown": 4}); err != nil {
+		t.Errorf("Validate failed on matching offsets: %v", err)
+	}
+	if err := layout.Validate(map[string]int{"d": 32}); err == nil {
+		t.Errorf("Validate should fail on a mismatched offset")
+	}
+}
+
+func TestPackBlockRejectsBadTypes(t *testing.T) {
+	if _, err := PackBlock(Std140, struct{ X float64 }{1}); err == nil {
+		t.Errorf("float64 members should be rejected")
+	}
+	if _, err := PackBlock(Std140, Vec4{}); err == nil {
+		t.Errorf("A bare vector isn't a block")
+	}
+}
+
+// BufferAPI that keeps buffer contents in Go memory
+type fakeBufferAPI struct {
+	next    gl.Uint
+	bound   map[gl.Enum]gl.Uint
+	data    map[gl.Uint][]byte
+	usage   map[gl.Uint]gl.Enum
+	mapped  map[gl.Uint]bool
+	uploads int
+}
+
+func newFakeBufferAPI() *fakeBufferAPI {
+	return &fakeBufferAPI{
+		bound:  make(map[gl.Enum]gl.Uint),
+		data:   make(map[gl.Uint][]byte),
+		usage:  make(map[gl.Uint]gl.Enum),
+		mapped: make(map[gl.Uint]bool),
+	}
+}
+
+func (f *fakeBufferAPI) GenBuffer() gl.Uint {
+	f.next++
+	f.data[f.next] = nil
+	return f.next
+}
+
+func (f *fakeBufferAPI) DeleteBuffer(id gl.Uint) {
+	delete(f.data, id)
+}
+
+func (f *fakeBufferAPI) BindBuffer(target gl.Enum, id gl.Uint) {
+	f.bound[target] = id
+}
+
+func (f *fakeBufferAPI) BindBufferBase(target gl.Enum, index gl.Uint, id gl.Uint) {
+	f.bound[target] = id
+}
+
+func (f *fakeBufferAPI) BufferData(target gl.Enum, size int, data unsafe.Pointer, usage gl.Enum) {
+	id := f.bound[target]
+	f.data[id] = make([]byte, size)
+	if data != nil {
+		copy(f.data[id], unsafe.Slice((*byte)(data), size))
+	}
+	f.usage[id] = usage
+	f.uploads++
+}
+
+func (f *fakeBufferAPI) BufferSubData(target gl.Enum, offset, size int, data unsafe.Pointer) {
+	copy(f.data[f.bound[target]][offset:], unsafe.Slice((*byte)(data), size))
+}
+
+func (f *fakeBufferAPI) MapBufferRange(target gl.Enum, offset, length int, access gl.Bitfield) unsafe.Pointer {
+	id := f.bound[target]
+	f.mapped[id] = true
+	return unsafe.Pointer(&f.data[id][offset])
+}
+
+func (f *fakeBufferAPI) UnmapBuffer(target gl.Enum) bool {
+	id := f.bound[target]
+	if !f.mapped[id] {
+		return false
+	}
+	f.mapped[id] = false
+	return true
+}
+
+func TestBufferUploadUpdate(t *testing.T) {
+	api := newFakeBufferAPI()
+	b := NewBufferWithAPI(api, gl.ARRAY_BUFFER, gl.STATIC_DRAW)
+	if err := b.UploadFloats([]gl.Float{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 16 || len(api.data[b.Id()]) != 16 || api.usage[b.Id()] != gl.STATIC_DRAW {
+		t.Errorf("Upload of 4 floats gives size %d", b.Size())
+	}
+	if err := b.UpdateFloats(8, []gl.Float{9}); err != nil {
+		t.Fatal(err)
+	}
+	if got := math.Float32frombits(binary.LittleEndian.Uint32(api.data[b.Id()][8:])); got != 9 {
+		t.Errorf("Updated float is %v, want 9", got)
+	}
+	if err := b.UpdateFloats(12, []gl.Float{1, 2}); err == nil {
+		t.Errorf("Update past the end of the buffer should fail")
+	}
+
+	e := NewBufferWithAPI(api, gl.ELEMENT_ARRAY_BUFFER, gl.STATIC_DRAW)
+	e.UploadUints([]gl.Uint{0, 1, 2})
+	if binary.LittleEndian.Uint32(api.data[e.Id()][8:]) != 2 {
+		t.Errorf("Uint upload went astray")
+	}
+
+	u := NewBufferWithAPI(api, gl.UNIFORM_BUFFER, gl.DYNAMIC_DRAW)
+	if err := u.UploadBlock(Std140, &testBlock{D: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if u.Size() != 160 {
+		t.Errorf("Block upload gives size %d, want 160", u.Size())
+	}
+}
+
+func TestBufferMap(t *testing.T) {
+	api := newFakeBufferAPI()
+	b := NewBufferWithAPI(api, ShaderStorageBuffer, gl.DYNAMIC_DRAW)
+	b.UploadUints([]gl.Uint{1, 2, 3})
+	data, err := b.MapUints(gl.MAP_READ_BIT | gl.MAP_WRITE_BIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3 || data[2] != 3 {
+		t.Errorf("Mapped %v, want [1 2 3]", data)
+	}
+	data[0] = 7
+	if err := b.UploadUints([]gl.Uint{1}); err == nil {
+		t.Errorf("Upload to a mapped buffer should fail")
+	}
+	if _, err := b.Map(0, 4, gl.MAP_READ_BIT); err == nil {
+		t.Errorf("Mapping twice should fail")
+	}
+	if err := b.Unmap(); err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(api.data[b.Id()]) != 7 {
+		t.Errorf("Write through the mapping was lost")
+	}
+	if err := b.Unmap(); err == nil {
+		t.Errorf("Unmapping an unmapped buffer should fail")
+	}
+	if _, err := b.Map(8, 8, gl.MAP_READ_BIT); err == nil {
+		t.Errorf("Mapping past the end should fail")
+	}
+}
+
+func TestBufferOrphanDelete(t *testing.T) {
+	api := newFakeBufferAPI()
+	b := NewBufferWithAPI(api, gl.ARRAY_BUFFER, gl.STREAM_DRAW)
+	b.Allocate(64)
+	uploads := api.uploads
+	if err := b.Orphan(); err != nil {
+		t.Fatal(err)
+	}
+	if api.uploads != uploads+1 || b.Size() != 64 || len(api.data[b.Id()]) != 64 {
+		t.Errorf("Orphan should re-specify storage at the same size")
+	}
+	id := b.Id()
+	b.Delete()
+	if _, ok := api.data[id]; ok || b.Id() != 0 {
+		t.Errorf("Delete should free the GL buffer")
+	}
+	if err := b.UploadFloats([]gl.Float{1}); err == nil {
+		t.Errorf("Upload to a deleted buffer should fail")
+	}
+}
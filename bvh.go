@@ -0,0 +1,727 @@
+// bvh - a bounding volume hierarchy over a mesh's triangles
+//
+// The tree is built top down, splitting each node where the surface area
+// heuristic (SAH) says rays will do the least work, with the triangles'
+// centres sorted into a fixed number of bins along the longest axis
+// rather than trying every split.  Nodes sit in one slice with both
+// children of a node next to each other, and a parent always before its
+// children, so Refit can walk the slice backwards to update the boxes of
+// a deforming mesh without rebuilding.
+//
+// The tree keeps its own copy of the positions; MarshalBinary and
+// UnmarshalBinary let tools build it ahead of time.
+
+package goglutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+const (
+	bvhBins        = 12 // SAH bins per split
+	bvhLeafSize    = 4  // Triangles a leaf may hold before splitting is considered
+	bvhMaxLeafSize = 16 // Triangles a leaf may hold even when splitting doesn't pay
+)
+
+// A ray, from Origin along Dir.  Dir needn't be unit length; distances
+// along the ray are in multiples of it.
+type Ray struct {
+	Origin, Dir Vec3
+}
+
+// The point t along the ray
+func (r Ray) At(t gl.Float) Vec3 {
+	return *r.Origin.Add(r.Dir.MulS(t))
+}
+
+// IntersectRay - where the ray enters the box, if it does so before maxT.
+// A ray starting inside enters at 0.
+func (b AABB) IntersectRay(r Ray, maxT gl.Float) (gl.Float, bool) {
+	inv := Vec3{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	return b.intersectRay(r.Origin, inv, maxT)
+}
+
+// Slab test with the ray's direction already inverted
+func (b AABB) intersectRay(origin, inv Vec3, maxT gl.Float) (gl.Float, bool) {
+	t0, _, ok := b.rayRange(origin, inv, maxT)
+	return t0, ok
+}
+
+// Where the ray enters and leaves the box, clipped to 0 to maxT
+func (b AABB) rayRange(origin, inv Vec3, maxT gl.Float) (gl.Float, gl.Float, bool) {
+	tmin, tmax := gl.Float(0), maxT
+	o := [3]gl.Float{origin.X, origin.Y, origin.Z}
+	d := [3]gl.Float{inv.X, inv.Y, inv.Z}
+	lo := [3]gl.Float{b.Min.X, b.Min.Y, b.Min.Z}
+	hi := [3]gl.Float{b.Max.X, b.Max.Y, b.Max.Z}
+	for k := 0; k < 3; k++ {
+		t0, t1 := (lo[k]-o[k])*d[k], (hi[k]-o[k])*d[k]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		// NaN from 0 * inf, a ray in the plane of a face, never narrows
+		if t0 > tmin {
+			tmin = t0
+		}
+		if t1 < tmax {
+			tmax = t1
+		}
+		if tmin > tmax {
+			return 0, 0, false
+		}
+	}
+	return tmin, tmax, true
+}
+
+// A ray hit on a triangle of a BVH
+type RayHit struct {
+	Triangle int      // Triangle number, in the order of the index's triangles
+	T        gl.Float // Distance along the ray
+	U, V     gl.Float // Barycentric coordinates of the hit on the second and third corners
+	Point    Vec3
+}
+
+// The point of a BVH closest to some other point
+type ClosestHit struct {
+	Triangle int
+	Point    Vec3
+	Distance gl.Float
+}
+
+type bvhNode struct {
+	box   AABB
+	first int // First child if count is 0, else the first of the node's triangles
+	count int // Triangles in a leaf, 0 for an inner node
+}
+
+// BVH - a bounding volume hierarchy over a mesh's triangles
+type BVH struct {
+	nodes  []bvhNode
+	order  []int        // Triangle numbers, in leaf order
+	tris   [][3]gl.Uint // Triangles' vertices
+	points []Vec3       // Vertex positions
+}
+
+// NewBVH - builds a BVH over the triangles of the given index
+func NewBVH(m *Mesh, index int) (*BVH, error) {
+	if index < 0 || index >= len(m.indices) {
+		return nil, errors.New(fmt.Sprintf("BVH:NewBVH: Mesh %s has no index %d", m.name, index))
+	}
+	tris, err := m.indices[index].triangleList("NewBVH")
+	if err != nil {
+		return nil, err
+	}
+	points := m.positions()
+	for _, t := range tris {
+		for _, v := range t {
+			if int(v) >= len(points) {
+				return nil, errors.New(fmt.Sprintf("BVH:NewBVH: Triangle uses vertex %d of %d", v, len(points)))
+			}
+		}
+	}
+	b := &BVH{tris: tris, points: points, order: make([]int, len(tris))}
+	for i := range b.order {
+		b.order[i] = i
+	}
+	b.build()
+	return b, nil
+}
+
+// Number of triangles in the tree
+func (b *BVH) NumTriangles() int {
+	return len(b.tris)
+}
+
+// Bounds of everything in the tree
+func (b *BVH) Bounds() AABB {
+	if len(b.nodes) == 0 {
+		return EmptyAABB()
+	}
+	return b.nodes[0].box
+}
+
+// The corners of triangle t
+func (b *BVH) Triangle(t int) [3]Vec3 {
+	tri := b.tris[t]
+	return [3]Vec3{b.points[tri[0]], b.points[tri[1]], b.points[tri[2]]}
+}
+
+func (b *BVH) triangleBox(t int) AABB {
+	c := b.Triangle(t)
+	return AABBFromPoints(c[:])
+}
+
+func (b *BVH) build() {
+	b.nodes = b.nodes[:0]
+	if len(b.tris) == 0 {
+		return
+	}
+	boxes := make([]AABB, len(b.tris))
+	centres := make([]Vec3, len(b.tris))
+	for t := range b.tris {
+		boxes[t] = b.triangleBox(t)
+		centres[t] = boxes[t].Center()
+	}
+	b.nodes = append(b.nodes, bvhNode{first: 0, count: len(b.tris)})
+	// Nodes still to split
+	stack := []int{0}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &b.nodes[n]
+		tris := b.order[node.first : node.first+node.count]
+		node.box = EmptyAABB()
+		cbox := EmptyAABB()
+		for _, t := range tris {
+			node.box = node.box.Union(boxes[t])
+			cbox = cbox.AddPoint(centres[t])
+		}
+		if len(tris) <= bvhLeafSize {
+			continue
+		}
+		mid, ok := sahSplit(tris, boxes, centres, cbox, node.box)
+		if !ok {
+			if len(tris) <= bvhMaxLeafSize {
+				continue
+			}
+			// Splitting doesn't pay, but the leaf is too big - halve it
+			mid = len(tris) / 2
+			medianSplit(tris, centres, cbox)
+		}
+		first := len(b.nodes)
+		b.nodes = append(b.nodes,
+			bvhNode{first: node.first, count: mid},
+			bvhNode{first: node.first + mid, count: len(tris) - mid})
+		node = &b.nodes[n]
+		node.first, node.count = first, 0
+		stack = append(stack, first, first+1)
+	}
+}
+
+func axisOf(v Vec3, k int) gl.Float {
+	switch k {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+// Longest axis of a box
+func longestAxis(b AABB) int {
+	d := b.Max.Sub(&b.Min)
+	switch {
+	case d.X >= d.Y && d.X >= d.Z:
+		return 0
+	case d.Y >= d.Z:
+		return 1
+	}
+	return 2
+}
+
+// Finds the cheapest binned split along the longest axis of the centres
+// and partitions tris around it.  Returns the number of triangles in the
+// first half, or false if no split beats leaving the node a leaf.
+func sahSplit(tris []int, boxes []AABB, centres []Vec3, cbox, box AABB) (int, bool) {
+	axis := longestAxis(cbox)
+	lo, hi := axisOf(cbox.Min, axis), axisOf(cbox.Max, axis)
+	if hi <= lo {
+		return 0, false
+	}
+	scale := gl.Float(bvhBins) / (hi - lo)
+	binOf := func(t int) int {
+		i := int((axisOf(centres[t], axis) - lo) * scale)
+		if i >= bvhBins {
+			i = bvhBins - 1
+		}
+		return i
+	}
+	var bins [bvhBins]struct {
+		box   AABB
+		count int
+	}
+	for i := range bins {
+		bins[i].box = EmptyAABB()
+	}
+	for _, t := range tris {
+		i := binOf(t)
+		bins[i].box = bins[i].box.Union(boxes[t])
+		bins[i].count++
+	}
+
+	// Sweep from the right for the cost of each right half, then from the
+	// left to find the cheapest
+	var rightArea [bvhBins]gl.Float
+	var rightCount [bvhBins]int
+	acc, n := EmptyAABB(), 0
+	for i := bvhBins - 1; i > 0; i-- {
+		acc = acc.Union(bins[i].box)
+		n += bins[i].count
+		rightArea[i], rightCount[i] = acc.SurfaceArea(), n
+	}
+	best, bestCost := -1, gl.Float(len(tris))*box.SurfaceArea()
+	acc, n = EmptyAABB(), 0
+	for i := 0; i < bvhBins-1; i++ {
+		acc = acc.Union(bins[i].box)
+		n += bins[i].count
+		if n == 0 || rightCount[i+1] == 0 {
+			continue
+		}
+		// Traversing a node costs about as much as a triangle test
+		cost := box.SurfaceArea() + acc.SurfaceArea()*gl.Float(n) + rightArea[i+1]*gl.Float(rightCount[i+1])
+		if cost < bestCost {
+			best, bestCost = i, cost
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+
+	// Partition in place
+	mid := 0
+	for i, t := range tris {
+		if binOf(t) <= best {
+			tris[i], tris[mid] = tris[mid], t
+			mid++
+		}
+	}
+	return mid, true
+}
+
+// Partially sorts tris so the first half has the smaller centres along
+// the longest axis
+func medianSplit(tris []int, centres []Vec3, cbox AABB) {
+	axis := longestAxis(cbox)
+	k := len(tris) / 2
+	lo, hi := 0, len(tris)-1
+	for lo < hi {
+		pivot := axisOf(centres[tris[(lo+hi)/2]], axis)
+		i, j := lo, hi
+		for i <= j {
+			for axisOf(centres[tris[i]], axis) < pivot {
+				i++
+			}
+			for axisOf(centres[tris[j]], axis) > pivot {
+				j--
+			}
+			if i <= j {
+				tris[i], tris[j] = tris[j], tris[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// Refit - updates the tree for the mesh's current positions, keeping its
+// structure.  Quick, but the tree gets slower to search the further the
+// mesh moves from the shape it was built for; rebuild with NewBVH then.
+func (b *BVH) Refit(m *Mesh) error {
+	points := m.positions()
+	if len(points) != len(b.points) {
+		return errors.New(fmt.Sprintf("BVH:Refit: Mesh %s has %d vertices, the tree was built for %d", m.name, len(points), len(b.points)))
+	}
+	b.points = points
+	for n := len(b.nodes) - 1; n >= 0; n-- {
+		node := &b.nodes[n]
+		if node.count > 0 {
+			node.box = EmptyAABB()
+			for _, t := range b.order[node.first : node.first+node.count] {
+				node.box = node.box.Union(b.triangleBox(t))
+			}
+		} else {
+			node.box = b.nodes[node.first].box.Union(b.nodes[node.first+1].box)
+		}
+	}
+	return nil
+}
+
+// Möller-Trumbore ray/triangle test, hitting either side
+func rayTriangle(r Ray, c [3]Vec3, maxT gl.Float) (t, u, v gl.Float, ok bool) {
+	o, d := toVec3d(r.Origin), toVec3d(r.Dir)
+	a := toVec3d(c[0])
+	e1, e2 := toVec3d(c[1]).sub(a), toVec3d(c[2]).sub(a)
+	p := d.cross(e2)
+	det := e1.dot(p)
+	if math.Abs(det) < 1e-20 {
+		return 0, 0, 0, false
+	}
+	inv := 1 / det
+	s := o.sub(a)
+	fu := s.dot(p) * inv
+	if fu < 0 || fu > 1 {
+		return 0, 0, 0, false
+	}
+	q := s.cross(e1)
+	fv := d.dot(q) * inv
+	if fv < 0 || fu+fv > 1 {
+		return 0, 0, 0, false
+	}
+	ft := e2.dot(q) * inv
+	if ft < 0 || ft > float64(maxT) {
+		return 0, 0, 0, false
+	}
+	return gl.Float(ft), gl.Float(fu), gl.Float(fv), true
+}
+
+// Walks the tree along the ray; hit is called for each triangle in a leaf
+// the ray reaches and returns the new maximum distance, or a negative one
+// to stop
+func (b *BVH) traceRay(r Ray, maxT gl.Float, hit func(t int) gl.Float) {
+	if len(b.nodes) == 0 {
+		return
+	}
+	inv := Vec3{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	if _, ok := b.nodes[0].box.intersectRay(r.Origin, inv, maxT); !ok {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if node.count > 0 {
+			for _, t := range b.order[node.first : node.first+node.count] {
+				if maxT = hit(t); maxT < 0 {
+					return
+				}
+			}
+			continue
+		}
+		// Visit the nearer child first, so maxT shrinks sooner
+		a, c := node.first, node.first+1
+		ta, hitA := b.nodes[a].box.intersectRay(r.Origin, inv, maxT)
+		tc, hitC := b.nodes[c].box.intersectRay(r.Origin, inv, maxT)
+		switch {
+		case hitA && hitC:
+			if tc < ta {
+				a, c = c, a
+			}
+			stack = append(stack, c, a)
+		case hitA:
+			stack = append(stack, a)
+		case hitC:
+			stack = append(stack, c)
+		}
+	}
+}
+
+// Raycast - the nearest triangle the ray hits within maxT of its origin.
+// Triangles are hit from either side.
+func (b *BVH) Raycast(r Ray, maxT gl.Float) (RayHit, bool) {
+	var best RayHit
+	found := false
+	b.traceRay(r, maxT, func(t int) gl.Float {
+		if d, u, v, ok := rayTriangle(r, b.Triangle(t), maxT); ok {
+			best = RayHit{Triangle: t, T: d, U: u, V: v}
+			found = true
+			maxT = d
+		}
+		return maxT
+	})
+	if found {
+		best.Point = r.At(best.T)
+	}
+	return best, found
+}
+
+// RaycastAny - does the ray hit anything within maxT?  Quicker than
+// Raycast, for shadow and line of sight tests.
+func (b *BVH) RaycastAny(r Ray, maxT gl.Float) bool {
+	found := false
+	b.traceRay(r, maxT, func(t int) gl.Float {
+		if _, _, _, ok := rayTriangle(r, b.Triangle(t), maxT); ok {
+			found = true
+			return -1
+		}
+		return maxT
+	})
+	return found
+}
+
+// Calls visit for every triangle in a leaf whose box passes the test
+func (b *BVH) query(test func(AABB) bool, visit func(t int)) {
+	if len(b.nodes) == 0 {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !test(node.box) {
+			continue
+		}
+		if node.count > 0 {
+			for _, t := range b.order[node.first : node.first+node.count] {
+				visit(t)
+			}
+			continue
+		}
+		stack = append(stack, node.first, node.first+1)
+	}
+}
+
+// QuerySphere - the triangles touching the sphere
+func (b *BVH) QuerySphere(s Sphere) []int {
+	var out []int
+	c := toVec3d(s.Center)
+	r2 := float64(s.Radius) * float64(s.Radius)
+	b.query(func(box AABB) bool {
+		d := toVec3d(box.ClosestPoint(s.Center)).sub(c)
+		return d.dot(d) <= r2
+	}, func(t int) {
+		d := closestOnTriangle(c, b.Triangle(t)).sub(c)
+		if d.dot(d) <= r2 {
+			out = append(out, t)
+		}
+	})
+	return out
+}
+
+// QueryAABB - the triangles touching the box
+func (b *BVH) QueryAABB(box AABB) []int {
+	var out []int
+	b.query(box.Overlaps, func(t int) {
+		if triangleOverlapsAABB(b.Triangle(t), box) {
+			out = append(out, t)
+		}
+	})
+	return out
+}
+
+// ClosestPoint - the nearest point on any triangle to p, if there's one
+// within maxDist
+func (b *BVH) ClosestPoint(p Vec3, maxDist gl.Float) (ClosestHit, bool) {
+	if len(b.nodes) == 0 {
+		return ClosestHit{}, false
+	}
+	pd := toVec3d(p)
+	best := ClosestHit{Triangle: -1}
+	bestD2 := float64(maxDist) * float64(maxDist)
+	boxD2 := func(box AABB) float64 {
+		d := toVec3d(box.ClosestPoint(p)).sub(pd)
+		return d.dot(d)
+	}
+	type entry struct {
+		node int
+		d2   float64
+	}
+	stack := []entry{{0, boxD2(b.nodes[0].box)}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.d2 > bestD2 {
+			continue
+		}
+		node := b.nodes[e.node]
+		if node.count > 0 {
+			for _, t := range b.order[node.first : node.first+node.count] {
+				q := closestOnTriangle(pd, b.Triangle(t))
+				if d := q.sub(pd); d.dot(d) <= bestD2 {
+					bestD2 = d.dot(d)
+					best = ClosestHit{Triangle: t, Point: q.vec3()}
+				}
+			}
+			continue
+		}
+		// Push the farther child first so the nearer is searched first
+		a := entry{node.first, boxD2(b.nodes[node.first].box)}
+		c := entry{node.first + 1, boxD2(b.nodes[node.first+1].box)}
+		if a.d2 < c.d2 {
+			a, c = c, a
+		}
+		stack = append(stack, a, c)
+	}
+	if best.Triangle < 0 {
+		return best, false
+	}
+	best.Distance = gl.Float(math.Sqrt(bestD2))
+	return best, true
+}
+
+// Closest point on a triangle to p, after Ericson's Real-Time Collision
+// Detection, working out which Voronoi region of the triangle p is in
+func closestOnTriangle(p vec3d, c [3]Vec3) vec3d {
+	a, b, cc := toVec3d(c[0]), toVec3d(c[1]), toVec3d(c[2])
+	ab, ac, ap := b.sub(a), cc.sub(a), p.sub(a)
+	d1, d2 := ab.dot(ap), ac.dot(ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+	bp := p.sub(b)
+	d3, d4 := ab.dot(bp), ac.dot(bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return a.add(ab.scale(d1 / (d1 - d3)))
+	}
+	cp := p.sub(cc)
+	d5, d6 := ab.dot(cp), ac.dot(cp)
+	if d6 >= 0 && d5 <= d6 {
+		return cc
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return a.add(ac.scale(d2 / (d2 - d6)))
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		return b.add(cc.sub(b).scale((d4 - d3) / ((d4 - d3) + (d5 - d6))))
+	}
+	denom := va + vb + vc
+	if denom == 0 {
+		return a
+	}
+	return a.add(ab.scale(vb / denom)).add(ac.scale(vc / denom))
+}
+
+// Separating axis test of a triangle against a box, after Akenine-Möller:
+// the box's three axes, the triangle's normal and the nine cross products
+// of their edges
+func triangleOverlapsAABB(c [3]Vec3, box AABB) bool {
+	centre, half := toVec3d(box.Center()), toVec3d(box.HalfExtents())
+	var v [3]vec3d
+	for i := range c {
+		v[i] = toVec3d(c[i]).sub(centre)
+	}
+	edges := [3]vec3d{v[1].sub(v[0]), v[2].sub(v[1]), v[0].sub(v[2])}
+	axes := []vec3d{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, edges[0].cross(edges[1])}
+	for _, e := range edges {
+		for _, a := range [3]vec3d{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+			axes = append(axes, a.cross(e))
+		}
+	}
+	for _, axis := range axes {
+		if axis.dot(axis) < 1e-24 {
+			continue
+		}
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, p := range v {
+			d := p.dot(axis)
+			lo, hi = math.Min(lo, d), math.Max(hi, d)
+		}
+		r := half[0]*math.Abs(axis[0]) + half[1]*math.Abs(axis[1]) + half[2]*math.Abs(axis[2])
+		if lo > r || hi < -r {
+			return false
+		}
+	}
+	return true
+}
+
+// Marks the start of a serialised BVH, with a version number
+var bvhMagic = [4]byte{'B', 'V', 'H', 1}
+
+// MarshalBinary - the tree as bytes, for UnmarshalBinary to read back
+func (b *BVH) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	put := func(v interface{}) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	put(bvhMagic)
+	put(uint32(len(b.points)))
+	put(uint32(len(b.tris)))
+	put(uint32(len(b.nodes)))
+	for _, p := range b.points {
+		put([3]float32{float32(p.X), float32(p.Y), float32(p.Z)})
+	}
+	for t, tri := range b.tris {
+		put([3]uint32{uint32(tri[0]), uint32(tri[1]), uint32(tri[2])})
+		put(uint32(b.order[t]))
+	}
+	for _, n := range b.nodes {
+		put([6]float32{
+			float32(n.box.Min.X), float32(n.box.Min.Y), float32(n.box.Min.Z),
+			float32(n.box.Max.X), float32(n.box.Max.Y), float32(n.box.Max.Z),
+		})
+		put([2]uint32{uint32(n.first), uint32(n.count)})
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary - reads back a tree written by MarshalBinary
+func (b *BVH) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var err error
+	get := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, v)
+		}
+	}
+	var magic [4]byte
+	var counts [3]uint32
+	get(&magic)
+	get(&counts)
+	if err != nil || magic != bvhMagic {
+		return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Not a serialised BVH"))
+	}
+	// Every vertex, triangle and node takes at least 12 bytes, so the
+	// counts can be checked before allocating anything
+	if uint64(counts[0])+uint64(counts[1])+uint64(counts[2]) > uint64(r.Len())/12 {
+		return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Data is truncated"))
+	}
+	out := BVH{
+		points: make([]Vec3, counts[0]),
+		tris:   make([][3]gl.Uint, counts[1]),
+		order:  make([]int, counts[1]),
+		nodes:  make([]bvhNode, counts[2]),
+	}
+	for i := range out.points {
+		var p [3]float32
+		get(&p)
+		out.points[i] = Vec3{gl.Float(p[0]), gl.Float(p[1]), gl.Float(p[2])}
+	}
+	for t := range out.tris {
+		var tri [3]uint32
+		var o uint32
+		get(&tri)
+		get(&o)
+		for k, v := range tri {
+			if v >= counts[0] {
+				return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Triangle %d uses vertex %d of %d", t, v, counts[0]))
+			}
+			out.tris[t][k] = gl.Uint(v)
+		}
+		if o >= counts[1] {
+			return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Bad triangle order"))
+		}
+		out.order[t] = int(o)
+	}
+	for i := range out.nodes {
+		var box [6]float32
+		var fc [2]uint32
+		get(&box)
+		get(&fc)
+		n := &out.nodes[i]
+		n.box = AABB{
+			Vec3{gl.Float(box[0]), gl.Float(box[1]), gl.Float(box[2])},
+			Vec3{gl.Float(box[3]), gl.Float(box[4]), gl.Float(box[5])},
+		}
+		n.first, n.count = int(fc[0]), int(fc[1])
+		// Children come after their parent, so a bad file can't loop
+		if (n.count == 0 && (n.first <= i || n.first+1 >= len(out.nodes))) ||
+			(n.count > 0 && n.first+n.count > len(out.order)) {
+			return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Node %d is broken", i))
+		}
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("BVH:UnmarshalBinary: Data is truncated"))
+	}
+	*b = out
+	return nil
+}
@@ -0,0 +1,129 @@
+package goglutils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func randomVec3(r *rand.Rand, scale float64) Vec3 {
+	return Vec3{
+		gl.Float((r.Float64()*2 - 1) * scale),
+		gl.Float((r.Float64()*2 - 1) * scale),
+		gl.Float((r.Float64()*2 - 1) * scale),
+	}
+}
+
+// Brute force nearest hit, to check the tree against
+func bruteRaycast(b *BVH, ray Ray) (gl.Float, bool) {
+	best, found := gl.Float(math.Inf(1)), false
+	for t := 0; t < b.NumTriangles(); t++ {
+		if d, _, _, ok := rayTriangle(ray, b.Triangle(t), best); ok {
+			best, found = d, true
+		}
+	}
+	return best, found
+}
+
+func TestBVHRaycast(t *testing.T) {
+	m := NewUVSphereMesh(1, 32, 16)
+	b, err := NewBVH(m, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(5))
+	inf := gl.Float(math.Inf(1))
+	for i := 0; i < 200; i++ {
+		from := randomVec3(r, 3)
+		to := randomVec3(r, 0.8)
+		ray := Ray{from, *to.Sub(&from)}
+		want, wantHit := bruteRaycast(b, ray)
+		hit, ok := b.Raycast(ray, inf)
+		if ok != wantHit || (ok && math.Abs(float64(hit.T-want)) > 1e-5) {
+			t.Fatalf("Ray %v hits at %v %v, want %v %v", ray, hit.T, ok, want, wantHit)
+		}
+		if b.RaycastAny(ray, inf) != wantHit {
+			t.Fatalf("RaycastAny disagrees for ray %v", ray)
+		}
+	}
+}
+
+func TestBVHQueries(t *testing.T) {
+	m := NewTorusMesh(2, 0.5, 32, 16)
+	b, err := NewBVH(m, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(6))
+	for i := 0; i < 50; i++ {
+		p := randomVec3(r, 3)
+		got, ok := b.ClosestPoint(p, 100)
+		if !ok {
+			t.Fatalf("No closest point to %v", p)
+		}
+		want := math.Inf(1)
+		for tri := 0; tri < b.NumTriangles(); tri++ {
+			q := closestOnTriangle(toVec3d(p), b.Triangle(tri))
+			want = math.Min(want, q.sub(toVec3d(p)).length())
+		}
+		if math.Abs(float64(got.Distance)-want) > 1e-5 {
+			t.Fatalf("Closest point to %v is %v away, want %v", p, got.Distance, want)
+		}
+
+		s := Sphere{p, 0.7}
+		n := 0
+		for tri := 0; tri < b.NumTriangles(); tri++ {
+			if closestOnTriangle(toVec3d(p), b.Triangle(tri)).sub(toVec3d(p)).length() <= 0.7 {
+				n++
+			}
+		}
+		if got := len(b.QuerySphere(s)); got != n {
+			t.Fatalf("Sphere query finds %d triangles, want %d", got, n)
+		}
+	}
+	if len(b.QueryAABB(AABB{Vec3{-0.1, -0.1, -0.1}, Vec3{0.1, 0.1, 0.1}})) != 0 {
+		t.Errorf("Box in the torus' hole should touch nothing")
+	}
+	if len(b.QueryAABB(AABB{Vec3{1.9, -0.1, -0.1}, Vec3{2.1, 0.1, 0.1}})) != 0 {
+		t.Errorf("Box inside the tube should touch nothing")
+	}
+	if len(b.QueryAABB(AABB{Vec3{2.4, -0.1, -0.1}, Vec3{2.6, 0.1, 0.1}})) == 0 {
+		t.Errorf("Box across the tube's surface should touch something")
+	}
+}
+
+func TestBVHRefitAndMarshal(t *testing.T) {
+	m := NewUVSphereMesh(1, 16, 8)
+	b, _ := NewBVH(m, 0)
+	pos := m.AttributeByDesc(AttribPosition)
+	data := pos.Data()
+	for i := 0; i < len(data); i += 3 {
+		data[i] += 10
+	}
+	pos.MarkDirty()
+	if err := b.Refit(m); err != nil {
+		t.Fatal(err)
+	}
+	down := Ray{Vec3{10, 5, 0}, Vec3{0, -1, 0}}
+	hit, ok := b.Raycast(down, 100)
+	if !ok || math.Abs(float64(hit.T)-4) > 1e-3 {
+		t.Errorf("Ray onto the moved sphere hits at %v %v, want 4", hit.T, ok)
+	}
+
+	data2, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c BVH
+	if err := c.UnmarshalBinary(data2); err != nil {
+		t.Fatal(err)
+	}
+	if hit2, ok := c.Raycast(down, 100); !ok || hit2 != hit {
+		t.Errorf("Unmarshalled tree hits %v, want %v", hit2, hit)
+	}
+	if err := c.UnmarshalBinary(data2[:len(data2)-5]); err == nil {
+		t.Errorf("Truncated data should fail")
+	}
+}
@@ -16,8 +16,15 @@ package goglutils
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+
+	gl "github.com/chsc/gogl/gl33"
 )
 
 type Collada struct {
@@ -35,19 +42,51 @@ type ColladaLibraryGeometries struct {
 type ColladaGeometry struct {
 	XMLName xml.Name    `xml:"geometry"`
 	Id      string      `xml:"id,attr"`
+	Name    string      `xml:"name,attr"`
 	Mesh    ColladaMesh `xml:"mesh"`
 }
 
 type ColladaMesh struct {
-	XMLName  xml.Name        `xml:"mesh"`
-	Source   []ColladaSource `xml:"source"`
-	Polylist ColladaPolylist
+	XMLName    xml.Name            `xml:"mesh"`
+	Source     []ColladaSource     `xml:"source"`
+	Vertices   ColladaVertices     `xml:"vertices"`
+	Triangles  []ColladaTriangles  `xml:"triangles"`
+	Polylist   []ColladaPolylist   `xml:"polylist"`
+	Polygons   []ColladaPolygons   `xml:"polygons"`
+	Lines      []ColladaLines      `xml:"lines"`
+	Linestrips []ColladaLinestrips `xml:"linestrips"`
 }
 
 type ColladaSource struct {
-	XMLName     xml.Name          `xml:"source"`
-	Id          string            `xml:"id,attr"`
-	Float_array ColladaFloatArray `xml:"float_array"`
+	XMLName          xml.Name               `xml:"source"`
+	Id               string                 `xml:"id,attr"`
+	Float_array      ColladaFloatArray      `xml:"float_array"`
+	Technique_Common ColladaSourceTechnique `xml:"technique_common"`
+}
+
+type ColladaSourceTechnique struct {
+	Accessor ColladaAccessor `xml:"accessor"`
+}
+
+// How to read values out of a source's array
+type ColladaAccessor struct {
+	Source string         `xml:"source,attr"`
+	Count  string         `xml:"count,attr"`
+	Offset string         `xml:"offset,attr"`
+	Stride string         `xml:"stride,attr"`
+	Param  []ColladaParam `xml:"param"`
+}
+
+// One component of an accessor's values.  Unnamed params are skipped.
+type ColladaParam struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// The per-vertex inputs a VERTEX input stands for
+type ColladaVertices struct {
+	Id    string         `xml:"id,attr"`
+	Input []ColladaInput `xml:"input"`
 }
 
 type ColladaInput struct {
@@ -55,6 +94,7 @@ type ColladaInput struct {
 	Semantic string   `xml:"semantic,attr"`
 	Source   string   `xml:"source,attr"`
 	Offset   string   `xml:"offset,attr"`
+	Set      string   `xml:"set,attr"`
 }
 
 type ColladaFloatArray struct {
@@ -64,11 +104,19 @@ type ColladaFloatArray struct {
 	Count   string   `xml:"count,attr"`
 }
 
+type ColladaTriangles struct {
+	Material string         `xml:"material,attr"`
+	Count    string         `xml:"count,attr"`
+	Input    []ColladaInput `xml:"input"`
+	P        string         `xml:"p"`
+}
+
 type ColladaPolylist struct {
-	XMLName xml.Name       `xml:"polylist"`
-	Id      string         `xml:"id,attr"`
-	Count   string         `xml:"count,attr"`
-	Input   []ColladaInput `xml:"input"`
+	XMLName  xml.Name       `xml:"polylist"`
+	Id       string         `xml:"id,attr"`
+	Material string         `xml:"material,attr"`
+	Count    string         `xml:"count,attr"`
+	Input    []ColladaInput `xml:"input"`
 
 	// List of integers, each specifying the number of vertices for one polygon
 	VCount string `xml:"vcount"`
@@ -77,6 +125,29 @@ type ColladaPolylist struct {
 	P string `xml:"p"`
 }
 
+// Polygons, one <p> each.  Polygons with holes (<ph>) aren't read.
+type ColladaPolygons struct {
+	Material string         `xml:"material,attr"`
+	Count    string         `xml:"count,attr"`
+	Input    []ColladaInput `xml:"input"`
+	P        []string       `xml:"p"`
+}
+
+type ColladaLines struct {
+	Material string         `xml:"material,attr"`
+	Count    string         `xml:"count,attr"`
+	Input    []ColladaInput `xml:"input"`
+	P        string         `xml:"p"`
+}
+
+// Line strips, one <p> each
+type ColladaLinestrips struct {
+	Material string         `xml:"material,attr"`
+	Count    string         `xml:"count,attr"`
+	Input    []ColladaInput `xml:"input"`
+	P        []string       `xml:"p"`
+}
+
 type ColladaLibraryVisualScenes struct {
 	XMLName     xml.Name           `xml:"library_visual_scenes"`
 	VisualScene ColladaVisualScene `xml:"visual_scene"`
@@ -114,7 +185,21 @@ func (m *ColladaMesh) Debug() {
 	for _, s := range m.Source {
 		s.Debug()
 	}
-	m.Polylist.Debug()
+	for _, t := range m.Triangles {
+		t.Debug()
+	}
+	for _, p := range m.Polylist {
+		p.Debug()
+	}
+	for _, p := range m.Polygons {
+		p.Debug()
+	}
+	for _, l := range m.Lines {
+		l.Debug()
+	}
+	for _, l := range m.Linestrips {
+		l.Debug()
+	}
 }
 
 func (s *ColladaSource) Debug() {
@@ -141,11 +226,56 @@ func (p *ColladaPolylist) Debug() {
 	}
 }
 
+func (t *ColladaTriangles) Debug() {
+	fmt.Fprintf(os.Stdout, "*** Triangles ***\n")
+	fmt.Fprintf(os.Stdout, "* Material: %s\n", t.Material)
+	fmt.Fprintf(os.Stdout, "* Count: %s\n", t.Count)
+	fmt.Fprintf(os.Stdout, "* P: %s\n", t.P)
+	for _, i := range t.Input {
+		i.Debug()
+	}
+}
+
+func (p *ColladaPolygons) Debug() {
+	fmt.Fprintf(os.Stdout, "*** Polygons ***\n")
+	fmt.Fprintf(os.Stdout, "* Material: %s\n", p.Material)
+	fmt.Fprintf(os.Stdout, "* Count: %s\n", p.Count)
+	for _, s := range p.P {
+		fmt.Fprintf(os.Stdout, "* P: %s\n", s)
+	}
+	for _, i := range p.Input {
+		i.Debug()
+	}
+}
+
+func (l *ColladaLines) Debug() {
+	fmt.Fprintf(os.Stdout, "*** Lines ***\n")
+	fmt.Fprintf(os.Stdout, "* Material: %s\n", l.Material)
+	fmt.Fprintf(os.Stdout, "* Count: %s\n", l.Count)
+	fmt.Fprintf(os.Stdout, "* P: %s\n", l.P)
+	for _, i := range l.Input {
+		i.Debug()
+	}
+}
+
+func (l *ColladaLinestrips) Debug() {
+	fmt.Fprintf(os.Stdout, "*** Linestrips ***\n")
+	fmt.Fprintf(os.Stdout, "* Material: %s\n", l.Material)
+	fmt.Fprintf(os.Stdout, "* Count: %s\n", l.Count)
+	for _, s := range l.P {
+		fmt.Fprintf(os.Stdout, "* P: %s\n", s)
+	}
+	for _, i := range l.Input {
+		i.Debug()
+	}
+}
+
 func (i *ColladaInput) Debug() {
 	fmt.Fprintf(os.Stdout, "*** Input ***\n")
 	fmt.Fprintf(os.Stdout, "* Semantic: %s\n", i.Semantic)
 	fmt.Fprintf(os.Stdout, "* Source: %s\n", i.Source)
 	fmt.Fprintf(os.Stdout, "* Offset: %s\n", i.Offset)
+	fmt.Fprintf(os.Stdout, "* Set: %s\n", i.Set)
 }
 
 // Given a filename, attempts to load the Collada data
@@ -156,21 +286,572 @@ func ReadColladaFile(filename string) (*Collada, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 	fi, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 	filelen := fi.Size()
 	buf := make([]byte, filelen)
-	read, err := file.Read(buf)
+	read, err := io.ReadFull(file, buf)
 	if read != int(filelen) || err != nil {
 		return nil, err
 	}
+	return ParseCollada(buf)
+}
 
+// ParseCollada - the Collada data in an XML document
+func ParseCollada(data []byte) (*Collada, error) {
 	c := new(Collada)
-	err = xml.Unmarshal(buf, c)
-	if err != nil {
+	if err := xml.Unmarshal(data, c); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
+
+// ******************************* //
+// *   Conversion to Mesh          * //
+// ******************************* //
+
+// Attribute descriptions for input semantics.  Others are lower-cased.
+var colladaSemantics = map[string]string{
+	"POSITION":    AttribPosition,
+	"NORMAL":      AttribNormal,
+	"TEXCOORD":    AttribTexCoord,
+	"COLOR":       AttribColor,
+	"TEXTANGENT":  AttribTangent,
+	"TANGENT":     AttribTangent,
+	"TEXBINORMAL": AttribBitangent,
+	"BINORMAL":    AttribBitangent,
+}
+
+// A source's values, read through its accessor
+type colladaValues struct {
+	data []gl.Float
+	size int // Components in each value
+}
+
+// An input of a primitive element, resolved to a source and an attribute
+type colladaStream struct {
+	attr   int // Number of the attribute it fills
+	source int // Number of the source
+	offset int // Where its index is in each vertex's run of <p>
+}
+
+// A primitive element, its polygons (or line segments) as lists of vertex
+// numbers - vertex v's indices being p[v*stride:(v+1)*stride]
+type colladaPrimitive struct {
+	desc    string
+	streams []colladaStream
+	p       []int
+	stride  int
+	polys   [][]int
+	lines   bool
+}
+
+// Turns a geometry into a mesh
+type colladaBuilder struct {
+	g        *ColladaGeometry
+	sources  map[string]*ColladaSource
+	sourceNo map[string]int
+	values   []colladaValues
+	descs    []string
+	sizes    []int
+	sets     map[string][]string // Sets seen of each semantic, in order
+}
+
+func colladaInts(s string) ([]int, error) {
+	fields := strings.Fields(s)
+	out := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// Parses an optional integer attribute
+func colladaAttrInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// The number of the source with the given id (or #id), reading it the
+// first time
+func (b *colladaBuilder) source(id string) (int, error) {
+	id = strings.TrimPrefix(id, "#")
+	if n, ok := b.sourceNo[id]; ok {
+		return n, nil
+	}
+	src := b.sources[id]
+	if src == nil {
+		return 0, errors.New(fmt.Sprintf("Collada: Geometry %s has no source %s", b.g.Id, id))
+	}
+	var arr []gl.Float
+	if strings.TrimSpace(src.Float_array.CDATA) != "" {
+		var err error
+		if arr, err = StringToGLFloatArray(src.Float_array.CDATA); err != nil {
+			return 0, err
+		}
+	}
+	acc := src.Technique_Common.Accessor
+	// Unnamed params are skipped; no params at all means take everything
+	var picks []int
+	for j, p := range acc.Param {
+		if p.Name != "" {
+			picks = append(picks, j)
+		}
+	}
+	stride, err := colladaAttrInt(acc.Stride, len(acc.Param))
+	if err == nil && stride < 1 {
+		stride = 1
+	}
+	offset, err2 := colladaAttrInt(acc.Offset, 0)
+	count, err3 := colladaAttrInt(acc.Count, -1)
+	if err != nil || err2 != nil || err3 != nil || offset < 0 {
+		return 0, errors.New(fmt.Sprintf("Collada: Source %s has a bad accessor", id))
+	}
+	if len(acc.Param) == 0 {
+		for j := 0; j < stride; j++ {
+			picks = append(picks, j)
+		}
+	}
+	if count < 0 {
+		count = (len(arr) - offset) / stride
+	}
+	v := colladaValues{make([]gl.Float, 0, count*len(picks)), len(picks)}
+	for i := 0; i < count; i++ {
+		for _, j := range picks {
+			k := offset + i*stride + j
+			if k >= len(arr) {
+				return 0, errors.New(fmt.Sprintf("Collada: Source %s has %d floats, too few for %d values", id, len(arr), count))
+			}
+			v.data = append(v.data, arr[k])
+		}
+	}
+	b.sourceNo[id] = len(b.values)
+	b.values = append(b.values, v)
+	return len(b.values) - 1, nil
+}
+
+// The number of the attribute for a semantic and set.  The first set of
+// a semantic gets the plain description, later ones a number after it.
+func (b *colladaBuilder) attribute(semantic, set string) int {
+	desc, ok := colladaSemantics[semantic]
+	if !ok {
+		desc = strings.ToLower(semantic)
+	}
+	n := -1
+	for i, s := range b.sets[semantic] {
+		if s == set {
+			n = i
+		}
+	}
+	if n < 0 {
+		n = len(b.sets[semantic])
+		b.sets[semantic] = append(b.sets[semantic], set)
+	}
+	if n > 0 {
+		desc += strconv.Itoa(n)
+	}
+	for a, d := range b.descs {
+		if d == desc {
+			return a
+		}
+	}
+	b.descs = append(b.descs, desc)
+	b.sizes = append(b.sizes, 0)
+	return len(b.descs) - 1
+}
+
+// Resolves a primitive element's inputs, looking through VERTEX to the
+// <vertices> inputs, and reads its <p> lists
+func (b *colladaBuilder) primitive(desc string, inputs []ColladaInput, ps []string) (*colladaPrimitive, error) {
+	prim := &colladaPrimitive{desc: desc}
+	add := func(in ColladaInput, offset int) error {
+		n, err := b.source(in.Source)
+		if err != nil {
+			return err
+		}
+		a := b.attribute(in.Semantic, in.Set)
+		if size := b.values[n].size; size > b.sizes[a] {
+			b.sizes[a] = size
+		}
+		prim.streams = append(prim.streams, colladaStream{a, n, offset})
+		return nil
+	}
+	for _, in := range inputs {
+		offset, err := colladaAttrInt(in.Offset, 0)
+		if err != nil || offset < 0 {
+			return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has input %s at offset %s", b.g.Id, in.Semantic, in.Offset))
+		}
+		if offset+1 > prim.stride {
+			prim.stride = offset + 1
+		}
+		if in.Semantic != "VERTEX" {
+			if err := add(in, offset); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if strings.TrimPrefix(in.Source, "#") != b.g.Mesh.Vertices.Id {
+			return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has no vertices %s", b.g.Id, in.Source))
+		}
+		for _, vin := range b.g.Mesh.Vertices.Input {
+			if err := add(vin, offset); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if prim.stride == 0 {
+		return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has a primitive with no inputs", b.g.Id))
+	}
+	for _, s := range ps {
+		p, err := colladaInts(s)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has bad indices: %v", b.g.Id, err))
+		}
+		prim.p = append(prim.p, p...)
+	}
+	if len(prim.p)%prim.stride != 0 {
+		return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has %d indices, not a multiple of %d", b.g.Id, len(prim.p), prim.stride))
+	}
+	return prim, nil
+}
+
+// Splits a primitive's vertices into polygons of the given sizes
+func (prim *colladaPrimitive) split(sizes []int) error {
+	v := 0
+	for _, n := range sizes {
+		if n < 0 || v+n > len(prim.p)/prim.stride {
+			return errors.New(fmt.Sprintf("Collada: Primitive %s has too few indices for its polygons", prim.desc))
+		}
+		poly := make([]int, n)
+		for i := range poly {
+			poly[i] = v + i
+		}
+		prim.polys = append(prim.polys, poly)
+		v += n
+	}
+	return nil
+}
+
+// Every n vertices are one polygon
+func (prim *colladaPrimitive) every(n int) error {
+	sizes := make([]int, len(prim.p)/prim.stride/n)
+	for i := range sizes {
+		sizes[i] = n
+	}
+	return prim.split(sizes)
+}
+
+// The geometry's primitive elements, in the order triangles, polylists,
+// polygons, lines, line strips
+func (b *colladaBuilder) primitives() ([]*colladaPrimitive, error) {
+	var prims []*colladaPrimitive
+	name := func(material string) string {
+		if material != "" {
+			return material
+		}
+		return strconv.Itoa(len(prims))
+	}
+	m := &b.g.Mesh
+	for _, t := range m.Triangles {
+		prim, err := b.primitive(name(t.Material), t.Input, []string{t.P})
+		if err == nil {
+			err = prim.every(3)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prims = append(prims, prim)
+	}
+	for _, pl := range m.Polylist {
+		prim, err := b.primitive(name(pl.Material), pl.Input, []string{pl.P})
+		if err != nil {
+			return nil, err
+		}
+		vcount, err := colladaInts(pl.VCount)
+		if err == nil {
+			err = prim.split(vcount)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prims = append(prims, prim)
+	}
+	for _, pg := range m.Polygons {
+		prim, err := b.primitive(name(pg.Material), pg.Input, pg.P)
+		if err != nil {
+			return nil, err
+		}
+		var sizes []int
+		for _, s := range pg.P {
+			sizes = append(sizes, len(strings.Fields(s))/prim.stride)
+		}
+		if err := prim.split(sizes); err != nil {
+			return nil, err
+		}
+		prims = append(prims, prim)
+	}
+	for _, l := range m.Lines {
+		prim, err := b.primitive(name(l.Material), l.Input, []string{l.P})
+		if err == nil {
+			err = prim.every(2)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prim.lines = true
+		prims = append(prims, prim)
+	}
+	for _, ls := range m.Linestrips {
+		prim, err := b.primitive(name(ls.Material), ls.Input, ls.P)
+		if err != nil {
+			return nil, err
+		}
+		// Each strip becomes its segments
+		v := 0
+		for _, s := range ls.P {
+			n := len(strings.Fields(s)) / prim.stride
+			for i := 1; i < n; i++ {
+				prim.polys = append(prim.polys, []int{v + i - 1, v + i})
+			}
+			v += n
+		}
+		prim.lines = true
+		prims = append(prims, prim)
+	}
+	return prims, nil
+}
+
+// ToMesh - the geometry as an indexed Mesh named after it.  Vertices are
+// shared wherever the same indices repeat, polygons are triangulated and
+// each primitive element gets an index named after its material.
+func (g *ColladaGeometry) ToMesh() (*Mesh, error) {
+	b := &colladaBuilder{
+		g:        g,
+		sources:  make(map[string]*ColladaSource),
+		sourceNo: make(map[string]int),
+		sets:     make(map[string][]string),
+	}
+	for i := range g.Mesh.Source {
+		b.sources[g.Mesh.Source[i].Id] = &g.Mesh.Source[i]
+	}
+	b.attribute("POSITION", "") // Positions first
+	prims, err := b.primitives()
+	if err != nil {
+		return nil, err
+	}
+	if b.sizes[0] < 3 {
+		return nil, errors.New(fmt.Sprintf("Collada: Geometry %s has no positions", g.Id))
+	}
+
+	data := make([][]gl.Float, len(b.descs))
+	vertices := make(map[string]gl.Uint)
+	var key []byte
+	count := gl.Uint(0)
+	vertex := func(prim *colladaPrimitive, v int) (gl.Uint, error) {
+		key = key[:0]
+		for _, s := range prim.streams {
+			key = strconv.AppendInt(key, int64(s.attr), 10)
+			key = append(key, ':')
+			key = strconv.AppendInt(key, int64(s.source), 10)
+			key = append(key, ':')
+			key = strconv.AppendInt(key, int64(prim.p[v*prim.stride+s.offset]), 10)
+			key = append(key, ' ')
+		}
+		if n, ok := vertices[string(key)]; ok {
+			return n, nil
+		}
+		for a := range data {
+			data[a] = append(data[a], make([]gl.Float, b.sizes[a])...)
+		}
+		for _, s := range prim.streams {
+			values := b.values[s.source]
+			i := prim.p[v*prim.stride+s.offset]
+			if i < 0 || (i+1)*values.size > len(values.data) {
+				return 0, errors.New(fmt.Sprintf("Collada: Geometry %s uses value %d of %s, which has %d", g.Id, i, b.descs[s.attr], len(values.data)/values.size))
+			}
+			start := int(count) * b.sizes[s.attr]
+			copy(data[s.attr][start:], values.data[i*values.size:(i+1)*values.size])
+		}
+		vertices[string(key)] = count
+		count++
+		return count - 1, nil
+	}
+
+	m := NewMesh(g.Name)
+	if g.Name == "" {
+		m.name = g.Id
+	}
+	indices := make([][]gl.Uint, len(prims))
+	for pi, prim := range prims {
+		for _, poly := range prim.polys {
+			corners := make([]gl.Uint, len(poly))
+			for i, v := range poly {
+				if corners[i], err = vertex(prim, v); err != nil {
+					return nil, err
+				}
+			}
+			if prim.lines || len(corners) == 3 {
+				indices[pi] = append(indices[pi], corners...)
+				continue
+			}
+			pts := make([]Vec3, len(corners))
+			for i, c := range corners {
+				p := data[0][int(c)*b.sizes[0]:]
+				pts[i] = Vec3{p[0], p[1], p[2]}
+			}
+			for _, t := range triangulatePolygon(pts) {
+				indices[pi] = append(indices[pi], corners[t[0]], corners[t[1]], corners[t[2]])
+			}
+		}
+	}
+	for a, desc := range b.descs {
+		if err := m.AddMeshAttribute(desc, data[a], b.sizes[a]); err != nil {
+			return nil, err
+		}
+	}
+	for pi, prim := range prims {
+		primitive := gl.Enum(gl.TRIANGLES)
+		if prim.lines {
+			primitive = gl.LINES
+		}
+		if err := m.AddMeshIndex(prim.desc, indices[pi], primitive, m.attributes[0]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Meshes - a Mesh for each geometry in the file
+func (c *Collada) Meshes() ([]*Mesh, error) {
+	var meshes []*Mesh
+	for i := range c.Library_Geometries.Geometry {
+		m, err := c.Library_Geometries.Geometry[i].ToMesh()
+		if err != nil {
+			return nil, err
+		}
+		meshes = append(meshes, m)
+	}
+	return meshes, nil
+}
+
+// LoadColladaMeshes - a Mesh for each geometry in a COLLADA (.dae) file
+func LoadColladaMeshes(file string) ([]*Mesh, error) {
+	c, err := ReadColladaFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return c.Meshes()
+}
+
+// Load a mesh from a COLLADA (.dae) file - the geometry with the mesh's
+// name as its id or name if there is one, otherwise the first
+func (m *Mesh) LoadCollada(file string) error {
+	if len(m.attributes) > 0 {
+		return errors.New(fmt.Sprintf("Mesh:LoadCollada: Mesh %s already has attributes", m.name))
+	}
+	c, err := ReadColladaFile(file)
+	if err != nil {
+		return err
+	}
+	geometries := c.Library_Geometries.Geometry
+	if len(geometries) == 0 {
+		return errors.New(fmt.Sprintf("Mesh:LoadCollada: No geometry in %s", file))
+	}
+	g := &geometries[0]
+	for i := range geometries {
+		if geometries[i].Id == m.name || geometries[i].Name == m.name {
+			g = &geometries[i]
+			break
+		}
+	}
+	loaded, err := g.ToMesh()
+	if err != nil {
+		return err
+	}
+	m.attributes, m.indices = loaded.attributes, loaded.indices
+	m.layoutDirty = true
+	return nil
+}
+
+// Splits a polygon into triangles of its corners by clipping ears in the
+// polygon's own plane, so concave polygons come out right
+func triangulatePolygon(pts []Vec3) [][3]int {
+	n := len(pts)
+	if n < 3 {
+		return nil
+	}
+	// Newell's normal, which copes with non-planar and concave polygons
+	var normal vec3d
+	for i := range pts {
+		a, b := toVec3d(pts[i]), toVec3d(pts[(i+1)%n])
+		normal[0] += (a[1] - b[1]) * (a[2] + b[2])
+		normal[1] += (a[2] - b[2]) * (a[0] + b[0])
+		normal[2] += (a[0] - b[0]) * (a[1] + b[1])
+	}
+	var tris [][3]int
+	if n == 3 || normal.length() == 0 {
+		for i := 1; i+1 < n; i++ {
+			tris = append(tris, [3]int{0, i, i + 1})
+		}
+		return tris
+	}
+
+	// Axes in the plane, counter-clockwise seen from the normal's side
+	normal = normal.normalize()
+	axis := vec3d{1, 0, 0}
+	if math.Abs(normal[0]) > 0.9 {
+		axis = vec3d{0, 1, 0}
+	}
+	u := normal.cross(axis).normalize()
+	v := normal.cross(u)
+	flat := make([][2]float64, n)
+	for i, p := range pts {
+		q := toVec3d(p)
+		flat[i] = [2]float64{q.dot(u), q.dot(v)}
+	}
+	cross := func(a, b, c int) float64 {
+		return (flat[b][0]-flat[a][0])*(flat[c][1]-flat[a][1]) - (flat[b][1]-flat[a][1])*(flat[c][0]-flat[a][0])
+	}
+
+	remaining := make([]int, n)
+	for i := range remaining {
+		remaining[i] = i
+	}
+	for len(remaining) > 3 {
+		m := len(remaining)
+		clipped := false
+		for i := 0; i < m && !clipped; i++ {
+			a, b, c := remaining[(i+m-1)%m], remaining[i], remaining[(i+1)%m]
+			if cross(a, b, c) <= 0 {
+				continue // Reflex or flat corner
+			}
+			ear := true
+			for _, o := range remaining {
+				if o != a && o != b && o != c && cross(a, b, o) >= 0 && cross(b, c, o) >= 0 && cross(c, a, o) >= 0 {
+					ear = false
+					break
+				}
+			}
+			if ear {
+				tris = append(tris, [3]int{a, b, c})
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				clipped = true
+			}
+		}
+		if !clipped {
+			// Self-intersecting or degenerate - cut a corner anyway
+			tris = append(tris, [3]int{remaining[m-1], remaining[0], remaining[1]})
+			remaining = remaining[1:]
+		}
+	}
+	return append(tris, [3]int{remaining[0], remaining[1], remaining[2]})
+}
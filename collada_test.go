@@ -0,0 +1,230 @@
+package goglutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// An L-shaped hexagon, as one polygon through <vertices>, with UVs whose
+// accessor skips a component; a triangle and a line sharing its corners;
+// and a quad and triangle as a polylist with two UV sets and colours
+const testCollada = `<?xml version="1.0" encoding="utf-8"?>
+<COLLADA xmlns="http://www.collada.org/2005/11/COLLADASchema" version="1.4.1">
+  <library_geometries>
+    <geometry id="ell-mesh" name="ell">
+      <mesh>
+        <source id="ell-pos">
+          <float_array id="ell-pos-array" count="18">0 0 0  2 0 0  2 1 0  1 1 0  1 2 0  0 2 0</float_array>
+          <technique_common>
+            <accessor source="#ell-pos-array" count="6" stride="3">
+              <param name="X" type="float"/><param name="Y" type="float"/><param name="Z" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <source id="ell-nrm">
+          <float_array id="ell-nrm-array" count="3">0 0 1</float_array>
+          <technique_common>
+            <accessor source="#ell-nrm-array" count="1" stride="3">
+              <param name="X" type="float"/><param name="Y" type="float"/><param name="Z" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <source id="ell-uv">
+          <float_array id="ell-uv-array" count="9">0 0 9  1 0 9  1 1 9</float_array>
+          <technique_common>
+            <accessor source="#ell-uv-array" count="3" stride="3">
+              <param name="S" type="float"/><param name="T" type="float"/><param type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <vertices id="ell-verts">
+          <input semantic="POSITION" source="#ell-pos"/>
+        </vertices>
+        <triangles material="skin" count="1">
+          <input semantic="VERTEX" source="#ell-verts" offset="0"/>
+          <input semantic="NORMAL" source="#ell-nrm" offset="1"/>
+          <input semantic="TEXCOORD" source="#ell-uv" offset="2" set="0"/>
+          <p>0 0 0  1 0 1  2 0 2</p>
+        </triangles>
+        <polygons count="1">
+          <input semantic="VERTEX" source="#ell-verts" offset="0"/>
+          <input semantic="NORMAL" source="#ell-nrm" offset="1"/>
+          <p>0 0 1 0 2 0 3 0 4 0 5 0</p>
+        </polygons>
+        <lines material="wire" count="1">
+          <input semantic="VERTEX" source="#ell-verts" offset="0"/>
+          <p>0 4</p>
+        </lines>
+      </mesh>
+    </geometry>
+    <geometry id="card-mesh" name="card">
+      <mesh>
+        <source id="card-pos">
+          <float_array id="card-pos-array" count="15">0 0 0 1 0 0 1 1 0 0 1 0 0 0 1</float_array>
+          <technique_common>
+            <accessor source="#card-pos-array" count="5" stride="3">
+              <param name="X" type="float"/><param name="Y" type="float"/><param name="Z" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <source id="card-uv0">
+          <float_array id="card-uv0-array" count="2">0.5 0.5</float_array>
+          <technique_common>
+            <accessor source="#card-uv0-array" count="1" stride="2">
+              <param name="S" type="float"/><param name="T" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <source id="card-uv1">
+          <float_array id="card-uv1-array" count="2">0.25 0.75</float_array>
+          <technique_common>
+            <accessor source="#card-uv1-array" count="1" stride="2">
+              <param name="S" type="float"/><param name="T" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <source id="card-col">
+          <float_array id="card-col-array" count="4">1 0 0 1</float_array>
+          <technique_common>
+            <accessor source="#card-col-array" count="1" stride="4">
+              <param name="R" type="float"/><param name="G" type="float"/><param name="B" type="float"/><param name="A" type="float"/>
+            </accessor>
+          </technique_common>
+        </source>
+        <vertices id="card-verts">
+          <input semantic="POSITION" source="#card-pos"/>
+        </vertices>
+        <polylist count="2">
+          <input semantic="VERTEX" source="#card-verts" offset="0"/>
+          <input semantic="TEXCOORD" source="#card-uv0" offset="1" set="1"/>
+          <input semantic="TEXCOORD" source="#card-uv1" offset="1" set="2"/>
+          <input semantic="COLOR" source="#card-col" offset="1"/>
+          <vcount>4 3</vcount>
+          <p>0 0 1 0 2 0 3 0  0 0 1 0 4 0</p>
+        </polylist>
+      </mesh>
+    </geometry>
+  </library_geometries>
+</COLLADA>`
+
+func TestColladaMeshes(t *testing.T) {
+	c, err := ParseCollada([]byte(testCollada))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meshes, err := c.Meshes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meshes) != 2 || meshes[0].Name() != "ell" || meshes[1].Name() != "card" {
+		t.Fatalf("Expected meshes ell and card, have %d", len(meshes))
+	}
+
+	ell := meshes[0]
+	if ell.NumAttributes() != 3 || ell.Attribute(0).Desc() != AttribPosition {
+		t.Fatalf("ell has %d attributes", ell.NumAttributes())
+	}
+	if uv := ell.AttributeByDesc(AttribTexCoord); uv == nil || uv.Stride() != 2 || uv.vec2(1) != (Vec2{1, 0}) {
+		t.Errorf("UVs should skip the unnamed param, have %v", uv)
+	}
+	// Corners only share a vertex when they have the same inputs - the
+	// triangle's have UVs, the polygon's normals, and the line's neither
+	if n := ell.Attribute(0).VertexCount(); n != 11 {
+		t.Errorf("ell has %d vertices, want 11", n)
+	}
+	want := []struct {
+		desc      string
+		primitive gl.Enum
+		entries   int
+	}{{"skin", gl.TRIANGLES, 3}, {"1", gl.TRIANGLES, 12}, {"wire", gl.LINES, 2}}
+	for i, w := range want {
+		indx := ell.Index(i)
+		if indx.Desc() != w.desc || indx.Primitive() != w.primitive || len(indx.Data()) != w.entries {
+			t.Errorf("Index %d is %s with %d entries, want %s with %d", i, indx.Desc(), len(indx.Data()), w.desc, w.entries)
+		}
+	}
+
+	// The concave hexagon is cut into triangles that all face +Z and
+	// cover its area of 3
+	pos := ell.Attribute(0)
+	area := gl.Float(0)
+	for _, tri := range ell.Index(1).Triangles() {
+		a, b, c := pos.vec3(int(tri[0])), pos.vec3(int(tri[1])), pos.vec3(int(tri[2]))
+		z := b.Sub(&a).Cross(c.Sub(&a)).Z
+		if z <= 0 {
+			t.Errorf("Triangle %v faces away", tri)
+		}
+		area += z / 2
+	}
+	if !nearly(area, 3) {
+		t.Errorf("Hexagon triangulates to area %v, want 3", area)
+	}
+
+	card := meshes[1]
+	for _, desc := range []string{AttribTexCoord, AttribTexCoord + "1", AttribColor} {
+		if card.AttributeByDesc(desc) == nil {
+			t.Errorf("card has no %s attribute", desc)
+		}
+	}
+	if uv := card.AttributeByDesc(AttribTexCoord + "1").vec2(0); uv != (Vec2{0.25, 0.75}) {
+		t.Errorf("Second UV set is %v", uv)
+	}
+	if n := card.Attribute(0).VertexCount(); n != 5 || len(card.Index(0).Data()) != 9 {
+		t.Errorf("card has %d vertices and %d index entries, want 5 and 9", n, len(card.Index(0).Data()))
+	}
+}
+
+func TestLoadCollada(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.dae")
+	if err := os.WriteFile(file, []byte(testCollada), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMesh("card")
+	if err := m.LoadCollada(file); err != nil {
+		t.Fatal(err)
+	}
+	if m.Attribute(0).VertexCount() != 5 || m.Name() != "card" {
+		t.Errorf("LoadCollada should load the geometry named after the mesh")
+	}
+	if err := m.LoadCollada(file); err == nil {
+		t.Errorf("LoadCollada should fail on a mesh that already has attributes")
+	}
+	if meshes, err := LoadColladaMeshes(file); err != nil || len(meshes) != 2 {
+		t.Errorf("LoadColladaMeshes gives %d meshes, %v", len(meshes), err)
+	}
+}
+
+func TestColladaBadIndex(t *testing.T) {
+	c, err := ParseCollada([]byte(testCollada))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Library_Geometries.Geometry[1].Mesh.Polylist[0].P = "0 0 1 0 2 0 3 0  0 0 1 0 5 0"
+	if _, err := c.Library_Geometries.Geometry[1].ToMesh(); err == nil {
+		t.Errorf("ToMesh should fail with an index past the end of a source")
+	}
+}
+
+func TestTriangulatePolygon(t *testing.T) {
+	// A comb with two teeth, which fans would get wrong
+	pts := []Vec3{{0, 0, 0}, {3, 0, 0}, {3, 2, 0}, {2, 2, 0}, {2, 1, 0}, {1, 1, 0}, {1, 2, 0}, {0, 2, 0}}
+	tris := triangulatePolygon(pts)
+	if len(tris) != 6 {
+		t.Fatalf("Octagon gives %d triangles, want 6", len(tris))
+	}
+	area := gl.Float(0)
+	for _, tri := range tris {
+		a, b, c := pts[tri[0]], pts[tri[1]], pts[tri[2]]
+		z := b.Sub(&a).Cross(c.Sub(&a)).Z
+		if z < 0 {
+			t.Errorf("Triangle %v is wound backwards", tri)
+		}
+		area += z / 2
+	}
+	if !nearly(area, 5) {
+		t.Errorf("Comb triangulates to area %v, want 5", area)
+	}
+}
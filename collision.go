@@ -0,0 +1,620 @@
+// collision - narrow phase collision tests
+//
+// GJK finds the distance between any two convex shapes, or that they
+// overlap, using nothing but each shape's support function - its furthest
+// point in a given direction.  When they overlap, EPA grows GJK's final
+// simplex out to the surface of the Minkowski difference to find how far
+// they overlap and in which direction.
+//
+// For the commonest pairs there are also separating axis tests (OBB/OBB
+// and triangle/AABB), and sweeps of a moving sphere against spheres,
+// boxes, triangles and a BVH, for movement that mustn't tunnel through
+// thin walls.  All of it works in float64 inside.
+
+package goglutils
+
+import (
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A convex shape, as far as GJK is concerned
+type ConvexShape interface {
+	// The point of the shape furthest in direction dir
+	Support(dir Vec3) Vec3
+}
+
+// Support - the point of the sphere furthest in direction dir
+func (s Sphere) Support(dir Vec3) Vec3 {
+	d := toVec3d(dir).normalize()
+	return toVec3d(s.Center).add(d.scale(float64(s.Radius))).vec3()
+}
+
+// Support - the corner of the box furthest in direction dir
+func (b AABB) Support(dir Vec3) Vec3 {
+	p := b.Min
+	if dir.X > 0 {
+		p.X = b.Max.X
+	}
+	if dir.Y > 0 {
+		p.Y = b.Max.Y
+	}
+	if dir.Z > 0 {
+		p.Z = b.Max.Z
+	}
+	return p
+}
+
+// Support - the corner of the box furthest in direction dir
+func (o OBB) Support(dir Vec3) Vec3 {
+	p := toVec3d(o.Center)
+	h := [3]float64{float64(o.HalfExtents.X), float64(o.HalfExtents.Y), float64(o.HalfExtents.Z)}
+	for k := 0; k < 3; k++ {
+		a := toVec3d(o.Axes[k])
+		if a.dot(toVec3d(dir)) < 0 {
+			a = a.scale(-1)
+		}
+		p = p.add(a.scale(h[k]))
+	}
+	return p.vec3()
+}
+
+// Support - the point of the hull furthest in direction dir
+func (h *ConvexHull) Support(dir Vec3) Vec3 {
+	best, far := Vec3{}, math.Inf(-1)
+	d := toVec3d(dir)
+	for _, p := range h.Points {
+		if x := toVec3d(p).dot(d); x > far {
+			best, far = p, x
+		}
+	}
+	return best
+}
+
+// Contact - how two shapes overlap
+type Contact struct {
+	Normal Vec3     // Unit direction to move b by to separate it from a
+	Depth  gl.Float // How far b has to move
+	PointA Vec3     // Deepest point of a inside b
+	PointB Vec3     // Deepest point of b inside a
+}
+
+// A point of the Minkowski difference a - b, with the points of a and b it
+// came from
+type minkowskiPoint struct {
+	w, a, b vec3d
+}
+
+func minkowskiSupport(a, b ConvexShape, dir vec3d) minkowskiPoint {
+	pa := toVec3d(a.Support(dir.vec3()))
+	pb := toVec3d(b.Support(dir.scale(-1).vec3()))
+	return minkowskiPoint{pa.sub(pb), pa, pb}
+}
+
+// Point in the affine hull of the simplex closest to the origin, as
+// barycentric weights, by least squares.  ok is false if the simplex is
+// degenerate.
+func affineClosest(s []minkowskiPoint) ([]float64, bool) {
+	n := len(s)
+	if n == 1 {
+		return []float64{1}, true
+	}
+	// Minimise |w0 + sum t_i (w_i - w0)|, solving the normal equations
+	m := n - 1
+	var g [3][3]float64
+	var r [3]float64
+	for i := 0; i < m; i++ {
+		ei := s[i+1].w.sub(s[0].w)
+		for j := 0; j < m; j++ {
+			g[i][j] = ei.dot(s[j+1].w.sub(s[0].w))
+		}
+		r[i] = -ei.dot(s[0].w)
+	}
+	t, ok := solveSmall(g, r, m)
+	if !ok {
+		return nil, false
+	}
+	lambda := make([]float64, n)
+	lambda[0] = 1
+	for i := 0; i < m; i++ {
+		lambda[i+1] = t[i]
+		lambda[0] -= t[i]
+	}
+	return lambda, true
+}
+
+// Solves the top-left n x n of g x = r by Gaussian elimination
+func solveSmall(g [3][3]float64, r [3]float64, n int) ([3]float64, bool) {
+	scale := 0.0
+	for i := 0; i < n; i++ {
+		scale = math.Max(scale, math.Abs(g[i][i]))
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(g[row][col]) > math.Abs(g[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(g[pivot][col]) <= 1e-12*scale {
+			return r, false
+		}
+		g[col], g[pivot] = g[pivot], g[col]
+		r[col], r[pivot] = r[pivot], r[col]
+		for row := col + 1; row < n; row++ {
+			f := g[row][col] / g[col][col]
+			for k := col; k < n; k++ {
+				g[row][k] -= f * g[col][k]
+			}
+			r[row] -= f * r[col]
+		}
+	}
+	var x [3]float64
+	for row := n - 1; row >= 0; row-- {
+		v := r[row]
+		for k := row + 1; k < n; k++ {
+			v -= g[row][k] * x[k]
+		}
+		x[row] = v / g[row][row]
+	}
+	return x, true
+}
+
+// The point of the simplex closest to the origin, trying every face of it
+// and keeping the nearest whose closest point lies inside that face.
+// Returns the smallest sub-simplex holding the point and its weights.
+func closestOnSimplex(s []minkowskiPoint) ([]minkowskiPoint, []float64) {
+	var best []minkowskiPoint
+	var bestW []float64
+	bestD := math.Inf(1)
+	for mask := 1; mask < 1<<uint(len(s)); mask++ {
+		var sub []minkowskiPoint
+		for i := range s {
+			if mask&(1<<uint(i)) != 0 {
+				sub = append(sub, s[i])
+			}
+		}
+		lambda, ok := affineClosest(sub)
+		if !ok {
+			continue
+		}
+		inside := true
+		var v vec3d
+		for i, l := range lambda {
+			if l < 0 {
+				inside = false
+				break
+			}
+			v = v.add(sub[i].w.scale(l))
+		}
+		if !inside {
+			continue
+		}
+		if d := v.dot(v); d < bestD || (d == bestD && len(sub) < len(best)) {
+			best, bestW, bestD = sub, lambda, d
+		}
+	}
+	return best, bestW
+}
+
+// Runs GJK.  Returns the final simplex and its weights; the distance is
+// 0 and the simplex surrounds the origin if the shapes overlap.
+func gjk(a, b ConvexShape) ([]minkowskiPoint, []float64, float64) {
+	simplex := []minkowskiPoint{minkowskiSupport(a, b, vec3d{1, 0, 0})}
+	weights := []float64{1}
+	for iter := 0; iter < 64; iter++ {
+		var v vec3d
+		for i, p := range simplex {
+			v = v.add(p.w.scale(weights[i]))
+		}
+		dist := v.length()
+		if len(simplex) == 4 || dist < 1e-12 {
+			return simplex, weights, 0
+		}
+		w := minkowskiSupport(a, b, v.scale(-1))
+		// No support point gets meaningfully closer - converged
+		if dist*dist-v.dot(w.w) <= 1e-10*dist*dist {
+			return simplex, weights, dist
+		}
+		for _, p := range simplex {
+			if p.w == w.w {
+				return simplex, weights, dist
+			}
+		}
+		next, nw := closestOnSimplex(append(append([]minkowskiPoint(nil), simplex...), w))
+		if next == nil {
+			return simplex, weights, dist
+		}
+		simplex, weights = next, nw
+	}
+	var v vec3d
+	for i, p := range simplex {
+		v = v.add(p.w.scale(weights[i]))
+	}
+	return simplex, weights, v.length()
+}
+
+// GJKIntersect - do the convex shapes overlap?
+func GJKIntersect(a, b ConvexShape) bool {
+	_, _, d := gjk(a, b)
+	return d == 0
+}
+
+// GJKDistance - the distance between the convex shapes and the closest
+// points of each, or 0 if they overlap
+func GJKDistance(a, b ConvexShape) (gl.Float, Vec3, Vec3) {
+	simplex, weights, d := gjk(a, b)
+	var pa, pb vec3d
+	for i, p := range simplex {
+		pa = pa.add(p.a.scale(weights[i]))
+		pb = pb.add(p.b.scale(weights[i]))
+	}
+	return gl.Float(d), pa.vec3(), pb.vec3()
+}
+
+// A face of the EPA polytope
+type epaFace struct {
+	v      [3]int
+	normal vec3d
+	dist   float64
+}
+
+// Penetration - whether the convex shapes overlap and if so, the contact
+// from EPA.  Shapes that only just touch may be reported as apart.
+func Penetration(a, b ConvexShape) (Contact, bool) {
+	simplex, _, d := gjk(a, b)
+	if d > 0 {
+		return Contact{}, false
+	}
+	// EPA needs a tetrahedron around the origin; GJK may have stopped
+	// with the origin on a face, edge or point
+	pts := append([]minkowskiPoint(nil), simplex...)
+	for len(pts) < 4 {
+		var dirs []vec3d
+		switch len(pts) {
+		case 1:
+			dirs = []vec3d{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}}
+		case 2:
+			e := pts[1].w.sub(pts[0].w)
+			u := toVec3d(perpendicular(e.normalize().vec3()))
+			v := e.cross(u)
+			dirs = []vec3d{u, u.scale(-1), v, v.scale(-1)}
+		case 3:
+			n := pts[1].w.sub(pts[0].w).cross(pts[2].w.sub(pts[0].w))
+			dirs = []vec3d{n, n.scale(-1)}
+		}
+		added := false
+		for _, dir := range dirs {
+			if p := minkowskiSupport(a, b, dir); offSimplex(pts, p.w) {
+				pts = append(pts, p)
+				added = true
+				break
+			}
+		}
+		if !added {
+			// The shapes are flat, so can only touch
+			return Contact{}, false
+		}
+	}
+	if pts[1].w.sub(pts[0].w).cross(pts[2].w.sub(pts[0].w)).dot(pts[3].w.sub(pts[0].w)) > 0 {
+		pts[1], pts[2] = pts[2], pts[1]
+	}
+
+	var faces []epaFace
+	addFace := func(i, j, k int) {
+		n := pts[j].w.sub(pts[i].w).cross(pts[k].w.sub(pts[i].w)).normalize()
+		faces = append(faces, epaFace{[3]int{i, j, k}, n, n.dot(pts[i].w)})
+	}
+	addFace(0, 1, 2)
+	addFace(0, 3, 1)
+	addFace(1, 3, 2)
+	addFace(2, 3, 0)
+
+	for iter := 0; iter < 64; iter++ {
+		closest := 0
+		for i, f := range faces {
+			if f.dist < faces[closest].dist {
+				closest = i
+			}
+		}
+		f := faces[closest]
+		p := minkowskiSupport(a, b, f.normal)
+		if p.w.dot(f.normal)-f.dist < 1e-6*math.Max(1, f.dist) || iter == 63 {
+			return epaContact(pts, f), true
+		}
+		// Remove the faces p can see, and patch the hole from the horizon
+		pts = append(pts, p)
+		n := len(pts) - 1
+		edges := make(map[[2]int]bool)
+		kept := faces[:0]
+		for _, g := range faces {
+			if g.normal.dot(p.w)-g.dist > 0 {
+				for k := 0; k < 3; k++ {
+					e := [2]int{g.v[k], g.v[(k+1)%3]}
+					if edges[[2]int{e[1], e[0]}] {
+						delete(edges, [2]int{e[1], e[0]})
+					} else {
+						edges[e] = true
+					}
+				}
+				continue
+			}
+			kept = append(kept, g)
+		}
+		faces = kept
+		for e := range edges {
+			addFace(e[0], e[1], n)
+		}
+		if len(faces) == 0 {
+			return Contact{}, false
+		}
+	}
+	return Contact{}, false
+}
+
+// Is p clear of the point, line or plane through the simplex?
+func offSimplex(pts []minkowskiPoint, p vec3d) bool {
+	d := p.sub(pts[0].w)
+	switch len(pts) {
+	case 2:
+		d = d.cross(pts[1].w.sub(pts[0].w).normalize())
+	case 3:
+		n := pts[1].w.sub(pts[0].w).cross(pts[2].w.sub(pts[0].w)).normalize()
+		d = n.scale(n.dot(d))
+	}
+	return d.length() > 1e-9
+}
+
+// The contact for the polytope face nearest the origin
+func epaContact(pts []minkowskiPoint, f epaFace) Contact {
+	// Barycentric weights of the origin's projection on the face
+	p := f.normal.scale(f.dist)
+	a, b, c := pts[f.v[0]], pts[f.v[1]], pts[f.v[2]]
+	v0, v1, v2 := b.w.sub(a.w), c.w.sub(a.w), p.sub(a.w)
+	d00, d01, d11 := v0.dot(v0), v0.dot(v1), v1.dot(v1)
+	d20, d21 := v2.dot(v0), v2.dot(v1)
+	den := d00*d11 - d01*d01
+	u, v := 0.0, 0.0
+	if den != 0 {
+		u = (d11*d20 - d01*d21) / den
+		v = (d00*d21 - d01*d20) / den
+	}
+	w := 1 - u - v
+	pa := a.a.scale(w).add(b.a.scale(u)).add(c.a.scale(v))
+	pb := a.b.scale(w).add(b.b.scale(u)).add(c.b.scale(v))
+	return Contact{
+		Normal: f.normal.vec3(),
+		Depth:  gl.Float(f.dist),
+		PointA: pa.vec3(),
+		PointB: pb.vec3(),
+	}
+}
+
+// SATOBB - separating axis test of two oriented boxes over the 15
+// possible axes: three face normals of each and the nine cross products
+// of their edges.  If they overlap, also returns the axis of least
+// overlap, pointing from a to b, and the overlap along it.
+func SATOBB(a, b OBB) (Vec3, gl.Float, bool) {
+	var axes []vec3d
+	for k := 0; k < 3; k++ {
+		axes = append(axes, toVec3d(a.Axes[k]), toVec3d(b.Axes[k]))
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			axes = append(axes, toVec3d(a.Axes[i]).cross(toVec3d(b.Axes[j])))
+		}
+	}
+	radius := func(o OBB, axis vec3d) float64 {
+		h := [3]float64{float64(o.HalfExtents.X), float64(o.HalfExtents.Y), float64(o.HalfExtents.Z)}
+		r := 0.0
+		for k := 0; k < 3; k++ {
+			r += h[k] * math.Abs(toVec3d(o.Axes[k]).dot(axis))
+		}
+		return r
+	}
+	t := toVec3d(b.Center).sub(toVec3d(a.Center))
+	var best vec3d
+	bestDepth := math.Inf(1)
+	for _, axis := range axes {
+		// Parallel edges give no axis
+		l := axis.length()
+		if l < 1e-9 {
+			continue
+		}
+		axis = axis.scale(1 / l)
+		dist := t.dot(axis)
+		overlap := radius(a, axis) + radius(b, axis) - math.Abs(dist)
+		if overlap < 0 {
+			return Vec3{}, 0, false
+		}
+		if overlap < bestDepth {
+			if dist < 0 {
+				axis = axis.scale(-1)
+			}
+			best, bestDepth = axis, overlap
+		}
+	}
+	return best.vec3(), gl.Float(bestDepth), true
+}
+
+// TriangleOverlapsAABB - separating axis test of a triangle and a box
+func TriangleOverlapsAABB(tri [3]Vec3, box AABB) bool {
+	return triangleOverlapsAABB(tri, box)
+}
+
+// First t >= 0 at which a ray meets a sphere, 0 if it starts inside
+func raySphere(o, d, c vec3d, r float64) (float64, bool) {
+	m := o.sub(c)
+	cc := m.dot(m) - r*r
+	if cc <= 0 {
+		return 0, true
+	}
+	b := m.dot(d)
+	a := d.dot(d)
+	if b > 0 || a == 0 {
+		return 0, false
+	}
+	disc := b*b - a*cc
+	if disc < 0 {
+		return 0, false
+	}
+	return (-b - math.Sqrt(disc)) / a, true
+}
+
+// First t >= 0 at which a ray meets a capsule around segment p-q, 0 if it
+// starts inside
+func rayCapsule(o, d, p, q vec3d, r float64) (float64, bool) {
+	best, hit := math.Inf(1), false
+	try := func(t float64, ok bool) {
+		if ok && t < best {
+			best, hit = t, true
+		}
+	}
+	try(raySphere(o, d, p, r))
+	try(raySphere(o, d, q, r))
+	ab, ao := q.sub(p), o.sub(p)
+	ab2 := ab.dot(ab)
+	if ab2 == 0 {
+		return best, hit
+	}
+	abd, abo := ab.dot(d), ab.dot(ao)
+	a := ab2*d.dot(d) - abd*abd
+	b := ab2*ao.dot(d) - abo*abd
+	c := ab2*ao.dot(ao) - abo*abo - r*r*ab2
+	if c <= 0 && abo >= 0 && abo <= ab2 {
+		// Starts inside the cylinder
+		return 0, true
+	}
+	if a > 1e-18 {
+		if disc := b*b - a*c; disc >= 0 {
+			t := (-b - math.Sqrt(disc)) / a
+			if s := abo + t*abd; t >= 0 && s >= 0 && s <= ab2 {
+				try(t, true)
+			}
+		}
+	}
+	return best, hit
+}
+
+// SweepSphereSphere - the fraction t of their moves (0 to 1) at which two
+// moving spheres first touch.  0 if they start touching.
+func SweepSphereSphere(a Sphere, moveA Vec3, b Sphere, moveB Vec3) (gl.Float, bool) {
+	// Move b relative to a and shrink a to a point
+	o := toVec3d(a.Center)
+	d := toVec3d(moveA).sub(toVec3d(moveB))
+	t, ok := raySphere(o, d, toVec3d(b.Center), float64(a.Radius+b.Radius))
+	if !ok || t > 1 {
+		return 0, false
+	}
+	return gl.Float(t), true
+}
+
+// SweepSphereAABB - the fraction t of its move (0 to 1) at which a moving
+// sphere first touches a box.  The box grown by the radius has rounded
+// edges, so is tested as three boxes grown along one axis each and
+// capsules round the twelve edges.
+func SweepSphereAABB(s Sphere, move Vec3, box AABB) (gl.Float, bool) {
+	o, d := toVec3d(s.Center), toVec3d(move)
+	r := s.Radius
+	best, hit := math.Inf(1), false
+	inv := Vec3{1 / move.X, 1 / move.Y, 1 / move.Z}
+	for k := 0; k < 3; k++ {
+		grown := box
+		switch k {
+		case 0:
+			grown.Min.X, grown.Max.X = box.Min.X-r, box.Max.X+r
+		case 1:
+			grown.Min.Y, grown.Max.Y = box.Min.Y-r, box.Max.Y+r
+		case 2:
+			grown.Min.Z, grown.Max.Z = box.Min.Z-r, box.Max.Z+r
+		}
+		if t, ok := grown.intersectRay(s.Center, inv, 1); ok && float64(t) < best {
+			best, hit = float64(t), true
+		}
+	}
+	var corners [8]vec3d
+	for i := range corners {
+		corners[i] = toVec3d(box.Support(Vec3{gl.Float(i&1*2 - 1), gl.Float(i>>1&1*2 - 1), gl.Float(i>>2&1*2 - 1)}))
+	}
+	for i := 0; i < 8; i++ {
+		for k := uint(0); k < 3; k++ {
+			if j := i | 1<<k; j != i {
+				if t, ok := rayCapsule(o, d, corners[i], corners[j], float64(r)); ok && t < best {
+					best, hit = t, true
+				}
+			}
+		}
+	}
+	if !hit || best > 1 {
+		return 0, false
+	}
+	return gl.Float(best), true
+}
+
+// SweepSphereTriangle - the fraction t of its move (0 to 1) at which a
+// moving sphere first touches a triangle, and the unit normal of the
+// contact, pointing back at the sphere
+func SweepSphereTriangle(s Sphere, move Vec3, tri [3]Vec3) (gl.Float, Vec3, bool) {
+	o, d := toVec3d(s.Center), toVec3d(move)
+	r := float64(s.Radius)
+	a, b, c := toVec3d(tri[0]), toVec3d(tri[1]), toVec3d(tri[2])
+	best, hit := math.Inf(1), false
+
+	// The face, moved out by the radius towards the sphere
+	if n := b.sub(a).cross(c.sub(a)).normalize(); n != (vec3d{}) {
+		dist := n.dot(o.sub(a))
+		if dist < 0 {
+			n, dist = n.scale(-1), -dist
+		}
+		var t float64
+		ok := true
+		if dist > r {
+			dn := n.dot(d)
+			if dn >= 0 {
+				ok = false
+			} else {
+				t = (dist - r) / -dn
+			}
+		}
+		if ok {
+			p := o.add(d.scale(t)).sub(n.scale(n.dot(o.add(d.scale(t)).sub(a))))
+			if q := closestOnTriangle(p, tri); q.sub(p).length() < 1e-9*math.Max(1, r) {
+				best, hit = t, true
+			}
+		}
+	}
+	// The edges and corners
+	for k := 0; k < 3; k++ {
+		p, q := toVec3d(tri[k]), toVec3d(tri[(k+1)%3])
+		if t, ok := rayCapsule(o, d, p, q, r); ok && t < best {
+			best, hit = t, true
+		}
+	}
+	if !hit || best > 1 {
+		return 0, Vec3{}, false
+	}
+	centre := o.add(d.scale(best))
+	normal := centre.sub(closestOnTriangle(centre, tri)).normalize()
+	return gl.Float(best), normal.vec3(), true
+}
+
+// SweepSphere - the first triangle of the tree a moving sphere touches,
+// with T the fraction of the move (0 to 1) it gets through first
+func (b *BVH) SweepSphere(s Sphere, move Vec3) (RayHit, bool) {
+	end := *s.Center.Add(&move)
+	region := AABB{s.Center, s.Center}.AddPoint(end).Grow(s.Radius)
+	best := RayHit{T: 2}
+	found := false
+	for _, t := range b.QueryAABB(region) {
+		if f, _, ok := SweepSphereTriangle(s, move, b.Triangle(t)); ok && f < best.T {
+			best = RayHit{Triangle: t, T: f}
+			found = true
+		}
+	}
+	if found {
+		centre := *s.Center.Add(move.MulS(best.T))
+		best.Point = closestOnTriangle(toVec3d(centre), b.Triangle(best.Triangle)).vec3()
+	}
+	return best, found
+}
@@ -0,0 +1,125 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+func TestGJKDistance(t *testing.T) {
+	a := Sphere{Vec3{0, 0, 0}, 1}
+	b := Sphere{Vec3{5, 0, 0}, 2}
+	d, pa, pb := GJKDistance(a, b)
+	if !near(float64(d), 2, 1e-4) || !near(float64(pa.X), 1, 1e-3) || !near(float64(pb.X), 3, 1e-3) {
+		t.Errorf("Sphere distance %v between %v and %v, want 2 between x=1 and x=3", d, pa, pb)
+	}
+
+	box := AABB{Vec3{-1, -1, -1}, Vec3{1, 1, 1}}
+	obb := OBB{Vec3{3, 3, 0}, [3]Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, Vec3{1, 1, 1}}
+	if d, _, _ := GJKDistance(box, obb); !near(float64(d), math.Sqrt2, 1e-4) {
+		t.Errorf("Box distance %v, want sqrt 2", d)
+	}
+	if GJKIntersect(box, obb) {
+		t.Errorf("Boxes apart should not intersect")
+	}
+	obb.Center = Vec3{1.5, 1.5, 0.5}
+	if !GJKIntersect(box, obb) {
+		t.Errorf("Overlapping boxes should intersect")
+	}
+
+	hull, _ := NewIcosphereMesh(1, 2).ConvexHull()
+	if !GJKIntersect(hull, Sphere{Vec3{0, 0, 1.5}, 0.6}) || GJKIntersect(hull, Sphere{Vec3{0, 0, 1.5}, 0.4}) {
+		t.Errorf("Hull against sphere gives the wrong answer")
+	}
+}
+
+func TestPenetration(t *testing.T) {
+	a := Sphere{Vec3{0, 0, 0}, 1}
+	b := Sphere{Vec3{1.5, 0, 0}, 1}
+	c, ok := Penetration(a, b)
+	if !ok {
+		t.Fatalf("Overlapping spheres give no contact")
+	}
+	if !near(float64(c.Depth), 0.5, 0.02) || c.Normal.X < 0.99 {
+		t.Errorf("Sphere contact %+v, want depth 0.5 along +x", c)
+	}
+
+	box := AABB{Vec3{-1, -1, -1}, Vec3{1, 1, 1}}
+	other := AABB{Vec3{-0.5, 0.8, -0.5}, Vec3{0.5, 2, 0.5}}
+	c, ok = Penetration(box, other)
+	if !ok || !near(float64(c.Depth), 0.2, 1e-4) || !near(float64(c.Normal.Y), 1, 1e-4) {
+		t.Errorf("Box contact %+v %v, want depth 0.2 along +y", c, ok)
+	}
+	if _, ok := Penetration(box, Sphere{Vec3{3, 0, 0}, 1}); ok {
+		t.Errorf("Shapes apart have a contact")
+	}
+}
+
+func TestSATOBB(t *testing.T) {
+	rot := RotateZ(45)
+	a := OBB{Vec3{0, 0, 0}, [3]Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, Vec3{1, 1, 1}}
+	b := a.Transform(rot)
+	b.Center = Vec3{2.3, 0, 0}
+	// The rotated box reaches sqrt 2 along x, so they overlap by about 0.11
+	n, depth, ok := SATOBB(a, b)
+	if !ok || !near(float64(depth), math.Sqrt2+1-2.3, 1e-4) || n.X < 0.99 {
+		t.Errorf("OBB overlap %v along %v (%v), want %v along +x", depth, n, ok, math.Sqrt2+1-2.3)
+	}
+	b.Center.X = 2.5
+	if _, _, ok := SATOBB(a, b); ok {
+		t.Errorf("OBBs apart overlap")
+	}
+}
+
+func TestTriangleOverlapsAABB(t *testing.T) {
+	box := AABB{Vec3{0, 0, 0}, Vec3{1, 1, 1}}
+	if !TriangleOverlapsAABB([3]Vec3{{-1, 0.5, -1}, {2, 0.5, -1}, {0.5, 0.5, 2}}, box) {
+		t.Errorf("Triangle through the box should overlap it")
+	}
+	if !TriangleOverlapsAABB([3]Vec3{{1.5, 0, 0.5}, {0, 1.5, 0.5}, {2, 2, 0.5}}, box) {
+		t.Errorf("Triangle across a corner should overlap")
+	}
+	// Bounding boxes overlap, but the triangle passes by the corner
+	if TriangleOverlapsAABB([3]Vec3{{2.1, 0, 0.5}, {0, 2.1, 0.5}, {2, 2, 0.5}}, box) {
+		t.Errorf("Triangle beyond the corner should not overlap")
+	}
+}
+
+func TestSweepSphere(t *testing.T) {
+	s := Sphere{Vec3{0, 0, 0}, 1}
+	if f, ok := SweepSphereSphere(s, Vec3{10, 0, 0}, Sphere{Vec3{5, 0, 0}, 1}, Vec3{}); !ok || !near(float64(f), 0.3, 1e-5) {
+		t.Errorf("Sphere sweep hits at %v %v, want 0.3", f, ok)
+	}
+
+	box := AABB{Vec3{4, -1, -1}, Vec3{5, 1, 1}}
+	if f, ok := SweepSphereAABB(s, Vec3{10, 0, 0}, box); !ok || !near(float64(f), 0.3, 1e-5) {
+		t.Errorf("Box face sweep hits at %v %v, want 0.3", f, ok)
+	}
+	// Just clipping the rounded edge
+	start := Sphere{Vec3{0, 1.5, 0}, 1}
+	f, ok := SweepSphereAABB(start, Vec3{10, 0, 0}, box)
+	want := (4 - math.Sqrt(1-0.25)) / 10
+	if !ok || !near(float64(f), want, 1e-5) {
+		t.Errorf("Box edge sweep hits at %v %v, want %v", f, ok, want)
+	}
+	if _, ok := SweepSphereAABB(Sphere{Vec3{0, 2.1, 0}, 1}, Vec3{10, 0, 0}, box); ok {
+		t.Errorf("Sweep past the box hits it")
+	}
+
+	// A thin wall the sphere would tunnel through in one step
+	wall := [3]Vec3{{3, -10, -10}, {3, 10, -10}, {3, 0, 10}}
+	f, n, ok := SweepSphereTriangle(s, Vec3{10, 0, 0}, wall)
+	if !ok || !near(float64(f), 0.2, 1e-5) || n.X > -0.99 {
+		t.Errorf("Wall sweep hits at %v %v with normal %v, want 0.2 facing -x", f, ok, n)
+	}
+
+	m := NewCubeMesh(2, 1)
+	bvh, _ := NewBVH(m, 0)
+	hit, ok := bvh.SweepSphere(Sphere{Vec3{0, 5, 0}, 0.5}, Vec3{0, -10, 0})
+	if !ok || !near(float64(hit.T), 0.35, 1e-5) || !near(float64(hit.Point.Y), 1, 1e-5) {
+		t.Errorf("BVH sweep hits %+v %v, want 0.35 on the top face", hit, ok)
+	}
+}
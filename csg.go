@@ -0,0 +1,450 @@
+// csg - boolean operations on closed meshes
+//
+// Union, Difference and Intersection after Evan Wallace's csg.js: each
+// mesh's triangles go into a BSP tree, each tree clips away the parts of
+// the other's polygons inside (or outside) it, and what's left of both is
+// the result.  Polygons cut by a plane are split, with every attribute of
+// the new vertices - UVs, normals, colours - interpolated along the cut,
+// so textures carry on across the cut faces.  Both meshes must be closed,
+// or inside and outside mean nothing.
+//
+// The result is a new welded Mesh with the first mesh's attributes (the
+// second mesh's vertices get zeros for any it lacks) and one triangle
+// index.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// How close to a plane counts as on it
+const csgEpsilon = 1e-5
+
+type csgPlane struct {
+	normal vec3d
+	w      float64
+}
+
+func (p csgPlane) flip() csgPlane {
+	return csgPlane{p.normal.scale(-1), -p.w}
+}
+
+// A polygon, its vertices' attributes laid out like the result mesh's
+type csgPolygon struct {
+	verts [][]float64
+	plane csgPlane
+}
+
+// The mesh layout the polygons' vertices follow
+type csgLayout struct {
+	attrs   []*MeshAttribute
+	stride  int
+	posAttr int // Number of the position attribute
+	posAt   int
+	nrmAt   int // -1 if there are no normals
+	tanAt   int // -1 if there are no 4 component tangents
+	bitAt   int // -1 if there are no bitangents
+}
+
+func (l *csgLayout) position(v []float64) vec3d {
+	return vec3d{v[l.posAt], v[l.posAt+1], v[l.posAt+2]}
+}
+
+// Turns a polygon over, reversing its winding and its normals
+func (l *csgLayout) flip(p *csgPolygon) {
+	for i, j := 0, len(p.verts)-1; i < j; i, j = i+1, j-1 {
+		p.verts[i], p.verts[j] = p.verts[j], p.verts[i]
+	}
+	for k, v := range p.verts {
+		v = append([]float64(nil), v...)
+		if l.nrmAt >= 0 {
+			for c := 0; c < 3; c++ {
+				v[l.nrmAt+c] = -v[l.nrmAt+c]
+			}
+		}
+		if l.tanAt >= 0 {
+			v[l.tanAt+3] = -v[l.tanAt+3]
+		}
+		p.verts[k] = v
+	}
+	p.plane = p.plane.flip()
+}
+
+// Point types for splitting
+const (
+	csgCoplanar = 0
+	csgFront    = 1
+	csgBack     = 2
+	csgSpanning = 3
+)
+
+// Sorts a polygon against a plane into the four lists, splitting it if it
+// crosses the plane
+func (l *csgLayout) split(pl csgPlane, p csgPolygon, coFront, coBack, front, back *[]csgPolygon) {
+	kind := 0
+	types := make([]int, len(p.verts))
+	for i, v := range p.verts {
+		t := pl.normal.dot(l.position(v)) - pl.w
+		switch {
+		case t < -csgEpsilon:
+			types[i] = csgBack
+		case t > csgEpsilon:
+			types[i] = csgFront
+		}
+		kind |= types[i]
+	}
+	switch kind {
+	case csgCoplanar:
+		if pl.normal.dot(p.plane.normal) > 0 {
+			*coFront = append(*coFront, p)
+		} else {
+			*coBack = append(*coBack, p)
+		}
+	case csgFront:
+		*front = append(*front, p)
+	case csgBack:
+		*back = append(*back, p)
+	default:
+		var f, b [][]float64
+		for i, vi := range p.verts {
+			j := (i + 1) % len(p.verts)
+			ti, tj := types[i], types[j]
+			if ti != csgBack {
+				f = append(f, vi)
+			}
+			if ti != csgFront {
+				b = append(b, vi)
+			}
+			if ti|tj == csgSpanning {
+				vj := p.verts[j]
+				pi, pj := l.position(vi), l.position(vj)
+				t := (pl.w - pl.normal.dot(pi)) / pl.normal.dot(pj.sub(pi))
+				v := make([]float64, len(vi))
+				for c := range v {
+					v[c] = vi[c] + (vj[c]-vi[c])*t
+				}
+				f = append(f, v)
+				b = append(b, v)
+			}
+		}
+		if len(f) >= 3 {
+			*front = append(*front, csgPolygon{f, p.plane})
+		}
+		if len(b) >= 3 {
+			*back = append(*back, csgPolygon{b, p.plane})
+		}
+	}
+}
+
+// A node of a BSP tree
+type csgNode struct {
+	plane       *csgPlane
+	front, back *csgNode
+	polygons    []csgPolygon
+}
+
+func (l *csgLayout) build(n *csgNode, polygons []csgPolygon) {
+	if len(polygons) == 0 {
+		return
+	}
+	if n.plane == nil {
+		pl := polygons[0].plane
+		n.plane = &pl
+	}
+	var front, back []csgPolygon
+	for _, p := range polygons {
+		l.split(*n.plane, p, &n.polygons, &n.polygons, &front, &back)
+	}
+	if len(front) > 0 {
+		if n.front == nil {
+			n.front = new(csgNode)
+		}
+		l.build(n.front, front)
+	}
+	if len(back) > 0 {
+		if n.back == nil {
+			n.back = new(csgNode)
+		}
+		l.build(n.back, back)
+	}
+}
+
+// Turns the solid inside out
+func (l *csgLayout) invert(n *csgNode) {
+	if n == nil {
+		return
+	}
+	for i := range n.polygons {
+		l.flip(&n.polygons[i])
+	}
+	if n.plane != nil {
+		pl := n.plane.flip()
+		n.plane = &pl
+	}
+	l.invert(n.front)
+	l.invert(n.back)
+	n.front, n.back = n.back, n.front
+}
+
+// The parts of the polygons outside the solid n
+func (l *csgLayout) clipPolygons(n *csgNode, polygons []csgPolygon) []csgPolygon {
+	if n.plane == nil {
+		return append([]csgPolygon(nil), polygons...)
+	}
+	var front, back []csgPolygon
+	for _, p := range polygons {
+		l.split(*n.plane, p, &front, &back, &front, &back)
+	}
+	if n.front != nil {
+		front = l.clipPolygons(n.front, front)
+	}
+	if n.back != nil {
+		back = l.clipPolygons(n.back, back)
+	} else {
+		back = nil
+	}
+	return append(front, back...)
+}
+
+// Removes the parts of n's polygons inside the solid other
+func (l *csgLayout) clipTo(n, other *csgNode) {
+	if n == nil {
+		return
+	}
+	n.polygons = l.clipPolygons(other, n.polygons)
+	l.clipTo(n.front, other)
+	l.clipTo(n.back, other)
+}
+
+func allPolygons(n *csgNode) []csgPolygon {
+	if n == nil {
+		return nil
+	}
+	out := append([]csgPolygon(nil), n.polygons...)
+	out = append(out, allPolygons(n.front)...)
+	return append(out, allPolygons(n.back)...)
+}
+
+// Checks the mesh is closed, joining vertices split only by their other
+// attributes
+func (m *Mesh) checkClosed(caller string) error {
+	pos := m.positionAttribute()
+	if pos == nil {
+		return errors.New(fmt.Sprintf("Mesh:%s: Mesh %s has no positions", caller, m.name))
+	}
+	groups := make(map[Vec3]int)
+	group := make([]int, pos.VertexCount())
+	for v := range group {
+		p := pos.vec3(v)
+		g, ok := groups[p]
+		if !ok {
+			g = len(groups)
+			groups[p] = g
+		}
+		group[v] = g
+	}
+	var faces [][]int
+	for _, t := range m.meshTriangles() {
+		a, b, c := group[t.v[0]], group[t.v[1]], group[t.v[2]]
+		if a != b && b != c && c != a {
+			faces = append(faces, []int{a, b, c})
+		}
+	}
+	if len(faces) == 0 {
+		return errors.New(fmt.Sprintf("Mesh:%s: Mesh %s has no triangles", caller, m.name))
+	}
+	hm, err := NewHalfEdgeMesh(len(groups), faces)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Mesh:%s: Mesh %s: %v", caller, m.name, err))
+	}
+	open := 0
+	for h := range hm.HalfEdges {
+		if hm.IsBoundary(h) {
+			open++
+		}
+	}
+	if open > 0 {
+		return errors.New(fmt.Sprintf("Mesh:%s: Mesh %s is not closed - %d edges are open or shared by more than two faces", caller, m.name, open))
+	}
+	return nil
+}
+
+// The layout of m's attributes
+func newCSGLayout(m *Mesh) *csgLayout {
+	l := &csgLayout{attrs: m.attributes, nrmAt: -1, tanAt: -1, bitAt: -1}
+	pos := m.positionAttribute()
+	for i, attr := range m.attributes {
+		switch {
+		case attr == pos:
+			l.posAttr, l.posAt = i, l.stride
+		case attr.desc == AttribNormal && attr.stride >= 3:
+			l.nrmAt = l.stride
+		case attr.desc == AttribTangent && attr.stride == 4:
+			l.tanAt = l.stride
+		case attr.desc == AttribBitangent && attr.stride >= 3:
+			l.bitAt = l.stride
+		}
+		l.stride += attr.stride
+	}
+	return l
+}
+
+// The mesh's triangles as polygons in layout l, taking each attribute of
+// l from the attribute of m with the same description
+func (l *csgLayout) polygons(m *Mesh) []csgPolygon {
+	src := make([]*MeshAttribute, len(l.attrs))
+	for i, attr := range l.attrs {
+		if i == l.posAttr {
+			src[i] = m.positionAttribute()
+		} else {
+			src[i] = m.AttributeByDesc(attr.desc)
+		}
+	}
+	vertex := func(v gl.Uint) []float64 {
+		out := make([]float64, l.stride)
+		offset := 0
+		for i, attr := range l.attrs {
+			if s := src[i]; s != nil {
+				for c := 0; c < attr.stride && c < s.stride; c++ {
+					out[offset+c] = float64(s.data[int(v)*s.stride+c])
+				}
+			}
+			offset += attr.stride
+		}
+		return out
+	}
+	var out []csgPolygon
+	for _, t := range m.meshTriangles() {
+		p := csgPolygon{verts: [][]float64{vertex(t.v[0]), vertex(t.v[1]), vertex(t.v[2])}}
+		a, b, c := l.position(p.verts[0]), l.position(p.verts[1]), l.position(p.verts[2])
+		n := b.sub(a).cross(c.sub(a))
+		if n.length() < 1e-12 {
+			continue
+		}
+		n = n.normalize()
+		p.plane = csgPlane{n, n.dot(a)}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Builds the result mesh from the polygons
+func (l *csgLayout) mesh(name string, polygons []csgPolygon) (*Mesh, error) {
+	data := make([][]gl.Float, len(l.attrs))
+	for _, p := range polygons {
+		for k := 1; k+1 < len(p.verts); k++ {
+			for _, v := range [3][]float64{p.verts[0], p.verts[k], p.verts[k+1]} {
+				// Interpolation shortens unit vectors, and leaves tangent
+				// handedness part way between -1 and 1 where a cut
+				// crosses a mirrored UV seam
+				v = append([]float64(nil), v...)
+				for _, at := range [3]int{l.nrmAt, l.tanAt, l.bitAt} {
+					if at >= 0 {
+						n := vec3d{v[at], v[at+1], v[at+2]}.normalize()
+						copy(v[at:], n[:])
+					}
+				}
+				if l.tanAt >= 0 {
+					v[l.tanAt+3] = math.Copysign(1, v[l.tanAt+3])
+				}
+				offset := 0
+				for i, attr := range l.attrs {
+					for _, f := range v[offset : offset+attr.stride] {
+						data[i] = append(data[i], gl.Float(f))
+					}
+					offset += attr.stride
+				}
+			}
+		}
+	}
+	m := NewMesh(name)
+	for i, attr := range l.attrs {
+		m.AddMeshAttribute(attr.desc, data[i], attr.stride)
+	}
+	if err := m.Weld(0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Sets up both meshes' BSP trees for a boolean operation
+func csgTrees(a, b *Mesh, caller string) (*csgLayout, *csgNode, *csgNode, error) {
+	if err := a.checkClosed(caller); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := b.checkClosed(caller); err != nil {
+		return nil, nil, nil, err
+	}
+	l := newCSGLayout(a)
+	ta, tb := new(csgNode), new(csgNode)
+	l.build(ta, l.polygons(a))
+	l.build(tb, l.polygons(b))
+	return l, ta, tb, nil
+}
+
+// Union - a new mesh of the space inside either mesh
+func (m *Mesh) Union(other *Mesh) (*Mesh, error) {
+	l, a, b, err := csgTrees(m, other, "Union")
+	if err != nil {
+		return nil, err
+	}
+	l.clipTo(a, b)
+	l.clipTo(b, a)
+	l.invert(b)
+	l.clipTo(b, a)
+	l.invert(b)
+	l.build(a, allPolygons(b))
+	return l.mesh(m.name+"-union", allPolygons(a))
+}
+
+// Difference - a new mesh of the space inside this mesh but not the other
+func (m *Mesh) Difference(other *Mesh) (*Mesh, error) {
+	l, a, b, err := csgTrees(m, other, "Difference")
+	if err != nil {
+		return nil, err
+	}
+	l.invert(a)
+	l.clipTo(a, b)
+	l.clipTo(b, a)
+	l.invert(b)
+	l.clipTo(b, a)
+	l.invert(b)
+	l.build(a, allPolygons(b))
+	l.invert(a)
+	return l.mesh(m.name+"-difference", allPolygons(a))
+}
+
+// Intersection - a new mesh of the space inside both meshes
+func (m *Mesh) Intersection(other *Mesh) (*Mesh, error) {
+	l, a, b, err := csgTrees(m, other, "Intersection")
+	if err != nil {
+		return nil, err
+	}
+	l.invert(a)
+	l.clipTo(b, a)
+	l.invert(b)
+	l.clipTo(a, b)
+	l.clipTo(b, a)
+	l.build(a, allPolygons(b))
+	l.invert(a)
+	return l.mesh(m.name+"-intersection", allPolygons(a))
+}
+
+// Signed volume of a closed mesh, negative if it is inside out
+func (m *Mesh) Volume() gl.Float {
+	pos := m.positionAttribute()
+	if pos == nil {
+		return 0
+	}
+	v := 0.0
+	for _, t := range m.meshTriangles() {
+		a, b, c := toVec3d(pos.vec3(int(t.v[0]))), toVec3d(pos.vec3(int(t.v[1]))), toVec3d(pos.vec3(int(t.v[2])))
+		v += a.dot(b.cross(c))
+	}
+	return gl.Float(v / 6)
+}
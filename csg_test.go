@@ -0,0 +1,115 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A cube of sides 2 moved by offset
+func movedCube(offset Vec3) *Mesh {
+	m := NewCubeMesh(2, 1)
+	pos := m.positionAttribute()
+	for i := 0; i < pos.VertexCount(); i++ {
+		pos.data[i*3] += offset.X
+		pos.data[i*3+1] += offset.Y
+		pos.data[i*3+2] += offset.Z
+	}
+	return m
+}
+
+func TestCSG(t *testing.T) {
+	a, b := movedCube(Vec3{}), movedCube(Vec3{1, 1, 1})
+	ops := []struct {
+		name   string
+		op     func(*Mesh) (*Mesh, error)
+		volume gl.Float
+	}{
+		{"Union", a.Union, 15},
+		{"Difference", a.Difference, 7},
+		{"Intersection", a.Intersection, 1},
+	}
+	for _, o := range ops {
+		m, err := o.op(b)
+		if err != nil {
+			t.Fatalf("%s: %v", o.name, err)
+		}
+		if v := m.Volume(); math.Abs(float64(v-o.volume)) > 1e-4 {
+			t.Errorf("%s has volume %v, want %v", o.name, v, o.volume)
+		}
+		if m.NumAttributes() != a.NumAttributes() || m.NumIndices() != 1 {
+			t.Errorf("%s has %d attributes and %d indices", o.name, m.NumAttributes(), m.NumIndices())
+		}
+		pos, nrm, uv := m.positionAttribute(), m.AttributeByDesc(AttribNormal), m.AttributeByDesc(AttribTexCoord)
+		for _, tri := range m.meshTriangles() {
+			p0, p1, p2 := pos.vec3(int(tri.v[0])), pos.vec3(int(tri.v[1])), pos.vec3(int(tri.v[2]))
+			face := p1.Sub(&p0).Cross(p2.Sub(&p0))
+			for _, v := range tri.v {
+				n := nrm.vec3(int(v))
+				if face.Dot(&n) <= 0 {
+					t.Fatalf("%s: Vertex normal %v faces away from its triangle", o.name, n)
+				}
+				if c := uv.vec2(int(v)); c.X < -1e-5 || c.X > 1+1e-5 || c.Y < -1e-5 || c.Y > 1+1e-5 {
+					t.Fatalf("%s: Cut vertex has UV %v outside its face", o.name, c)
+				}
+			}
+		}
+	}
+}
+
+// Cut faces take the UVs of the point they're at on the original face
+func TestCSGInterpolatesUVs(t *testing.T) {
+	m, err := movedCube(Vec3{}).Difference(movedCube(Vec3{1, 1, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, uv := m.positionAttribute(), m.AttributeByDesc(AttribTexCoord)
+	found := false
+	for i := 0; i < pos.VertexCount(); i++ {
+		// The cut at (0, -1, 0) on the +Z face sits half way across it
+		if pos.vec3(i) == (Vec3{0, -1, 1}) && m.AttributeByDesc(AttribNormal).vec3(i) == (Vec3{0, 0, 1}) {
+			found = true
+			if c := uv.vec2(i); math.Abs(float64(c.X-0.5)) > 1e-5 || math.Abs(float64(c.Y)) > 1e-5 {
+				t.Errorf("Cut vertex has UV %v, want {0.5 0}", c)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("No vertex where the cut meets the +Z face's bottom edge")
+	}
+}
+
+// Cut vertices get unit normals, tangents and bitangents, and tangent
+// handedness of 1 or -1
+func TestCSGRenormalises(t *testing.T) {
+	m := NewMesh("frame")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0}, 3)
+	m.AddMeshAttribute(AttribNormal, []gl.Float{0, 0, 1}, 3)
+	m.AddMeshAttribute(AttribTangent, []gl.Float{1, 0, 0, 1}, 4)
+	m.AddMeshAttribute(AttribBitangent, []gl.Float{0, 1, 0}, 3)
+	l := newCSGLayout(m)
+	// Half way across a mirrored seam
+	cut := func(x float64) []float64 {
+		return []float64{x, 0, 0, 0, 0, 0.5, 0.5, 0, 0, -0.2, 0, 0.5, 0}
+	}
+	out, err := l.mesh("cut", []csgPolygon{{verts: [][]float64{cut(0), cut(1), {0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 0, 1, 0}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := out.AttributeByDesc(AttribNormal).vec3(0); !closeVec3(n, Vec3{0, 0, 1}) {
+		t.Errorf("Cut normal is %v, want {0 0 1}", n)
+	}
+	if tg := out.AttributeByDesc(AttribTangent).Data()[:4]; !closeVec3(Vec3{tg[0], tg[1], tg[2]}, Vec3{1, 0, 0}) || tg[3] != -1 {
+		t.Errorf("Cut tangent is %v, want {1 0 0 -1}", tg)
+	}
+	if b := out.AttributeByDesc(AttribBitangent).vec3(0); !closeVec3(b, Vec3{0, 1, 0}) {
+		t.Errorf("Cut bitangent is %v, want {0 1 0}", b)
+	}
+}
+
+func TestCSGOpenMesh(t *testing.T) {
+	if _, err := NewCubeMesh(1, 1).Union(NewPlaneMesh(1, 1, 1, 1)); err == nil {
+		t.Errorf("Union with an open mesh should fail")
+	}
+}
@@ -0,0 +1,582 @@
+// halfedge - half-edge adjacency for polygon meshes
+//
+// A HalfEdgeMesh is built from a list of faces, each a list of vertex
+// numbers in counter-clockwise order.  Every face is a loop of half-edges,
+// each running from its origin vertex to the origin of the next, and
+// paired with its twin running the other way in the neighbouring face.
+// Half-edges on a border have no twin (Twin is -1), and neither do the
+// half-edges of a non-manifold edge - one shared by more than two faces,
+// or by two faces wound in the same direction - so everything else can
+// treat those as borders.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A half-edge.  Each field is a number into the HalfEdgeMesh's lists, or
+// -1 for none.
+type HalfEdge struct {
+	Origin int // Vertex the half-edge leaves from
+	Twin   int // The half-edge running the other way, -1 on a border
+	Next   int // Next half-edge around the face
+	Prev   int // Previous half-edge around the face
+	Face   int // Face the half-edge belongs to
+}
+
+// HalfEdgeMesh - the connectivity of a polygon mesh
+type HalfEdgeMesh struct {
+	HalfEdges     []HalfEdge
+	Faces         []int   // First half-edge of each face
+	outgoing      [][]int // Half-edges leaving each vertex
+	edges         []int   // One half-edge of each edge
+	edgeOf        []int   // Edge of each half-edge
+	nonManifold   []int   // Half-edges left without a twin by a non-manifold edge
+	nonManifoldAt []bool  // Whether each half-edge is in nonManifold
+}
+
+// NewHalfEdgeMesh - builds the half-edge structure for the given faces
+// over vertices numbered 0 to vertices-1
+func NewHalfEdgeMesh(vertices int, faces [][]int) (*HalfEdgeMesh, error) {
+	hm := new(HalfEdgeMesh)
+	hm.outgoing = make([][]int, vertices)
+	hm.Faces = make([]int, len(faces))
+	for f, face := range faces {
+		if len(face) < 3 {
+			return nil, errors.New(fmt.Sprintf("HalfEdgeMesh: Face %d has only %d vertices", f, len(face)))
+		}
+		first := len(hm.HalfEdges)
+		hm.Faces[f] = first
+		for k, v := range face {
+			if v < 0 || v >= vertices {
+				return nil, errors.New(fmt.Sprintf("HalfEdgeMesh: Face %d uses vertex %d of %d", f, v, vertices))
+			}
+			for _, w := range face[:k] {
+				if w == v {
+					return nil, errors.New(fmt.Sprintf("HalfEdgeMesh: Face %d uses vertex %d twice", f, v))
+				}
+			}
+			n := len(face)
+			hm.HalfEdges = append(hm.HalfEdges, HalfEdge{
+				Origin: v,
+				Twin:   -1,
+				Next:   first + (k+1)%n,
+				Prev:   first + (k+n-1)%n,
+				Face:   f,
+			})
+			hm.outgoing[v] = append(hm.outgoing[v], first+k)
+		}
+	}
+
+	// Pair up half-edges by the edge they run along
+	byEdge := make(map[[2]int][]int)
+	for h := range hm.HalfEdges {
+		a, b := hm.HalfEdges[h].Origin, hm.Dest(h)
+		if b < a {
+			a, b = b, a
+		}
+		byEdge[[2]int{a, b}] = append(byEdge[[2]int{a, b}], h)
+	}
+	hm.nonManifoldAt = make([]bool, len(hm.HalfEdges))
+	for h := range hm.HalfEdges {
+		a, b := hm.HalfEdges[h].Origin, hm.Dest(h)
+		if b < a {
+			a, b = b, a
+		}
+		list := byEdge[[2]int{a, b}]
+		switch {
+		case len(list) == 1:
+		case len(list) == 2 && hm.HalfEdges[list[0]].Origin != hm.HalfEdges[list[1]].Origin:
+			if list[0] == h {
+				hm.HalfEdges[h].Twin = list[1]
+			} else {
+				hm.HalfEdges[h].Twin = list[0]
+			}
+		default:
+			hm.nonManifold = append(hm.nonManifold, h)
+			hm.nonManifoldAt[h] = true
+		}
+	}
+
+	hm.edgeOf = make([]int, len(hm.HalfEdges))
+	for h, he := range hm.HalfEdges {
+		if he.Twin >= 0 && he.Twin < h {
+			hm.edgeOf[h] = hm.edgeOf[he.Twin]
+			continue
+		}
+		hm.edgeOf[h] = len(hm.edges)
+		hm.edges = append(hm.edges, h)
+	}
+	return hm, nil
+}
+
+// HalfEdgeMesh - builds the half-edge structure for the triangles of the
+// given index
+func (m *Mesh) HalfEdgeMesh(index int) (*HalfEdgeMesh, error) {
+	if index < 0 || index >= len(m.indices) {
+		return nil, errors.New(fmt.Sprintf("Mesh:HalfEdgeMesh: No index %d", index))
+	}
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return nil, err
+	}
+	return NewHalfEdgeMesh(vertices, trianglesToFaces(m.indices[index].Triangles()))
+}
+
+func trianglesToFaces(tris [][3]gl.Uint) [][]int {
+	faces := make([][]int, len(tris))
+	for i, t := range tris {
+		faces[i] = []int{int(t[0]), int(t[1]), int(t[2])}
+	}
+	return faces
+}
+
+// Number of vertices, including any no face uses
+func (hm *HalfEdgeMesh) NumVertices() int {
+	return len(hm.outgoing)
+}
+
+// Number of faces
+func (hm *HalfEdgeMesh) NumFaces() int {
+	return len(hm.Faces)
+}
+
+// Number of edges.  Each half-edge of a non-manifold edge counts as an
+// edge of its own.
+func (hm *HalfEdgeMesh) NumEdges() int {
+	return len(hm.edges)
+}
+
+// The vertex half-edge h runs to
+func (hm *HalfEdgeMesh) Dest(h int) int {
+	return hm.HalfEdges[hm.HalfEdges[h].Next].Origin
+}
+
+// The edge half-edge h runs along
+func (hm *HalfEdgeMesh) Edge(h int) int {
+	return hm.edgeOf[h]
+}
+
+// One of the half-edges of edge e - the one without a twin, if it's on a
+// border
+func (hm *HalfEdgeMesh) EdgeHalfEdge(e int) int {
+	return hm.edges[e]
+}
+
+// Is half-edge h on a border (or a non-manifold edge)?
+func (hm *HalfEdgeMesh) IsBoundary(h int) bool {
+	return hm.HalfEdges[h].Twin < 0
+}
+
+// The half-edges around face f, in order
+func (hm *HalfEdgeMesh) FaceHalfEdges(f int) []int {
+	var out []int
+	h := hm.Faces[f]
+	for {
+		out = append(out, h)
+		h = hm.HalfEdges[h].Next
+		if h == hm.Faces[f] {
+			return out
+		}
+	}
+}
+
+// The vertices of face f, in order
+func (hm *HalfEdgeMesh) FaceVertices(f int) []int {
+	hs := hm.FaceHalfEdges(f)
+	out := make([]int, len(hs))
+	for i, h := range hs {
+		out[i] = hm.HalfEdges[h].Origin
+	}
+	return out
+}
+
+// The half-edges leaving vertex v, in no particular order
+func (hm *HalfEdgeMesh) Outgoing(v int) []int {
+	return hm.outgoing[v]
+}
+
+// The vertices joined to v by an edge, in no particular order
+func (hm *HalfEdgeMesh) Neighbours(v int) []int {
+	var out []int
+	seen := make(map[int]bool)
+	add := func(n int) {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	for _, h := range hm.outgoing[v] {
+		add(hm.Dest(h))
+		// A border edge coming in has no outgoing twin to find it by
+		if in := hm.HalfEdges[h].Prev; hm.IsBoundary(in) {
+			add(hm.HalfEdges[in].Origin)
+		}
+	}
+	return out
+}
+
+// Does vertex v lie on a border?
+func (hm *HalfEdgeMesh) IsBoundaryVertex(v int) bool {
+	for _, h := range hm.outgoing[v] {
+		if hm.IsBoundary(h) || hm.IsBoundary(hm.HalfEdges[h].Prev) {
+			return true
+		}
+	}
+	return false
+}
+
+// The outgoing half-edges of v in order around it, starting from a
+// border if v is on one.  ok is false if v is non-manifold - its faces
+// don't make a single fan - in which case the half-edges are unordered.
+func (hm *HalfEdgeMesh) fan(v int) (fan []int, ok bool) {
+	out := hm.outgoing[v]
+	if len(out) == 0 {
+		return nil, true
+	}
+	start := out[0]
+	for _, h := range out {
+		if hm.IsBoundary(h) {
+			start = h
+			break
+		}
+	}
+	for h := start; ; {
+		fan = append(fan, h)
+		in := hm.HalfEdges[h].Prev
+		if hm.IsBoundary(in) {
+			break
+		}
+		h = hm.HalfEdges[in].Twin
+		if h == start || len(fan) > len(out) {
+			break
+		}
+	}
+	if len(fan) != len(out) {
+		return out, false
+	}
+	return fan, true
+}
+
+// OneRing - the neighbours of v in order around it.  On a border the ring
+// runs from one border neighbour to the other.  Non-manifold vertices get
+// their neighbours in no particular order.
+func (hm *HalfEdgeMesh) OneRing(v int) []int {
+	fan, ok := hm.fan(v)
+	if !ok {
+		return hm.Neighbours(v)
+	}
+	ring := make([]int, 0, len(fan)+1)
+	for _, h := range fan {
+		ring = append(ring, hm.Dest(h))
+	}
+	if len(fan) > 0 {
+		if in := hm.HalfEdges[fan[len(fan)-1]].Prev; hm.IsBoundary(in) {
+			ring = append(ring, hm.HalfEdges[in].Origin)
+		}
+	}
+	return ring
+}
+
+// VertexFaces - the faces around v, in the same order as OneRing
+func (hm *HalfEdgeMesh) VertexFaces(v int) []int {
+	fan, _ := hm.fan(v)
+	faces := make([]int, len(fan))
+	for i, h := range fan {
+		faces[i] = hm.HalfEdges[h].Face
+	}
+	return faces
+}
+
+// FaceNeighbours - the face across each edge of f, in the order of
+// FaceHalfEdges, or -1 across a border
+func (hm *HalfEdgeMesh) FaceNeighbours(f int) []int {
+	hs := hm.FaceHalfEdges(f)
+	out := make([]int, len(hs))
+	for i, h := range hs {
+		out[i] = -1
+		if t := hm.HalfEdges[h].Twin; t >= 0 {
+			out[i] = hm.HalfEdges[t].Face
+		}
+	}
+	return out
+}
+
+// Is half-edge h one of a non-manifold edge's?
+func (hm *HalfEdgeMesh) isNonManifold(h int) bool {
+	return hm.nonManifoldAt[h]
+}
+
+// NonManifoldEdges - the edges shared by more than two faces, or by two
+// faces wound the same way, as pairs of vertices
+func (hm *HalfEdgeMesh) NonManifoldEdges() [][2]int {
+	var out [][2]int
+	seen := make(map[[2]int]bool)
+	for _, h := range hm.nonManifold {
+		key := edgeKey(hm.HalfEdges[h].Origin, hm.Dest(h))
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// NonManifoldVertices - vertices whose faces don't form a single fan,
+// such as the tip where two cones touch
+func (hm *HalfEdgeMesh) NonManifoldVertices() []int {
+	var out []int
+	for v := range hm.outgoing {
+		if _, ok := hm.fan(v); !ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// IsManifold - true if every edge has at most two faces, consistently
+// wound, and every vertex a single fan of faces
+func (hm *HalfEdgeMesh) IsManifold() bool {
+	return len(hm.nonManifold) == 0 && len(hm.NonManifoldVertices()) == 0
+}
+
+// BoundaryLoops - the borders of the mesh, each as a loop of vertices
+// running the same way as the faces beside them.  Non-manifold edges
+// aren't counted as borders.
+func (hm *HalfEdgeMesh) BoundaryLoops() [][]int {
+	var loops [][]int
+	done := make([]bool, len(hm.HalfEdges))
+	for start := range hm.HalfEdges {
+		if done[start] || !hm.IsBoundary(start) || hm.isNonManifold(start) {
+			continue
+		}
+		var loop []int
+		for h := start; !done[h]; {
+			done[h] = true
+			loop = append(loop, hm.HalfEdges[h].Origin)
+			// Turn around the end vertex until the next border
+			next := hm.HalfEdges[h].Next
+			for steps := 0; !hm.IsBoundary(next) && steps < len(hm.HalfEdges); steps++ {
+				next = hm.HalfEdges[hm.HalfEdges[next].Twin].Next
+			}
+			if !hm.IsBoundary(next) || hm.isNonManifold(next) {
+				break
+			}
+			h = next
+		}
+		loops = append(loops, loop)
+	}
+	return loops
+}
+
+// ConnectedComponents - the faces, split into groups that are joined
+// through shared vertices
+func (hm *HalfEdgeMesh) ConnectedComponents() [][]int {
+	parent := make([]int, len(hm.Faces))
+	for f := range parent {
+		parent[f] = f
+	}
+	var find func(int) int
+	find = func(f int) int {
+		if parent[f] != f {
+			parent[f] = find(parent[f])
+		}
+		return parent[f]
+	}
+	for _, out := range hm.outgoing {
+		if len(out) == 0 {
+			continue
+		}
+		for _, h := range out[1:] {
+			a, b := find(hm.HalfEdges[out[0]].Face), find(hm.HalfEdges[h].Face)
+			if a != b {
+				parent[a] = b
+			}
+		}
+	}
+	index := make(map[int]int)
+	var components [][]int
+	for f := range hm.Faces {
+		r := find(f)
+		c, ok := index[r]
+		if !ok {
+			c = len(components)
+			index[r] = c
+			components = append(components, nil)
+		}
+		components[c] = append(components[c], f)
+	}
+	return components
+}
+
+// EulerCharacteristic - V - E + F, counting only vertices some face uses.
+// 2 for a closed surface like a sphere, 0 for a torus, 1 for a disk.
+func (hm *HalfEdgeMesh) EulerCharacteristic() int {
+	used := 0
+	for _, out := range hm.outgoing {
+		if len(out) > 0 {
+			used++
+		}
+	}
+	return used - hm.NumEdges() + hm.NumFaces()
+}
+
+// Works out which faces to reverse so that every pair of faces sharing an
+// edge runs along it in opposite directions.  Faces are walked outwards
+// from the first face of each component; edges shared by more than two
+// faces are ignored, as are the conflicts of a non-orientable surface.
+func consistentWinding(faces [][]int) []bool {
+	byEdge := make(map[[2]int][]int)
+	for f, face := range faces {
+		for k, a := range face {
+			key := edgeKey(a, face[(k+1)%len(face)])
+			byEdge[key] = append(byEdge[key], f)
+		}
+	}
+	// Does face f run from a to b?
+	runs := func(f, a, b int) bool {
+		face := faces[f]
+		for k, v := range face {
+			if v == a && face[(k+1)%len(face)] == b {
+				return true
+			}
+		}
+		return false
+	}
+
+	flip := make([]bool, len(faces))
+	visited := make([]bool, len(faces))
+	for seed := range faces {
+		if visited[seed] {
+			continue
+		}
+		visited[seed] = true
+		queue := []int{seed}
+		for len(queue) > 0 {
+			f := queue[0]
+			queue = queue[1:]
+			face := faces[f]
+			for k, a := range face {
+				b := face[(k+1)%len(face)]
+				if flip[f] {
+					a, b = b, a
+				}
+				shared := byEdge[edgeKey(a, b)]
+				if len(shared) != 2 {
+					continue
+				}
+				g := shared[0]
+				if g == f {
+					g = shared[1]
+				}
+				if visited[g] {
+					continue
+				}
+				visited[g] = true
+				// g should run from b to a
+				flip[g] = runs(g, a, b)
+				queue = append(queue, g)
+			}
+		}
+	}
+	return flip
+}
+
+// FixWinding - makes the winding of the given triangle index consistent,
+// so neighbouring triangles agree on which side is the front, and turns
+// each closed piece of the mesh outwards.  Triangles meeting at UV or
+// normal seams count as neighbours.  Returns the number of triangles
+// reversed.  Strips and fans become a triangle list.
+func (m *Mesh) FixWinding(index int) (int, error) {
+	if index < 0 || index >= len(m.indices) {
+		return 0, errors.New(fmt.Sprintf("Mesh:FixWinding: No index %d", index))
+	}
+	indx := m.indices[index]
+	tris, err := indx.triangleList("FixWinding")
+	if err != nil {
+		return 0, err
+	}
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return 0, err
+	}
+	if err := m.checkIndices(vertices, "Mesh:FixWinding"); err != nil {
+		return 0, err
+	}
+
+	// Work over positions, so vertices split at UV or normal seams still
+	// join their neighbours.  Triangles with two corners at one position
+	// have no edges to go by, and are left as they are.
+	pos := m.positionAttribute()
+	group := make([]int, vertices)
+	groups := make(map[Vec3]int)
+	for v := range group {
+		group[v] = v
+		if pos != nil {
+			p := pos.vec3(v)
+			g, ok := groups[p]
+			if !ok {
+				g = len(groups)
+				groups[p] = g
+			}
+			group[v] = g
+		}
+	}
+	if pos != nil {
+		vertices = len(groups)
+	}
+	var gfaces [][]int
+	var faceTri []int
+	for t, tri := range tris {
+		a, b, c := group[tri[0]], group[tri[1]], group[tri[2]]
+		if a != b && b != c && c != a {
+			gfaces = append(gfaces, []int{a, b, c})
+			faceTri = append(faceTri, t)
+		}
+	}
+	flip := consistentWinding(gfaces)
+	for f, face := range gfaces {
+		if flip[f] {
+			face[1], face[2] = face[2], face[1]
+		}
+	}
+
+	// Closed pieces with a negative volume are inside out
+	if hm, err := NewHalfEdgeMesh(vertices, gfaces); err == nil && pos != nil {
+		for _, component := range hm.ConnectedComponents() {
+			closed := true
+			volume := 0.0
+			for _, f := range component {
+				for _, h := range hm.FaceHalfEdges(f) {
+					if hm.IsBoundary(h) {
+						closed = false
+					}
+				}
+				tri := tris[faceTri[f]]
+				p0, p1, p2 := toVec3d(pos.vec3(int(tri[0]))), toVec3d(pos.vec3(int(tri[1]))), toVec3d(pos.vec3(int(tri[2])))
+				if flip[f] {
+					p1, p2 = p2, p1
+				}
+				volume += p0.dot(p1.cross(p2))
+			}
+			if closed && volume < 0 {
+				for _, f := range component {
+					flip[f] = !flip[f]
+				}
+			}
+		}
+	}
+
+	flipped := 0
+	for f, t := range faceTri {
+		if flip[f] {
+			tris[t][1], tris[t][2] = tris[t][2], tris[t][1]
+			flipped++
+		}
+	}
+	indx.setTriangles(tris)
+	return flipped, nil
+}
@@ -0,0 +1,148 @@
+package goglutils
+
+import (
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// An octahedron of radius 1, wound outwards
+var octahedronFaces = [][]int{
+	{0, 2, 4}, {2, 1, 4}, {1, 3, 4}, {3, 0, 4},
+	{2, 0, 5}, {1, 2, 5}, {3, 1, 5}, {0, 3, 5},
+}
+
+func octahedronMesh() *Mesh {
+	m := NewMesh("octahedron")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{
+		1, 0, 0, -1, 0, 0, 0, 1, 0, 0, -1, 0, 0, 0, 1, 0, 0, -1,
+	}, 3)
+	var data []gl.Uint
+	for _, f := range octahedronFaces {
+		data = append(data, gl.Uint(f[0]), gl.Uint(f[1]), gl.Uint(f[2]))
+	}
+	m.AddMeshIndex("0", data, gl.TRIANGLES, m.Attribute(0))
+	return m
+}
+
+func TestHalfEdgeClosed(t *testing.T) {
+	hm, err := NewHalfEdgeMesh(6, octahedronFaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hm.IsManifold() {
+		t.Errorf("Octahedron should be manifold")
+	}
+	if e := hm.EulerCharacteristic(); e != 2 {
+		t.Errorf("Euler characteristic %d, want 2", e)
+	}
+	if loops := hm.BoundaryLoops(); len(loops) != 0 {
+		t.Errorf("Closed mesh has boundary loops %v", loops)
+	}
+	ring := hm.OneRing(4)
+	if len(ring) != 4 {
+		t.Fatalf("One-ring of the apex is %v", ring)
+	}
+	// Neighbours around the apex alternate between the x and y axes
+	for i, v := range ring {
+		if v/2 == ring[(i+1)%4]/2 {
+			t.Errorf("One-ring %v is out of order", ring)
+		}
+	}
+	for _, n := range hm.FaceNeighbours(0) {
+		if n < 0 {
+			t.Errorf("Face 0 of a closed mesh has a border")
+		}
+	}
+}
+
+func TestHalfEdgeBorders(t *testing.T) {
+	// Two quads side by side, and a triangle off on its own
+	hm, err := NewHalfEdgeMesh(9, [][]int{{0, 1, 4, 3}, {1, 2, 5, 4}, {6, 7, 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loops := hm.BoundaryLoops()
+	if len(loops) != 2 || len(loops[0])+len(loops[1]) != 9 {
+		t.Errorf("Boundary loops %v, want one of 6 and one of 3", loops)
+	}
+	if c := hm.ConnectedComponents(); len(c) != 2 {
+		t.Errorf("%d components, want 2", len(c))
+	}
+	if spare, _ := NewHalfEdgeMesh(10, [][]int{{0, 1, 2}}); len(spare.ConnectedComponents()) != 1 {
+		t.Errorf("Vertices no face uses shouldn't make components")
+	}
+	if e := hm.EulerCharacteristic(); e != 2 {
+		t.Errorf("Euler characteristic %d, want 2 for two disks", e)
+	}
+	ring := hm.OneRing(1)
+	if len(ring) != 3 || ring[0] != 2 || ring[2] != 0 {
+		t.Errorf("One-ring of a border vertex is %v, want [2 4 0]", ring)
+	}
+	if n := hm.FaceNeighbours(0); n[1] != 1 || n[0] != -1 {
+		t.Errorf("Face neighbours %v", n)
+	}
+}
+
+func TestHalfEdgeNonManifold(t *testing.T) {
+	// Three triangles sharing the edge 0-1
+	hm, err := NewHalfEdgeMesh(5, [][]int{{0, 1, 2}, {1, 0, 3}, {0, 1, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := hm.NonManifoldEdges()
+	if len(edges) != 1 || edges[0] != [2]int{0, 1} {
+		t.Errorf("Non-manifold edges %v, want [[0 1]]", edges)
+	}
+	if hm.IsManifold() {
+		t.Errorf("Mesh should not be manifold")
+	}
+}
+
+func TestFixWinding(t *testing.T) {
+	m := octahedronMesh()
+	data := m.Index(0).Data()
+	// Reverse two faces
+	data[1], data[2] = data[2], data[1]
+	data[16], data[17] = data[17], data[16]
+	n, err := m.FixWinding(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Reversed %d triangles, want 2", n)
+	}
+	hm, _ := m.HalfEdgeMesh(0)
+	if !hm.IsManifold() {
+		t.Errorf("Winding is still inconsistent")
+	}
+
+	// Turn the whole thing inside out
+	m = octahedronMesh()
+	data = m.Index(0).Data()
+	for i := 0; i < len(data); i += 3 {
+		data[i+1], data[i+2] = data[i+2], data[i+1]
+	}
+	if n, _ := m.FixWinding(0); n != 8 {
+		t.Errorf("Reversed %d triangles of an inside out mesh, want 8", n)
+	}
+}
+
+func TestFixWindingSeams(t *testing.T) {
+	// The cube's faces only meet by position, each having its own vertices
+	m := NewCubeMesh(1, 1)
+	data := m.Index(0).Data()
+	for i := 0; i < 6; i += 3 {
+		data[i+1], data[i+2] = data[i+2], data[i+1]
+	}
+	n, err := m.FixWinding(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Reversed %d triangles, want 2", n)
+	}
+	if v := m.Volume(); v < 0.999 || v > 1.001 {
+		t.Errorf("Cube has volume %v after fixing its winding, want 1", v)
+	}
+}
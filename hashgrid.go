@@ -0,0 +1,313 @@
+// hashgrid - a uniform grid of objects by bounding box
+//
+// Space is cut into equal cubes and only the cubes holding something are
+// kept, in a map, so the grid has no edges.  An object goes into every
+// cell its box touches; the few objects that would cover too many cells
+// are kept in a list of their own and checked by every query.  Rays step
+// from cell to cell (Amanatides and Woo) through the part of space that
+// holds objects.
+
+package goglutils
+
+import (
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Objects that would cover more cells than this go in the oversized list
+const hashGridMaxCells = 64
+
+type gridCell [3]int32
+
+// HashGrid - a SpatialIndex for many similar sized objects
+type HashGrid struct {
+	cellSize  gl.Float
+	cells     map[gridCell][]int
+	objects   map[int]*gridEntry
+	oversized map[int]bool
+	// Takes in every object that has been in a cell; it only grows
+	bounds AABB
+}
+
+type gridEntry struct {
+	box    AABB
+	lo, hi gridCell // Range of cells the object is in, unless it's oversized
+}
+
+// NewHashGrid - a grid of cubes cellSize across.  A little bigger than a
+// typical object works best.
+func NewHashGrid(cellSize gl.Float) *HashGrid {
+	if cellSize <= 0 {
+		cellSize = 1
+	}
+	return &HashGrid{
+		cellSize:  cellSize,
+		cells:     make(map[gridCell][]int),
+		objects:   make(map[int]*gridEntry),
+		oversized: make(map[int]bool),
+		bounds:    EmptyAABB(),
+	}
+}
+
+// The cell holding p
+func (g *HashGrid) cellOf(p Vec3) gridCell {
+	return gridCell{
+		int32(math.Floor(float64(p.X / g.cellSize))),
+		int32(math.Floor(float64(p.Y / g.cellSize))),
+		int32(math.Floor(float64(p.Z / g.cellSize))),
+	}
+}
+
+// The cell's box
+func (g *HashGrid) cellBox(c gridCell) AABB {
+	min := Vec3{gl.Float(c[0]) * g.cellSize, gl.Float(c[1]) * g.cellSize, gl.Float(c[2]) * g.cellSize}
+	return AABB{min, Vec3{min.X + g.cellSize, min.Y + g.cellSize, min.Z + g.cellSize}}
+}
+
+// Calls f for each cell from lo to hi
+func eachCell(lo, hi gridCell, f func(c gridCell)) {
+	for x := lo[0]; x <= hi[0]; x++ {
+		for y := lo[1]; y <= hi[1]; y++ {
+			for z := lo[2]; z <= hi[2]; z++ {
+				f(gridCell{x, y, z})
+			}
+		}
+	}
+}
+
+// Number of cells from lo to hi
+func cellCount(lo, hi gridCell) int64 {
+	n := int64(1)
+	for k := 0; k < 3; k++ {
+		n *= int64(hi[k]) - int64(lo[k]) + 1
+	}
+	return n
+}
+
+// Insert - adds an object, or moves it if the id is already there
+func (g *HashGrid) Insert(id int, box AABB) {
+	g.Remove(id)
+	e := &gridEntry{box: box, lo: g.cellOf(box.Min), hi: g.cellOf(box.Max)}
+	g.objects[id] = e
+	if box.IsEmpty() || cellCount(e.lo, e.hi) > hashGridMaxCells {
+		g.oversized[id] = true
+		return
+	}
+	g.bounds = g.bounds.Union(box)
+	eachCell(e.lo, e.hi, func(c gridCell) {
+		g.cells[c] = append(g.cells[c], id)
+	})
+}
+
+// Update - moves an object, returning false if the id isn't there
+func (g *HashGrid) Update(id int, box AABB) bool {
+	e, ok := g.objects[id]
+	if !ok {
+		return false
+	}
+	// Moving within the same cells needs no more than the new box
+	if !g.oversized[id] && !box.IsEmpty() && g.cellOf(box.Min) == e.lo && g.cellOf(box.Max) == e.hi {
+		e.box = box
+		g.bounds = g.bounds.Union(box)
+		return true
+	}
+	g.Insert(id, box)
+	return true
+}
+
+// Remove - removes an object, returning false if the id isn't there
+func (g *HashGrid) Remove(id int) bool {
+	e, ok := g.objects[id]
+	if !ok {
+		return false
+	}
+	delete(g.objects, id)
+	if g.oversized[id] {
+		delete(g.oversized, id)
+		return true
+	}
+	eachCell(e.lo, e.hi, func(c gridCell) {
+		ids := g.cells[c]
+		for i, other := range ids {
+			if other == id {
+				ids[i] = ids[len(ids)-1]
+				ids = ids[:len(ids)-1]
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(g.cells, c)
+		} else {
+			g.cells[c] = ids
+		}
+	})
+	return true
+}
+
+// Bounds - the object's box
+func (g *HashGrid) Bounds(id int) (AABB, bool) {
+	e, ok := g.objects[id]
+	if !ok {
+		return AABB{}, false
+	}
+	return e.box, true
+}
+
+// Len - number of objects
+func (g *HashGrid) Len() int {
+	return len(g.objects)
+}
+
+// Calls visit once for each object in a cell that passes cellTest (if
+// given) and touches the region (if given), and for each oversized object
+func (g *HashGrid) query(region *AABB, cellTest func(AABB) bool, visit func(id int, box AABB)) {
+	seen := make(map[int]bool)
+	visitCell := func(c gridCell) {
+		if cellTest != nil && !cellTest(g.cellBox(c)) {
+			return
+		}
+		for _, id := range g.cells[c] {
+			if !seen[id] {
+				seen[id] = true
+				visit(id, g.objects[id].box)
+			}
+		}
+	}
+	switch {
+	case region == nil:
+		for c := range g.cells {
+			visitCell(c)
+		}
+	default:
+		r := region.Intersect(g.bounds)
+		if r.IsEmpty() {
+			break
+		}
+		lo, hi := g.cellOf(r.Min), g.cellOf(r.Max)
+		if cellCount(lo, hi) <= int64(len(g.cells)) {
+			eachCell(lo, hi, func(c gridCell) {
+				if _, ok := g.cells[c]; ok {
+					visitCell(c)
+				}
+			})
+			break
+		}
+		// The region covers more cells than are in use - walk the map
+		for c := range g.cells {
+			if r.Overlaps(g.cellBox(c)) {
+				visitCell(c)
+			}
+		}
+	}
+	for id := range g.oversized {
+		visit(id, g.objects[id].box)
+	}
+}
+
+// QueryAABB - objects whose boxes touch the box
+func (g *HashGrid) QueryAABB(box AABB) []int {
+	var out []int
+	g.query(&box, nil, func(id int, b AABB) {
+		if box.Overlaps(b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryRadius - objects whose boxes come within radius of centre
+func (g *HashGrid) QueryRadius(centre Vec3, radius gl.Float) []int {
+	var out []int
+	region := AABB{centre, centre}.Grow(radius)
+	g.query(&region, nil, func(id int, b AABB) {
+		if sphereOverlapsAABB(centre, radius, b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryFrustum - objects whose boxes are at least partly in view
+func (g *HashGrid) QueryFrustum(f *ViewFrustum) []int {
+	var out []int
+	g.query(nil, f.IntersectsAABB, func(id int, b AABB) {
+		if f.IntersectsAABB(b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryRay - objects whose boxes the ray passes through before maxT,
+// nearest first
+func (g *HashGrid) QueryRay(r Ray, maxT gl.Float) []ObjectHit {
+	var out []ObjectHit
+	seen := make(map[int]bool)
+	inv := Vec3{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	test := func(id int, b AABB) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if t, ok := b.intersectRay(r.Origin, inv, maxT); ok {
+			out = append(out, ObjectHit{id, t})
+		}
+	}
+	for id := range g.oversized {
+		test(id, g.objects[id].box)
+	}
+
+	// Only step through the part of the ray inside the occupied bounds
+	t0, t1, ok := g.bounds.rayRange(r.Origin, inv, maxT)
+	if !ok {
+		sortObjectHits(out)
+		return out
+	}
+	cell := g.cellOf(g.bounds.ClosestPoint(r.At(t0)))
+	last := g.cellOf(g.bounds.ClosestPoint(r.At(t1)))
+
+	// Distance along the ray to the next cell boundary on each axis, and
+	// between boundaries
+	var step [3]int32
+	var next, delta [3]float64
+	o := [3]float64{float64(r.Origin.X), float64(r.Origin.Y), float64(r.Origin.Z)}
+	d := [3]float64{float64(r.Dir.X), float64(r.Dir.Y), float64(r.Dir.Z)}
+	size := float64(g.cellSize)
+	for k := 0; k < 3; k++ {
+		switch {
+		case d[k] > 0:
+			step[k] = 1
+			next[k] = (float64(cell[k]+1)*size - o[k]) / d[k]
+			delta[k] = size / d[k]
+		case d[k] < 0:
+			step[k] = -1
+			next[k] = (float64(cell[k])*size - o[k]) / d[k]
+			delta[k] = -size / d[k]
+		default:
+			next[k], delta[k] = math.Inf(1), math.Inf(1)
+		}
+	}
+	for {
+		for _, id := range g.cells[cell] {
+			test(id, g.objects[id].box)
+		}
+		if cell == last {
+			break
+		}
+		k := 0
+		if next[1] < next[k] {
+			k = 1
+		}
+		if next[2] < next[k] {
+			k = 2
+		}
+		if next[k] > float64(t1) {
+			break
+		}
+		cell[k] += step[k]
+		next[k] += delta[k]
+	}
+	sortObjectHits(out)
+	return out
+}
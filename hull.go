@@ -0,0 +1,270 @@
+// hull - 3D convex hulls by quickhull
+//
+// Starts from a tetrahedron of four far apart points, then repeatedly
+// takes the point furthest outside some face, removes every face that
+// point can see and fans new faces from it to the edge of the hole (the
+// horizon).  Each face keeps the list of points outside it, so points
+// already inside the hull are never looked at again.  Works in float64.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// ConvexHull - a closed convex polyhedron, with triangles wound
+// counter-clockwise seen from outside
+type ConvexHull struct {
+	Points []Vec3
+	Faces  [][3]int // Numbers into Points
+}
+
+type hullFace struct {
+	v       [3]int
+	normal  vec3d
+	offset  float64 // normal . p for points p on the face
+	outside []int
+	dead    bool
+}
+
+func newHullFace(pts []vec3d, a, b, c int) *hullFace {
+	n := pts[b].sub(pts[a]).cross(pts[c].sub(pts[a])).normalize()
+	return &hullFace{v: [3]int{a, b, c}, normal: n, offset: n.dot(pts[a])}
+}
+
+func (f *hullFace) distance(p vec3d) float64 {
+	return f.normal.dot(p) - f.offset
+}
+
+// NewConvexHull - the convex hull of the points.  Fails if the points are
+// all in a plane, as then there is no solid hull.
+func NewConvexHull(points []Vec3) (*ConvexHull, error) {
+	pts := toVec3ds(points)
+	if len(pts) < 4 {
+		return nil, errors.New(fmt.Sprintf("ConvexHull: Need at least 4 points, have %d", len(pts)))
+	}
+	scale := 0.0
+	for _, p := range pts {
+		scale = math.Max(scale, math.Max(math.Abs(p[0]), math.Max(math.Abs(p[1]), math.Abs(p[2]))))
+	}
+	eps := 1e-10 * math.Max(scale, 1)
+
+	// Starting tetrahedron: the two points furthest apart along an axis,
+	// the point furthest from the line through them, and the point
+	// furthest from the plane of those three
+	var a, b int
+	best := -1.0
+	for k := 0; k < 3; k++ {
+		lo, hi := 0, 0
+		for i, p := range pts {
+			if p[k] < pts[lo][k] {
+				lo = i
+			}
+			if p[k] > pts[hi][k] {
+				hi = i
+			}
+		}
+		if d := pts[hi][k] - pts[lo][k]; d > best {
+			a, b, best = lo, hi, d
+		}
+	}
+	if best <= eps {
+		return nil, errors.New(fmt.Sprintf("ConvexHull: Points are all the same"))
+	}
+	line := pts[b].sub(pts[a]).normalize()
+	c, best := -1, eps
+	for i, p := range pts {
+		if d := p.sub(pts[a]).cross(line).length(); d > best {
+			c, best = i, d
+		}
+	}
+	if c < 0 {
+		return nil, errors.New(fmt.Sprintf("ConvexHull: Points are all in a line"))
+	}
+	base := newHullFace(pts, a, b, c)
+	d, best := -1, eps
+	for i, p := range pts {
+		if dist := math.Abs(base.distance(p)); dist > best {
+			d, best = i, dist
+		}
+	}
+	if d < 0 {
+		return nil, errors.New(fmt.Sprintf("ConvexHull: Points are all in a plane"))
+	}
+	if base.distance(pts[d]) > 0 {
+		b, c = c, b
+	}
+	faces := []*hullFace{
+		newHullFace(pts, a, b, c),
+		newHullFace(pts, a, d, b),
+		newHullFace(pts, b, d, c),
+		newHullFace(pts, c, d, a),
+	}
+
+	// Hand each point to the first face it is outside of
+	assign := func(candidates []int, to []*hullFace) {
+		for _, i := range candidates {
+			for _, f := range to {
+				if f.distance(pts[i]) > eps {
+					f.outside = append(f.outside, i)
+					break
+				}
+			}
+		}
+	}
+	all := make([]int, 0, len(pts))
+	for i := range pts {
+		if i != a && i != b && i != c && i != d {
+			all = append(all, i)
+		}
+	}
+	assign(all, faces)
+
+	// Which face each directed edge belongs to
+	edges := make(map[[2]int]*hullFace)
+	addFace := func(f *hullFace) {
+		for k := 0; k < 3; k++ {
+			edges[[2]int{f.v[k], f.v[(k+1)%3]}] = f
+		}
+	}
+	for _, f := range faces {
+		addFace(f)
+	}
+
+	for work := 0; work < len(faces); work++ {
+		f := faces[work]
+		if f.dead || len(f.outside) == 0 {
+			continue
+		}
+		// The furthest point outside this face
+		eye, far := -1, -1.0
+		for _, i := range f.outside {
+			if dist := f.distance(pts[i]); dist > far {
+				eye, far = i, dist
+			}
+		}
+		p := pts[eye]
+
+		// Flood out over the faces the eye point can see, collecting the
+		// horizon - edges between a visible face and a hidden one - in
+		// order around the hole
+		var visible []*hullFace
+		var horizon [][2]int
+		f.dead = true
+		var visit func(g *hullFace, from int)
+		visit = func(g *hullFace, from int) {
+			visible = append(visible, g)
+			for k := 0; k < 3; k++ {
+				e := (from + k) % 3
+				u, v := g.v[e], g.v[(e+1)%3]
+				n := edges[[2]int{v, u}]
+				if n == nil || n.dead {
+					continue
+				}
+				if n.distance(p) > eps {
+					n.dead = true
+					// Carry on round n starting after the shared edge
+					for j := 0; j < 3; j++ {
+						if n.v[j] == v {
+							visit(n, (j+1)%3)
+						}
+					}
+				} else {
+					horizon = append(horizon, [2]int{u, v})
+				}
+			}
+		}
+		visit(f, 0)
+
+		var orphans []int
+		for _, g := range visible {
+			for k := 0; k < 3; k++ {
+				delete(edges, [2]int{g.v[k], g.v[(k+1)%3]})
+			}
+			for _, i := range g.outside {
+				if i != eye {
+					orphans = append(orphans, i)
+				}
+			}
+			g.outside = nil
+		}
+		var fresh []*hullFace
+		for _, e := range horizon {
+			nf := newHullFace(pts, e[0], e[1], eye)
+			fresh = append(fresh, nf)
+			faces = append(faces, nf)
+			addFace(nf)
+		}
+		assign(orphans, fresh)
+	}
+
+	// Renumber the points the hull uses
+	h := new(ConvexHull)
+	renumber := make(map[int]int)
+	for _, f := range faces {
+		if f.dead {
+			continue
+		}
+		var tri [3]int
+		for k, v := range f.v {
+			n, ok := renumber[v]
+			if !ok {
+				n = len(h.Points)
+				renumber[v] = n
+				h.Points = append(h.Points, points[v])
+			}
+			tri[k] = n
+		}
+		h.Faces = append(h.Faces, tri)
+	}
+	return h, nil
+}
+
+// ConvexHull - the convex hull of the mesh's positions
+func (m *Mesh) ConvexHull() (*ConvexHull, error) {
+	return NewConvexHull(m.positions())
+}
+
+// Mesh - the hull as a mesh with flat normals
+func (h *ConvexHull) Mesh(name string) *Mesh {
+	pos := make([]gl.Float, 0, len(h.Points)*3)
+	for _, p := range h.Points {
+		pos = append(pos, p.X, p.Y, p.Z)
+	}
+	index := make([]gl.Uint, 0, len(h.Faces)*3)
+	for _, f := range h.Faces {
+		index = append(index, gl.Uint(f[0]), gl.Uint(f[1]), gl.Uint(f[2]))
+	}
+	m := NewMesh(name)
+	m.AddMeshAttribute(AttribPosition, pos, 3)
+	m.AddMeshIndex("0", index, gl.TRIANGLES, m.attributes[0])
+	m.ComputeFlatNormals()
+	return m
+}
+
+// Volume of the hull
+func (h *ConvexHull) Volume() gl.Float {
+	v := 0.0
+	for _, f := range h.Faces {
+		a, b, c := toVec3d(h.Points[f[0]]), toVec3d(h.Points[f[1]]), toVec3d(h.Points[f[2]])
+		v += a.dot(b.cross(c))
+	}
+	return gl.Float(v / 6)
+}
+
+// Is p inside the hull (or on its surface)?
+func (h *ConvexHull) Contains(p Vec3) bool {
+	q := toVec3d(p)
+	for _, f := range h.Faces {
+		a := toVec3d(h.Points[f[0]])
+		n := toVec3d(h.Points[f[1]]).sub(a).cross(toVec3d(h.Points[f[2]]).sub(a))
+		if n.dot(q.sub(a)) > 1e-9*n.length() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package goglutils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func TestConvexHull(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	points := make([]Vec3, 1000)
+	for i := range points {
+		points[i] = randomVec3(r, 1)
+	}
+	// The corners of the cube, so the hull is exactly the cube
+	for i := 0; i < 8; i++ {
+		points[i] = Vec3{signOf(i & 1), signOf(i & 2), signOf(i & 4)}
+	}
+	h, err := NewConvexHull(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := h.Volume(); math.Abs(float64(v)-8) > 1e-4 {
+		t.Errorf("Hull volume %v, want 8", v)
+	}
+	for _, p := range points {
+		if !h.Contains(p) {
+			t.Fatalf("Hull leaves out %v", p)
+		}
+	}
+
+	if m := h.Mesh("hull"); m.TriangleCount() != len(h.Faces) || m.AttributeByDesc(AttribNormal) == nil {
+		t.Errorf("Hull mesh has %d triangles, want %d with normals", m.TriangleCount(), len(h.Faces))
+	}
+}
+
+func signOf(bit int) gl.Float {
+	if bit != 0 {
+		return 1
+	}
+	return -1
+}
+
+func TestConvexHullOfMesh(t *testing.T) {
+	h, err := NewIcosphereMesh(2, 1).ConvexHull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Points) != 42 || len(h.Faces) != 80 {
+		t.Errorf("Hull of an icosphere has %d points and %d faces, want 42 and 80", len(h.Points), len(h.Faces))
+	}
+	var faces [][]int
+	for _, f := range h.Faces {
+		faces = append(faces, []int{f[0], f[1], f[2]})
+	}
+	hm, _ := NewHalfEdgeMesh(len(h.Points), faces)
+	if !hm.IsManifold() || hm.EulerCharacteristic() != 2 {
+		t.Errorf("Hull should be a closed manifold")
+	}
+
+	if _, err := NewConvexHull([]Vec3{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0}}); err == nil {
+		t.Errorf("Flat points should have no hull")
+	}
+}
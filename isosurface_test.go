@@ -0,0 +1,84 @@
+package goglutils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func unitSphereField(p Vec3) gl.Float {
+	return 1 - p.Dot(&p)
+}
+
+// Checks the mesh is a closed surface around the origin close to the
+// unit sphere, with normals and triangles facing out
+func checkSphereSurface(t *testing.T, name string, m *Mesh, tolerance float64) {
+	pos := m.AttributeByDesc(AttribPosition)
+	nrm := m.AttributeByDesc(AttribNormal)
+	for v := 0; v < pos.VertexCount(); v++ {
+		p, n := pos.vec3(v), nrm.vec3(v)
+		if l := float64(p.Length()); math.Abs(l-1) > tolerance {
+			t.Fatalf("%s: vertex %v is %v from the centre", name, p, l)
+		}
+		if n.Dot(&p) < 0.9*p.Length() {
+			t.Fatalf("%s: normal %v at %v doesn't face out", name, n, p)
+		}
+	}
+	for _, tri := range m.Index(0).Triangles() {
+		a, b, c := pos.vec3(int(tri[0])), pos.vec3(int(tri[1])), pos.vec3(int(tri[2]))
+		n := b.Sub(&a).Cross(c.Sub(&a))
+		if n.Dot(&a) <= 0 {
+			t.Fatalf("%s: triangle %v faces in", name, tri)
+		}
+	}
+	hm, err := m.HalfEdgeMesh(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hm.BoundaryLoops()) != 0 || !hm.IsManifold() || hm.EulerCharacteristic() != 2 {
+		t.Errorf("%s: surface isn't a closed sphere", name)
+	}
+}
+
+func TestMarchingCubes(t *testing.T) {
+	bounds := AABB{Vec3{-1.3, -1.3, -1.3}, Vec3{1.3, 1.3, 1.3}}
+	m, err := MarchingCubes(unitSphereField, bounds, [3]int{16, 16, 16}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSphereSurface(t, "MarchingCubes", m, 0.02)
+
+	m, err = SurfaceNets(unitSphereField, bounds, [3]int{16, 16, 16}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSphereSurface(t, "SurfaceNets", m, 0.05)
+}
+
+func TestMarchingCubesHasNoHoles(t *testing.T) {
+	// Random noise, kept outside at the grid's edges, gives every case
+	// including the ambiguous ones
+	r := rand.New(rand.NewSource(9))
+	g, _ := NewVoxelGrid([3]int{8, 8, 8}, Vec3{}, Vec3{1, 1, 1})
+	for z := 1; z < 7; z++ {
+		for y := 1; y < 7; y++ {
+			for x := 1; x < 7; x++ {
+				g.Set(x, y, z, gl.Float(r.Float64()*2-1))
+			}
+		}
+	}
+	for i := range g.Values {
+		if g.Values[i] == 0 {
+			g.Values[i] = -1
+		}
+	}
+	hm, err := g.MarchingCubes(0).HalfEdgeMesh(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loops := hm.BoundaryLoops(); len(loops) != 0 || len(hm.NonManifoldEdges()) != 0 {
+		t.Errorf("Surface has %d holes and %d bad edges", len(loops), len(hm.NonManifoldEdges()))
+	}
+}
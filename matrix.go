@@ -43,6 +43,20 @@ const Pi = (gl.Float)(math.Pi)
 // Change this to change where debug messages get sent
 var debugOut = os.Stderr
 
+// ******************************* //
+// *     VEC2 - A 2x1 vector     * //
+// ******************************* //
+
+// Struct that kinda, sorta represents a vec2 glm/glsl vector
+type Vec2 struct {
+	X, Y gl.Float
+}
+
+// Bit useless, literal form is preferred usually
+func NewVec2(x, y gl.Float) *Vec2 {
+	return &Vec2{x, y}
+}
+
 // ******************************* //
 // *     VEC3 - A 3x1 vector     * //
 // ******************************* //
@@ -74,6 +88,11 @@ func (v *Vec3) Normalize() *Vec3{
 	return &Vec3{ v.X / lenv, v.Y / lenv, v.Z / lenv }
 }
 
+// Dot product - Vec3 version, u.Dot(v) = u . v
+func (u *Vec3) Dot(v *Vec3) gl.Float {
+	return u.X*v.X + u.Y*v.Y + u.Z*v.Z
+}
+
 // Cross product - Vec3 version, u.Cross(v) = u x v
 func (u *Vec3) Cross(v *Vec3) *Vec3 {
 	s := Vec3{
@@ -115,6 +134,56 @@ func (u *Vec3) MulS(f gl.Float) *Vec3 {
 }
 
 
+// ******************************* //
+// *   vec3d - float64 Vec3 for    * //
+// *   numerically touchy code     * //
+// ******************************* //
+
+// Geometry code that accumulates a lot (quadrics, hulls, collision) works
+// in float64 to keep the error down
+type vec3d [3]float64
+
+func toVec3d(v Vec3) vec3d {
+	return vec3d{float64(v.X), float64(v.Y), float64(v.Z)}
+}
+
+func (u vec3d) vec3() Vec3 {
+	return Vec3{gl.Float(u[0]), gl.Float(u[1]), gl.Float(u[2])}
+}
+
+func (u vec3d) add(v vec3d) vec3d {
+	return vec3d{u[0] + v[0], u[1] + v[1], u[2] + v[2]}
+}
+
+func (u vec3d) sub(v vec3d) vec3d {
+	return vec3d{u[0] - v[0], u[1] - v[1], u[2] - v[2]}
+}
+
+func (u vec3d) scale(f float64) vec3d {
+	return vec3d{u[0] * f, u[1] * f, u[2] * f}
+}
+
+func (u vec3d) dot(v vec3d) float64 {
+	return u[0]*v[0] + u[1]*v[1] + u[2]*v[2]
+}
+
+func (u vec3d) cross(v vec3d) vec3d {
+	return vec3d{u[1]*v[2] - u[2]*v[1], u[2]*v[0] - u[0]*v[2], u[0]*v[1] - u[1]*v[0]}
+}
+
+func (u vec3d) length() float64 {
+	return math.Sqrt(u.dot(u))
+}
+
+// Unit vector, or zero for a zero vector
+func (u vec3d) normalize() vec3d {
+	l := u.length()
+	if l == 0 {
+		return vec3d{}
+	}
+	return u.scale(1 / l)
+}
+
 // ******************************* //
 // *     VEC4 - A 4x1 vector     * //
 // ******************************* //
@@ -156,6 +225,32 @@ func (v *Vec4) Normalize() *Vec4{
 	return &Vec4{ v.X / lenv, v.Y / lenv, v.Z / lenv, v.W }
 }
 
+// ******************************* //
+// *     MAT3 - A 3x3 Matrix     * //
+// ******************************* //
+
+// Struct that kinda, sorta represents a glm/glsl 3x3 matrix.  Same
+// column order as Mat4.
+type Mat3 [3]Vec3
+
+// Return a Mat3 with identity values
+func IdentMat3() *Mat3 {
+	var m Mat3
+	m[0].X = 1.0
+	m[1].Y = 1.0
+	m[2].Z = 1.0
+	return &m
+}
+
+// The upper-left 3x3 of a Mat4, i.e. its rotation/scale part
+func (m *Mat4) Mat3() *Mat3 {
+	return &Mat3{
+		{m[0].X, m[0].Y, m[0].Z},
+		{m[1].X, m[1].Y, m[1].Z},
+		{m[2].X, m[2].Y, m[2].Z},
+	}
+}
+
 // ******************************* //
 // *     MAT4 - A 4x4 Matrix     * //
 // ******************************* //
@@ -211,6 +306,69 @@ func (m1 *Mat4) MulM(m2 *Mat4) *Mat4 {
 	return &rm
 }
 
+// Transforms a point, i.e. with w = 1, dropping the resulting w
+func (m *Mat4) MulPoint(p *Vec3) *Vec3 {
+	return &Vec3{
+		m[0].X*p.X + m[1].X*p.Y + m[2].X*p.Z + m[3].X,
+		m[0].Y*p.X + m[1].Y*p.Y + m[2].Y*p.Z + m[3].Y,
+		m[0].Z*p.X + m[1].Z*p.Y + m[2].Z*p.Z + m[3].Z,
+	}
+}
+
+// Transforms a direction, i.e. with w = 0, ignoring translation
+func (m *Mat4) MulDir(d *Vec3) *Vec3 {
+	return &Vec3{
+		m[0].X*d.X + m[1].X*d.Y + m[2].X*d.Z,
+		m[0].Y*d.X + m[1].Y*d.Y + m[2].Y*d.Z,
+		m[0].Z*d.X + m[1].Z*d.Y + m[2].Z*d.Z,
+	}
+}
+
+// TransformPoints - transforms a run of points in interleaved float data,
+// the first 3 floats of every srcStride in src to the first 3 of every
+// dstStride in dst.  dst and src may be the same slice.
+func (m *Mat4) TransformPoints(dst []gl.Float, dstStride int, src []gl.Float, srcStride int) {
+	for i, j := 0, 0; i+2 < len(src) && j+2 < len(dst); i, j = i+srcStride, j+dstStride {
+		x, y, z := src[i], src[i+1], src[i+2]
+		dst[j] = m[0].X*x + m[1].X*y + m[2].X*z + m[3].X
+		dst[j+1] = m[0].Y*x + m[1].Y*y + m[2].Y*z + m[3].Y
+		dst[j+2] = m[0].Z*x + m[1].Z*y + m[2].Z*z + m[3].Z
+	}
+}
+
+// TransformDirs - TransformPoints for directions, ignoring translation
+func (m *Mat4) TransformDirs(dst []gl.Float, dstStride int, src []gl.Float, srcStride int) {
+	for i, j := 0, 0; i+2 < len(src) && j+2 < len(dst); i, j = i+srcStride, j+dstStride {
+		x, y, z := src[i], src[i+1], src[i+2]
+		dst[j] = m[0].X*x + m[1].X*y + m[2].X*z
+		dst[j+1] = m[0].Y*x + m[1].Y*y + m[2].Y*z
+		dst[j+2] = m[0].Z*x + m[1].Z*y + m[2].Z*z
+	}
+}
+
+// TransformNormals - TransformDirs for normals, which go through the
+// inverse transpose (so stay at right angles to surfaces under scaling)
+// and are renormalised
+func (m *Mat4) TransformNormals(dst []gl.Float, dstStride int, src []gl.Float, srcStride int) {
+	// The cofactor matrix is the inverse transpose times the determinant,
+	// and the length doesn't matter
+	c0 := (&Vec3{m[1].X, m[1].Y, m[1].Z}).Cross(&Vec3{m[2].X, m[2].Y, m[2].Z})
+	c1 := (&Vec3{m[2].X, m[2].Y, m[2].Z}).Cross(&Vec3{m[0].X, m[0].Y, m[0].Z})
+	c2 := (&Vec3{m[0].X, m[0].Y, m[0].Z}).Cross(&Vec3{m[1].X, m[1].Y, m[1].Z})
+	if c0.Dot(&Vec3{m[0].X, m[0].Y, m[0].Z}) < 0 {
+		// Mirrored - keep normals on the same side of the surface
+		c0, c1, c2 = c0.MulS(-1), c1.MulS(-1), c2.MulS(-1)
+	}
+	for i, j := 0, 0; i+2 < len(src) && j+2 < len(dst); i, j = i+srcStride, j+dstStride {
+		x, y, z := src[i], src[i+1], src[i+2]
+		n := Vec3{c0.X*x + c1.X*y + c2.X*z, c0.Y*x + c1.Y*y + c2.Y*z, c0.Z*x + c1.Z*y + c2.Z*z}
+		if l := n.Length(); l > 0 {
+			n = *n.MulS(1 / l)
+		}
+		dst[j], dst[j+1], dst[j+2] = n.X, n.Y, n.Z
+	}
+}
+
 // Returns the transpose of a given matrix
 func (m *Mat4) Transpose() *Mat4 {
 	var rm = Mat4{
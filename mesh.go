@@ -21,16 +21,36 @@ type Mesh struct {
 	//attributes []*MeshAttribute
 	attributes []*MeshAttribute
 	//indices    []*MeshIndex
-	indices  []*MeshIndex
-	vao		gl.Uint  // Vertex attribute array
-	vbo 	gl.Uint  // Vertex buffer
-	ebo		gl.Uint  // Element buffer
+	indices []*MeshIndex
+	vao     gl.Uint // Vertex attribute array
+	vbo     *Buffer // Vertex buffer, every attribute one after the other
+	ebo     *Buffer // Element buffer, every index one after the other
+	// Set when attributes or indices are added, so the next Upload
+	// lays the buffers out again from scratch
+	layoutDirty bool
+	// If set, attributes are interleaved in the vertex buffer following
+	// this format rather than stored one after the other
+	format *VertexFormat
+	// Bounding volumes of the positions, worked out on demand
+	bounds *meshBounds
+	// Blend shapes, offsets from the attributes above
+	morphs []*MorphTarget
+	// GL calls made by Upload and Release, the real GL if nil.  Tests
+	// swap in fakes.
+	bufferAPI      BufferAPI
+	vertexArrayAPI vertexArrayAPI
 }
 
 type MeshAttribute struct {
 	desc   string
 	data   []gl.Float
 	stride int
+	offset int  // Byte offset of the data in the mesh's vertex buffer
+	size   int  // Size in bytes when last uploaded
+	dirty  bool // Data has changed since it was last uploaded
+	// Bumped on every change, so anything cached from the data can tell
+	// it is stale
+	version int
 }
 
 type MeshIndex struct {
@@ -38,6 +58,13 @@ type MeshIndex struct {
 	data      []gl.Uint
 	primitive gl.Enum
 	ref       *MeshAttribute
+	offset    int  // Byte offset of the data in the mesh's element buffer
+	size      int  // Size in bytes when last uploaded
+	dirty     bool // Data has changed since it was last uploaded
+	// Primitive restart - when restartEnabled, restart in the data ends
+	// one strip/fan/loop and starts the next
+	restart        gl.Uint
+	restartEnabled bool
 }
 
 func (mi *MeshIndex) Debug() {
@@ -89,6 +116,7 @@ func NewMeshIndex(desc string, data []gl.Uint, primitive gl.Enum, ref *MeshAttri
 	}
 	i.primitive = primitive
 	i.ref = ref
+	i.dirty = true
 	return i
 }
 
@@ -101,6 +129,7 @@ func NewMeshAttribute(desc string, data []gl.Float, stride int) *MeshAttribute {
 		return nil
 	}
 	a.stride = stride
+	a.dirty = true
 	return a
 }
 
@@ -110,11 +139,42 @@ func NewMesh(name string) *Mesh {
 	m.attributes = []*MeshAttribute{}
 	m.indices = []*MeshIndex{}
 	m.vao = 0
-	m.vbo = 0
-	m.ebo = 0 
+	m.vbo = nil
+	m.ebo = nil
+	m.layoutDirty = true
 	return m
 }
 
+// Clone - a copy of the mesh's attributes and indices under a new name.
+// The copy has no GL objects of its own until it is uploaded.
+func (m *Mesh) Clone(name string) *Mesh {
+	c := NewMesh(name)
+	c.format = m.format
+	refs := make(map[*MeshAttribute]*MeshAttribute)
+	for _, attr := range m.attributes {
+		ca := NewMeshAttribute(attr.desc, append([]gl.Float(nil), attr.data...), attr.stride)
+		refs[attr] = ca
+		c.attributes = append(c.attributes, ca)
+	}
+	for _, indx := range m.indices {
+		ci := NewMeshIndex(indx.desc, append([]gl.Uint(nil), indx.data...), indx.primitive, refs[indx.ref])
+		ci.restart, ci.restartEnabled = indx.restart, indx.restartEnabled
+		c.indices = append(c.indices, ci)
+	}
+	for _, mt := range m.morphs {
+		cm := &MorphTarget{Name: mt.Name, Weight: mt.Weight, mesh: c, deltas: make(map[string]*morphDeltas)}
+		for desc, d := range mt.deltas {
+			cd := &morphDeltas{data: append([]gl.Float(nil), d.data...)}
+			if d.vertices != nil {
+				cd.vertices = append([]int{}, d.vertices...)
+			}
+			cm.deltas[desc] = cd
+		}
+		c.morphs = append(c.morphs, cm)
+	}
+	return c
+}
+
 // Add an attribute array to a mesh
 func (m *Mesh) AddMeshAttribute(desc string, data []gl.Float, stride int) error {
 	ma := NewMeshAttribute(desc, data, stride)
@@ -122,6 +182,7 @@ func (m *Mesh) AddMeshAttribute(desc string, data []gl.Float, stride int) error
 		return errors.New("Mesh:AddMeshAttribute: Could not allocate new attribute array\n")
 	}
 	m.attributes = append(m.attributes, ma)
+	m.layoutDirty = true
 	return nil
 }
 
@@ -132,6 +193,7 @@ func (m *Mesh) AddMeshIndex(desc string, data []gl.Uint, primitive gl.Enum, ref
 		return errors.New("Mesh:AddMeshIndex: Could not allocate new index array\n")
 	}
 	m.indices = append(m.indices, mi)
+	m.layoutDirty = true
 	return nil
 }
 
@@ -160,6 +222,158 @@ func (m *Mesh) Attribute(index int) *MeshAttribute {
 	return m.attributes[index]
 }
 
+// Get the first attribute with the given description, or nil if
+// there isn't one
+func (m *Mesh) AttributeByDesc(desc string) *MeshAttribute {
+	for _, attr := range m.attributes {
+		if attr.desc == desc {
+			return attr
+		}
+	}
+	return nil
+}
+
+// Get a specific Index
+func (m *Mesh) Index(index int) *MeshIndex {
+	return m.indices[index]
+}
+
+// Number of attribute arrays in the mesh
+func (m *Mesh) NumAttributes() int {
+	return len(m.attributes)
+}
+
+// Number of index arrays in the mesh
+func (m *Mesh) NumIndices() int {
+	return len(m.indices)
+}
+
+// The mesh's name
+func (m *Mesh) Name() string {
+	return m.name
+}
+
+// The attribute's description
+func (ma *MeshAttribute) Desc() string {
+	return ma.desc
+}
+
+// Number of components per vertex
+func (ma *MeshAttribute) Stride() int {
+	return ma.stride
+}
+
+// Number of vertices in the attribute
+func (ma *MeshAttribute) VertexCount() int {
+	if ma.stride <= 0 {
+		return 0
+	}
+	return len(ma.data) / ma.stride
+}
+
+// The attribute's data.  If you change it in place, call MarkDirty so the
+// change gets uploaded.
+func (ma *MeshAttribute) Data() []gl.Float {
+	return ma.data
+}
+
+// Replace the attribute's data with a copy of data
+func (ma *MeshAttribute) SetData(data []gl.Float) {
+	ma.data = make([]gl.Float, len(data))
+	copy(ma.data, data)
+	ma.changed()
+}
+
+// Flag the attribute as needing to be uploaded again
+func (ma *MeshAttribute) MarkDirty() {
+	ma.changed()
+}
+
+// The data has changed - it needs uploading, and caches built from it are
+// stale
+func (ma *MeshAttribute) changed() {
+	ma.dirty = true
+	ma.version++
+}
+
+// The index's description
+func (mi *MeshIndex) Desc() string {
+	return mi.desc
+}
+
+// The primitive type this index draws
+func (mi *MeshIndex) Primitive() gl.Enum {
+	return mi.primitive
+}
+
+// The attribute array this index points to
+func (mi *MeshIndex) Attribute() *MeshAttribute {
+	return mi.ref
+}
+
+// The index's data.  If you change it in place, call MarkDirty so the
+// change gets uploaded.
+func (mi *MeshIndex) Data() []gl.Uint {
+	return mi.data
+}
+
+// Replace the index's data with a copy of data
+func (mi *MeshIndex) SetData(data []gl.Uint) {
+	mi.data = make([]gl.Uint, len(data))
+	copy(mi.data, data)
+	mi.dirty = true
+}
+
+// Flag the index as needing to be uploaded again
+func (mi *MeshIndex) MarkDirty() {
+	mi.dirty = true
+}
+
+// The attribute holding vertex positions - the one described as
+// AttribPosition, or failing that the first one (GLUT meshes put positions
+// first).  nil if the mesh has no attributes.
+func (m *Mesh) positionAttribute() *MeshAttribute {
+	if attr := m.AttributeByDesc(AttribPosition); attr != nil {
+		return attr
+	}
+	if len(m.attributes) == 0 {
+		return nil
+	}
+	return m.attributes[0]
+}
+
+// Vertex i of the attribute as a Vec3.  Missing components are 0.
+func (ma *MeshAttribute) vec3(i int) Vec3 {
+	var v [3]gl.Float
+	for c := 0; c < 3 && c < ma.stride; c++ {
+		v[c] = ma.data[i*ma.stride+c]
+	}
+	return Vec3{v[0], v[1], v[2]}
+}
+
+// Vertex i of the attribute as a Vec2.  Missing components are 0.
+func (ma *MeshAttribute) vec2(i int) Vec2 {
+	var v [2]gl.Float
+	for c := 0; c < 2 && c < ma.stride; c++ {
+		v[c] = ma.data[i*ma.stride+c]
+	}
+	return Vec2{v[0], v[1]}
+}
+
+// Adds an attribute, or replaces the data of the existing attribute with
+// the same description.
+func (m *Mesh) setMeshAttribute(desc string, data []gl.Float, stride int) error {
+	if attr := m.AttributeByDesc(desc); attr != nil {
+		if attr.stride != stride {
+			m.layoutDirty = true
+		}
+		attr.SetData(data)
+		attr.stride = stride
+		return nil
+	}
+	return m.AddMeshAttribute(desc, data, stride)
+}
+
 // Helper function, splits a string of floats - like
 // "23.3 0.0 2323.0" to a []gl.Float
 func StringToGLFloatArray(data string) ([]gl.Float, error) {
@@ -265,60 +479,300 @@ func (m *Mesh) Debug() {
 	}
 }
 
-// Set the Mesh's render context
+// Set the Mesh's render context - use existing GL objects rather than
+// having Upload create them.  Everything is uploaded again on the next
+// Upload or Render.
 func (m *Mesh) SetRenderContext(vao, vbo, ebo gl.Uint) {
+	buffers, _ := m.glAPIs()
 	m.vao = vao
-	m.vbo = vbo
-	m.ebo = ebo
+	m.vbo = &Buffer{api: buffers, id: vbo, target: gl.ARRAY_BUFFER, usage: gl.STATIC_DRAW}
+	m.ebo = &Buffer{api: buffers, id: ebo, target: gl.ELEMENT_ARRAY_BUFFER, usage: gl.STATIC_DRAW}
+	m.layoutDirty = true
 }
 
-// Render the mesh using the provided context.
-func (m *Mesh) Render() error {
+// Interleave the mesh's attributes in its vertex buffer following format,
+// instead of storing them one after the other.  Pass nil to go back to
+// the default.  Takes effect on the next Upload.
+func (m *Mesh) SetVertexFormat(format *VertexFormat) {
+	m.format = format
+	m.layoutDirty = true
+}
 
-	// Bind the VAO & Buffers
-	gl.BindVertexArray(m.vao)
-	gl.BindBuffer(gl.ARRAY_BUFFER, m.vbo)
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, m.ebo)
+// The format the mesh's vertices are interleaved with, or nil
+func (m *Mesh) VertexFormat() *VertexFormat {
+	return m.format
+}
+
+// The shader location an attribute is bound to.  Attributes whose
+// description is a number (as GLUT meshes have) use that number, anything
+// else uses its position in the mesh.
+func (m *Mesh) attributeLocation(i int) gl.Uint {
+	return defaultAttribLocation(m.attributes[i].desc, i)
+}
 
-	// If we don't have a valid context, we return an error.
-	if m.vao == 0 || m.vbo == 0 || m.ebo == 0{
-		return errors.New(fmt.Sprintf("Mesh:Render: Mesh context invalid: vao=%d, buffer=%d, element buffer=%d\n", m.vao, m.vbo, m.ebo))
+// The location for the i'th attribute or element, described by desc,
+// when nothing says otherwise - shared by meshes and VertexFormats so a
+// mesh's locations don't change when it's given a format
+func defaultAttribLocation(desc string, i int) gl.Uint {
+	if loc, err := strconv.ParseUint(desc, 10, 32); err == nil {
+		return gl.Uint(loc)
 	}
-	if gl.IsBuffer(m.vbo) == gl.FALSE || gl.IsBuffer(m.ebo) == gl.FALSE {
-		return errors.New("Mesh:Render: Invalid OpenGL buffer!")
+	return gl.Uint(i)
+}
+
+// Is there anything Upload would need to send?
+func (m *Mesh) isDirty() bool {
+	if m.layoutDirty {
+		return true
 	}
-	if gl.IsVertexArray(m.vao) == gl.FALSE {
-		return errors.New("Mesh:Render: Invalid OpenGL VAO!")
+	for _, attr := range m.attributes {
+		if attr.dirty {
+			return true
+		}
+	}
+	for _, indx := range m.indices {
+		if indx.dirty {
+			return true
+		}
+	}
+	return false
+}
+
+// The vertex array calls Upload and Release make, alongside BufferAPI
+type vertexArrayAPI interface {
+	GenVertexArray() gl.Uint
+	DeleteVertexArray(id gl.Uint)
+	BindVertexArray(id gl.Uint)
+	// Enables loc and points it at the bound vertex buffer
+	AttribPointer(loc gl.Uint, count int, componentType gl.Enum, normalized bool, stride, offset int)
+}
+
+type glVertexArrayAPI struct{}
+
+func (glVertexArrayAPI) GenVertexArray() gl.Uint {
+	var id gl.Uint
+	gl.GenVertexArrays(1, &id)
+	return id
+}
+
+func (glVertexArrayAPI) DeleteVertexArray(id gl.Uint) {
+	gl.DeleteVertexArrays(1, &id)
+}
+
+func (glVertexArrayAPI) BindVertexArray(id gl.Uint) {
+	gl.BindVertexArray(id)
+}
+
+func (glVertexArrayAPI) AttribPointer(loc gl.Uint, count int, componentType gl.Enum, normalized bool, stride, offset int) {
+	norm := gl.Boolean(gl.FALSE)
+	if normalized {
+		norm = gl.TRUE
+	}
+	gl.EnableVertexAttribArray(loc)
+	gl.VertexAttribPointer(loc, gl.Int(count), componentType, norm, gl.Sizei(stride), bufferOffset(offset))
+}
+
+func (m *Mesh) glAPIs() (BufferAPI, vertexArrayAPI) {
+	buffers, arrays := m.bufferAPI, m.vertexArrayAPI
+	if buffers == nil {
+		buffers = glBufferAPI{}
+	}
+	if arrays == nil {
+		arrays = glVertexArrayAPI{}
+	}
+	return buffers, arrays
+}
+
+// Upload - sends the mesh to the GL.  The first call creates the VAO,
+// vertex buffer and element buffer: every attribute goes into the vertex
+// buffer one after the other, with an attribute pointer for each, and every
+// index goes into the element buffer one after the other.  Later calls only
+// send the attributes and indices that have changed (see MarkDirty), unless
+// something was added or changed size, in which case everything is laid
+// out again.  If the mesh has a VertexFormat, the attributes are
+// interleaved instead, and any change re-sends the whole vertex buffer.
+func (m *Mesh) Upload() error {
+	if len(m.attributes) == 0 {
+		return errors.New("Mesh:Upload: Mesh has no attributes")
+	}
+
+	buffers, arrays := m.glAPIs()
+	if m.vao == 0 {
+		m.vao = arrays.GenVertexArray()
+		m.layoutDirty = true
+	}
+	if m.vbo == nil {
+		m.vbo = NewBufferWithAPI(buffers, gl.ARRAY_BUFFER, gl.STATIC_DRAW)
+		m.layoutDirty = true
+	}
+	if m.ebo == nil {
+		m.ebo = NewBufferWithAPI(buffers, gl.ELEMENT_ARRAY_BUFFER, gl.STATIC_DRAW)
+		m.layoutDirty = true
+	}
+	floatSize := int(unsafe.Sizeof(gl.Float(0)))
+	uintSize := int(unsafe.Sizeof(gl.Uint(0)))
+	for _, attr := range m.attributes {
+		if attr.dirty && attr.size != len(attr.data)*floatSize {
+			m.layoutDirty = true
+		}
+	}
+	for _, indx := range m.indices {
+		if indx.dirty && indx.size != len(indx.data)*uintSize {
+			m.layoutDirty = true
+		}
+	}
+
+	arrays.BindVertexArray(m.vao)
+	defer arrays.BindVertexArray(0)
+
+	if m.layoutDirty && m.format != nil {
+		data, err := m.format.Interleave(m)
+		if err != nil {
+			return err
+		}
+		if err := m.vbo.UploadBytes(data); err != nil {
+			return err
+		}
+		m.format.setupAttribPointers(arrays, 0)
+		for _, attr := range m.attributes {
+			attr.size = len(attr.data) * floatSize
+			attr.dirty = false
+		}
+	} else if m.layoutDirty {
+		// Lay the attributes out one after the other
+		vertexSize := 0
+		for _, attr := range m.attributes {
+			attr.offset = vertexSize
+			attr.size = len(attr.data) * floatSize
+			vertexSize += attr.size
+		}
+		if err := m.vbo.Allocate(vertexSize); err != nil {
+			return err
+		}
+		for i, attr := range m.attributes {
+			if err := m.vbo.UpdateFloats(attr.offset, attr.data); err != nil {
+				return err
+			}
+			arrays.AttribPointer(m.attributeLocation(i), attr.stride, gl.FLOAT, false, 0, attr.offset)
+			attr.dirty = false
+		}
 	}
-	// If we don't have any indices, we don't have anything to do, return
-	if len(m.indices) == 0 {
+
+	if m.layoutDirty {
+		// Lay the indices out one after the other.  The element buffer
+		// binding is part of the VAO's state, so it's bound while the
+		// VAO is.
+		indexSize := 0
+		for _, indx := range m.indices {
+			indx.offset = indexSize
+			indx.size = len(indx.data) * uintSize
+			indexSize += indx.size
+		}
+		if err := m.ebo.Allocate(indexSize); err != nil {
+			return err
+		}
+		for _, indx := range m.indices {
+			if err := m.ebo.UpdateUints(indx.offset, indx.data); err != nil {
+				return err
+			}
+			indx.dirty = false
+		}
+		m.layoutDirty = false
 		return nil
 	}
 
-	// Buffer the vertex positions
-	bufferLen := unsafe.Sizeof(gl.Float(0)) * (uintptr)(len(m.attributes[0].data))
-	gl.BufferData(gl.ARRAY_BUFFER,
-		gl.Sizeiptr(bufferLen),
-		gl.Pointer(&m.attributes[0].data[0]),
-		gl.STATIC_DRAW)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	// Just send what's changed
+	if m.format != nil {
+		for _, attr := range m.attributes {
+			if attr.dirty {
+				data, err := m.format.Interleave(m)
+				if err != nil {
+					return err
+				}
+				if err := m.vbo.UpdateBytes(0, data); err != nil {
+					return err
+				}
+				for _, attr := range m.attributes {
+					attr.dirty = false
+				}
+				break
+			}
+		}
+	}
+	for _, attr := range m.attributes {
+		if attr.dirty {
+			if err := m.vbo.UpdateFloats(attr.offset, attr.data); err != nil {
+				return err
+			}
+			attr.dirty = false
+		}
+	}
+	for _, indx := range m.indices {
+		if indx.dirty {
+			if err := m.ebo.UpdateUints(indx.offset, indx.data); err != nil {
+				return err
+			}
+			indx.dirty = false
+		}
+	}
+	return nil
+}
+
+// Byte offset into the bound buffer, for the GL calls that take one in
+// place of a pointer.  The pointer is only ever an offset for the driver
+// and never dereferenced, though go vet reports the conversion as a
+// possible misuse of unsafe.Pointer.
+func bufferOffset(offset int) gl.Pointer {
+	return gl.Pointer(uintptr(offset))
+}
+
+// Render the mesh: bind its VAO and issue one draw call per index.  Anything
+// that has changed since the last Upload is uploaded first.
+func (m *Mesh) Render() error {
+	if m.isDirty() {
+		if err := m.Upload(); err != nil {
+			return err
+		}
+	}
+	if m.vao == 0 || gl.IsVertexArray(m.vao) == gl.FALSE {
+		return errors.New("Mesh:Render: Invalid OpenGL VAO!")
+	}
 
-	// For each index, buffer the indices and
-	// draw that part of the mesh
+	gl.BindVertexArray(m.vao)
 	for _, indx := range m.indices {
-		bufferLen = unsafe.Sizeof(gl.Uint(0)) * (uintptr)(len(indx.data))
-		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER,
-			gl.Sizeiptr(bufferLen),
-			gl.Pointer(&indx.data[0]),
-			gl.STATIC_DRAW)
+		if len(indx.data) == 0 {
+			continue
+		}
+		if indx.restartEnabled {
+			gl.Enable(gl.PRIMITIVE_RESTART)
+			gl.PrimitiveRestartIndex(indx.restart)
+		}
 		gl.DrawElements(indx.primitive,
 			gl.Sizei(len(indx.data)),
-			gl.UNSIGNED_INT, nil)
+			gl.UNSIGNED_INT, bufferOffset(indx.offset))
+		if indx.restartEnabled {
+			gl.Disable(gl.PRIMITIVE_RESTART)
+		}
 	}
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, 0)
-	// Unbind the VAO
 	gl.BindVertexArray(0)
 	return nil
 }
 
-
+// Release - frees the mesh's GL objects.  The mesh data is kept, so the
+// mesh can be uploaded again later.
+func (m *Mesh) Release() {
+	if m.vbo != nil {
+		m.vbo.Delete()
+		m.vbo = nil
+	}
+	if m.ebo != nil {
+		m.ebo.Delete()
+		m.ebo = nil
+	}
+	if m.vao != 0 {
+		_, arrays := m.glAPIs()
+		arrays.DeleteVertexArray(m.vao)
+		m.vao = 0
+	}
+	m.layoutDirty = true
+}
@@ -0,0 +1,181 @@
+package goglutils
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// vertexArrayAPI that records the attribute pointers set up
+type fakeVertexArrayAPI struct {
+	next     gl.Uint
+	live     map[gl.Uint]bool
+	bound    gl.Uint
+	pointers map[gl.Uint]fakeAttribPointer
+}
+
+type fakeAttribPointer struct {
+	count          int
+	componentType  gl.Enum
+	normalized     bool
+	stride, offset int
+}
+
+func newFakeVertexArrayAPI() *fakeVertexArrayAPI {
+	return &fakeVertexArrayAPI{live: make(map[gl.Uint]bool), pointers: make(map[gl.Uint]fakeAttribPointer)}
+}
+
+func (f *fakeVertexArrayAPI) GenVertexArray() gl.Uint {
+	f.next++
+	f.live[f.next] = true
+	return f.next
+}
+
+func (f *fakeVertexArrayAPI) DeleteVertexArray(id gl.Uint) {
+	delete(f.live, id)
+}
+
+func (f *fakeVertexArrayAPI) BindVertexArray(id gl.Uint) {
+	f.bound = id
+}
+
+func (f *fakeVertexArrayAPI) AttribPointer(loc gl.Uint, count int, componentType gl.Enum, normalized bool, stride, offset int) {
+	f.pointers[loc] = fakeAttribPointer{count, componentType, normalized, stride, offset}
+}
+
+func fakeMesh() (*Mesh, *fakeBufferAPI, *fakeVertexArrayAPI) {
+	m := NewMesh("tri")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0, 1, 0, 0, 0, 1, 0}, 3)
+	m.AddMeshAttribute(AttribTexCoord, []gl.Float{0, 0, 1, 0, 0, 1}, 2)
+	m.AddMeshIndex("tris", []gl.Uint{0, 1, 2}, gl.TRIANGLES, m.Attribute(0))
+	m.AddMeshIndex("edge", []gl.Uint{0, 1}, gl.LINES, m.Attribute(0))
+	buffers, arrays := newFakeBufferAPI(), newFakeVertexArrayAPI()
+	m.bufferAPI, m.vertexArrayAPI = buffers, arrays
+	return m, buffers, arrays
+}
+
+func fakeFloat(data []byte, i int) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+}
+
+func TestMeshUpload(t *testing.T) {
+	m, buffers, arrays := fakeMesh()
+	if err := m.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	if !arrays.live[m.vao] || arrays.bound != 0 {
+		t.Errorf("Upload should create a VAO and leave it unbound")
+	}
+	vertices := buffers.data[m.vbo.Id()]
+	if len(vertices) != 15*4 || fakeFloat(vertices, 3) != 1 || fakeFloat(vertices, 11) != 1 {
+		t.Errorf("Vertex buffer holds %d bytes, want the attributes one after the other", len(vertices))
+	}
+	if p := arrays.pointers[1]; p.count != 2 || p.componentType != gl.FLOAT || p.offset != 36 {
+		t.Errorf("Texcoord pointer is %+v, want 2 floats at 36", p)
+	}
+	elements := buffers.data[m.ebo.Id()]
+	if len(elements) != 5*4 || binary.LittleEndian.Uint32(elements[16:]) != 1 || m.Index(1).offset != 12 {
+		t.Errorf("Element buffer holds %d bytes, want the indices one after the other", len(elements))
+	}
+
+	// A change of contents is sent in place
+	uploads := buffers.uploads
+	m.Attribute(1).Data()[0] = 0.5
+	m.Attribute(1).MarkDirty()
+	if !m.isDirty() {
+		t.Fatalf("MarkDirty should leave the mesh needing an upload")
+	}
+	m.Upload()
+	if buffers.uploads != uploads || fakeFloat(buffers.data[m.vbo.Id()], 9) != 0.5 {
+		t.Errorf("Changed attribute should be updated in place")
+	}
+
+	// A change of size lays everything out again
+	m.Attribute(1).SetData([]gl.Float{0, 0, 1, 0, 0, 1, 1, 1})
+	m.Attribute(0).SetData(append(m.Attribute(0).Data(), 1, 1, 0))
+	m.Upload()
+	if buffers.uploads == uploads || len(buffers.data[m.vbo.Id()]) != 20*4 {
+		t.Errorf("Resized attributes should be laid out again")
+	}
+	if m.isDirty() {
+		t.Errorf("Mesh still needs an upload after Upload")
+	}
+}
+
+func TestMeshUploadFormat(t *testing.T) {
+	m, buffers, arrays := fakeMesh()
+	format, _ := NewVertexFormat(
+		VertexElement{Semantic: AttribPosition, Type: gl.FLOAT, Count: 3},
+		VertexElement{Semantic: AttribTexCoord, Type: gl.UNSIGNED_SHORT, Count: 2, Normalized: true},
+	)
+	m.SetVertexFormat(format)
+	if err := m.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	vertices := buffers.data[m.vbo.Id()]
+	if len(vertices) != 3*format.Stride || fakeFloat(vertices[format.Stride:], 0) != 1 {
+		t.Errorf("Vertex buffer holds %d bytes, want 3 interleaved vertices", len(vertices))
+	}
+	if p := arrays.pointers[1]; p.componentType != gl.UNSIGNED_SHORT || !p.normalized || p.stride != format.Stride || p.offset != 12 {
+		t.Errorf("Texcoord pointer is %+v", p)
+	}
+}
+
+func TestMeshRelease(t *testing.T) {
+	m, buffers, arrays := fakeMesh()
+	m.Upload()
+	vao, vbo, ebo := m.vao, m.vbo.Id(), m.ebo.Id()
+	m.Release()
+	if arrays.live[vao] || m.vao != 0 || m.vbo != nil || m.ebo != nil {
+		t.Errorf("Release should free the VAO and buffers")
+	}
+	if _, ok := buffers.data[vbo]; ok {
+		t.Errorf("Release left the vertex buffer")
+	}
+	if _, ok := buffers.data[ebo]; ok {
+		t.Errorf("Release left the element buffer")
+	}
+	if m.NumAttributes() != 2 || !m.isDirty() {
+		t.Errorf("Release should keep the mesh data for the next Upload")
+	}
+	if err := m.Upload(); err != nil || !arrays.live[m.vao] || len(buffers.data[m.vbo.Id()]) != 15*4 {
+		t.Errorf("Upload after Release should build everything again")
+	}
+}
+
+func TestMeshUploadEmpty(t *testing.T) {
+	m := NewMesh("empty")
+	if err := m.Upload(); err == nil {
+		t.Errorf("Upload of a mesh with no attributes should fail")
+	}
+}
+
+func TestMeshFormatKeepsLocations(t *testing.T) {
+	// A GLUT mesh's numbered attributes stay at their numbers when it's
+	// given a format listing them in another order
+	m := NewMesh("glut")
+	m.AddMeshAttribute("0", []gl.Float{0, 0, 0, 1, 0, 0, 0, 1, 0}, 3)
+	m.AddMeshAttribute("2", []gl.Float{0, 0, 1, 0, 0, 1}, 2)
+	arrays := newFakeVertexArrayAPI()
+	m.bufferAPI, m.vertexArrayAPI = newFakeBufferAPI(), arrays
+	m.Upload()
+	plain := map[gl.Uint]int{}
+	for loc, p := range arrays.pointers {
+		plain[loc] = p.count
+	}
+
+	format, _ := NewVertexFormat(
+		VertexElement{Semantic: "2", Type: gl.FLOAT, Count: 2},
+		VertexElement{Semantic: "0", Type: gl.FLOAT, Count: 3},
+	)
+	m.SetVertexFormat(format)
+	arrays.pointers = make(map[gl.Uint]fakeAttribPointer)
+	m.Upload()
+	for loc, count := range plain {
+		if p, ok := arrays.pointers[loc]; !ok || p.count != count {
+			t.Errorf("Location %d has %d components with a format, %d without", loc, p.count, count)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// meshlists - expand strips, fans and loops into plain lists, and back
+//
+// A MeshIndex can draw triangle strips and fans, line strips and loops,
+// optionally broken up with a primitive restart index.  Triangles() and
+// Lines() unwind any of these into explicit triangles and line segments,
+// so CPU-side code only ever has to deal with lists, and ToTriangleList()
+// and ToLineList() rewrite an index that way.  Stripify() goes the other
+// way, turning a triangle list into strips joined either by the restart
+// index or by degenerate triangles.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// The usual primitive restart index for GL_UNSIGNED_INT indices
+const DefaultRestartIndex gl.Uint = 0xFFFFFFFF
+
+// SetRestartIndex - treats restart in the index data as the end of one
+// strip/fan/loop and the start of the next.  Render() enables
+// GL_PRIMITIVE_RESTART while drawing the index.
+func (mi *MeshIndex) SetRestartIndex(restart gl.Uint) {
+	mi.restart = restart
+	mi.restartEnabled = true
+}
+
+// ClearRestartIndex - turns primitive restart off for the index
+func (mi *MeshIndex) ClearRestartIndex() {
+	mi.restartEnabled = false
+}
+
+// RestartIndex - the primitive restart index, and whether it's enabled
+func (mi *MeshIndex) RestartIndex() (gl.Uint, bool) {
+	return mi.restart, mi.restartEnabled
+}
+
+// The index data split at each restart index
+func (mi *MeshIndex) runs() [][]gl.Uint {
+	if !mi.restartEnabled {
+		return [][]gl.Uint{mi.data}
+	}
+	var runs [][]gl.Uint
+	start := 0
+	for i, v := range mi.data {
+		if v == mi.restart {
+			runs = append(runs, mi.data[start:i])
+			start = i + 1
+		}
+	}
+	return append(runs, mi.data[start:])
+}
+
+// Does the primitive draw triangles?
+func isTrianglePrimitive(primitive gl.Enum) bool {
+	return primitive == gl.TRIANGLES || primitive == gl.TRIANGLE_STRIP || primitive == gl.TRIANGLE_FAN
+}
+
+// Does the primitive draw lines?
+func isLinePrimitive(primitive gl.Enum) bool {
+	return primitive == gl.LINES || primitive == gl.LINE_STRIP || primitive == gl.LINE_LOOP
+}
+
+// Triangles - the triangles the index draws, as triples of vertex indices.
+// Strips are unwound so every triangle keeps the strip's winding, and the
+// degenerate triangles used to join strips are dropped.  Indices that
+// don't draw triangles have none.
+func (mi *MeshIndex) Triangles() [][3]gl.Uint {
+	var tris [][3]gl.Uint
+	for _, d := range mi.runs() {
+		switch mi.primitive {
+		case gl.TRIANGLES:
+			for i := 0; i+2 < len(d); i += 3 {
+				tris = append(tris, [3]gl.Uint{d[i], d[i+1], d[i+2]})
+			}
+		case gl.TRIANGLE_STRIP:
+			for i := 0; i+2 < len(d); i++ {
+				if d[i] == d[i+1] || d[i+1] == d[i+2] || d[i] == d[i+2] {
+					continue
+				}
+				if i%2 == 0 {
+					tris = append(tris, [3]gl.Uint{d[i], d[i+1], d[i+2]})
+				} else {
+					tris = append(tris, [3]gl.Uint{d[i+1], d[i], d[i+2]})
+				}
+			}
+		case gl.TRIANGLE_FAN:
+			for i := 1; i+1 < len(d); i++ {
+				tris = append(tris, [3]gl.Uint{d[0], d[i], d[i+1]})
+			}
+		}
+	}
+	return tris
+}
+
+// Lines - the line segments the index draws, as pairs of vertex indices.
+// Triangles give their edges, each shared edge only once, for wireframes.
+// Points have none.
+func (mi *MeshIndex) Lines() [][2]gl.Uint {
+	var lines [][2]gl.Uint
+	if isTrianglePrimitive(mi.primitive) {
+		seen := make(map[[2]gl.Uint]bool)
+		for _, t := range mi.Triangles() {
+			for k := 0; k < 3; k++ {
+				a, b := t[k], t[(k+1)%3]
+				if b < a {
+					a, b = b, a
+				}
+				if !seen[[2]gl.Uint{a, b}] {
+					seen[[2]gl.Uint{a, b}] = true
+					lines = append(lines, [2]gl.Uint{t[k], t[(k+1)%3]})
+				}
+			}
+		}
+		return lines
+	}
+	for _, d := range mi.runs() {
+		switch mi.primitive {
+		case gl.LINES:
+			for i := 0; i+1 < len(d); i += 2 {
+				lines = append(lines, [2]gl.Uint{d[i], d[i+1]})
+			}
+		case gl.LINE_STRIP, gl.LINE_LOOP:
+			for i := 0; i+1 < len(d); i++ {
+				lines = append(lines, [2]gl.Uint{d[i], d[i+1]})
+			}
+			if mi.primitive == gl.LINE_LOOP && len(d) > 2 {
+				lines = append(lines, [2]gl.Uint{d[len(d)-1], d[0]})
+			}
+		}
+	}
+	return lines
+}
+
+// Replaces the index data with a plain list
+func (mi *MeshIndex) setList(primitive gl.Enum, data []gl.Uint) {
+	mi.data = data
+	mi.primitive = primitive
+	mi.restartEnabled = false
+	mi.dirty = true
+}
+
+// ToTriangleList - rewrites a triangle strip or fan as GL_TRIANGLES
+func (mi *MeshIndex) ToTriangleList() error {
+	if !isTrianglePrimitive(mi.primitive) {
+		return errors.New(fmt.Sprintf("MeshIndex:ToTriangleList: Index %s doesn't draw triangles", mi.desc))
+	}
+	tris := mi.Triangles()
+	data := make([]gl.Uint, 0, len(tris)*3)
+	for _, t := range tris {
+		data = append(data, t[0], t[1], t[2])
+	}
+	mi.setList(gl.TRIANGLES, data)
+	return nil
+}
+
+// ToLineList - rewrites a line strip or loop as GL_LINES.  Triangle
+// primitives become a list of their edges.
+func (mi *MeshIndex) ToLineList() error {
+	if !isTrianglePrimitive(mi.primitive) && !isLinePrimitive(mi.primitive) {
+		return errors.New(fmt.Sprintf("MeshIndex:ToLineList: Index %s doesn't draw lines or triangles", mi.desc))
+	}
+	lines := mi.Lines()
+	data := make([]gl.Uint, 0, len(lines)*2)
+	for _, l := range lines {
+		data = append(data, l[0], l[1])
+	}
+	mi.setList(gl.LINES, data)
+	return nil
+}
+
+// ExpandPrimitives - rewrites every index of the mesh as a plain list:
+// triangle primitives become GL_TRIANGLES and line primitives GL_LINES.
+// Points are left alone.
+func (m *Mesh) ExpandPrimitives() {
+	for _, indx := range m.indices {
+		if isTrianglePrimitive(indx.primitive) {
+			indx.ToTriangleList()
+		} else if isLinePrimitive(indx.primitive) {
+			indx.ToLineList()
+		}
+	}
+}
+
+// Stripify - rewrites the index's triangles as a GL_TRIANGLE_STRIP,
+// keeping their winding.  Strips are grown greedily across shared edges;
+// with useRestart they are joined by the index's restart index
+// (DefaultRestartIndex if none is set), otherwise by degenerate triangles.
+func (mi *MeshIndex) Stripify(useRestart bool) error {
+	if !isTrianglePrimitive(mi.primitive) {
+		return errors.New(fmt.Sprintf("MeshIndex:Stripify: Index %s doesn't draw triangles", mi.desc))
+	}
+	tris := mi.Triangles()
+
+	// Triangles by each of their directed edges
+	type edge [2]gl.Uint
+	byEdge := make(map[edge][]int)
+	for t, tri := range tris {
+		for k := 0; k < 3; k++ {
+			e := edge{tri[k], tri[(k+1)%3]}
+			byEdge[e] = append(byEdge[e], t)
+		}
+	}
+	used := make([]bool, len(tris))
+
+	// The unused triangle that continues a strip across edge a->b, and
+	// its third vertex
+	next := func(a, b gl.Uint) (int, gl.Uint, bool) {
+		for _, t := range byEdge[edge{a, b}] {
+			if used[t] {
+				continue
+			}
+			for k := 0; k < 3; k++ {
+				if tris[t][k] == a {
+					return t, tris[t][(k+2)%3], true
+				}
+			}
+		}
+		return 0, 0, false
+	}
+
+	// Grows a strip from triangle t, starting at corner k.  Triangles used
+	// are marked in used, so the caller has to reset them for a trial run.
+	grow := func(t, k int) ([]gl.Uint, []int) {
+		tri := tris[t]
+		strip := []gl.Uint{tri[k], tri[(k+1)%3], tri[(k+2)%3]}
+		taken := []int{t}
+		used[t] = true
+		for {
+			n := len(strip)
+			a, b := strip[n-2], strip[n-1]
+			// Triangle n-2 of the strip is odd if n is odd
+			if n%2 == 1 {
+				a, b = b, a
+			}
+			nt, v, ok := next(a, b)
+			if !ok {
+				return strip, taken
+			}
+			used[nt] = true
+			taken = append(taken, nt)
+			strip = append(strip, v)
+		}
+	}
+
+	restart := DefaultRestartIndex
+	if mi.restartEnabled {
+		restart = mi.restart
+	}
+	var data []gl.Uint
+	for t := range tris {
+		if used[t] {
+			continue
+		}
+		// Try each rotation of the starting triangle and keep the longest
+		bestK, bestLen := 0, 0
+		for k := 0; k < 3; k++ {
+			strip, taken := grow(t, k)
+			for _, u := range taken {
+				used[u] = false
+			}
+			if len(strip) > bestLen {
+				bestK, bestLen = k, len(strip)
+			}
+		}
+		strip, _ := grow(t, bestK)
+
+		switch {
+		case len(data) == 0:
+		case useRestart:
+			data = append(data, restart)
+		default:
+			// Join with degenerate triangles, keeping the next strip on
+			// an even position so its winding is preserved
+			last := data[len(data)-1]
+			if len(data)%2 == 1 {
+				data = append(data, last)
+			}
+			data = append(data, last, strip[0])
+		}
+		data = append(data, strip...)
+	}
+
+	mi.setList(gl.TRIANGLE_STRIP, data)
+	if useRestart {
+		mi.SetRestartIndex(restart)
+	}
+	return nil
+}
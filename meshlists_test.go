@@ -0,0 +1,117 @@
+package goglutils
+
+import (
+	"sort"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Triangles rotated to start at their smallest vertex and sorted, so two
+// lists drawing the same triangles with the same winding compare equal
+func canonicalTriangles(tris [][3]gl.Uint) [][3]gl.Uint {
+	out := make([][3]gl.Uint, len(tris))
+	for i, t := range tris {
+		for t[0] > t[1] || t[0] > t[2] {
+			t = [3]gl.Uint{t[1], t[2], t[0]}
+		}
+		out[i] = t
+	}
+	sort.Slice(out, func(i, j int) bool {
+		for k := 0; k < 3; k++ {
+			if out[i][k] != out[j][k] {
+				return out[i][k] < out[j][k]
+			}
+		}
+		return false
+	})
+	return out
+}
+
+func equalTriangles(a, b [][3]gl.Uint) bool {
+	a, b = canonicalTriangles(a), canonicalTriangles(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestToTriangleList(t *testing.T) {
+	strip := NewMeshIndex("strip", []gl.Uint{0, 1, 2, 3, DefaultRestartIndex, 4, 5, 6}, gl.TRIANGLE_STRIP, nil)
+	strip.SetRestartIndex(DefaultRestartIndex)
+	if err := strip.ToTriangleList(); err != nil {
+		t.Fatal(err)
+	}
+	want := []gl.Uint{0, 1, 2, 2, 1, 3, 4, 5, 6}
+	if strip.Primitive() != gl.TRIANGLES || len(strip.Data()) != len(want) {
+		t.Fatalf("Strip expands to %v", strip.Data())
+	}
+	for i := range want {
+		if strip.Data()[i] != want[i] {
+			t.Fatalf("Strip expands to %v, want %v", strip.Data(), want)
+		}
+	}
+	if _, ok := strip.RestartIndex(); ok {
+		t.Errorf("A triangle list has no use for primitive restart")
+	}
+
+	fan := NewMeshIndex("fan", []gl.Uint{0, 1, 2, 3}, gl.TRIANGLE_FAN, nil)
+	fan.ToTriangleList()
+	if !equalTriangles(fan.Triangles(), [][3]gl.Uint{{0, 1, 2}, {0, 2, 3}}) {
+		t.Errorf("Fan expands to %v", fan.Data())
+	}
+
+	lines := NewMeshIndex("lines", []gl.Uint{0, 1}, gl.LINES, nil)
+	if err := lines.ToTriangleList(); err == nil {
+		t.Errorf("Lines can't become triangles")
+	}
+}
+
+func TestToLineList(t *testing.T) {
+	loop := NewMeshIndex("loop", []gl.Uint{0, 1, 2, 9, 3, 4, 5}, gl.LINE_LOOP, nil)
+	loop.SetRestartIndex(9)
+	if err := loop.ToLineList(); err != nil {
+		t.Fatal(err)
+	}
+	if loop.Primitive() != gl.LINES || len(loop.Data()) != 12 {
+		t.Errorf("Two 3-vertex loops give %v", loop.Data())
+	}
+
+	tris := NewMeshIndex("quad", []gl.Uint{0, 1, 2, 2, 1, 3}, gl.TRIANGLES, nil)
+	if len(tris.Lines()) != 5 {
+		t.Errorf("A quad has 5 edges, got %v", tris.Lines())
+	}
+}
+
+func TestStripify(t *testing.T) {
+	// A 3x2 grid of quads
+	var data []gl.Uint
+	for y := gl.Uint(0); y < 2; y++ {
+		for x := gl.Uint(0); x < 3; x++ {
+			v := y*4 + x
+			data = append(data, v, v+1, v+4, v+4, v+1, v+5)
+		}
+	}
+	data = append(data, 20, 21, 22) // and one on its own
+	for _, restart := range []bool{false, true} {
+		indx := NewMeshIndex("grid", append([]gl.Uint(nil), data...), gl.TRIANGLES, nil)
+		want := indx.Triangles()
+		if err := indx.Stripify(restart); err != nil {
+			t.Fatal(err)
+		}
+		if indx.Primitive() != gl.TRIANGLE_STRIP {
+			t.Errorf("Stripify gives primitive 0x%x", indx.Primitive())
+		}
+		if _, ok := indx.RestartIndex(); ok != restart {
+			t.Errorf("Restart index enabled is %v, want %v", ok, restart)
+		}
+		if !equalTriangles(indx.Triangles(), want) {
+			t.Errorf("Strips %v don't draw the original triangles", indx.Data())
+		}
+	}
+}
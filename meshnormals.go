@@ -0,0 +1,367 @@
+// meshnormals - generate normals and tangents for a Mesh
+//
+// Normals are worked out from the position attribute and the mesh's
+// triangles (points and lines are left alone) and stored as an
+// AttribNormal attribute, replacing any that's already there.  Where a
+// vertex needs more than one normal - a corner shared by differently
+// facing triangles, or a vertex on a crease - it is duplicated: the copies are
+// added to the end of every attribute and the triangles that need them
+// are re-pointed, so existing vertex numbers stay valid.  Triangle
+// strips and fans that get re-pointed become plain triangle lists.
+//
+// Tangents follow MikkTSpace's approach: per-corner tangents from the UV
+// derivatives, projected into the tangent plane, angle-weighted,
+// orthogonalised against the normal, and stored as a vec4 with the
+// bitangent's handedness in w, so bitangent = w * cross(normal, tangent).
+// Vertices where triangles with mirrored UVs meet are split, as MikkTSpace
+// splits them, so each side keeps its own handedness.  The results are
+// close to the reference implementation's but not bit-for-bit: it also
+// splits vertices between faces that only touch at a corner, which this
+// doesn't.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+)
+
+// How face normals are weighted when averaged into a smooth vertex normal
+type NormalWeighting int
+
+const (
+	AreaWeighted  NormalWeighting = iota // Bigger faces count for more
+	AngleWeighted                        // Faces count by the angle they make at the vertex
+)
+
+// Normalize, falling back to fallback for zero-length vectors
+func normalizeOr(v, fallback Vec3) Vec3 {
+	l := v.Length()
+	if l == 0 || math.IsNaN(float64(l)) {
+		return fallback
+	}
+	return Vec3{v.X / l, v.Y / l, v.Z / l}
+}
+
+// Angle between two vectors, in radians
+func angleBetween(u, v Vec3) gl.Float {
+	l := u.Length() * v.Length()
+	if l == 0 {
+		return 0
+	}
+	return gl.Float(math.Acos(float64(Clamp(u.Dot(&v)/l, -1, 1))))
+}
+
+// Number of vertices in the mesh.  Every attribute has to have the same
+// number for per-vertex operations to make sense.
+func (m *Mesh) vertexCount() (int, error) {
+	if len(m.attributes) == 0 {
+		return 0, errors.New("Mesh: Mesh has no attributes")
+	}
+	n := m.attributes[0].VertexCount()
+	for _, attr := range m.attributes[1:] {
+		if attr.VertexCount() != n {
+			return 0, errors.New(fmt.Sprintf("Mesh: Attribute %s has %d vertices, expected %d", attr.desc, attr.VertexCount(), n))
+		}
+	}
+	return n, nil
+}
+
+// A triangle of the mesh, and the index it came from
+type meshTriangle struct {
+	index *MeshIndex
+	v     [3]gl.Uint
+}
+
+// Every triangle the mesh draws
+func (m *Mesh) meshTriangles() []meshTriangle {
+	var tris []meshTriangle
+	for _, indx := range m.indices {
+		for _, t := range indx.Triangles() {
+			tris = append(tris, meshTriangle{indx, t})
+		}
+	}
+	return tris
+}
+
+// Appends a copy of vertex v to every attribute and returns its number
+func (m *Mesh) duplicateVertex(v gl.Uint) gl.Uint {
+	var n gl.Uint
+	for _, attr := range m.attributes {
+		n = gl.Uint(attr.VertexCount())
+		start := int(v) * attr.stride
+		attr.data = append(attr.data, attr.data[start:start+attr.stride]...)
+		attr.changed()
+	}
+	m.duplicateMorphVertex(int(v), int(n))
+	return n
+}
+
+// Stores one normal per triangle corner, duplicating vertices that end up
+// with more than one.
+func (m *Mesh) applyCornerNormals(tris []meshTriangle, corners [][3]Vec3, vertices int) error {
+	normals := make([]Vec3, vertices)
+	assigned := make([]bool, vertices)
+	type split struct {
+		v gl.Uint
+		n Vec3
+	}
+	copies := make(map[split]gl.Uint)
+	changed := make(map[*MeshIndex]bool)
+
+	for t := range tris {
+		for k := 0; k < 3; k++ {
+			v, n := tris[t].v[k], corners[t][k]
+			switch {
+			case !assigned[v]:
+				normals[v] = n
+				assigned[v] = true
+			case normals[v] == n:
+			default:
+				c, ok := copies[split{v, n}]
+				if !ok {
+					c = m.duplicateVertex(v)
+					copies[split{v, n}] = c
+					normals = append(normals, n)
+				}
+				tris[t].v[k] = c
+				changed[tris[t].index] = true
+			}
+		}
+	}
+
+	repointTriangles(tris, changed)
+
+	flat := make([]gl.Float, 0, len(normals)*3)
+	for _, n := range normals {
+		flat = append(flat, n.X, n.Y, n.Z)
+	}
+	return m.setMeshAttribute(AttribNormal, flat, 3)
+}
+
+// Rewrites the indices in changed from tris, whose corners have been
+// re-pointed at copied vertices
+func repointTriangles(tris []meshTriangle, changed map[*MeshIndex]bool) {
+	for indx := range changed {
+		data := make([]gl.Uint, 0, len(indx.data))
+		for _, t := range tris {
+			if t.index == indx {
+				data = append(data, t.v[0], t.v[1], t.v[2])
+			}
+		}
+		indx.setList(gl.TRIANGLES, data)
+	}
+}
+
+// Face normals of every triangle, scaled by twice the triangle's area
+func faceNormals(pos *MeshAttribute, tris []meshTriangle) []Vec3 {
+	normals := make([]Vec3, len(tris))
+	for i, t := range tris {
+		p0, p1, p2 := pos.vec3(int(t.v[0])), pos.vec3(int(t.v[1])), pos.vec3(int(t.v[2]))
+		normals[i] = *p1.Sub(&p0).Cross(p2.Sub(&p0))
+	}
+	return normals
+}
+
+// ComputeFlatNormals - gives every triangle's corners the triangle's face
+// normal.  Neighbouring triangles only share vertices if they face the
+// same way.
+func (m *Mesh) ComputeFlatNormals() error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	pos := m.positionAttribute()
+	tris := m.meshTriangles()
+	faces := faceNormals(pos, tris)
+	corners := make([][3]Vec3, len(tris))
+	for t := range tris {
+		n := normalizeOr(faces[t], Vec3{0, 0, 1})
+		corners[t] = [3]Vec3{n, n, n}
+	}
+	return m.applyCornerNormals(tris, corners, vertices)
+}
+
+// ComputeSmoothNormals - averages the normals of the faces around each
+// vertex.  Vertices at the same position are averaged together, so UV
+// seams don't show up as hard edges.  Faces whose normals differ by more
+// than creaseAngle degrees aren't averaged together, giving a hard edge
+// between them; pass 180 for fully smooth normals.
+func (m *Mesh) ComputeSmoothNormals(weighting NormalWeighting, creaseAngle gl.Float) error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	pos := m.positionAttribute()
+	tris := m.meshTriangles()
+	faces := faceNormals(pos, tris)
+	units := make([]Vec3, len(tris))
+	for t := range tris {
+		units[t] = normalizeOr(faces[t], Vec3{})
+	}
+	cosCrease := CosGL(DegToRad(Clamp(creaseAngle, 0, 180)))
+
+	// Group the triangle corners by position
+	type corner struct {
+		tri, k int
+	}
+	groups := make(map[Vec3][]corner)
+	for t := range tris {
+		for k := 0; k < 3; k++ {
+			p := pos.vec3(int(tris[t].v[k]))
+			groups[p] = append(groups[p], corner{t, k})
+		}
+	}
+
+	// The weighted normal face t contributes at its corner k
+	contribution := func(t, k int) Vec3 {
+		if weighting == AreaWeighted {
+			return faces[t]
+		}
+		p := pos.vec3(int(tris[t].v[k]))
+		a := pos.vec3(int(tris[t].v[(k+1)%3]))
+		b := pos.vec3(int(tris[t].v[(k+2)%3]))
+		return *units[t].MulS(angleBetween(*a.Sub(&p), *b.Sub(&p)))
+	}
+
+	corners := make([][3]Vec3, len(tris))
+	for t := range tris {
+		degenerate := units[t] == (Vec3{})
+		for k := 0; k < 3; k++ {
+			var sum Vec3
+			for _, c := range groups[pos.vec3(int(tris[t].v[k]))] {
+				if !degenerate && units[t].Dot(&units[c.tri]) < cosCrease-1e-6 {
+					continue
+				}
+				n := contribution(c.tri, c.k)
+				sum = *sum.Add(&n)
+			}
+			corners[t][k] = normalizeOr(sum, normalizeOr(faces[t], Vec3{0, 0, 1}))
+		}
+	}
+	return m.applyCornerNormals(tris, corners, vertices)
+}
+
+// ComputeTangents - works out per-vertex tangents and bitangents from the
+// mesh's positions, normals (AttribNormal) and UVs (AttribTexCoord), and
+// stores them as AttribTangent (vec4, handedness in w) and AttribBitangent.
+// Vertices shared by triangles with mirrored UVs are duplicated.
+func (m *Mesh) ComputeTangents() error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	pos := m.positionAttribute()
+	nrm := m.AttributeByDesc(AttribNormal)
+	uv := m.AttributeByDesc(AttribTexCoord)
+	if nrm == nil || uv == nil {
+		return errors.New("Mesh:ComputeTangents: Mesh needs normals and texture coordinates")
+	}
+
+	// Each triangle's UV-space tangent, and which way round its UVs go.
+	// A vertex shared by triangles whose UVs go opposite ways - where a
+	// mirrored half of a model meets the other - can't have one tangent
+	// frame, so, as MikkTSpace does, the triangles going the other way
+	// from the first get a copy of it.  Bitangents are rebuilt from the
+	// normal, tangent and handedness.
+	tris := m.meshTriangles()
+	faceT := make([]Vec3, len(tris))
+	orient := make([]gl.Float, len(tris)) // 1, -1, or 0 for degenerate UVs
+	for i, t := range tris {
+		var p [3]Vec3
+		var w [3]Vec2
+		for k := 0; k < 3; k++ {
+			p[k] = pos.vec3(int(t.v[k]))
+			w[k] = uv.vec2(int(t.v[k]))
+		}
+		e1, e2 := p[1].Sub(&p[0]), p[2].Sub(&p[0])
+		du1, dv1 := w[1].X-w[0].X, w[1].Y-w[0].Y
+		du2, dv2 := w[2].X-w[0].X, w[2].Y-w[0].Y
+		r := du1*dv2 - du2*dv1
+		if r == 0 {
+			// Degenerate UVs - nothing to contribute
+			continue
+		}
+		faceT[i] = *e1.MulS(dv2).Sub(e2.MulS(dv1)).MulS(1 / r)
+		orient[i] = 1
+		if r < 0 {
+			orient[i] = -1
+		}
+	}
+
+	signs := make([]gl.Float, vertices)
+	mirrored := make(map[gl.Uint]gl.Uint)
+	changed := make(map[*MeshIndex]bool)
+	for i := range tris {
+		if orient[i] == 0 {
+			continue
+		}
+		for k := 0; k < 3; k++ {
+			v := tris[i].v[k]
+			switch signs[v] {
+			case 0:
+				signs[v] = orient[i]
+			case orient[i]:
+			default:
+				c, ok := mirrored[v]
+				if !ok {
+					c = m.duplicateVertex(v)
+					mirrored[v] = c
+					signs = append(signs, orient[i])
+				}
+				tris[i].v[k] = c
+				changed[tris[i].index] = true
+			}
+		}
+	}
+	repointTriangles(tris, changed)
+	vertices = len(signs)
+
+	tangents := make([]Vec3, vertices)
+	for i, t := range tris {
+		if orient[i] == 0 {
+			continue
+		}
+		var p [3]Vec3
+		for k := 0; k < 3; k++ {
+			p[k] = pos.vec3(int(t.v[k]))
+		}
+		for k := 0; k < 3; k++ {
+			v := t.v[k]
+			n := nrm.vec3(int(v))
+			a, b := p[(k+1)%3].Sub(&p[k]), p[(k+2)%3].Sub(&p[k])
+			angle := angleBetween(*a, *b)
+			ct := normalizeOr(*faceT[i].Sub(n.MulS(n.Dot(&faceT[i]))), Vec3{})
+			tangents[v] = *tangents[v].Add(ct.MulS(angle))
+		}
+	}
+
+	tdata := make([]gl.Float, 0, vertices*4)
+	bdata := make([]gl.Float, 0, vertices*3)
+	for v := 0; v < vertices; v++ {
+		n := normalizeOr(nrm.vec3(v), Vec3{0, 0, 1})
+		t := normalizeOr(*tangents[v].Sub(n.MulS(n.Dot(&tangents[v]))), perpendicular(n))
+		sign := signs[v]
+		if sign == 0 {
+			sign = 1
+		}
+		b := n.Cross(&t).MulS(sign)
+		tdata = append(tdata, t.X, t.Y, t.Z, sign)
+		bdata = append(bdata, b.X, b.Y, b.Z)
+	}
+	if err := m.setMeshAttribute(AttribTangent, tdata, 4); err != nil {
+		return err
+	}
+	return m.setMeshAttribute(AttribBitangent, bdata, 3)
+}
+
+// Some unit vector perpendicular to n
+func perpendicular(n Vec3) Vec3 {
+	axis := Vec3{1, 0, 0}
+	if gl.Float(math.Abs(float64(n.X))) > 0.9 {
+		axis = Vec3{0, 1, 0}
+	}
+	return normalizeOr(*n.Cross(&axis), Vec3{0, 1, 0})
+}
@@ -0,0 +1,110 @@
+package goglutils
+
+import (
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Two triangles folded 90 degrees along the edge from vertex 0 to 1
+func foldedMesh() *Mesh {
+	m := NewMesh("fold")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1}, 3)
+	m.AddMeshAttribute(AttribTexCoord, []gl.Float{0, 0, 1, 0, 0, 1, 0, 1}, 2)
+	m.AddMeshIndex("0", []gl.Uint{0, 1, 2, 1, 0, 3}, gl.TRIANGLES, m.Attribute(0))
+	return m
+}
+
+func closeVec3(a, b Vec3) bool {
+	d := a.Sub(&b)
+	return d.Length() < 1e-5
+}
+
+func TestComputeFlatNormals(t *testing.T) {
+	m := foldedMesh()
+	if err := m.ComputeFlatNormals(); err != nil {
+		t.Fatal(err)
+	}
+	normals := m.AttributeByDesc(AttribNormal)
+	if normals == nil || normals.VertexCount() != 6 {
+		t.Fatalf("Shared edge should be split into 6 vertices")
+	}
+	if m.Attribute(1).VertexCount() != 6 {
+		t.Errorf("Split vertices should be added to every attribute")
+	}
+	data := m.Index(0).Data()
+	for k, want := range []Vec3{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 1, 0}, {0, 1, 0}, {0, 1, 0}} {
+		if got := normals.vec3(int(data[k])); !closeVec3(got, want) {
+			t.Errorf("Corner %d has normal %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestComputeSmoothNormals(t *testing.T) {
+	m := foldedMesh()
+	if err := m.ComputeSmoothNormals(AngleWeighted, 180); err != nil {
+		t.Fatal(err)
+	}
+	normals := m.AttributeByDesc(AttribNormal)
+	if normals.VertexCount() != 4 {
+		t.Fatalf("Smooth normals shouldn't split vertices, have %d", normals.VertexCount())
+	}
+	n := (&Vec3{0, 1, 1}).Normalize()
+	if got := normals.vec3(0); !closeVec3(got, *n) {
+		t.Errorf("Normal on the fold is %v, want %v", got, *n)
+	}
+
+	// A crease angle under 90 degrees keeps the fold hard
+	m = foldedMesh()
+	m.ComputeSmoothNormals(AreaWeighted, 60)
+	if m.AttributeByDesc(AttribNormal).VertexCount() != 6 {
+		t.Errorf("Crease should split the shared edge")
+	}
+}
+
+func TestComputeTangents(t *testing.T) {
+	m := foldedMesh()
+	m.ComputeFlatNormals()
+	if err := m.ComputeTangents(); err != nil {
+		t.Fatal(err)
+	}
+	tangents := m.AttributeByDesc(AttribTangent)
+	if tangents == nil || tangents.Stride() != 4 || m.AttributeByDesc(AttribBitangent) == nil {
+		t.Fatalf("Tangents should be a vec4 attribute alongside bitangents")
+	}
+	v := m.Index(0).Data()[0]
+	tangent := tangents.Data()[int(v)*4 : int(v)*4+4]
+	if !closeVec3(Vec3{tangent[0], tangent[1], tangent[2]}, Vec3{1, 0, 0}) || tangent[3] != 1 {
+		t.Errorf("Tangent of the upper triangle is %v, want (1 0 0 1)", tangent)
+	}
+}
+
+func TestComputeTangentsMirrored(t *testing.T) {
+	// A quad whose right half mirrors the left half's UVs across x = 1
+	m := NewMesh("mirrored")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0, 1, 0, 0, 2, 0, 0, 0, 1, 0, 1, 1, 0, 2, 1, 0}, 3)
+	m.AddMeshAttribute(AttribNormal, []gl.Float{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1}, 3)
+	m.AddMeshAttribute(AttribTexCoord, []gl.Float{0, 0, 1, 0, 0, 0, 0, 1, 1, 1, 0, 1}, 2)
+	m.AddMeshIndex("0", []gl.Uint{0, 1, 4, 0, 4, 3, 1, 2, 5, 1, 5, 4}, gl.TRIANGLES, m.Attribute(0))
+	if err := m.ComputeTangents(); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Attribute(0).VertexCount(); n != 8 {
+		t.Fatalf("Mirror seam should split its 2 vertices, have %d vertices", n)
+	}
+	tangents := m.AttributeByDesc(AttribTangent).Data()
+	data := m.Index(0).Data()
+	for tri := 0; tri < 4; tri++ {
+		want := Vec3{1, 0, 0}
+		sign := gl.Float(1)
+		if tri >= 2 {
+			want, sign = Vec3{-1, 0, 0}, -1
+		}
+		for _, v := range data[tri*3 : tri*3+3] {
+			tg := tangents[v*4 : v*4+4]
+			if !closeVec3(Vec3{tg[0], tg[1], tg[2]}, want) || tg[3] != sign {
+				t.Errorf("Triangle %d vertex %d has tangent %v, want %v %v", tri, v, tg, want, sign)
+			}
+		}
+	}
+}
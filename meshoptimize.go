@@ -0,0 +1,407 @@
+// meshoptimize - reorder a mesh for the GPU's vertex cache and overdraw
+//
+// OptimizeVertexCache reorders an index's triangles with Tom Forsyth's
+// linear-speed vertex cache optimisation, so vertices are reused while
+// they're still in the post-transform cache.  OptimizeOverdraw then sorts
+// runs of those triangles, roughly outermost first, so nearer surfaces
+// tend to be drawn first without undoing much of the cache work.
+// OptimizeVertexFetch renumbers the vertices in the order the indices
+// first use them, so attribute fetches walk memory in order.
+//
+// AnalyzeVertexCache simulates a FIFO cache to report the ACMR (average
+// cache miss ratio - vertices transformed per triangle) and ATVR (average
+// transform to vertex ratio - vertices transformed per unique vertex).
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+	"sort"
+)
+
+// Cache size Forsyth's scoring is tuned for
+const forsythCacheSize = 32
+
+// Forsyth's tuning constants
+const (
+	forsythCacheDecayPower   = 1.5
+	forsythLastTriScore      = 0.75
+	forsythValenceBoostScale = 2.0
+	forsythValenceBoostPower = 0.5
+)
+
+// Score of a vertex at the given cache position (-1 if not cached) with
+// the given number of triangles still to draw
+func forsythScore(cachePos, remaining int) float64 {
+	if remaining == 0 {
+		return -1
+	}
+	score := 0.0
+	switch {
+	case cachePos < 0:
+	case cachePos < 3:
+		// The last triangle's vertices get a fixed score, so the next
+		// triangle doesn't just pick whichever of them ranks highest
+		score = forsythLastTriScore
+	default:
+		scaler := 1.0 / (forsythCacheSize - 3)
+		score = math.Pow(1-float64(cachePos-3)*scaler, forsythCacheDecayPower)
+	}
+	return score + forsythValenceBoostScale*math.Pow(float64(remaining), -forsythValenceBoostPower)
+}
+
+// Index triangles as a flat list, or an error if it doesn't draw any
+func (mi *MeshIndex) triangleList(caller string) ([][3]gl.Uint, error) {
+	if !isTrianglePrimitive(mi.primitive) {
+		return nil, errors.New(fmt.Sprintf("MeshIndex:%s: Index %s doesn't draw triangles", caller, mi.desc))
+	}
+	return mi.Triangles(), nil
+}
+
+// Stores triangles as the index's data, as a triangle list
+func (mi *MeshIndex) setTriangles(tris [][3]gl.Uint) {
+	data := make([]gl.Uint, 0, len(tris)*3)
+	for _, t := range tris {
+		data = append(data, t[0], t[1], t[2])
+	}
+	mi.setList(gl.TRIANGLES, data)
+}
+
+// Largest vertex number the triangles use, plus one
+func triangleVertexCount(tris [][3]gl.Uint) int {
+	n := 0
+	for _, t := range tris {
+		for _, v := range t {
+			if int(v) >= n {
+				n = int(v) + 1
+			}
+		}
+	}
+	return n
+}
+
+// Forsyth's algorithm proper - the triangles in their new order
+func forsythOrder(tris [][3]gl.Uint) [][3]gl.Uint {
+	vertices := triangleVertexCount(tris)
+
+	// Triangles using each vertex
+	remaining := make([]int, vertices)
+	for _, t := range tris {
+		for _, v := range t {
+			remaining[v]++
+		}
+	}
+	offsets := make([]int, vertices+1)
+	for v := 0; v < vertices; v++ {
+		offsets[v+1] = offsets[v] + remaining[v]
+	}
+	adjacency := make([]int, offsets[vertices])
+	fill := append([]int(nil), offsets[:vertices]...)
+	for i, t := range tris {
+		for _, v := range t {
+			adjacency[fill[v]] = i
+			fill[v]++
+		}
+	}
+
+	cachePos := make([]int, vertices)
+	score := make([]float64, vertices)
+	for v := range score {
+		cachePos[v] = -1
+		score[v] = forsythScore(-1, remaining[v])
+	}
+	triScore := make([]float64, len(tris))
+	for i, t := range tris {
+		triScore[i] = score[t[0]] + score[t[1]] + score[t[2]]
+	}
+	drawn := make([]bool, len(tris))
+
+	// Removes triangle i from its vertices' lists of triangles to draw
+	remove := func(i int) {
+		for _, v := range tris[i] {
+			list := adjacency[offsets[v] : offsets[v]+remaining[v]]
+			for k, t := range list {
+				if t == i {
+					list[k] = list[len(list)-1]
+					break
+				}
+			}
+			remaining[v]--
+		}
+	}
+
+	out := make([][3]gl.Uint, 0, len(tris))
+	var cache []gl.Uint
+	best := -1
+	next := 0 // Where to resume a full scan when the cache has nothing
+	for len(out) < len(tris) {
+		if best < 0 {
+			bestScore := -1.0
+			for ; next < len(tris); next++ {
+				if !drawn[next] {
+					break
+				}
+			}
+			for i := next; i < len(tris); i++ {
+				if !drawn[i] && triScore[i] > bestScore {
+					best, bestScore = i, triScore[i]
+				}
+			}
+		}
+
+		drawn[best] = true
+		out = append(out, tris[best])
+		remove(best)
+
+		// Move the triangle's vertices to the front of the cache
+		newCache := make([]gl.Uint, 0, forsythCacheSize+3)
+		newCache = append(newCache, tris[best][:]...)
+		for _, v := range cache {
+			if v != tris[best][0] && v != tris[best][1] && v != tris[best][2] {
+				newCache = append(newCache, v)
+			}
+		}
+		// Rescore everything that was or is in the cache
+		for i, v := range newCache {
+			if i < forsythCacheSize {
+				cachePos[v] = i
+			} else {
+				cachePos[v] = -1
+			}
+			score[v] = forsythScore(cachePos[v], remaining[v])
+		}
+		best = -1
+		bestScore := -1.0
+		for _, v := range newCache {
+			for _, t := range adjacency[offsets[v] : offsets[v]+remaining[v]] {
+				tri := tris[t]
+				triScore[t] = score[tri[0]] + score[tri[1]] + score[tri[2]]
+				if triScore[t] > bestScore {
+					best, bestScore = t, triScore[t]
+				}
+			}
+		}
+		if len(newCache) > forsythCacheSize {
+			newCache = newCache[:forsythCacheSize]
+		}
+		cache = newCache
+	}
+	return out
+}
+
+// OptimizeVertexCache - reorders the index's triangles for the
+// post-transform vertex cache, using Forsyth's algorithm.  Strips and fans
+// become a triangle list.
+func (mi *MeshIndex) OptimizeVertexCache() error {
+	tris, err := mi.triangleList("OptimizeVertexCache")
+	if err != nil {
+		return err
+	}
+	mi.setTriangles(forsythOrder(tris))
+	return nil
+}
+
+// Splits a cache-optimised triangle list into clusters, each ending where
+// drawing it from a cold cache would cost no more than threshold ACMR, so
+// the clusters can be drawn in any order without hurting the hit rate
+// much.  Returns the start of each cluster, and the end of the last.
+func clusterBoundaries(tris [][3]gl.Uint, cacheSize int, threshold float64) []int {
+	bounds := []int{0}
+	fifo := newVertexFIFO(cacheSize)
+	misses := 0
+	start := 0
+	for i, t := range tris {
+		for _, v := range t {
+			if !fifo.touch(v) {
+				misses++
+			}
+		}
+		// End the cluster when its ACMR drops below the threshold, ie.
+		// when the cache is working well and a restart would cost little
+		if n := i + 1 - start; n >= 16 && float64(misses)/float64(n) <= threshold {
+			bounds = append(bounds, i+1)
+			start, misses = i+1, 0
+			fifo = newVertexFIFO(cacheSize)
+		}
+	}
+	if bounds[len(bounds)-1] != len(tris) {
+		bounds = append(bounds, len(tris))
+	}
+	return bounds
+}
+
+// OptimizeOverdraw - splits the index's triangles into clusters and sorts
+// the clusters so those facing away from the mesh's centre, which tend to
+// hide the rest, come first.  Run it after OptimizeVertexCache; threshold
+// is how far the ACMR may rise as a result, e.g. 1.05 for 5%.
+func (m *Mesh) OptimizeOverdraw(index int, threshold gl.Float) error {
+	if index < 0 || index >= len(m.indices) {
+		return errors.New(fmt.Sprintf("Mesh:OptimizeOverdraw: No index %d", index))
+	}
+	indx := m.indices[index]
+	tris, err := indx.triangleList("OptimizeOverdraw")
+	if err != nil {
+		return err
+	}
+	pos := m.positionAttribute()
+	if pos == nil || len(tris) == 0 {
+		return nil
+	}
+	base := AnalyzeTriangles(tris, forsythCacheSize).ACMR
+	bounds := clusterBoundaries(tris, forsythCacheSize, float64(base*threshold))
+
+	// Mesh centre, weighted by triangle area
+	var centre Vec3
+	var total gl.Float
+	area := make([]gl.Float, len(tris))
+	centroid := make([]Vec3, len(tris))
+	normal := make([]Vec3, len(tris))
+	for i, t := range tris {
+		p0, p1, p2 := pos.vec3(int(t[0])), pos.vec3(int(t[1])), pos.vec3(int(t[2]))
+		n := p1.Sub(&p0).Cross(p2.Sub(&p0))
+		area[i] = n.Length()
+		normal[i] = *n
+		centroid[i] = *p0.Add(&p1).Add(&p2).MulS(1.0 / 3)
+		centre = *centre.Add(centroid[i].MulS(area[i]))
+		total += area[i]
+	}
+	if total > 0 {
+		centre = *centre.MulS(1 / total)
+	}
+
+	// A cluster's sort key is how far its area-weighted centroid lies
+	// along its area-weighted normal, from the centre
+	type cluster struct {
+		start, end int
+		key        gl.Float
+	}
+	clusters := make([]cluster, len(bounds)-1)
+	for c := range clusters {
+		var cc, cn Vec3
+		var ca gl.Float
+		for i := bounds[c]; i < bounds[c+1]; i++ {
+			cc = *cc.Add(centroid[i].MulS(area[i]))
+			cn = *cn.Add(&normal[i])
+			ca += area[i]
+		}
+		key := gl.Float(0)
+		if ca > 0 {
+			cc = *cc.MulS(1 / ca)
+			d := cc.Sub(&centre)
+			key = d.Dot(&cn) / ca
+		}
+		clusters[c] = cluster{bounds[c], bounds[c+1], key}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].key > clusters[j].key
+	})
+
+	out := make([][3]gl.Uint, 0, len(tris))
+	for _, c := range clusters {
+		out = append(out, tris[c.start:c.end]...)
+	}
+	indx.setTriangles(out)
+	return nil
+}
+
+// OptimizeVertexFetch - renumbers the mesh's vertices in the order the
+// indices first use them, so attribute data is fetched in order.  Vertices
+// no index uses are dropped.
+func (m *Mesh) OptimizeVertexFetch() error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	if err := m.checkIndices(vertices, "Mesh:OptimizeVertexFetch"); err != nil {
+		return err
+	}
+	remap := make([]int, vertices)
+	for v := range remap {
+		remap[v] = -1
+	}
+	var order []int
+	for _, indx := range m.indices {
+		for i, v := range indx.data {
+			if indx.restartEnabled && v == indx.restart {
+				continue
+			}
+			if remap[v] < 0 {
+				remap[v] = len(order)
+				order = append(order, int(v))
+			}
+			indx.data[i] = gl.Uint(remap[v])
+		}
+		indx.dirty = true
+	}
+	m.keepVertices(order)
+	m.layoutDirty = true
+	return nil
+}
+
+// Simulated FIFO post-transform cache
+type vertexFIFO struct {
+	size    int
+	entries []gl.Uint
+	cached  map[gl.Uint]bool
+}
+
+func newVertexFIFO(size int) *vertexFIFO {
+	return &vertexFIFO{size: size, cached: make(map[gl.Uint]bool)}
+}
+
+// Looks a vertex up, adding it on a miss.  Returns true on a hit.
+func (f *vertexFIFO) touch(v gl.Uint) bool {
+	if f.cached[v] {
+		return true
+	}
+	f.entries = append(f.entries, v)
+	f.cached[v] = true
+	if len(f.entries) > f.size {
+		delete(f.cached, f.entries[0])
+		f.entries = f.entries[1:]
+	}
+	return false
+}
+
+// VertexCacheStats - how well a triangle order uses a vertex cache
+type VertexCacheStats struct {
+	Triangles  int
+	Vertices   int      // Unique vertices used
+	Transforms int      // Cache misses, ie. vertex shader runs
+	ACMR       gl.Float // Transforms per triangle - 0.5 is ideal, 3 is worst
+	ATVR       gl.Float // Transforms per vertex - 1 is ideal
+}
+
+// AnalyzeTriangles - simulates drawing the triangles through a FIFO
+// vertex cache of the given size
+func AnalyzeTriangles(tris [][3]gl.Uint, cacheSize int) VertexCacheStats {
+	var stats VertexCacheStats
+	fifo := newVertexFIFO(cacheSize)
+	seen := make(map[gl.Uint]bool)
+	for _, t := range tris {
+		for _, v := range t {
+			if !fifo.touch(v) {
+				stats.Transforms++
+			}
+			seen[v] = true
+		}
+	}
+	stats.Triangles = len(tris)
+	stats.Vertices = len(seen)
+	if stats.Triangles > 0 {
+		stats.ACMR = gl.Float(stats.Transforms) / gl.Float(stats.Triangles)
+	}
+	if stats.Vertices > 0 {
+		stats.ATVR = gl.Float(stats.Transforms) / gl.Float(stats.Vertices)
+	}
+	return stats
+}
+
+// AnalyzeVertexCache - ACMR and ATVR of the index's triangles for a FIFO
+// vertex cache of the given size (16-32 is typical)
+func (mi *MeshIndex) AnalyzeVertexCache(cacheSize int) VertexCacheStats {
+	return AnalyzeTriangles(mi.Triangles(), cacheSize)
+}
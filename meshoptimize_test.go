@@ -0,0 +1,101 @@
+package goglutils
+
+import (
+	"math/rand"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// An n x n grid of quads in the z=0 plane, triangles shuffled
+func shuffledGrid(n int) *Mesh {
+	m := NewMesh("grid")
+	var pos []gl.Float
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			pos = append(pos, gl.Float(x), gl.Float(y), 0)
+		}
+	}
+	var tris [][3]gl.Uint
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			v := gl.Uint(y*(n+1) + x)
+			w := gl.Uint(n + 1)
+			tris = append(tris, [3]gl.Uint{v, v + 1, v + w}, [3]gl.Uint{v + w, v + 1, v + w + 1})
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(tris), func(i, j int) { tris[i], tris[j] = tris[j], tris[i] })
+	var data []gl.Uint
+	for _, t := range tris {
+		data = append(data, t[0], t[1], t[2])
+	}
+	m.AddMeshAttribute(AttribPosition, pos, 3)
+	m.AddMeshIndex("0", data, gl.TRIANGLES, m.Attribute(0))
+	return m
+}
+
+func TestOptimizeVertexCache(t *testing.T) {
+	m := shuffledGrid(32)
+	indx := m.Index(0)
+	want := indx.Triangles()
+	before := indx.AnalyzeVertexCache(16)
+	if err := indx.OptimizeVertexCache(); err != nil {
+		t.Fatal(err)
+	}
+	after := indx.AnalyzeVertexCache(16)
+	if !equalTriangles(indx.Triangles(), want) {
+		t.Fatalf("Optimisation changed the triangles drawn")
+	}
+	if after.ACMR >= before.ACMR || after.ACMR > 1 {
+		t.Errorf("ACMR went from %v to %v", before.ACMR, after.ACMR)
+	}
+	if after.Vertices != 33*33 || after.ATVR < 1 {
+		t.Errorf("Stats are off: %+v", after)
+	}
+
+	if err := m.OptimizeOverdraw(0, 1.05); err != nil {
+		t.Fatal(err)
+	}
+	if !equalTriangles(indx.Triangles(), want) {
+		t.Errorf("Overdraw sort changed the triangles drawn")
+	}
+}
+
+func TestOptimizeVertexFetch(t *testing.T) {
+	m := shuffledGrid(4)
+	indx := m.Index(0)
+	first := indx.Triangles()[0]
+	p := m.Attribute(0).vec3(int(first[1]))
+	if err := m.OptimizeVertexFetch(); err != nil {
+		t.Fatal(err)
+	}
+	data := indx.Data()
+	if data[0] != 0 || data[1] != 1 || data[2] != 2 {
+		t.Errorf("Vertices should be numbered in order of use, got %v", data[:3])
+	}
+	if got := m.Attribute(0).vec3(1); got != p {
+		t.Errorf("Vertex 1 is at %v, want %v", got, p)
+	}
+}
+
+func TestOptimizeVertexFetchBadIndex(t *testing.T) {
+	m := NewMesh("bad")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0}, 3)
+	m.AddMeshIndex("a", []gl.Uint{2, 1, 0}, gl.TRIANGLES, m.Attribute(0))
+	m.AddMeshIndex("b", []gl.Uint{3, 2, 9}, gl.TRIANGLES, m.Attribute(0))
+	if err := m.OptimizeVertexFetch(); err == nil {
+		t.Errorf("OptimizeVertexFetch with an index past the last vertex should fail")
+	}
+	a, b := m.Index(0).Data(), m.Index(1).Data()
+	if m.Attribute(0).VertexCount() != 4 || a[0] != 2 || a[2] != 0 || b[0] != 3 || b[2] != 9 {
+		t.Errorf("A failed OptimizeVertexFetch shouldn't change the mesh, indices are %v and %v", a, b)
+	}
+}
+
+func TestAnalyzeTriangles(t *testing.T) {
+	stats := AnalyzeTriangles([][3]gl.Uint{{0, 1, 2}, {2, 1, 3}}, 16)
+	if stats.Transforms != 4 || stats.ACMR != 2 || stats.ATVR != 1 {
+		t.Errorf("Quad stats are %+v", stats)
+	}
+}
@@ -0,0 +1,368 @@
+// meshprimitives - procedurally generated meshes
+//
+// Each generator returns a Mesh with AttribPosition, AttribNormal,
+// AttribTexCoord, AttribTangent and AttribBitangent attributes and a
+// single GL_TRIANGLES index, wound counter-clockwise when seen from
+// outside.  Meshes are centred on the origin with Y up.  Segment counts
+// below the minimum a shape needs are raised to it.
+
+package goglutils
+
+import (
+	"errors"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+)
+
+// Collects vertices and triangles for a generated mesh
+type meshBuilder struct {
+	pos, nrm, uv []gl.Float
+	index        []gl.Uint
+}
+
+// Adds a vertex and returns its number
+func (b *meshBuilder) vertex(p, n Vec3, u, v gl.Float) gl.Uint {
+	i := gl.Uint(len(b.pos) / 3)
+	b.pos = append(b.pos, p.X, p.Y, p.Z)
+	b.nrm = append(b.nrm, n.X, n.Y, n.Z)
+	b.uv = append(b.uv, u, v)
+	return i
+}
+
+func (b *meshBuilder) position(i gl.Uint) Vec3 {
+	return Vec3{b.pos[i*3], b.pos[i*3+1], b.pos[i*3+2]}
+}
+
+// Adds a triangle, unless two of its corners are in the same place (as
+// happens at the poles of a sphere)
+func (b *meshBuilder) triangle(i, j, k gl.Uint) {
+	pi, pj, pk := b.position(i), b.position(j), b.position(k)
+	if pi == pj || pj == pk || pi == pk {
+		return
+	}
+	b.index = append(b.index, i, j, k)
+}
+
+// Adds a grid of (cols+1) x (rows+1) vertices from f, which is given the
+// column and row of each, and joins them up into quads.  Triangles face
+// the way of the cross product of the column and row directions.
+func (b *meshBuilder) surface(cols, rows int, f func(col, row int) (p, n Vec3, u, v gl.Float)) {
+	base := gl.Uint(len(b.pos) / 3)
+	for row := 0; row <= rows; row++ {
+		for col := 0; col <= cols; col++ {
+			b.vertex(f(col, row))
+		}
+	}
+	at := func(col, row int) gl.Uint {
+		return base + gl.Uint(row*(cols+1)+col)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			a, c := at(col, row), at(col+1, row+1)
+			b.triangle(a, at(col+1, row), c)
+			b.triangle(a, c, at(col, row+1))
+		}
+	}
+}
+
+// Adds a flat disk at centre, facing up (+Y) or down
+func (b *meshBuilder) disk(centre Vec3, radius gl.Float, segments int, up bool) {
+	n := Vec3{0, 1, 0}
+	if !up {
+		n.Y = -1
+	}
+	mid := b.vertex(centre, n, 0.5, 0.5)
+	ring := make([]gl.Uint, segments+1)
+	for i := range ring {
+		s, c := sinCos(2 * math.Pi * float64(i) / float64(segments))
+		p := Vec3{centre.X + radius*s, centre.Y, centre.Z + radius*c}
+		u := 0.5 + 0.5*s
+		if !up {
+			u = 0.5 - 0.5*s
+		}
+		ring[i] = b.vertex(p, n, u, 0.5+0.5*c)
+	}
+	for i := 0; i < segments; i++ {
+		if up {
+			b.triangle(mid, ring[i], ring[i+1])
+		} else {
+			b.triangle(mid, ring[i+1], ring[i])
+		}
+	}
+}
+
+// Builds the mesh and works out its tangents
+func (b *meshBuilder) mesh(name string) *Mesh {
+	m := NewMesh(name)
+	m.AddMeshAttribute(AttribPosition, b.pos, 3)
+	m.AddMeshAttribute(AttribNormal, b.nrm, 3)
+	m.AddMeshAttribute(AttribTexCoord, b.uv, 2)
+	m.AddMeshIndex("0", b.index, gl.TRIANGLES, m.attributes[0])
+	m.ComputeTangents()
+	return m
+}
+
+// Sine and cosine of an angle in radians, as gl.Floats
+func sinCos(a float64) (gl.Float, gl.Float) {
+	s, c := math.Sincos(a)
+	return gl.Float(s), gl.Float(c)
+}
+
+func atLeast(n, min int) int {
+	if n < min {
+		return min
+	}
+	return n
+}
+
+// NewPlaneMesh - a width x depth plane in XZ facing +Y, split into
+// segX x segZ quads
+func NewPlaneMesh(width, depth gl.Float, segX, segZ int) *Mesh {
+	segX, segZ = atLeast(segX, 1), atLeast(segZ, 1)
+	b := new(meshBuilder)
+	b.surface(segX, segZ, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		u, v := gl.Float(col)/gl.Float(segX), gl.Float(row)/gl.Float(segZ)
+		return Vec3{width * (u - 0.5), 0, depth * (0.5 - v)}, Vec3{0, 1, 0}, u, v
+	})
+	return b.mesh("plane")
+}
+
+// NewCubeMesh - a cube with sides of size, each face split into
+// segments x segments quads with its own 0-1 UVs
+func NewCubeMesh(size gl.Float, segments int) *Mesh {
+	segments = atLeast(segments, 1)
+	// Each face's normal and the directions its columns and rows run in
+	faces := [6][3]Vec3{
+		{{1, 0, 0}, {0, 0, -1}, {0, 1, 0}},
+		{{-1, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+		{{0, 1, 0}, {1, 0, 0}, {0, 0, -1}},
+		{{0, -1, 0}, {1, 0, 0}, {0, 0, 1}},
+		{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},
+		{{0, 0, -1}, {-1, 0, 0}, {0, 1, 0}},
+	}
+	b := new(meshBuilder)
+	for _, f := range faces {
+		n, s, t := f[0], f[1], f[2]
+		b.surface(segments, segments, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+			u, v := gl.Float(col)/gl.Float(segments), gl.Float(row)/gl.Float(segments)
+			p := n.MulS(size / 2).Add(s.MulS(size * (u - 0.5))).Add(t.MulS(size * (v - 0.5)))
+			return *p, n, u, v
+		})
+	}
+	return b.mesh("cube")
+}
+
+// Unit vector for longitude col/segments and latitude row/rings, from the
+// south pole up
+func spherePoint(col, segments, row, rings int) Vec3 {
+	st, ct := sinCos(2 * math.Pi * float64(col) / float64(segments))
+	sa, ca := sinCos(math.Pi * float64(row) / float64(rings))
+	if row == 0 || row == rings {
+		sa = 0 // exactly on the pole
+	}
+	return Vec3{sa * st, -ca, sa * ct}
+}
+
+// NewUVSphereMesh - a sphere of radius made of segments around and rings
+// from pole to pole
+func NewUVSphereMesh(radius gl.Float, segments, rings int) *Mesh {
+	segments, rings = atLeast(segments, 3), atLeast(rings, 2)
+	b := new(meshBuilder)
+	b.surface(segments, rings, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		n := spherePoint(col, segments, row, rings)
+		return *n.MulS(radius), n, gl.Float(col) / gl.Float(segments), gl.Float(row) / gl.Float(rings)
+	})
+	return b.mesh("uvsphere")
+}
+
+// NewIcosphereMesh - a sphere of radius made by splitting each triangle
+// of an icosahedron into four, subdivisions times.  Vertices on the UV
+// seam and at the poles are duplicated so the texture doesn't smear.
+func NewIcosphereMesh(radius gl.Float, subdivisions int) *Mesh {
+	subdivisions = atLeast(subdivisions, 0)
+	phi := gl.Float((1 + math.Sqrt(5)) / 2)
+	points := []Vec3{
+		{-1, phi, 0}, {1, phi, 0}, {-1, -phi, 0}, {1, -phi, 0},
+		{0, -1, phi}, {0, 1, phi}, {0, -1, -phi}, {0, 1, -phi},
+		{phi, 0, -1}, {phi, 0, 1}, {-phi, 0, -1}, {-phi, 0, 1},
+	}
+	for i := range points {
+		points[i] = *points[i].Normalize()
+	}
+	faces := [][3]int{
+		{0, 11, 5}, {0, 5, 1}, {0, 1, 7}, {0, 7, 10}, {0, 10, 11},
+		{1, 5, 9}, {5, 11, 4}, {11, 10, 2}, {10, 7, 6}, {7, 1, 8},
+		{3, 9, 4}, {3, 4, 2}, {3, 2, 6}, {3, 6, 8}, {3, 8, 9},
+		{4, 9, 5}, {2, 4, 11}, {6, 2, 10}, {8, 6, 7}, {9, 8, 1},
+	}
+	for s := 0; s < subdivisions; s++ {
+		midpoints := make(map[[2]int]int)
+		midpoint := func(a, b int) int {
+			key := [2]int{a, b}
+			if b < a {
+				key = [2]int{b, a}
+			}
+			if m, ok := midpoints[key]; ok {
+				return m
+			}
+			p := points[a].Add(&points[b]).Normalize()
+			points = append(points, *p)
+			midpoints[key] = len(points) - 1
+			return len(points) - 1
+		}
+		next := make([][3]int, 0, len(faces)*4)
+		for _, f := range faces {
+			ab, bc, ca := midpoint(f[0], f[1]), midpoint(f[1], f[2]), midpoint(f[2], f[0])
+			next = append(next, [3]int{f[0], ab, ca}, [3]int{f[1], bc, ab}, [3]int{f[2], ca, bc}, [3]int{ab, bc, ca})
+		}
+		faces = next
+	}
+
+	// Spherical UVs, worked out per corner so triangles across the seam
+	// or touching a pole get their own vertices
+	type corner struct {
+		point int
+		u, v  gl.Float
+	}
+	b := new(meshBuilder)
+	vertices := make(map[corner]gl.Uint)
+	for _, f := range faces {
+		var us, vs [3]gl.Float
+		var pole [3]bool
+		for k, i := range f {
+			p := points[i]
+			us[k] = gl.Float(0.5 + math.Atan2(float64(p.X), float64(p.Z))/(2*math.Pi))
+			vs[k] = gl.Float(0.5 + math.Asin(float64(Clamp(p.Y, -1, 1)))/math.Pi)
+			pole[k] = math.Abs(float64(p.X)) < 1e-6 && math.Abs(float64(p.Z)) < 1e-6
+		}
+		// Unwrap across the seam
+		lo, hi := gl.Float(1), gl.Float(0)
+		for k := 0; k < 3; k++ {
+			if !pole[k] {
+				lo, hi = gl.Float(math.Min(float64(lo), float64(us[k]))), gl.Float(math.Max(float64(hi), float64(us[k])))
+			}
+		}
+		if hi-lo > 0.5 {
+			for k := 0; k < 3; k++ {
+				if us[k] < 0.5 {
+					us[k]++
+				}
+			}
+		}
+		// A pole takes the middle of the other two corners' u
+		for k := 0; k < 3; k++ {
+			if pole[k] {
+				us[k] = (us[(k+1)%3] + us[(k+2)%3]) / 2
+			}
+		}
+		var idx [3]gl.Uint
+		for k, i := range f {
+			c := corner{i, us[k], vs[k]}
+			v, ok := vertices[c]
+			if !ok {
+				v = b.vertex(*points[i].MulS(radius), points[i], c.u, c.v)
+				vertices[c] = v
+			}
+			idx[k] = v
+		}
+		b.triangle(idx[0], idx[1], idx[2])
+	}
+	return b.mesh("icosphere")
+}
+
+// NewCylinderMesh - a capped cylinder of radius and height along Y, made
+// of segments around and stacks along its length
+func NewCylinderMesh(radius, height gl.Float, segments, stacks int) *Mesh {
+	segments, stacks = atLeast(segments, 3), atLeast(stacks, 1)
+	b := new(meshBuilder)
+	b.surface(segments, stacks, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		u, v := gl.Float(col)/gl.Float(segments), gl.Float(row)/gl.Float(stacks)
+		s, c := sinCos(2 * math.Pi * float64(u))
+		return Vec3{radius * s, height * (v - 0.5), radius * c}, Vec3{s, 0, c}, u, v
+	})
+	b.disk(Vec3{0, height / 2, 0}, radius, segments, true)
+	b.disk(Vec3{0, -height / 2, 0}, radius, segments, false)
+	return b.mesh("cylinder")
+}
+
+// NewConeMesh - a cone of base radius and height along Y, point up, with
+// its base capped
+func NewConeMesh(radius, height gl.Float, segments, stacks int) *Mesh {
+	segments, stacks = atLeast(segments, 3), atLeast(stacks, 1)
+	b := new(meshBuilder)
+	b.surface(segments, stacks, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		u, v := gl.Float(col)/gl.Float(segments), gl.Float(row)/gl.Float(stacks)
+		s, c := sinCos(2 * math.Pi * float64(u))
+		r := radius * (1 - v)
+		n := (&Vec3{height * s, radius, height * c}).Normalize()
+		return Vec3{r * s, height * (v - 0.5), r * c}, *n, u, v
+	})
+	b.disk(Vec3{0, -height / 2, 0}, radius, segments, false)
+	return b.mesh("cone")
+}
+
+// NewCapsuleMesh - a cylinder of radius with a hemisphere on each end,
+// height long between the centres of the hemispheres.  rings is the
+// number of rings in each hemisphere.
+func NewCapsuleMesh(radius, height gl.Float, segments, rings int) *Mesh {
+	segments, rings = atLeast(segments, 3), atLeast(rings, 1)
+	total := height + 2*radius
+	b := new(meshBuilder)
+	// Rows 0-rings are the bottom hemisphere, rings+1 to 2*rings+1 the top,
+	// and the quads between rows rings and rings+1 the cylinder
+	b.surface(segments, 2*rings+1, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		n, offset := spherePoint(col, segments, row, 2*rings), -height/2
+		if row > rings {
+			n, offset = spherePoint(col, segments, row-1, 2*rings), height/2
+		}
+		p := n.MulS(radius)
+		p.Y += offset
+		return *p, n, gl.Float(col) / gl.Float(segments), (p.Y + total/2) / total
+	})
+	return b.mesh("capsule")
+}
+
+// NewTorusMesh - a torus around Y, majorRadius from its centre to the
+// middle of the tube, which has minorRadius
+func NewTorusMesh(majorRadius, minorRadius gl.Float, majorSegments, minorSegments int) *Mesh {
+	majorSegments, minorSegments = atLeast(majorSegments, 3), atLeast(minorSegments, 3)
+	b := new(meshBuilder)
+	b.surface(majorSegments, minorSegments, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		u, v := gl.Float(col)/gl.Float(majorSegments), gl.Float(row)/gl.Float(minorSegments)
+		st, ct := sinCos(2 * math.Pi * float64(u))
+		sp, cp := sinCos(2 * math.Pi * float64(v))
+		n := Vec3{cp * st, sp, cp * ct}
+		r := majorRadius + minorRadius*cp
+		return Vec3{r * st, minorRadius * sp, r * ct}, n, u, v
+	})
+	return b.mesh("torus")
+}
+
+// NewDiskMesh - a flat disk of radius in XZ facing +Y
+func NewDiskMesh(radius gl.Float, segments int) *Mesh {
+	b := new(meshBuilder)
+	b.disk(Vec3{}, radius, atLeast(segments, 3), true)
+	return b.mesh("disk")
+}
+
+// NewHeightfieldMesh - a width x depth grid in XZ with a vertex for each
+// pixel of the bitmap, raised by the pixel's brightness times height.
+// The top of the image is at -Z.
+func NewHeightfieldMesh(bmp *Bitmap, width, depth, height gl.Float) (*Mesh, error) {
+	if bmp == nil || bmp.Width() < 2 || bmp.Height() < 2 {
+		return nil, errors.New("NewHeightfieldMesh: Need a bitmap at least 2x2 pixels")
+	}
+	w, h := bmp.Width(), bmp.Height()
+	b := new(meshBuilder)
+	b.surface(w-1, h-1, func(col, row int) (Vec3, Vec3, gl.Float, gl.Float) {
+		u, v := gl.Float(col)/gl.Float(w-1), gl.Float(row)/gl.Float(h-1)
+		r, g, bl := bmp.Pixel(col, h-1-row)
+		y := (0.299*gl.Float(r) + 0.587*gl.Float(g) + 0.114*gl.Float(bl)) / 255 * height
+		return Vec3{width * (u - 0.5), y, depth * (0.5 - v)}, Vec3{0, 1, 0}, u, v
+	})
+	m := b.mesh("heightfield")
+	if err := m.ComputeSmoothNormals(AreaWeighted, 180); err != nil {
+		return nil, err
+	}
+	return m, m.ComputeTangents()
+}
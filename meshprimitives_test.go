@@ -0,0 +1,96 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+)
+
+// Checks the attributes line up, normals are unit length, and every
+// triangle faces the same way as its vertex normals
+func checkPrimitive(t *testing.T, m *Mesh) {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		t.Fatalf("%s: %v", m.Name(), err)
+	}
+	for _, desc := range []string{AttribPosition, AttribNormal, AttribTexCoord, AttribTangent, AttribBitangent} {
+		if m.AttributeByDesc(desc) == nil {
+			t.Errorf("%s has no %s attribute", m.Name(), desc)
+		}
+	}
+	pos, nrm := m.AttributeByDesc(AttribPosition), m.AttributeByDesc(AttribNormal)
+	for v := 0; v < vertices; v++ {
+		n := nrm.vec3(v)
+		if l := n.Length(); math.Abs(float64(l)-1) > 1e-4 {
+			t.Errorf("%s: vertex %d normal %v has length %v", m.Name(), v, n, l)
+			return
+		}
+	}
+	tris := m.Index(0).Triangles()
+	if len(tris) == 0 {
+		t.Fatalf("%s has no triangles", m.Name())
+	}
+	for _, tri := range tris {
+		p0, p1, p2 := pos.vec3(int(tri[0])), pos.vec3(int(tri[1])), pos.vec3(int(tri[2]))
+		face := p1.Sub(&p0).Cross(p2.Sub(&p0))
+		n0, n1, n2 := nrm.vec3(int(tri[0])), nrm.vec3(int(tri[1])), nrm.vec3(int(tri[2]))
+		n := n0.Add(&n1).Add(&n2)
+		if face.Dot(n) <= 0 {
+			t.Errorf("%s: triangle %v faces away from its normals", m.Name(), tri)
+			return
+		}
+	}
+}
+
+func TestPrimitiveMeshes(t *testing.T) {
+	for _, m := range []*Mesh{
+		NewPlaneMesh(2, 3, 4, 5),
+		NewCubeMesh(2, 3),
+		NewUVSphereMesh(1, 16, 8),
+		NewIcosphereMesh(1, 2),
+		NewCylinderMesh(1, 2, 12, 2),
+		NewConeMesh(1, 2, 12, 2),
+		NewCapsuleMesh(0.5, 2, 12, 4),
+		NewTorusMesh(2, 0.5, 16, 8),
+		NewDiskMesh(1, 0),
+	} {
+		checkPrimitive(t, m)
+	}
+
+	if n := NewCubeMesh(1, 1).TriangleCount(); n != 12 {
+		t.Errorf("Cube has %d triangles, want 12", n)
+	}
+	if n := NewUVSphereMesh(1, 8, 4).TriangleCount(); n != 8*4*2-2*8 {
+		t.Errorf("UV sphere has %d triangles, want %d", n, 8*4*2-2*8)
+	}
+	if n := NewIcosphereMesh(1, 1).TriangleCount(); n != 80 {
+		t.Errorf("Icosphere has %d triangles, want 80", n)
+	}
+}
+
+func TestHeightfieldMesh(t *testing.T) {
+	// 3x2 BGR bitmap, rows bottom up and padded to 12 bytes; the top
+	// middle pixel is white
+	bmp := &Bitmap{width: 3, height: 2, data: []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 255, 255, 255, 0, 0, 0, 0, 0, 0,
+	}}
+	if r, g, b := bmp.Pixel(1, 0); r != 255 || g != 255 || b != 255 {
+		t.Errorf("Top middle pixel is %d %d %d", r, g, b)
+	}
+	m, err := NewHeightfieldMesh(bmp, 2, 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPrimitive(t, m)
+	// Top row of the image is at -Z
+	pos := m.AttributeByDesc(AttribPosition)
+	for v := 0; v < pos.VertexCount(); v++ {
+		p := pos.vec3(v)
+		if p.Y != 0 && (p.X != 0 || p.Z != -0.5 || math.Abs(float64(p.Y)-5) > 1e-4) {
+			t.Errorf("Vertex at %v shouldn't be raised", p)
+		}
+	}
+	if _, err := NewHeightfieldMesh(&Bitmap{width: 1, height: 1}, 1, 1, 1); err == nil {
+		t.Errorf("A 1x1 bitmap can't make a heightfield")
+	}
+}
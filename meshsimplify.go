@@ -0,0 +1,555 @@
+// meshsimplify - quadric error metric simplification and LOD chains
+//
+// Simplify uses Garland and Heckbert's quadric error metrics with half-edge
+// collapses: a vertex is folded into one of its neighbours, so surviving
+// vertices keep their positions and attributes exactly.  Vertices at the
+// same position (a UV or normal seam, say) are collapsed together, and
+// vertices on a border or seam may only slide along it, so seams stay
+// closed and outlines keep their shape.  Collapses that would flip a
+// triangle are skipped, as are ones that fold a vertex into one moving
+// differently under a morph target, so morphs still work on the result.
+//
+// GenerateLODs builds a chain of ever simpler meshes, each with the error
+// it introduced, and LODChain.Select picks the level whose error projects
+// to no more than a given number of pixels on screen.
+
+package goglutils
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+	"strconv"
+)
+
+// Extra weight given to the planes that hold borders and seams in place
+const simplifyBorderWeight = 10.0
+
+// Symmetric 4x4 quadric, upper triangle: aa ab ac ad bb bc bd cc cd dd
+type quadric [10]float64
+
+// Quadric of the squared distance to the plane n.p + d = 0, times w
+func planeQuadric(n vec3d, d, w float64) quadric {
+	a, b, c := n[0], n[1], n[2]
+	return quadric{a * a * w, a * b * w, a * c * w, a * d * w,
+		b * b * w, b * c * w, b * d * w, c * c * w, c * d * w, d * d * w}
+}
+
+func (q *quadric) add(o quadric) {
+	for i := range q {
+		q[i] += o[i]
+	}
+}
+
+func (q *quadric) eval(p vec3d) float64 {
+	x, y, z := p[0], p[1], p[2]
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z + q[9]
+}
+
+// How a position may move
+type simplifyKind int
+
+const (
+	simplifyInterior simplifyKind = iota // Anywhere
+	simplifyBorder                       // Along its border
+	simplifySeam                         // Along its seam
+	simplifyLocked                       // Not at all
+)
+
+// A candidate collapse of group u into group v
+type collapse struct {
+	cost    float64
+	u, v    int
+	version int
+}
+
+type collapseHeap []collapse
+
+func (h collapseHeap) Len() int            { return len(h) }
+func (h collapseHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h collapseHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *collapseHeap) Push(x interface{}) { *h = append(*h, x.(collapse)) }
+func (h *collapseHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// Simplification state.  Vertices are gathered into groups by position,
+// and collapses work on groups.
+type simplifier struct {
+	tris      []meshTriangle
+	alive     []bool
+	live      int
+	pos       []vec3d     // Position of each group
+	group     []int       // Group of each vertex
+	wedges    [][]gl.Uint // Vertices of each group
+	groupTris [][]int     // Triangles around each group
+	quadrics  []quadric   // Error quadric of each group
+	weights   []float64   // Area the quadric covers
+	kind      []simplifyKind
+	dead      []bool       // Group has been collapsed away
+	version   []int        // Bumped whenever a group's candidate collapse changes
+	remap     []gl.Uint    // Vertex each vertex was collapsed into
+	morphs    [][]gl.Float // Offsets of every morph target, 3 per vertex
+}
+
+func newSimplifier(m *Mesh, vertices int) *simplifier {
+	s := new(simplifier)
+	posAttr := m.positionAttribute()
+	s.group = make([]int, vertices)
+	s.remap = make([]gl.Uint, vertices)
+	for _, mt := range m.morphs {
+		for _, d := range mt.deltas {
+			s.morphs = append(s.morphs, d.dense(vertices))
+		}
+	}
+	groups := make(map[Vec3]int)
+	for v := 0; v < vertices; v++ {
+		p := posAttr.vec3(v)
+		g, ok := groups[p]
+		if !ok {
+			g = len(s.pos)
+			groups[p] = g
+			s.pos = append(s.pos, toVec3d(p))
+			s.wedges = append(s.wedges, nil)
+		}
+		s.group[v] = g
+		s.wedges[g] = append(s.wedges[g], gl.Uint(v))
+		s.remap[v] = gl.Uint(v)
+	}
+	n := len(s.pos)
+	s.groupTris = make([][]int, n)
+	s.quadrics = make([]quadric, n)
+	s.weights = make([]float64, n)
+	s.kind = make([]simplifyKind, n)
+	s.dead = make([]bool, n)
+	s.version = make([]int, n)
+
+	for _, t := range m.meshTriangles() {
+		g0, g1, g2 := s.group[t.v[0]], s.group[t.v[1]], s.group[t.v[2]]
+		if g0 == g1 || g1 == g2 || g0 == g2 {
+			continue // degenerate already
+		}
+		i := len(s.tris)
+		s.tris = append(s.tris, t)
+		s.alive = append(s.alive, true)
+		for _, g := range [3]int{g0, g1, g2} {
+			s.groupTris[g] = append(s.groupTris[g], i)
+		}
+
+		// Plane quadric, weighted by area
+		n := s.pos[g1].sub(s.pos[g0]).cross(s.pos[g2].sub(s.pos[g0]))
+		area := n.length() / 2
+		if area == 0 {
+			continue
+		}
+		n = n.normalize()
+		q := planeQuadric(n, -n.dot(s.pos[g0]), area)
+		for _, g := range [3]int{g0, g1, g2} {
+			s.quadrics[g].add(q)
+			s.weights[g] += area
+		}
+	}
+	s.live = len(s.tris)
+	s.classify()
+	return s
+}
+
+// Group of the k'th vertex of triangle t
+func (s *simplifier) triGroup(t, k int) int {
+	return s.group[s.tris[t].v[k]]
+}
+
+// Is group g a corner of triangle t?
+func (s *simplifier) hasGroup(t, g int) bool {
+	return s.triGroup(t, 0) == g || s.triGroup(t, 1) == g || s.triGroup(t, 2) == g
+}
+
+// The live triangles around group g
+func (s *simplifier) trianglesAround(g int) []int {
+	var out []int
+	for _, t := range s.groupTris[g] {
+		if s.alive[t] && s.hasGroup(t, g) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Groups sharing a live triangle with g
+func (s *simplifier) neighbours(g int) []int {
+	var out []int
+	seen := map[int]bool{g: true}
+	for _, t := range s.trianglesAround(g) {
+		for k := 0; k < 3; k++ {
+			if n := s.triGroup(t, k); !seen[n] {
+				seen[n] = true
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+// How many live triangles share the edge between groups a and b, and
+// whether they disagree on its vertices - an attribute seam
+func (s *simplifier) edgeInfo(a, b int) (int, bool, []int) {
+	var tris []int
+	var pairs [][2]gl.Uint
+	for _, t := range s.trianglesAround(a) {
+		if !s.hasGroup(t, b) {
+			continue
+		}
+		var pair [2]gl.Uint
+		for k := 0; k < 3; k++ {
+			switch s.triGroup(t, k) {
+			case a:
+				pair[0] = s.tris[t].v[k]
+			case b:
+				pair[1] = s.tris[t].v[k]
+			}
+		}
+		tris = append(tris, t)
+		pairs = append(pairs, pair)
+	}
+	return len(tris), len(pairs) == 2 && pairs[0] != pairs[1], tris
+}
+
+// Classifies every group by the borders and seams through it, and adds
+// planes along those to the quadrics to hold them in place
+func (s *simplifier) classify() {
+	borders := make([]int, len(s.pos))
+	seams := make([]int, len(s.pos))
+	done := make(map[[2]int]bool)
+	for t := range s.tris {
+		for k := 0; k < 3; k++ {
+			a, b := s.triGroup(t, k), s.triGroup(t, (k+1)%3)
+			key := [2]int{a, b}
+			if b < a {
+				key = [2]int{b, a}
+			}
+			if done[key] {
+				continue
+			}
+			done[key] = true
+			count, seam, tris := s.edgeInfo(a, b)
+			switch {
+			case count > 2:
+				s.kind[a], s.kind[b] = simplifyLocked, simplifyLocked
+				continue
+			case count == 1:
+				borders[a]++
+				borders[b]++
+			case seam:
+				seams[a]++
+				seams[b]++
+			default:
+				continue
+			}
+			edge := s.pos[b].sub(s.pos[a])
+			for _, et := range tris {
+				n := s.pos[s.triGroup(et, 1)].sub(s.pos[s.triGroup(et, 0)]).cross(s.pos[s.triGroup(et, 2)].sub(s.pos[s.triGroup(et, 0)]))
+				plane := edge.cross(n).normalize()
+				w := edge.dot(edge) * simplifyBorderWeight
+				q := planeQuadric(plane, -plane.dot(s.pos[a]), w)
+				s.quadrics[a].add(q)
+				s.quadrics[b].add(q)
+			}
+		}
+	}
+	for g := range s.pos {
+		switch {
+		case s.kind[g] == simplifyLocked:
+		case borders[g] == 0 && seams[g] == 0:
+			s.kind[g] = simplifyInterior
+		case borders[g] == 2 && seams[g] == 0:
+			s.kind[g] = simplifyBorder
+		case seams[g] == 2 && borders[g] == 0:
+			s.kind[g] = simplifySeam
+		default:
+			s.kind[g] = simplifyLocked
+		}
+	}
+}
+
+// Checks whether group u can be collapsed into group v.  If so, returns
+// the vertex of v each of u's vertices should become.
+func (s *simplifier) canCollapse(u, v int) (map[gl.Uint]gl.Uint, bool) {
+	switch s.kind[u] {
+	case simplifyLocked:
+		return nil, false
+	case simplifyBorder, simplifySeam:
+		if s.kind[v] != s.kind[u] && s.kind[v] != simplifyLocked {
+			return nil, false
+		}
+		count, seam, _ := s.edgeInfo(u, v)
+		if (s.kind[u] == simplifyBorder && count != 1) || (s.kind[u] == simplifySeam && !seam) {
+			return nil, false
+		}
+	}
+
+	// Match each of u's vertices with the vertex of v it shares a
+	// triangle with, and make sure no triangle flips
+	targets := make(map[gl.Uint]gl.Uint)
+	for _, t := range s.trianglesAround(u) {
+		var wu gl.Uint
+		var ku int
+		for k := 0; k < 3; k++ {
+			if s.triGroup(t, k) == u {
+				wu, ku = s.tris[t].v[k], k
+			}
+		}
+		if s.hasGroup(t, v) {
+			for k := 0; k < 3; k++ {
+				if s.triGroup(t, k) == v {
+					targets[wu] = s.tris[t].v[k]
+				}
+			}
+			if !s.sameMorphs(wu, targets[wu]) {
+				return nil, false
+			}
+			continue
+		}
+		a, b := s.pos[s.triGroup(t, (ku+1)%3)], s.pos[s.triGroup(t, (ku+2)%3)]
+		before := a.sub(s.pos[u]).cross(b.sub(s.pos[u]))
+		after := a.sub(s.pos[v]).cross(b.sub(s.pos[v]))
+		if before.dot(after) <= 0 {
+			return nil, false
+		}
+	}
+	for _, w := range s.wedges[u] {
+		if _, ok := targets[w]; !ok {
+			// Only fine if w isn't used any more
+			for _, t := range s.trianglesAround(u) {
+				for k := 0; k < 3; k++ {
+					if s.tris[t].v[k] == w {
+						return nil, false
+					}
+				}
+			}
+			targets[w] = s.wedges[v][0]
+		}
+	}
+	return targets, true
+}
+
+// Do vertices a and b move the same under every morph target?
+func (s *simplifier) sameMorphs(a, b gl.Uint) bool {
+	for _, d := range s.morphs {
+		if d[a*3] != d[b*3] || d[a*3+1] != d[b*3+1] || d[a*3+2] != d[b*3+2] {
+			return false
+		}
+	}
+	return true
+}
+
+// Error of collapsing group u into v - mean squared distance to the
+// planes of both
+func (s *simplifier) cost(u, v int) float64 {
+	q := s.quadrics[u]
+	q.add(s.quadrics[v])
+	w := s.weights[u] + s.weights[v]
+	if w == 0 {
+		w = 1
+	}
+	return math.Max(q.eval(s.pos[v])/w, 0)
+}
+
+// The cheapest allowed collapse of group u
+func (s *simplifier) best(u int) (collapse, bool) {
+	c := collapse{cost: math.Inf(1), u: u, version: s.version[u]}
+	found := false
+	for _, v := range s.neighbours(u) {
+		if _, ok := s.canCollapse(u, v); !ok {
+			continue
+		}
+		if cost := s.cost(u, v); cost < c.cost {
+			c.cost, c.v, found = cost, v, true
+		}
+	}
+	return c, found
+}
+
+// Folds group u into group v
+func (s *simplifier) collapse(u, v int, targets map[gl.Uint]gl.Uint) {
+	for _, t := range s.trianglesAround(u) {
+		for k := 0; k < 3; k++ {
+			if w := s.tris[t].v[k]; s.group[w] == u {
+				s.tris[t].v[k] = targets[w]
+			}
+		}
+		if s.hasGroup(t, v) && (s.triGroup(t, 0) == s.triGroup(t, 1) || s.triGroup(t, 1) == s.triGroup(t, 2) || s.triGroup(t, 0) == s.triGroup(t, 2)) {
+			s.alive[t] = false
+			s.live--
+			continue
+		}
+		s.groupTris[v] = append(s.groupTris[v], t)
+	}
+	for _, w := range s.wedges[u] {
+		s.remap[w] = targets[w]
+	}
+	s.quadrics[v].add(s.quadrics[u])
+	s.weights[v] += s.weights[u]
+	s.dead[u] = true
+}
+
+// Vertex that v ended up as
+func (s *simplifier) resolve(v gl.Uint) gl.Uint {
+	for s.remap[v] != v {
+		v = s.remap[v]
+	}
+	return v
+}
+
+// Simplify - collapses edges until the mesh's triangle count is down to
+// targetRatio of what it was, or the next collapse would add more than
+// targetError (in the mesh's units; 0 for no limit).  Returns the error
+// of the simplified mesh.  Strips and fans become triangle lists, and
+// vertices no longer used are dropped.
+func (m *Mesh) Simplify(targetRatio, targetError gl.Float) (gl.Float, error) {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return 0, err
+	}
+	if targetRatio < 0 || targetRatio > 1 {
+		return 0, errors.New(fmt.Sprintf("Mesh:Simplify: Target ratio %v isn't between 0 and 1", targetRatio))
+	}
+	if err := m.checkIndices(vertices, "Mesh:Simplify"); err != nil {
+		return 0, err
+	}
+	s := newSimplifier(m, vertices)
+	target := int(math.Ceil(float64(targetRatio) * float64(s.live)))
+	maxCost := math.Inf(1)
+	if targetError > 0 {
+		maxCost = float64(targetError) * float64(targetError)
+	}
+
+	h := new(collapseHeap)
+	for g := range s.pos {
+		if c, ok := s.best(g); ok {
+			heap.Push(h, c)
+		}
+	}
+	reached := 0.0
+	for s.live > target && h.Len() > 0 {
+		c := heap.Pop(h).(collapse)
+		if s.dead[c.u] || c.version != s.version[c.u] {
+			continue
+		}
+		targets, ok := s.canCollapse(c.u, c.v)
+		if s.dead[c.v] || !ok || s.cost(c.u, c.v) > c.cost*(1+1e-9) {
+			// Out of date - try again with what's best now
+			s.version[c.u]++
+			if nc, ok := s.best(c.u); ok {
+				heap.Push(h, nc)
+			}
+			continue
+		}
+		if c.cost > maxCost {
+			break
+		}
+		s.collapse(c.u, c.v, targets)
+		reached = math.Max(reached, c.cost)
+
+		for _, g := range append(s.neighbours(c.v), c.v) {
+			s.version[g]++
+			if nc, ok := s.best(g); ok {
+				heap.Push(h, nc)
+			}
+		}
+	}
+
+	// Write the surviving triangles back
+	for _, indx := range m.indices {
+		if isTrianglePrimitive(indx.primitive) {
+			var tris [][3]gl.Uint
+			for t, tri := range s.tris {
+				if s.alive[t] && tri.index == indx {
+					tris = append(tris, tri.v)
+				}
+			}
+			indx.setTriangles(tris)
+			continue
+		}
+		for i, v := range indx.data {
+			if !indx.restartEnabled || v != indx.restart {
+				indx.data[i] = s.resolve(v)
+			}
+		}
+		indx.dirty = true
+	}
+	if err := m.OptimizeVertexFetch(); err != nil {
+		return 0, err
+	}
+	return gl.Float(math.Sqrt(reached)), nil
+}
+
+// Number of triangles the mesh draws
+func (m *Mesh) TriangleCount() int {
+	n := 0
+	for _, indx := range m.indices {
+		n += len(indx.Triangles())
+	}
+	return n
+}
+
+// One level of detail - a mesh and how far it strays from the original
+type MeshLOD struct {
+	Mesh  *Mesh
+	Error gl.Float // In the mesh's units
+}
+
+// A chain of levels of detail, most detailed first
+type LODChain []MeshLOD
+
+// GenerateLODs - builds a chain of up to levels meshes, starting with m
+// itself, each simplified to ratio of the triangles of the one before.
+// Stops early once simplification gets stuck.
+func (m *Mesh) GenerateLODs(levels int, ratio gl.Float) (LODChain, error) {
+	if levels < 1 {
+		return nil, errors.New(fmt.Sprintf("Mesh:GenerateLODs: Invalid number of levels %d", levels))
+	}
+	chain := LODChain{{m, 0}}
+	for i := 1; i < levels; i++ {
+		prev := chain[len(chain)-1]
+		lod := prev.Mesh.Clone(m.name + "_lod" + strconv.Itoa(i))
+		lodErr, err := lod.Simplify(ratio, 0)
+		if err != nil {
+			return chain, err
+		}
+		if lod.TriangleCount() >= prev.Mesh.TriangleCount() {
+			break
+		}
+		// Errors of successive levels add up, at worst
+		chain = append(chain, MeshLOD{lod, prev.Error + lodErr})
+	}
+	return chain, nil
+}
+
+// ProjectedError - how many pixels an error of err (in world units) at
+// distance covers, for a perspective projection with a vertical field of
+// view of fovY degrees onto a screen screenHeight pixels tall
+func ProjectedError(err, distance, fovY gl.Float, screenHeight int) gl.Float {
+	if distance <= 0 {
+		return gl.Float(math.Inf(1))
+	}
+	return err * gl.Float(screenHeight) / (2 * distance * TanGL(DegToRad(fovY)/2))
+}
+
+// Select - the coarsest level whose error projects to no more than
+// maxPixels on screen, given the distance to the viewer
+func (c LODChain) Select(distance, fovY gl.Float, screenHeight int, maxPixels gl.Float) int {
+	best := 0
+	for i, lod := range c {
+		if ProjectedError(lod.Error, distance, fovY, screenHeight) <= maxPixels {
+			best = i
+		}
+	}
+	return best
+}
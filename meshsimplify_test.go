@@ -0,0 +1,184 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// An n x n grid in the z=0 plane with height(x, y) as z, and UVs.  With
+// seam set, the column x=n/2 is duplicated with different UVs.
+func heightGrid(n int, seam bool, height func(x, y int) gl.Float) *Mesh {
+	m := NewMesh("grid")
+	var pos, uv []gl.Float
+	var data []gl.Uint
+	id := make(map[[3]int]gl.Uint)
+	vertex := func(x, y, side int) gl.Uint {
+		if !seam || x != n/2 {
+			side = 0
+		}
+		key := [3]int{x, y, side}
+		if v, ok := id[key]; ok {
+			return v
+		}
+		v := gl.Uint(len(pos) / 3)
+		id[key] = v
+		pos = append(pos, gl.Float(x), gl.Float(y), height(x, y))
+		uv = append(uv, gl.Float(x)/gl.Float(n)+gl.Float(side), gl.Float(y)/gl.Float(n))
+		return v
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			side := 0
+			if x >= n/2 {
+				side = 1
+			}
+			a, b := vertex(x, y, side), vertex(x+1, y, side)
+			c, d := vertex(x, y+1, side), vertex(x+1, y+1, side)
+			data = append(data, a, b, c, c, b, d)
+		}
+	}
+	m.AddMeshAttribute(AttribPosition, pos, 3)
+	m.AddMeshAttribute(AttribTexCoord, uv, 2)
+	m.AddMeshIndex("0", data, gl.TRIANGLES, m.Attribute(0))
+	return m
+}
+
+func flat(x, y int) gl.Float { return 0 }
+
+// Position edges used by only one triangle
+func openEdges(m *Mesh) [][2]Vec3 {
+	pos := m.positionAttribute()
+	count := make(map[[2]Vec3]int)
+	for _, t := range m.Index(0).Triangles() {
+		for k := 0; k < 3; k++ {
+			a, b := pos.vec3(int(t[k])), pos.vec3(int(t[(k+1)%3]))
+			if b.X < a.X || (b.X == a.X && b.Y < a.Y) {
+				a, b = b, a
+			}
+			count[[2]Vec3{a, b}]++
+		}
+	}
+	var open [][2]Vec3
+	for e, c := range count {
+		if c == 1 {
+			open = append(open, e)
+		}
+	}
+	return open
+}
+
+func TestSimplifyPlane(t *testing.T) {
+	m := heightGrid(16, false, flat)
+	err, e := m.Simplify(0, 1e-3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if n := m.TriangleCount(); n > 8 {
+		t.Errorf("A flat grid should simplify to a few triangles, has %d", n)
+	}
+	if err > 1e-3 {
+		t.Errorf("Flat grid simplifies with error %v", err)
+	}
+	for _, e := range openEdges(m) {
+		for _, p := range e {
+			if p.X != 0 && p.X != 16 && p.Y != 0 && p.Y != 16 {
+				t.Errorf("Border edge %v has moved inside the grid", e)
+			}
+		}
+	}
+	if m.Attribute(0).VertexCount() != m.Attribute(1).VertexCount() {
+		t.Errorf("Attributes no longer correspond")
+	}
+}
+
+func TestSimplifyKeepsMorphs(t *testing.T) {
+	// A bump in the middle of a flat grid that only the morph raises
+	m := heightGrid(8, false, flat)
+	pos := m.positionAttribute()
+	bump := -1
+	for v := 0; v < pos.VertexCount(); v++ {
+		if pos.vec3(v) == (Vec3{4, 4, 0}) {
+			bump = v
+		}
+	}
+	raise, _ := m.AddMorphTarget("raise")
+	raise.SetSparseDeltas(AttribPosition, []int{bump}, []gl.Float{0, 0, 1})
+	if _, err := m.Simplify(0, 1e-3); err != nil {
+		t.Fatal(err)
+	}
+	pos = m.positionAttribute()
+	raised := 0
+	for v := 0; v < pos.VertexCount(); v++ {
+		if d := raise.Delta(AttribPosition, v); d != (Vec3{}) {
+			raised++
+			if d != (Vec3{0, 0, 1}) || pos.vec3(v) != (Vec3{4, 4, 0}) {
+				t.Errorf("Vertex at %v has offset %v", pos.vec3(v), d)
+			}
+		}
+	}
+	if raised != 1 {
+		t.Errorf("Simplify should keep the one raised vertex, has %d", raised)
+	}
+}
+
+func TestSimplifyBadIndex(t *testing.T) {
+	m := heightGrid(4, false, flat)
+	m.AddMeshIndex("bad", []gl.Uint{0, 1, 99}, gl.TRIANGLES, m.Attribute(0))
+	n := m.TriangleCount()
+	if _, err := m.Simplify(0, 0); err == nil {
+		t.Errorf("Simplify with an index past the last vertex should fail")
+	}
+	if m.TriangleCount() != n {
+		t.Errorf("A failed Simplify shouldn't change the mesh")
+	}
+}
+
+func TestSimplifyKeepsSeams(t *testing.T) {
+	m := heightGrid(16, true, flat)
+	m.Simplify(0.1, 0)
+	for _, e := range openEdges(m) {
+		for _, p := range e {
+			if p.X != 0 && p.X != 16 && p.Y != 0 && p.Y != 16 {
+				t.Errorf("Seam has opened up at %v", e)
+			}
+		}
+	}
+	// Every vertex on the seam keeps the UV of its side
+	pos, uv := m.Attribute(0), m.Attribute(1)
+	for v := 0; v < pos.VertexCount(); v++ {
+		p, w := pos.vec3(v), uv.vec2(v)
+		if p.X == 8 && w.X != 0.5 && w.X != 1.5 {
+			t.Errorf("Seam vertex %v has UV %v", p, w)
+		}
+	}
+}
+
+func TestGenerateLODs(t *testing.T) {
+	bumpy := func(x, y int) gl.Float {
+		return gl.Float(math.Sin(float64(x)/2) * math.Cos(float64(y)/3))
+	}
+	m := heightGrid(24, false, bumpy)
+	chain, err := m.GenerateLODs(4, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 4 || chain[0].Mesh != m || chain[0].Error != 0 {
+		t.Fatalf("Chain has %d levels", len(chain))
+	}
+	for i := 1; i < len(chain); i++ {
+		if chain[i].Mesh.TriangleCount() >= chain[i-1].Mesh.TriangleCount() {
+			t.Errorf("Level %d isn't simpler than level %d", i, i-1)
+		}
+		if chain[i].Error < chain[i-1].Error {
+			t.Errorf("Level %d has less error than level %d", i, i-1)
+		}
+	}
+	if chain.Select(1, 60, 1080, 1) != 0 {
+		t.Errorf("Close up should select the full mesh")
+	}
+	if chain.Select(1e6, 60, 1080, 1) != len(chain)-1 {
+		t.Errorf("Far away should select the coarsest mesh")
+	}
+}
@@ -0,0 +1,200 @@
+// meshweld - merge duplicate vertices, or split every vertex apart
+//
+// Weld finds vertices whose attributes and morph offsets all match - to
+// within an epsilon - and merges them, so the mesh's indices share one
+// copy.  Vertices are bucketed by position in a spatial hash with epsilon
+// sized cells, so only vertices in neighbouring cells are compared.
+// Deindex is the reverse: every index entry gets a vertex of its own.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+)
+
+// Cell in the weld spatial hash
+type weldCell [3]int64
+
+// Do vertices a and b match in every attribute and every morph offset,
+// to within epsilon?  morphs holds each target's offsets, 3 per vertex.
+func (m *Mesh) verticesMatch(a, b int, epsilon gl.Float, morphs [][]gl.Float) bool {
+	for _, attr := range m.attributes {
+		s := attr.stride
+		for c := 0; c < s; c++ {
+			d := attr.data[a*s+c] - attr.data[b*s+c]
+			if d > epsilon || d < -epsilon {
+				return false
+			}
+		}
+	}
+	for _, d := range morphs {
+		for c := 0; c < 3; c++ {
+			if diff := d[a*3+c] - d[b*3+c]; diff > epsilon || diff < -epsilon {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Checks every index entry refers to one of the mesh's vertices, before
+// anything gets changed.  caller goes in the error, "Mesh:Weld" say.
+func (m *Mesh) checkIndices(vertices int, caller string) error {
+	for _, indx := range m.indices {
+		for _, v := range indx.data {
+			if indx.restartEnabled && v == indx.restart {
+				continue
+			}
+			if int(v) >= vertices {
+				return errors.New(fmt.Sprintf("%s: Index %s refers to vertex %d of %d", caller, indx.desc, v, vertices))
+			}
+		}
+	}
+	return nil
+}
+
+// Weld - merges vertices whose attributes are all within epsilon of each
+// other (pass 0 to only merge exact duplicates) and re-points the indices
+// at the merged vertices, which take the attributes of the first of them.
+// Index primitives and restart indices are kept.  A mesh with no indices
+// is taken to be a triangle list, and gets one.
+func (m *Mesh) Weld(epsilon gl.Float) error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	if epsilon < 0 {
+		return errors.New(fmt.Sprintf("Mesh:Weld: Negative epsilon %v", epsilon))
+	}
+	if err := m.checkIndices(vertices, "Mesh:Weld"); err != nil {
+		return err
+	}
+	if len(m.indices) == 0 {
+		data := make([]gl.Uint, vertices)
+		for i := range data {
+			data[i] = gl.Uint(i)
+		}
+		if err := m.AddMeshIndex("0", data, gl.TRIANGLES, m.attributes[0]); err != nil {
+			return err
+		}
+	}
+
+	pos := m.positionAttribute()
+	cellSize := float64(epsilon)
+	if cellSize == 0 {
+		cellSize = 1e-6
+	}
+	cellOf := func(v int) weldCell {
+		p := pos.vec3(v)
+		return weldCell{
+			int64(math.Floor(float64(p.X) / cellSize)),
+			int64(math.Floor(float64(p.Y) / cellSize)),
+			int64(math.Floor(float64(p.Z) / cellSize)),
+		}
+	}
+
+	var morphs [][]gl.Float
+	for _, mt := range m.morphs {
+		for _, d := range mt.deltas {
+			morphs = append(morphs, d.dense(vertices))
+		}
+	}
+
+	// Map every vertex to the first vertex it matches
+	cells := make(map[weldCell][]int)
+	remap := make([]gl.Uint, vertices)
+	var kept []int
+	for v := 0; v < vertices; v++ {
+		cell := cellOf(v)
+		match := -1
+	search:
+		for dx := int64(-1); dx <= 1; dx++ {
+			for dy := int64(-1); dy <= 1; dy++ {
+				for dz := int64(-1); dz <= 1; dz++ {
+					for _, r := range cells[weldCell{cell[0] + dx, cell[1] + dy, cell[2] + dz}] {
+						if m.verticesMatch(v, kept[r], epsilon, morphs) {
+							match = r
+							break search
+						}
+					}
+				}
+			}
+		}
+		if match < 0 {
+			match = len(kept)
+			kept = append(kept, v)
+			cells[cell] = append(cells[cell], match)
+		}
+		remap[v] = gl.Uint(match)
+	}
+	if len(kept) == vertices {
+		return nil
+	}
+
+	m.keepVertices(kept)
+	for _, indx := range m.indices {
+		for i, v := range indx.data {
+			if indx.restartEnabled && v == indx.restart {
+				continue
+			}
+			indx.data[i] = remap[v]
+		}
+		indx.dirty = true
+	}
+	m.layoutDirty = true
+	return nil
+}
+
+// Replaces every attribute's data with just the given vertices, in order
+func (m *Mesh) keepVertices(vertices []int) {
+	for _, attr := range m.attributes {
+		s := attr.stride
+		data := make([]gl.Float, 0, len(vertices)*s)
+		for _, v := range vertices {
+			data = append(data, attr.data[v*s:(v+1)*s]...)
+		}
+		attr.data = data
+		attr.changed()
+	}
+	m.keepMorphVertices(vertices)
+}
+
+// Deindex - the reverse of Weld.  Gives every index entry its own copy of
+// the vertex it refers to, so no two entries share a vertex, and numbers
+// the entries in order.  Index primitives and restart indices are kept.
+func (m *Mesh) Deindex() error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	if err := m.checkIndices(vertices, "Mesh:Deindex"); err != nil {
+		return err
+	}
+	entries := 0
+	for _, indx := range m.indices {
+		entries += len(indx.data)
+	}
+	for _, indx := range m.indices {
+		if indx.restartEnabled && int(indx.restart) < entries {
+			return errors.New(fmt.Sprintf("Mesh:Deindex: Index %s restart index %d clashes with the new vertices", indx.desc, indx.restart))
+		}
+	}
+
+	order := make([]int, 0, entries)
+	for _, indx := range m.indices {
+		for i, v := range indx.data {
+			if indx.restartEnabled && v == indx.restart {
+				continue
+			}
+			indx.data[i] = gl.Uint(len(order))
+			order = append(order, int(v))
+		}
+		indx.dirty = true
+	}
+	m.keepVertices(order)
+	m.layoutDirty = true
+	return nil
+}
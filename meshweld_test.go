@@ -0,0 +1,115 @@
+package goglutils
+
+import (
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A quad as unindexed triangle soup, with a little noise on one corner
+func soupQuad() *Mesh {
+	m := NewMesh("soup")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{
+		0, 0, 0, 1, 0, 0, 0, 1, 0,
+		0, 1.0001, 0, 1, 0, 0, 1, 1, 0,
+	}, 3)
+	m.AddMeshAttribute(AttribTexCoord, []gl.Float{0, 0, 1, 0, 0, 1, 0, 1, 1, 0, 1, 1}, 2)
+	return m
+}
+
+func TestWeld(t *testing.T) {
+	m := soupQuad()
+	if err := m.Weld(0); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Attribute(0).VertexCount(); n != 5 {
+		t.Errorf("Exact weld leaves %d vertices, want 5", n)
+	}
+	if m.NumIndices() != 1 || len(m.Index(0).Data()) != 6 {
+		t.Fatalf("Weld should give a soup mesh a triangle index")
+	}
+
+	m = soupQuad()
+	m.Weld(0.001)
+	if n := m.Attribute(0).VertexCount(); n != 4 {
+		t.Errorf("Weld with epsilon leaves %d vertices, want 4", n)
+	}
+	want := []gl.Uint{0, 1, 2, 2, 1, 3}
+	for i, v := range m.Index(0).Data() {
+		if v != want[i] {
+			t.Fatalf("Welded index is %v, want %v", m.Index(0).Data(), want)
+		}
+	}
+	if uv := m.Attribute(1).vec2(3); uv != (Vec2{1, 1}) {
+		t.Errorf("Welded UVs lost correspondence, vertex 3 has %v", uv)
+	}
+}
+
+func TestWeldKeepsSeams(t *testing.T) {
+	m := soupQuad()
+	m.Attribute(1).Data()[6] = 0.5 // vertex 3's UV no longer matches vertex 2's
+	m.Weld(0.001)
+	if n := m.Attribute(0).VertexCount(); n != 5 {
+		t.Errorf("Vertices with different UVs shouldn't weld, have %d", n)
+	}
+}
+
+func TestWeldKeepsMorphSeams(t *testing.T) {
+	// Vertices 2 and 3 are the corners of two lips, which part
+	m := soupQuad()
+	open, _ := m.AddMorphTarget("open")
+	open.SetSparseDeltas(AttribPosition, []int{2, 3}, []gl.Float{0, 1, 0, 0, -1, 0})
+	m.Weld(0.001)
+	if n := m.Attribute(0).VertexCount(); n != 5 {
+		t.Errorf("Vertices with different morph offsets shouldn't weld, have %d", n)
+	}
+
+	m = soupQuad()
+	raise, _ := m.AddMorphTarget("raise")
+	raise.SetSparseDeltas(AttribPosition, []int{2, 3}, []gl.Float{0, 1, 0, 0, 1, 0})
+	m.Weld(0.001)
+	if n := m.Attribute(0).VertexCount(); n != 4 {
+		t.Errorf("Vertices with the same morph offsets should weld, have %d", n)
+	}
+}
+
+func TestDeindex(t *testing.T) {
+	m := soupQuad()
+	m.Weld(0.001)
+	m.Index(0).Stripify(true)
+	if err := m.Deindex(); err != nil {
+		t.Fatal(err)
+	}
+	indx := m.Index(0)
+	if _, ok := indx.RestartIndex(); !ok || indx.Primitive() != gl.TRIANGLE_STRIP {
+		t.Errorf("Deindex should keep the primitive and restart index")
+	}
+	if m.Attribute(0).VertexCount() != len(indx.Data()) {
+		t.Errorf("Deindex gives %d vertices for %d entries", m.Attribute(0).VertexCount(), len(indx.Data()))
+	}
+	m.Weld(0.001)
+	if n := m.Attribute(0).VertexCount(); n != 4 {
+		t.Errorf("Weld after Deindex gives %d vertices, want 4", n)
+	}
+}
+
+func TestWeldBadIndex(t *testing.T) {
+	m := soupQuad()
+	m.AddMeshIndex("0", []gl.Uint{0, 1, 2, 3, 4, 6}, gl.TRIANGLES, m.Attribute(0))
+	if err := m.Weld(0.001); err == nil {
+		t.Errorf("Weld with an index past the last vertex should fail")
+	}
+	if err := m.Deindex(); err == nil {
+		t.Errorf("Deindex with an index past the last vertex should fail")
+	}
+	if n := m.Attribute(0).VertexCount(); n != 6 || m.Index(0).Data()[5] != 6 {
+		t.Errorf("A failed Weld or Deindex shouldn't change the mesh")
+	}
+
+	m = soupQuad()
+	m.AddMeshIndex("0", []gl.Uint{0, 1, 2, 99, 3, 4, 5}, gl.TRIANGLE_STRIP, m.Attribute(0))
+	m.Index(0).SetRestartIndex(99)
+	if err := m.Weld(0.001); err != nil {
+		t.Errorf("Restart indices aren't vertices, Weld fails with %v", err)
+	}
+}
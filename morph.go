@@ -0,0 +1,340 @@
+// morph - morph targets (blend shapes)
+//
+// A MorphTarget holds offsets from a mesh's base positions, normals and
+// tangents - 3 floats a vertex, tangent handedness isn't morphed - and a
+// weight.  ApplyMorphs() adds every target's offsets times its weight to
+// a base attribute and writes the result to a working attribute, so the
+// base stays as it was.  Offsets are kept dense, one per vertex, or
+// sparse, a list of the vertices that move, which suits shapes like a
+// blink that only touch a few.  Welding, optimising, subdividing and
+// splitting vertices for normals keep the offsets in step with the
+// vertices.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// MorphTarget - a named set of offsets and its weight
+type MorphTarget struct {
+	Name   string
+	Weight gl.Float
+	mesh   *Mesh
+	deltas map[string]*morphDeltas // By attribute description
+}
+
+type morphDeltas struct {
+	vertices []int      // Vertices that move, or nil if dense
+	data     []gl.Float // 3 floats for each vertex, or each listed vertex
+}
+
+// AddMorphTarget - adds an empty morph target with weight 0
+func (m *Mesh) AddMorphTarget(name string) (*MorphTarget, error) {
+	if m.MorphTarget(name) != nil {
+		return nil, errors.New(fmt.Sprintf("Mesh:AddMorphTarget: Mesh %s already has a morph target called %s", m.name, name))
+	}
+	mt := &MorphTarget{Name: name, mesh: m, deltas: make(map[string]*morphDeltas)}
+	m.morphs = append(m.morphs, mt)
+	return mt, nil
+}
+
+// AddMorphTargetFromMesh - adds a morph target from the difference
+// between the mesh and shape, a copy of it with the same vertices moved.
+// Positions, normals and tangents are compared, and stored sparse if
+// fewer than half the vertices move.
+func (m *Mesh) AddMorphTargetFromMesh(name string, shape *Mesh) (*MorphTarget, error) {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := m.AddMorphTarget(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range []string{AttribPosition, AttribNormal, AttribTangent} {
+		base, moved := m.AttributeByDesc(desc), shape.AttributeByDesc(desc)
+		if desc == AttribPosition {
+			base, moved = m.positionAttribute(), shape.positionAttribute()
+		}
+		if base == nil || moved == nil {
+			continue
+		}
+		if moved.VertexCount() != vertices || moved.stride != base.stride || base.stride < 3 {
+			m.RemoveMorphTarget(name)
+			return nil, errors.New(fmt.Sprintf("Mesh:AddMorphTargetFromMesh: Shape %s's %s doesn't match mesh %s's", shape.name, desc, m.name))
+		}
+		var list []int
+		var data []gl.Float
+		for v := 0; v < vertices; v++ {
+			a, b := base.vec3(v), moved.vec3(v)
+			if d := b.Sub(&a); *d != (Vec3{}) {
+				list = append(list, v)
+				data = append(data, d.X, d.Y, d.Z)
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		if len(list) < vertices/2 {
+			mt.deltas[desc] = &morphDeltas{list, data}
+			continue
+		}
+		dense := make([]gl.Float, vertices*3)
+		for i, v := range list {
+			copy(dense[v*3:v*3+3], data[i*3:i*3+3])
+		}
+		mt.deltas[desc] = &morphDeltas{nil, dense}
+	}
+	return mt, nil
+}
+
+// RemoveMorphTarget - removes the named morph target, returning false if
+// there isn't one
+func (m *Mesh) RemoveMorphTarget(name string) bool {
+	for i, mt := range m.morphs {
+		if mt.Name == name {
+			m.morphs = append(m.morphs[:i], m.morphs[i+1:]...)
+			mt.mesh = nil
+			return true
+		}
+	}
+	return false
+}
+
+// MorphTarget - the named morph target, or nil
+func (m *Mesh) MorphTarget(name string) *MorphTarget {
+	for _, mt := range m.morphs {
+		if mt.Name == name {
+			return mt
+		}
+	}
+	return nil
+}
+
+// NumMorphTargets - number of morph targets
+func (m *Mesh) NumMorphTargets() int {
+	return len(m.morphs)
+}
+
+// MorphTargetAt - morph target i, in the order they were added
+func (m *Mesh) MorphTargetAt(i int) *MorphTarget {
+	return m.morphs[i]
+}
+
+// SetMorphWeights - sets the morph targets' weights in order, as from an
+// animation's float channel.  Targets past the end of weights are left.
+func (m *Mesh) SetMorphWeights(weights []gl.Float) {
+	for i, w := range weights {
+		if i < len(m.morphs) {
+			m.morphs[i].Weight = w
+		}
+	}
+}
+
+// SetDeltas - sets the offsets for the attribute with description desc,
+// 3 floats for every vertex of the mesh
+func (mt *MorphTarget) SetDeltas(desc string, deltas []gl.Float) error {
+	if mt.mesh == nil {
+		return errors.New(fmt.Sprintf("MorphTarget:SetDeltas: Morph target %s has been removed", mt.Name))
+	}
+	vertices, err := mt.mesh.vertexCount()
+	if err != nil {
+		return err
+	}
+	if len(deltas) != vertices*3 {
+		return errors.New(fmt.Sprintf("MorphTarget:SetDeltas: Morph target %s has %d floats of %s offsets for %d vertices", mt.Name, len(deltas), desc, vertices))
+	}
+	data := make([]gl.Float, len(deltas))
+	copy(data, deltas)
+	mt.deltas[desc] = &morphDeltas{nil, data}
+	return nil
+}
+
+// SetSparseDeltas - sets the offsets for the attribute with description
+// desc for just the listed vertices, 3 floats each
+func (mt *MorphTarget) SetSparseDeltas(desc string, vertices []int, deltas []gl.Float) error {
+	if mt.mesh == nil {
+		return errors.New(fmt.Sprintf("MorphTarget:SetSparseDeltas: Morph target %s has been removed", mt.Name))
+	}
+	n, err := mt.mesh.vertexCount()
+	if err != nil {
+		return err
+	}
+	if len(deltas) != len(vertices)*3 {
+		return errors.New(fmt.Sprintf("MorphTarget:SetSparseDeltas: Morph target %s has %d floats of %s offsets for %d vertices", mt.Name, len(deltas), desc, len(vertices)))
+	}
+	for _, v := range vertices {
+		if v < 0 || v >= n {
+			return errors.New(fmt.Sprintf("MorphTarget:SetSparseDeltas: Morph target %s moves vertex %d of %d", mt.Name, v, n))
+		}
+	}
+	list := make([]int, len(vertices))
+	copy(list, vertices)
+	data := make([]gl.Float, len(deltas))
+	copy(data, deltas)
+	mt.deltas[desc] = &morphDeltas{list, data}
+	return nil
+}
+
+// IsSparse - are the offsets for desc stored sparse?
+func (mt *MorphTarget) IsSparse(desc string) bool {
+	d := mt.deltas[desc]
+	return d != nil && d.vertices != nil
+}
+
+// Delta - the offset of vertex v's desc attribute
+func (mt *MorphTarget) Delta(desc string, v int) Vec3 {
+	d := mt.deltas[desc]
+	if d == nil {
+		return Vec3{}
+	}
+	if d.vertices == nil {
+		return Vec3{d.data[v*3], d.data[v*3+1], d.data[v*3+2]}
+	}
+	for i, u := range d.vertices {
+		if u == v {
+			return Vec3{d.data[i*3], d.data[i*3+1], d.data[i*3+2]}
+		}
+	}
+	return Vec3{}
+}
+
+// ApplyMorphs - writes the desc attribute, morphed by every target's
+// offsets times its weight, into target, which needs the same vertex
+// count and stride as the base attribute.  Normals and tangents are
+// renormalised.
+func (m *Mesh) ApplyMorphs(desc string, target *MeshAttribute) error {
+	base := m.AttributeByDesc(desc)
+	if desc == AttribPosition {
+		base = m.positionAttribute()
+	}
+	if base == nil {
+		return errors.New(fmt.Sprintf("Mesh:ApplyMorphs: Mesh %s has no %s attribute", m.name, desc))
+	}
+	vertices := base.VertexCount()
+	if target == nil || target.stride != base.stride || target.VertexCount() != vertices {
+		return errors.New(fmt.Sprintf("Mesh:ApplyMorphs: Target doesn't match mesh %s's %s attribute", m.name, desc))
+	}
+	if base.stride < 3 {
+		return errors.New(fmt.Sprintf("Mesh:ApplyMorphs: Mesh %s's %s attribute has %d components, need 3", m.name, desc, base.stride))
+	}
+	s := base.stride
+	out := target.data
+	copy(out, base.data)
+	for _, mt := range m.morphs {
+		d := mt.deltas[desc]
+		if d == nil || mt.Weight == 0 {
+			continue
+		}
+		if d.vertices == nil {
+			if len(d.data) != vertices*3 {
+				return errors.New(fmt.Sprintf("Mesh:ApplyMorphs: Morph target %s has %s offsets for %d vertices, mesh has %d", mt.Name, desc, len(d.data)/3, vertices))
+			}
+			for v := 0; v < vertices; v++ {
+				out[v*s] += d.data[v*3] * mt.Weight
+				out[v*s+1] += d.data[v*3+1] * mt.Weight
+				out[v*s+2] += d.data[v*3+2] * mt.Weight
+			}
+			continue
+		}
+		for i, v := range d.vertices {
+			if v >= vertices {
+				return errors.New(fmt.Sprintf("Mesh:ApplyMorphs: Morph target %s moves vertex %d, mesh has %d", mt.Name, v, vertices))
+			}
+			out[v*s] += d.data[i*3] * mt.Weight
+			out[v*s+1] += d.data[i*3+1] * mt.Weight
+			out[v*s+2] += d.data[i*3+2] * mt.Weight
+		}
+	}
+	if desc == AttribNormal || desc == AttribTangent || desc == AttribBitangent {
+		for v := 0; v < vertices; v++ {
+			n := Vec3{out[v*s], out[v*s+1], out[v*s+2]}
+			if l := n.Length(); l > 0 {
+				out[v*s], out[v*s+1], out[v*s+2] = n.X/l, n.Y/l, n.Z/l
+			}
+		}
+	}
+	target.changed()
+	return nil
+}
+
+// The offsets, one for every one of the vertices
+func (d *morphDeltas) dense(vertices int) []gl.Float {
+	if d.vertices == nil {
+		return d.data
+	}
+	out := make([]gl.Float, vertices*3)
+	for i, v := range d.vertices {
+		copy(out[v*3:v*3+3], d.data[i*3:i*3+3])
+	}
+	return out
+}
+
+// Replaces the offsets with data, one for every vertex, keeping sparse
+// offsets sparse by listing just the vertices that move
+func (d *morphDeltas) setDense(data []gl.Float) {
+	if d.vertices == nil {
+		d.data = data
+		return
+	}
+	list, moved := []int{}, []gl.Float(nil)
+	for v := 0; v < len(data)/3; v++ {
+		if data[v*3] != 0 || data[v*3+1] != 0 || data[v*3+2] != 0 {
+			list = append(list, v)
+			moved = append(moved, data[v*3:v*3+3]...)
+		}
+	}
+	d.vertices, d.data = list, moved
+}
+
+// Keeps the morph offsets in step with keepVertices
+func (m *Mesh) keepMorphVertices(vertices []int) {
+	for _, mt := range m.morphs {
+		for _, d := range mt.deltas {
+			if d.vertices == nil {
+				data := make([]gl.Float, 0, len(vertices)*3)
+				for _, v := range vertices {
+					data = append(data, d.data[v*3:v*3+3]...)
+				}
+				d.data = data
+				continue
+			}
+			moved := make(map[int]int, len(d.vertices))
+			for i, v := range d.vertices {
+				moved[v] = i
+			}
+			list, data := []int{}, []gl.Float(nil)
+			for n, v := range vertices {
+				if i, ok := moved[v]; ok {
+					list = append(list, n)
+					data = append(data, d.data[i*3:i*3+3]...)
+				}
+			}
+			d.vertices, d.data = list, data
+		}
+	}
+}
+
+// Keeps the morph offsets in step with duplicateVertex, v having been
+// copied to n
+func (m *Mesh) duplicateMorphVertex(v, n int) {
+	for _, mt := range m.morphs {
+		for _, d := range mt.deltas {
+			if d.vertices == nil {
+				d.data = append(d.data, d.data[v*3:v*3+3]...)
+				continue
+			}
+			for i, u := range d.vertices {
+				if u == v {
+					d.vertices = append(d.vertices, n)
+					d.data = append(d.data, d.data[i*3:i*3+3]...)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package goglutils
+
+import (
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A quad with one dense and one sparse morph target
+func morphQuad(t *testing.T) *Mesh {
+	m := NewMesh("face")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0}, 3)
+	m.AddMeshAttribute(AttribNormal, []gl.Float{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1}, 3)
+	m.AddMeshIndex("0", []gl.Uint{0, 1, 2, 2, 1, 3}, gl.TRIANGLES, m.Attribute(0))
+	puff, err := m.AddMorphTarget("puff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := puff.SetDeltas(AttribPosition, []gl.Float{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	blink, _ := m.AddMorphTarget("blink")
+	if err := blink.SetSparseDeltas(AttribPosition, []int{3}, []gl.Float{0, -1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blink.SetSparseDeltas(AttribNormal, []int{3}, []gl.Float{0, 1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestApplyMorphs(t *testing.T) {
+	m := morphQuad(t)
+	m.SetMorphWeights([]gl.Float{0.5, 1})
+	pos := NewMeshAttribute("morphed", make([]gl.Float, 12), 3)
+	if err := m.ApplyMorphs(AttribPosition, pos); err != nil {
+		t.Fatal(err)
+	}
+	want := []Vec3{{0, 0, 0.5}, {1, 0, 0.5}, {0, 1, 0.5}, {1, 0, 0.5}}
+	for v, w := range want {
+		if p := pos.vec3(v); !closeVec3(p, w) {
+			t.Errorf("Morphed vertex %d is at %v, want %v", v, p, w)
+		}
+	}
+	nrm := NewMeshAttribute("morphednormal", make([]gl.Float, 12), 3)
+	if err := m.ApplyMorphs(AttribNormal, nrm); err != nil {
+		t.Fatal(err)
+	}
+	if n := nrm.vec3(3); !closeVec3(n, Vec3{0, 1, 0}) || !closeVec3(nrm.vec3(0), Vec3{0, 0, 1}) {
+		t.Errorf("Morphed normals are %v and %v", nrm.vec3(0), n)
+	}
+	if p := m.Attribute(0).vec3(3); p != (Vec3{1, 1, 0}) {
+		t.Errorf("ApplyMorphs changed the base positions")
+	}
+	if err := m.ApplyMorphs(AttribPosition, NewMeshAttribute("short", make([]gl.Float, 9), 3)); err == nil {
+		t.Errorf("ApplyMorphs should fail with a target of the wrong size")
+	}
+}
+
+func TestMorphTargetErrors(t *testing.T) {
+	m := morphQuad(t)
+	if _, err := m.AddMorphTarget("puff"); err == nil {
+		t.Errorf("AddMorphTarget should fail with a name already in use")
+	}
+	mt := m.MorphTarget("puff")
+	if err := mt.SetDeltas(AttribPosition, make([]gl.Float, 9)); err == nil {
+		t.Errorf("SetDeltas should fail with too few offsets")
+	}
+	if err := mt.SetSparseDeltas(AttribPosition, []int{4}, make([]gl.Float, 3)); err == nil {
+		t.Errorf("SetSparseDeltas should fail with a vertex that doesn't exist")
+	}
+	if !m.RemoveMorphTarget("puff") || m.NumMorphTargets() != 1 || m.MorphTargetAt(0).Name != "blink" {
+		t.Errorf("RemoveMorphTarget left %d targets", m.NumMorphTargets())
+	}
+}
+
+func TestMorphTargetFromMesh(t *testing.T) {
+	m := morphQuad(t)
+	shape := m.Clone("smile")
+	shape.Attribute(0).Data()[1] = -0.5 // only vertex 0 moves
+	mt, err := m.AddMorphTargetFromMesh("smile", shape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mt.IsSparse(AttribPosition) || mt.Delta(AttribPosition, 0) != (Vec3{0, -0.5, 0}) || mt.Delta(AttribPosition, 1) != (Vec3{}) {
+		t.Errorf("Morph target from mesh has vertex 0 offset %v, sparse %v", mt.Delta(AttribPosition, 0), mt.IsSparse(AttribPosition))
+	}
+	if mt.Delta(AttribNormal, 0) != (Vec3{}) {
+		t.Errorf("Normals didn't change, but have offset %v", mt.Delta(AttribNormal, 0))
+	}
+	if c := m.Clone("copy"); c.NumMorphTargets() != 3 || !c.MorphTarget("smile").IsSparse(AttribPosition) {
+		t.Errorf("Clone should copy morph targets")
+	}
+}
+
+// Morph offsets follow their vertices through welding and splitting
+func TestMorphsFollowVertices(t *testing.T) {
+	m := morphQuad(t)
+	// Reverse the vertices
+	m.keepVertices([]int{3, 2, 1, 0})
+	blink := m.MorphTarget("blink")
+	if blink.Delta(AttribPosition, 0) != (Vec3{0, -1, 0}) || blink.Delta(AttribPosition, 3) != (Vec3{}) {
+		t.Errorf("Sparse offsets didn't follow reordered vertices")
+	}
+	if n := m.duplicateVertex(0); blink.Delta(AttribPosition, int(n)) != (Vec3{0, -1, 0}) || m.MorphTarget("puff").Delta(AttribPosition, int(n)) != (Vec3{0, 0, 1}) {
+		t.Errorf("Offsets didn't follow a duplicated vertex")
+	}
+	pos := NewMeshAttribute("morphed", make([]gl.Float, 15), 3)
+	if err := m.ApplyMorphs(AttribPosition, pos); err != nil {
+		t.Errorf("ApplyMorphs after splitting: %v", err)
+	}
+}
+
+func TestSetDeltasCopies(t *testing.T) {
+	m := morphQuad(t)
+	mt := m.MorphTarget("puff")
+	deltas := []gl.Float{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1}
+	mt.SetDeltas(AttribNormal, deltas)
+	list, sparse := []int{2}, []gl.Float{1, 0, 0}
+	mt.SetSparseDeltas(AttribTangent, list, sparse)
+	deltas[0], list[0], sparse[0] = 5, 3, 5
+	if mt.Delta(AttribNormal, 0) != (Vec3{0, 0, 1}) || mt.Delta(AttribTangent, 2) != (Vec3{1, 0, 0}) {
+		t.Errorf("Changing the caller's slices changed the morph target's offsets")
+	}
+}
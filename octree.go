@@ -0,0 +1,223 @@
+// octree - a loose octree of objects by bounding box
+//
+// Each node's loose bounds are twice the size of its cell, so an object
+// can be stored at the deepest level whose cells are at least as big as
+// it is, in the cell holding its centre, and never has to straddle a
+// split.  Nodes are made as objects arrive and dropped again once their
+// subtree is empty.  Objects outside the world bounds live in the root.
+
+package goglutils
+
+import (
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// LooseOctree - a SpatialIndex for objects of very different sizes
+type LooseOctree struct {
+	root     *octreeNode
+	maxDepth int
+	objects  map[int]*octreeEntry
+}
+
+type octreeEntry struct {
+	box  AABB
+	node *octreeNode
+}
+
+type octreeNode struct {
+	centre   Vec3
+	half     gl.Float // Half the size of the cell; the loose bounds are twice that
+	parent   *octreeNode
+	slot     int // Which of the parent's children this is
+	children [8]*octreeNode
+	ids      []int
+	count    int // Objects here and below
+}
+
+// NewLooseOctree - an octree over a cube taking in the given bounds,
+// splitting at most maxDepth times
+func NewLooseOctree(bounds AABB, maxDepth int) *LooseOctree {
+	h := bounds.HalfExtents()
+	half := max32(h.X, max32(h.Y, h.Z))
+	if half <= 0 {
+		half = 1
+	}
+	return &LooseOctree{
+		root:     &octreeNode{centre: bounds.Center(), half: half, slot: -1},
+		maxDepth: maxDepth,
+		objects:  make(map[int]*octreeEntry),
+	}
+}
+
+// The node's cell grown by scale - 1 for the cell itself, 2 for its loose
+// bounds
+func (n *octreeNode) bounds(scale gl.Float) AABB {
+	d := scale * n.half
+	return AABB{
+		Vec3{n.centre.X - d, n.centre.Y - d, n.centre.Z - d},
+		Vec3{n.centre.X + d, n.centre.Y + d, n.centre.Z + d},
+	}
+}
+
+// Insert - adds an object, or moves it if the id is already there
+func (o *LooseOctree) Insert(id int, box AABB) {
+	o.Remove(id)
+	h := box.HalfExtents()
+	size := max32(h.X, max32(h.Y, h.Z))
+	c := box.Center()
+
+	node := o.root
+	if o.root.bounds(1).Contains(c) {
+		for depth := 0; depth < o.maxDepth && size <= node.half/2; depth++ {
+			slot := 0
+			offset := Vec3{-node.half / 2, -node.half / 2, -node.half / 2}
+			if c.X >= node.centre.X {
+				slot |= 1
+				offset.X = -offset.X
+			}
+			if c.Y >= node.centre.Y {
+				slot |= 2
+				offset.Y = -offset.Y
+			}
+			if c.Z >= node.centre.Z {
+				slot |= 4
+				offset.Z = -offset.Z
+			}
+			if node.children[slot] == nil {
+				node.children[slot] = &octreeNode{
+					centre: *node.centre.Add(&offset),
+					half:   node.half / 2,
+					parent: node,
+					slot:   slot,
+				}
+			}
+			node = node.children[slot]
+		}
+	}
+	node.ids = append(node.ids, id)
+	for n := node; n != nil; n = n.parent {
+		n.count++
+	}
+	o.objects[id] = &octreeEntry{box, node}
+}
+
+// Update - moves an object, returning false if the id isn't there
+func (o *LooseOctree) Update(id int, box AABB) bool {
+	if _, ok := o.objects[id]; !ok {
+		return false
+	}
+	o.Insert(id, box)
+	return true
+}
+
+// Remove - removes an object, returning false if the id isn't there
+func (o *LooseOctree) Remove(id int) bool {
+	e, ok := o.objects[id]
+	if !ok {
+		return false
+	}
+	delete(o.objects, id)
+	node := e.node
+	for i, other := range node.ids {
+		if other == id {
+			last := len(node.ids) - 1
+			node.ids[i] = node.ids[last]
+			node.ids = node.ids[:last]
+			break
+		}
+	}
+	for n := node; n != nil; n = n.parent {
+		n.count--
+		if n.count == 0 && n.parent != nil {
+			n.parent.children[n.slot] = nil
+		}
+	}
+	return true
+}
+
+// Bounds - the object's box
+func (o *LooseOctree) Bounds(id int) (AABB, bool) {
+	e, ok := o.objects[id]
+	if !ok {
+		return AABB{}, false
+	}
+	return e.box, true
+}
+
+// Len - number of objects
+func (o *LooseOctree) Len() int {
+	return len(o.objects)
+}
+
+// Calls visit for each object in a node whose loose bounds pass the test
+func (o *LooseOctree) query(test func(AABB) bool, visit func(id int, box AABB)) {
+	stack := []*octreeNode{o.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		// The root holds everything outside the world, so is always searched
+		if n.count == 0 || (n.parent != nil && !test(n.bounds(2))) {
+			continue
+		}
+		for _, id := range n.ids {
+			visit(id, o.objects[id].box)
+		}
+		for _, c := range n.children {
+			if c != nil {
+				stack = append(stack, c)
+			}
+		}
+	}
+}
+
+// QueryAABB - objects whose boxes touch the box
+func (o *LooseOctree) QueryAABB(box AABB) []int {
+	var out []int
+	o.query(box.Overlaps, func(id int, b AABB) {
+		if box.Overlaps(b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryRadius - objects whose boxes come within radius of centre
+func (o *LooseOctree) QueryRadius(centre Vec3, radius gl.Float) []int {
+	var out []int
+	test := func(b AABB) bool { return sphereOverlapsAABB(centre, radius, b) }
+	o.query(test, func(id int, b AABB) {
+		if test(b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryFrustum - objects whose boxes are at least partly in view
+func (o *LooseOctree) QueryFrustum(f *ViewFrustum) []int {
+	var out []int
+	o.query(f.IntersectsAABB, func(id int, b AABB) {
+		if f.IntersectsAABB(b) {
+			out = append(out, id)
+		}
+	})
+	return out
+}
+
+// QueryRay - objects whose boxes the ray passes through before maxT,
+// nearest first
+func (o *LooseOctree) QueryRay(r Ray, maxT gl.Float) []ObjectHit {
+	var out []ObjectHit
+	inv := Vec3{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	test := func(b AABB) bool {
+		_, ok := b.intersectRay(r.Origin, inv, maxT)
+		return ok
+	}
+	o.query(test, func(id int, b AABB) {
+		if t, ok := b.intersectRay(r.Origin, inv, maxT); ok {
+			out = append(out, ObjectHit{id, t})
+		}
+	})
+	sortObjectHits(out)
+	return out
+}
@@ -0,0 +1,306 @@
+/* Program binary cache
+
+An opt-in, on-disk cache of linked program binaries.  Linking a large
+shader set can take seconds on some drivers; with a cache the binaries
+from the last run are handed straight back to the driver with
+glProgramBinary, and we only fall back to compiling from source when
+there's no entry or the driver rejects the one we have.
+
+Entries are keyed by a hash of the shader sources, the #defines they were
+built with and the driver's vendor/renderer/version strings, and listed in
+an index.json in the cache directory.  The index handling is plain Go;
+only LoadProgram/StoreProgram/CreateCachedShaderProgram touch GL (4.1+,
+through the gl43 binding).
+
+	cache, err := OpenProgramBinaryCache("shadercache", CurrentDriverInfo())
+	program := CreateCachedShaderProgram(cache, files, nil)
+	cache.Save()
+*/
+
+package goglutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	gl43 "github.com/chsc/gogl/gl43"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const programCacheIndex = "index.json"
+
+// DriverInfo - the strings identifying the GL driver.  A binary is only
+// good for the exact driver that produced it.
+type DriverInfo struct {
+	Vendor   string
+	Renderer string
+	Version  string
+}
+
+// Reads the driver strings from the current GL context
+func CurrentDriverInfo() DriverInfo {
+	return DriverInfo{
+		Vendor:   gl.GoStringUb(gl.GetString(gl.VENDOR)),
+		Renderer: gl.GoStringUb(gl.GetString(gl.RENDERER)),
+		Version:  gl.GoStringUb(gl.GetString(gl.VERSION)),
+	}
+}
+
+// A single string identifying the driver, as stored in the index
+func (d DriverInfo) String() string {
+	return d.Vendor + "\x00" + d.Renderer + "\x00" + d.Version
+}
+
+// SourceHash - hex SHA-256 over the contents of the given shader files,
+// in order.
+func SourceHash(shaderFiles []string) (string, error) {
+	h := sha256.New()
+	for _, file := range shaderFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.Ext(file), len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ProgramCacheKey - the cache key for a program built from sources with the
+// given hash and defines, on the given driver.  The order of defines
+// doesn't matter.
+func ProgramCacheKey(sourceHash string, defines []string, driver DriverInfo) string {
+	sorted := make([]string, len(defines))
+	copy(sorted, defines)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", sourceHash)
+	for _, define := range sorted {
+		fmt.Fprintf(h, "%s\x00", define)
+	}
+	fmt.Fprintf(h, "%s", driver.String())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type programCacheEntry struct {
+	File   string `json:"file"`
+	Format uint32 `json:"format"`
+	Size   int    `json:"size"`
+	Driver string `json:"driver"`
+}
+
+// A key has to be usable as a file name in the cache directory, and an
+// entry read from the index has to point at its own key's file - anything
+// else could reach outside the directory.
+func validCacheKey(key string) bool {
+	return key != "" && !filepath.IsAbs(key) && !strings.ContainsAny(key, `/\`) && !strings.Contains(key, "..")
+}
+
+func validCacheFile(key string, entry programCacheEntry) bool {
+	return validCacheKey(key) && entry.File == key+".bin"
+}
+
+type ProgramBinaryCache struct {
+	dir    string
+	driver DriverInfo
+	index  map[string]programCacheEntry
+	dirty  bool
+}
+
+// OpenProgramBinaryCache - opens (creating if needed) a cache in dir for
+// the given driver.  Entries left by any other driver, entries whose
+// binary file has gone missing and entries that don't make sense are
+// dropped straight away.  An unreadable index is treated as empty rather
+// than an error - it's only a cache.
+func OpenProgramBinaryCache(dir string, driver DriverInfo) (*ProgramBinaryCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := new(ProgramBinaryCache)
+	c.dir = dir
+	c.driver = driver
+	c.index = make(map[string]programCacheEntry)
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, programCacheIndex)); err == nil {
+		if err := json.Unmarshal(data, &c.index); err != nil {
+			c.index = make(map[string]programCacheEntry)
+			c.dirty = true
+		}
+	}
+	for key, entry := range c.index {
+		if !validCacheFile(key, entry) {
+			// Don't trust its file name enough to remove anything
+			delete(c.index, key)
+			c.dirty = true
+			continue
+		}
+		if entry.Driver != driver.String() || entry.Size <= 0 {
+			c.Invalidate(key)
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dir, entry.File))
+		if err != nil || fi.Size() != int64(entry.Size) {
+			c.Invalidate(key)
+		}
+	}
+	return c, nil
+}
+
+// Number of entries in the cache
+func (c *ProgramBinaryCache) Len() int {
+	return len(c.index)
+}
+
+// Lookup - returns the binary format and data stored under key, if any.
+func (c *ProgramBinaryCache) Lookup(key string) (gl.Enum, []byte, bool) {
+	entry, ok := c.index[key]
+	if !ok || !validCacheFile(key, entry) || entry.Size <= 0 {
+		return 0, nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, entry.File))
+	if err != nil || len(data) != entry.Size {
+		c.Invalidate(key)
+		return 0, nil, false
+	}
+	return gl.Enum(entry.Format), data, true
+}
+
+// Store - writes a program binary under key, replacing any existing entry.
+func (c *ProgramBinaryCache) Store(key string, format gl.Enum, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("ProgramBinaryCache:Store: Empty program binary")
+	}
+	if !validCacheKey(key) {
+		return errors.New(fmt.Sprintf("ProgramBinaryCache:Store: Key %q can't be used as a file name", key))
+	}
+	file := key + ".bin"
+	if err := writeFileAtomic(filepath.Join(c.dir, file), data); err != nil {
+		return err
+	}
+	c.index[key] = programCacheEntry{
+		File:   file,
+		Format: uint32(format),
+		Size:   len(data),
+		Driver: c.driver.String(),
+	}
+	c.dirty = true
+	return nil
+}
+
+// Invalidate - drops the entry for key and removes its binary.
+func (c *ProgramBinaryCache) Invalidate(key string) {
+	if entry, ok := c.index[key]; ok {
+		if validCacheFile(key, entry) {
+			os.Remove(filepath.Join(c.dir, entry.File))
+		}
+		delete(c.index, key)
+		c.dirty = true
+	}
+}
+
+// Clear - drops every entry in the cache.
+func (c *ProgramBinaryCache) Clear() {
+	for key := range c.index {
+		c.Invalidate(key)
+	}
+}
+
+// Save - writes the index out, if anything has changed since it was read.
+func (c *ProgramBinaryCache) Save() error {
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.index, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, programCacheIndex), data); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Writes to a temporary file and renames it into place, so a crash
+// part-way through never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadProgram - creates a program from the binary stored under key.
+// If there's no entry, or the driver won't link the binary, 0 is returned
+// (and a rejected entry is invalidated).
+func (c *ProgramBinaryCache) LoadProgram(key string) gl.Uint {
+	format, data, ok := c.Lookup(key)
+	if !ok || len(data) == 0 {
+		return 0
+	}
+	program := gl.CreateProgram()
+	if program == 0 {
+		return 0
+	}
+	gl43.ProgramBinary(gl43.Uint(program), gl43.Enum(format), gl43.Pointer(&data[0]), gl43.Sizei(len(data)))
+
+	var result gl.Int
+	gl.GetProgramiv(program, gl.LINK_STATUS, &result)
+	if result != gl.TRUE {
+		gl.DeleteProgram(program)
+		c.Invalidate(key)
+		return 0
+	}
+	return program
+}
+
+// StoreProgram - reads back the binary of a linked program and stores it
+// under key.  The program should have been linked with
+// GL_PROGRAM_BINARY_RETRIEVABLE_HINT set.
+func (c *ProgramBinaryCache) StoreProgram(key string, program gl.Uint) error {
+	var length gl43.Int
+	gl43.GetProgramiv(gl43.Uint(program), gl43.PROGRAM_BINARY_LENGTH, &length)
+	if length <= 0 {
+		return errors.New("ProgramBinaryCache:StoreProgram: Driver returned no program binary")
+	}
+	data := make([]byte, length)
+	var written gl43.Sizei
+	var format gl43.Enum
+	gl43.GetProgramBinary(gl43.Uint(program), gl43.Sizei(length), &written, &format, gl43.Pointer(&data[0]))
+	return c.Store(key, gl.Enum(format), data[:written])
+}
+
+// CreateCachedShaderProgram - like CreateShaderProgramDefines, but goes
+// through cache first.  On a miss, or if the driver rejects the cached
+// binary, the program is compiled from source and its binary stored.
+// Returns 0 if the program can't be built at all.  Call cache.Save() once
+// you're done building programs to write the index out.
+func CreateCachedShaderProgram(cache *ProgramBinaryCache, shaderFiles []string, defines []string) gl.Uint {
+	sourceHash, err := SourceHash(shaderFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: CreateCachedShaderProgram: %s\n", err)
+		return 0
+	}
+	key := ProgramCacheKey(sourceHash, defines, cache.driver)
+	if program := cache.LoadProgram(key); program != 0 {
+		return program
+	}
+
+	program := linkShaderProgram(shaderFiles, defines, false, true)
+	if program == 0 {
+		return 0
+	}
+	if err := cache.StoreProgram(key, program); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: CreateCachedShaderProgram: %s\n", err)
+	}
+	return program
+}
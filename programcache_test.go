@@ -0,0 +1,154 @@
+package goglutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testDriver = DriverInfo{"Vendor", "Renderer 9000", "4.3.0 Driver 1.2"}
+
+func TestProgramCacheKey(t *testing.T) {
+	key := ProgramCacheKey("abc", []string{"A 1", "B 2"}, testDriver)
+	if key != ProgramCacheKey("abc", []string{"B 2", "A 1"}, testDriver) {
+		t.Errorf("Key should not depend on the order of defines")
+	}
+	if key == ProgramCacheKey("abd", []string{"A 1", "B 2"}, testDriver) {
+		t.Errorf("Key should change with the source hash")
+	}
+	if key == ProgramCacheKey("abc", []string{"A 1"}, testDriver) {
+		t.Errorf("Key should change with the defines")
+	}
+	other := testDriver
+	other.Version = "4.3.0 Driver 1.3"
+	if key == ProgramCacheKey("abc", []string{"A 1", "B 2"}, other) {
+		t.Errorf("Key should change with the driver version")
+	}
+}
+
+func TestSourceHash(t *testing.T) {
+	dir := t.TempDir()
+	vert := filepath.Join(dir, "a.vert")
+	ioutil.WriteFile(vert, []byte("void main() {}\n"), 0644)
+	h1, err := SourceHash([]string{vert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(vert, []byte("void main() { }\n"), 0644)
+	h2, _ := SourceHash([]string{vert})
+	if h1 == h2 {
+		t.Errorf("SourceHash should change when the source does")
+	}
+	if _, err := SourceHash([]string{filepath.Join(dir, "missing.frag")}); err == nil {
+		t.Errorf("SourceHash of a missing file should fail")
+	}
+}
+
+func TestProgramBinaryCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenProgramBinaryCache(dir, testDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store("k1", 0x1234, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ = OpenProgramBinaryCache(dir, testDriver)
+	format, data, ok := c.Lookup("k1")
+	if !ok || format != 0x1234 || len(data) != 4 || data[3] != 4 {
+		t.Errorf("Lookup after reopen yields %v %v %v", format, data, ok)
+	}
+	if _, _, ok := c.Lookup("k2"); ok {
+		t.Errorf("Lookup of a missing key should miss")
+	}
+}
+
+func TestProgramBinaryCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := OpenProgramBinaryCache(dir, testDriver)
+	c.Store("k1", 1, []byte{1})
+	c.Store("k2", 1, []byte{2, 2})
+	c.Save()
+
+	// A rejected binary is dropped along with its file
+	c.Invalidate("k1")
+	if _, err := os.Stat(filepath.Join(dir, "k1.bin")); !os.IsNotExist(err) {
+		t.Errorf("Invalidate should remove the binary file")
+	}
+	c.Save()
+	c, _ = OpenProgramBinaryCache(dir, testDriver)
+	if c.Len() != 1 {
+		t.Errorf("Cache has %d entries after invalidation, want 1", c.Len())
+	}
+
+	// A truncated binary is dropped on open
+	ioutil.WriteFile(filepath.Join(dir, "k2.bin"), []byte{2}, 0644)
+	c, _ = OpenProgramBinaryCache(dir, testDriver)
+	if c.Len() != 0 {
+		t.Errorf("Cache kept a binary whose size changed")
+	}
+
+	// A driver update drops everything from the old driver
+	c.Store("k3", 1, []byte{3})
+	c.Save()
+	updated := testDriver
+	updated.Version = "4.3.0 Driver 2.0"
+	c, _ = OpenProgramBinaryCache(dir, updated)
+	if c.Len() != 0 {
+		t.Errorf("Cache kept entries from another driver")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "k3.bin")); !os.IsNotExist(err) {
+		t.Errorf("Entries from another driver should be removed from disk")
+	}
+}
+
+func TestProgramBinaryCacheCorruptIndex(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, programCacheIndex), []byte("{not json"), 0644)
+	c, err := OpenProgramBinaryCache(dir, testDriver)
+	if err != nil {
+		t.Fatalf("A corrupt index should be ignored, got %v", err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Corrupt index yields %d entries, want 0", c.Len())
+	}
+}
+
+func TestProgramBinaryCacheBadEntries(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "cache")
+	os.MkdirAll(dir, 0755)
+	outside := filepath.Join(root, "precious")
+	ioutil.WriteFile(outside, []byte{1}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "empty.bin"), nil, 0644)
+	index := `{
+		"evil": {"file": "../precious", "format": 1, "size": 1, "driver": "other"},
+		"../precious": {"file": "../precious.bin", "format": 1, "size": 1, "driver": "other"},
+		"empty": {"file": "empty.bin", "format": 1, "size": 0, "driver": "` + testDriver.String() + `"}
+	}`
+	ioutil.WriteFile(filepath.Join(dir, programCacheIndex), []byte(index), 0644)
+
+	c, err := OpenProgramBinaryCache(dir, testDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Cache kept %d bad entries", c.Len())
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Opening the cache removed a file outside it: %v", err)
+	}
+	if _, _, ok := c.Lookup("empty"); ok {
+		t.Errorf("Lookup of an empty binary should miss")
+	}
+	for _, key := range []string{"", "../x", "a/b", `a\b`, "/abs"} {
+		if err := c.Store(key, 1, []byte{1}); err == nil {
+			t.Errorf("Store(%q) should fail", key)
+		}
+	}
+}
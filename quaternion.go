@@ -0,0 +1,212 @@
+// quaternion - rotation quaternions and dual quaternions
+//
+// Quat is a unit quaternion for rotations, with W as the scalar part.
+// DualQuat holds a rotation and a translation together, and blends them
+// without the shrinking you get averaging matrices, which is what
+// dual-quaternion skinning is for.  Angles are in degrees, like RotateX().
+
+package goglutils
+
+import (
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Quat - a quaternion X i + Y j + Z k + W
+type Quat struct {
+	X, Y, Z, W gl.Float
+}
+
+// IdentQuat - the quaternion for no rotation
+func IdentQuat() Quat {
+	return Quat{0, 0, 0, 1}
+}
+
+// QuatFromAxisAngle - rotation by angle degrees about axis
+func QuatFromAxisAngle(axis Vec3, angle gl.Float) Quat {
+	a := toVec3d(axis).normalize()
+	s, c := math.Sincos(float64(DegToRad(angle)) / 2)
+	return Quat{gl.Float(a[0] * s), gl.Float(a[1] * s), gl.Float(a[2] * s), gl.Float(c)}
+}
+
+// QuatFromMat4 - the rotation part of a matrix, which should have no
+// scale or shear
+func QuatFromMat4(m *Mat4) Quat {
+	// Shepperd's method - pick the largest of w, x, y, z to divide by
+	m00, m11, m22 := float64(m[0].X), float64(m[1].Y), float64(m[2].Z)
+	var q [4]float64
+	switch trace := m00 + m11 + m22; {
+	case trace > 0:
+		s := math.Sqrt(trace+1) * 2
+		q = [4]float64{
+			float64(m[1].Z-m[2].Y) / s,
+			float64(m[2].X-m[0].Z) / s,
+			float64(m[0].Y-m[1].X) / s,
+			s / 4,
+		}
+	case m00 > m11 && m00 > m22:
+		s := math.Sqrt(1+m00-m11-m22) * 2
+		q = [4]float64{
+			s / 4,
+			float64(m[1].X+m[0].Y) / s,
+			float64(m[2].X+m[0].Z) / s,
+			float64(m[1].Z-m[2].Y) / s,
+		}
+	case m11 > m22:
+		s := math.Sqrt(1+m11-m00-m22) * 2
+		q = [4]float64{
+			float64(m[1].X+m[0].Y) / s,
+			s / 4,
+			float64(m[2].Y+m[1].Z) / s,
+			float64(m[2].X-m[0].Z) / s,
+		}
+	default:
+		s := math.Sqrt(1+m22-m00-m11) * 2
+		q = [4]float64{
+			float64(m[2].X+m[0].Z) / s,
+			float64(m[2].Y+m[1].Z) / s,
+			s / 4,
+			float64(m[0].Y-m[1].X) / s,
+		}
+	}
+	return Quat{gl.Float(q[0]), gl.Float(q[1]), gl.Float(q[2]), gl.Float(q[3])}.Normalize()
+}
+
+// Mul - the product qp, which rotates by p and then by q
+func (q Quat) Mul(p Quat) Quat {
+	return Quat{
+		q.W*p.X + q.X*p.W + q.Y*p.Z - q.Z*p.Y,
+		q.W*p.Y - q.X*p.Z + q.Y*p.W + q.Z*p.X,
+		q.W*p.Z + q.X*p.Y - q.Y*p.X + q.Z*p.W,
+		q.W*p.W - q.X*p.X - q.Y*p.Y - q.Z*p.Z,
+	}
+}
+
+// Conjugate - the inverse rotation, for a unit quaternion
+func (q Quat) Conjugate() Quat {
+	return Quat{-q.X, -q.Y, -q.Z, q.W}
+}
+
+// Dot - the 4D dot product
+func (q Quat) Dot(p Quat) gl.Float {
+	return q.X*p.X + q.Y*p.Y + q.Z*p.Z + q.W*p.W
+}
+
+// Scale - every component times s
+func (q Quat) Scale(s gl.Float) Quat {
+	return Quat{q.X * s, q.Y * s, q.Z * s, q.W * s}
+}
+
+// Add - the componentwise sum
+func (q Quat) Add(p Quat) Quat {
+	return Quat{q.X + p.X, q.Y + p.Y, q.Z + p.Z, q.W + p.W}
+}
+
+// Length of the quaternion as a 4D vector
+func (q Quat) Length() gl.Float {
+	return gl.Float(math.Sqrt(float64(q.Dot(q))))
+}
+
+// Normalize - the quaternion scaled to unit length, or no rotation for a
+// zero quaternion
+func (q Quat) Normalize() Quat {
+	l := q.Length()
+	if l == 0 {
+		return IdentQuat()
+	}
+	return q.Scale(1 / l)
+}
+
+// Rotate - v rotated by the quaternion
+func (q Quat) Rotate(v Vec3) Vec3 {
+	// v + 2w (u x v) + 2 u x (u x v), u the vector part
+	u := Vec3{q.X, q.Y, q.Z}
+	t := u.Cross(&v).MulS(2)
+	return *v.Add(t.MulS(q.W)).Add(u.Cross(t))
+}
+
+// Mat4 - the rotation as a matrix
+func (q Quat) Mat4() *Mat4 {
+	x, y, z, w := q.X, q.Y, q.Z, q.W
+	m := IdentMat4()
+	m[0] = Vec4{1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0}
+	m[1] = Vec4{2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0}
+	m[2] = Vec4{2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0}
+	return m
+}
+
+// Nlerp - normalised linear interpolation along the shorter way round.
+// Quicker than Slerp but doesn't turn at an even speed.
+func (q Quat) Nlerp(p Quat, t gl.Float) Quat {
+	if q.Dot(p) < 0 {
+		p = p.Scale(-1)
+	}
+	return q.Scale(1 - t).Add(p.Scale(t)).Normalize()
+}
+
+// Slerp - spherical linear interpolation along the shorter way round
+func (q Quat) Slerp(p Quat, t gl.Float) Quat {
+	d := float64(q.Dot(p))
+	if d < 0 {
+		p, d = p.Scale(-1), -d
+	}
+	if d > 0.9995 {
+		return q.Nlerp(p, t)
+	}
+	theta := math.Acos(d)
+	s := math.Sin(theta)
+	a := math.Sin((1-float64(t))*theta) / s
+	b := math.Sin(float64(t)*theta) / s
+	return q.Scale(gl.Float(a)).Add(p.Scale(gl.Float(b))).Normalize()
+}
+
+// DualQuat - a rigid transform, rotation Real and translation encoded in
+// Dual
+type DualQuat struct {
+	Real, Dual Quat
+}
+
+// NewDualQuat - rotation r followed by translation t
+func NewDualQuat(r Quat, t Vec3) DualQuat {
+	return DualQuat{r, Quat{t.X, t.Y, t.Z, 0}.Mul(r).Scale(0.5)}
+}
+
+// DualQuatFromMat4 - the rigid transform of a matrix, which should have
+// no scale or shear
+func DualQuatFromMat4(m *Mat4) DualQuat {
+	return NewDualQuat(QuatFromMat4(m), Vec3{m[3].X, m[3].Y, m[3].Z})
+}
+
+// Translation - the translation part
+func (d DualQuat) Translation() Vec3 {
+	t := d.Dual.Scale(2).Mul(d.Real.Conjugate())
+	return Vec3{t.X, t.Y, t.Z}
+}
+
+// Normalize - scales both parts so the rotation is a unit quaternion,
+// which is what makes a blend of dual quaternions a rigid transform again
+func (d DualQuat) Normalize() DualQuat {
+	l := d.Real.Length()
+	if l == 0 {
+		return DualQuat{IdentQuat(), Quat{}}
+	}
+	r, du := d.Real.Scale(1/l), d.Dual.Scale(1/l)
+	// Remove the part of the dual along the real, so the two stay at
+	// right angles
+	return DualQuat{r, du.Add(r.Scale(-r.Dot(du)))}
+}
+
+// TransformPoint - p rotated then translated
+func (d DualQuat) TransformPoint(p Vec3) Vec3 {
+	r, t := d.Real.Rotate(p), d.Translation()
+	return *r.Add(&t)
+}
+
+// Mat4 - the transform as a matrix
+func (d DualQuat) Mat4() *Mat4 {
+	m := d.Real.Mat4()
+	t := d.Translation()
+	m[3] = Vec4{t.X, t.Y, t.Z, 1}
+	return m
+}
@@ -0,0 +1,64 @@
+package goglutils
+
+import (
+	"testing"
+)
+
+func TestQuatRotation(t *testing.T) {
+	q := QuatFromAxisAngle(Vec3{0, 0, 1}, 90)
+	if r := q.Rotate(Vec3{1, 0, 0}); !closeVec3(r, Vec3{0, 1, 0}) {
+		t.Errorf("90 degrees about Z takes X to %v", r)
+	}
+	p := Vec3{0.3, -2, 5}
+	want := RotateZ(90).MulPoint(&p)
+	if got := q.Mat4().MulPoint(&p); !closeVec3(*got, *want) {
+		t.Errorf("Quat matrix gives %v, RotateZ gives %v", *got, *want)
+	}
+
+	// Round trip through a matrix, from each branch of QuatFromMat4
+	for _, q := range []Quat{
+		QuatFromAxisAngle(Vec3{1, 2, 3}, 40),
+		QuatFromAxisAngle(Vec3{1, 0, 0}, 170),
+		QuatFromAxisAngle(Vec3{0, 1, 0}, 170),
+		QuatFromAxisAngle(Vec3{0, 0, 1}, 170),
+	} {
+		back := QuatFromMat4(q.Mat4())
+		if d := back.Dot(q); d < 0.99999 && d > -0.99999 {
+			t.Errorf("QuatFromMat4 gives %v back for %v", back, q)
+		}
+	}
+
+	// q * q^-1 is no rotation, and q.Mul(p) rotates by p first
+	if id := q.Mul(q.Conjugate()); id.Dot(IdentQuat()) < 0.99999 {
+		t.Errorf("q q* is %v", id)
+	}
+	qx := QuatFromAxisAngle(Vec3{1, 0, 0}, 90)
+	if r := q.Mul(qx).Rotate(Vec3{0, 1, 0}); !closeVec3(r, q.Rotate(qx.Rotate(Vec3{0, 1, 0}))) {
+		t.Errorf("Composed rotation gives %v", r)
+	}
+}
+
+func TestQuatSlerp(t *testing.T) {
+	a, b := IdentQuat(), QuatFromAxisAngle(Vec3{0, 1, 0}, 120)
+	half := a.Slerp(b, 0.5)
+	if want := QuatFromAxisAngle(Vec3{0, 1, 0}, 60); half.Dot(want) < 0.99999 {
+		t.Errorf("Halfway slerp is %v, want %v", half, want)
+	}
+	// The short way round, even with b's sign flipped
+	if s := a.Slerp(b.Scale(-1), 0.5); s.Dot(half) < 0.99999 && s.Dot(half) > -0.99999 {
+		t.Errorf("Slerp to -b goes the long way, %v", s)
+	}
+}
+
+func TestDualQuat(t *testing.T) {
+	m := RotateY(30).MulM(RotateX(-70))
+	m[3] = Vec4{1, -2, 3, 1}
+	dq := DualQuatFromMat4(m)
+	p := Vec3{0.5, 1.5, -4}
+	if got, want := dq.TransformPoint(p), m.MulPoint(&p); !closeVec3(got, *want) {
+		t.Errorf("Dual quaternion moves point to %v, matrix to %v", got, *want)
+	}
+	if tr := dq.Translation(); !closeVec3(tr, Vec3{1, -2, 3}) {
+		t.Errorf("Translation is %v", tr)
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"bytes"
 	"fmt"
 	gl "github.com/chsc/gogl/gl33"
+	gl43 "github.com/chsc/gogl/gl43"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Reads a file and returns its contents as a single string.
@@ -45,12 +47,73 @@ func ReadSourceFile(filename string) (string, error) {
 
 }
 
+// InjectDefines - insert a "#define <define>" line for each entry in
+// defines into GLSL source.  The defines go straight after the #version
+// directive (which must stay first, though comments may come before it),
+// followed by a #line directive so compiler errors still point at the
+// right line of the original file.
+func InjectDefines(source string, defines []string) string {
+	var buffer bytes.Buffer
+	for _, define := range defines {
+		buffer.WriteString("#define " + define + "\n")
+	}
+
+	// Find the #version line, if there is one, skipping any comments
+	// (a licence block, say) in front of it
+	lines := strings.SplitAfter(source, "\n")
+	inComment := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		for {
+			if inComment {
+				end := strings.Index(trimmed, "*/")
+				if end < 0 {
+					trimmed = ""
+					break
+				}
+				trimmed = strings.TrimSpace(trimmed[end+2:])
+				inComment = false
+			}
+			if !strings.HasPrefix(trimmed, "/*") {
+				break
+			}
+			trimmed = trimmed[2:]
+			inComment = true
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#version") {
+			if !strings.HasSuffix(line, "\n") {
+				lines[i] = line + "\n"
+			}
+			fmt.Fprintf(&buffer, "#line %d\n", i+2)
+			return strings.Join(lines[:i+1], "") + buffer.String() + strings.Join(lines[i+1:], "")
+		}
+		break
+	}
+	buffer.WriteString("#line 1\n")
+	return buffer.String() + source
+}
+
 // Create and Compile a shader, and return its shader Id.  shaderType
-// should be one of gl.VERTEX_SHADER, gl.FRAGMENT_SHADER or gl.GEOMETRY_SHADER
+// should be one of gl.VERTEX_SHADER, gl.FRAGMENT_SHADER, gl.GEOMETRY_SHADER,
+// or, on a GL 4.x context, TessControlShader, TessEvaluationShader or
+// ComputeShader.
 func CreateShader(shaderType gl.Enum, filePath string) gl.Uint {
+	return CreateShaderDefines(shaderType, filePath, nil)
+}
+
+// CreateShaderDefines - like CreateShader, but the given #define lines
+// (e.g. "SKINNED 1", "MAX_LIGHTS 4") are injected into the source before
+// it is compiled.  See InjectDefines.
+func CreateShaderDefines(shaderType gl.Enum, filePath string, defines []string) gl.Uint {
 
 	// Start by creating the shader object
-	if (shaderType != gl.VERTEX_SHADER) && (shaderType != gl.FRAGMENT_SHADER) && (shaderType != gl.GEOMETRY_SHADER) {
+	switch shaderType {
+	case gl.VERTEX_SHADER, gl.FRAGMENT_SHADER, gl.GEOMETRY_SHADER:
+	case TessControlShader, TessEvaluationShader, ComputeShader:
+	default:
 		fmt.Fprintf(os.Stderr, "ERROR: not a supported shader type passed to CreateShader\n")
 		return 0
 	}
@@ -62,6 +125,9 @@ func CreateShader(shaderType gl.Enum, filePath string) gl.Uint {
 		fmt.Fprintf(os.Stderr, "ERROR: Could not read file %s\n", filePath)
 		return 0
 	}
+	if len(defines) > 0 {
+		shaderCode = InjectDefines(shaderCode, defines)
+	}
 
 	// Compile the shader
 	var result gl.Int = gl.TRUE
@@ -89,6 +155,33 @@ func CreateShader(shaderType gl.Enum, filePath string) gl.Uint {
 	return shaderId
 }
 
+// ShaderTypeFromFile - work out what kind of shader a file holds from its
+// extension.  Returns 0 if the extension isn't one we know about.
+//
+// Vertex Shaders: .vert, .vertexshader, .vertex, .vs
+// Fragment Shaders: .frag, .fragmentshader, .fragment, .fs
+// Geometry Shaders: .geom, .geometryshader, .geometry, .gs
+// Tessellation Control Shaders: .tesc, .tesscontrolshader, .tesscontrol, .tcs
+// Tessellation Evaluation Shaders: .tese, .tessevaluationshader, .tessevaluation, .tes
+// Compute Shaders: .comp, .computeshader, .compute, .cs
+func ShaderTypeFromFile(filePath string) gl.Enum {
+	switch filepath.Ext(filePath) {
+	case ".vertexshader", ".vert", ".vertex", ".vs":
+		return gl.VERTEX_SHADER
+	case ".fragmentshader", ".frag", ".fragment", ".fs":
+		return gl.FRAGMENT_SHADER
+	case ".geometryshader", ".geom", ".geometry", ".gs":
+		return gl.GEOMETRY_SHADER
+	case ".tesscontrolshader", ".tesc", ".tesscontrol", ".tcs":
+		return TessControlShader
+	case ".tessevaluationshader", ".tese", ".tessevaluation", ".tes":
+		return TessEvaluationShader
+	case ".computeshader", ".comp", ".compute", ".cs":
+		return ComputeShader
+	}
+	return 0
+}
+
 // CreateShaderProgram - create a shader program and attach the various shader objects
 // defined by the files in the slice, then return the programID.  If the program
 // cannot be created, 0 is returned instead.  Note that we don't exit if we cannot
@@ -96,13 +189,22 @@ func CreateShader(shaderType gl.Enum, filePath string) gl.Uint {
 // 
 // shaderFiles should contain a list of relative or absolute filenames of GLSL
 // shaders to compile - we determine what kind of shader each is by its extension
-// for this reason, filenames passed to CreateShaderProgram should have one of the
-// following extensions based on the type of shader it is:
-//
-// Vertex Shaders: .vert, .vertexshader, .vertex, .vs
-// Fragment Shaders: .frag, .fragmentshader, .fragment, .fs
-// Geometry Shaders: .geom, .geometryshader, .geometry, .gs
+// (see ShaderTypeFromFile for the list of extensions we understand).
 func CreateShaderProgram(shaderFiles []string) gl.Uint {
+	return linkShaderProgram(shaderFiles, nil, false, false)
+}
+
+// CreateShaderProgramDefines - like CreateShaderProgram, but every shader
+// in the program is compiled with the given #define lines injected.
+func CreateShaderProgramDefines(shaderFiles []string, defines []string) gl.Uint {
+	return linkShaderProgram(shaderFiles, defines, false, false)
+}
+
+// Does the actual work for CreateShaderProgram and friends.  If separable
+// is set, the program is flagged with GL_PROGRAM_SEPARABLE before linking
+// so it can be bound to a ProgramPipeline.  If retrievable is set, the
+// driver is told we'll want the program binary back (see programcache.go).
+func linkShaderProgram(shaderFiles []string, defines []string, separable, retrievable bool) gl.Uint {
 
 	// Create the Program object
 	var ProgramID gl.Uint = gl.CreateProgram()
@@ -110,27 +212,28 @@ func CreateShaderProgram(shaderFiles []string) gl.Uint {
 		fmt.Fprintf(os.Stderr, "ERROR: Cannot create shader program!")
 		return 0
 	}
+	if separable {
+		gl43.ProgramParameteri(gl43.Uint(ProgramID), gl43.PROGRAM_SEPARABLE, gl43.TRUE)
+	}
+	if retrievable {
+		gl43.ProgramParameteri(gl43.Uint(ProgramID), gl43.PROGRAM_BINARY_RETRIEVABLE_HINT, gl43.TRUE)
+	}
 
 	// For each attached shader, figure out its extension, and load a shader of
 	// that type.
 	var sid gl.Uint = 0
 	for _, shader := range shaderFiles {
 		sid = 0
-		switch extension := filepath.Ext(shader); extension {
-		case ".vertexshader", ".vert", ".vertex", ".vs":
-			sid = CreateShader(gl.VERTEX_SHADER, shader)
-
-		case ".fragmentshader", ".frag", ".fragment", ".fs":
-			sid = CreateShader(gl.FRAGMENT_SHADER, shader)
-
-		case ".geometryshader", ".geom", ".geometry", ".gs":
-			sid = CreateShader(gl.GEOMETRY_SHADER, shader)
-
-		default:
-			fmt.Fprintf(os.Stderr, "ERROR: Don't understand extension %s\n", extension)
+		if shaderType := ShaderTypeFromFile(shader); shaderType != 0 {
+			sid = CreateShaderDefines(shaderType, shader, defines)
+		} else {
+			fmt.Fprintf(os.Stderr, "ERROR: Don't understand extension %s\n", filepath.Ext(shader))
 			fmt.Fprintf(os.Stderr, "Accepted extensions: .fragmentshader/.frag/.fragment/.fs for fragment shaders\n")
-			fmt.Fprintf(os.Stderr, ".vertexshader/.vert/.vertex/.vs for vertex shaders, and\n")
-			fmt.Fprintf(os.Stderr, ".geometryshader/.geom/.geometry/.gs for geometry shaders.\n")
+			fmt.Fprintf(os.Stderr, ".vertexshader/.vert/.vertex/.vs for vertex shaders,\n")
+			fmt.Fprintf(os.Stderr, ".geometryshader/.geom/.geometry/.gs for geometry shaders,\n")
+			fmt.Fprintf(os.Stderr, ".tesscontrolshader/.tesc/.tesscontrol/.tcs for tessellation control shaders,\n")
+			fmt.Fprintf(os.Stderr, ".tessevaluationshader/.tese/.tessevaluation/.tes for tessellation evaluation shaders, and\n")
+			fmt.Fprintf(os.Stderr, ".computeshader/.comp/.compute/.cs for compute shaders.\n")
 		}
 		if sid != 0 {
 			gl.AttachShader(ProgramID, sid)
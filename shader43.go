@@ -0,0 +1,205 @@
+/* GL 4.x shader stages
+
+Tessellation and compute shaders, separable programs and program
+pipeline objects.  None of this exists in gl33, so these routines go
+through the gl43 binding - you'll need a 4.3 context and to have called
+gl43.Init() alongside gl33.Init() before using anything in here.
+
+Handles are passed around as gl33 types so they mix freely with the rest
+of the package; both bindings use the same underlying GL names.
+*/
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	gl43 "github.com/chsc/gogl/gl43"
+)
+
+// Shader types gl33 doesn't know about.  Pass these to CreateShader.
+const (
+	TessControlShader    gl.Enum = gl43.TESS_CONTROL_SHADER
+	TessEvaluationShader gl.Enum = gl43.TESS_EVALUATION_SHADER
+	ComputeShader        gl.Enum = gl43.COMPUTE_SHADER
+)
+
+// Primitive type to draw tessellated geometry with
+const Patches gl.Enum = gl43.PATCHES
+
+// Stage bits for ProgramPipeline.UseProgramStages
+const (
+	VertexShaderBit         gl.Bitfield = gl43.VERTEX_SHADER_BIT
+	FragmentShaderBit       gl.Bitfield = gl43.FRAGMENT_SHADER_BIT
+	GeometryShaderBit       gl.Bitfield = gl43.GEOMETRY_SHADER_BIT
+	TessControlShaderBit    gl.Bitfield = gl43.TESS_CONTROL_SHADER_BIT
+	TessEvaluationShaderBit gl.Bitfield = gl43.TESS_EVALUATION_SHADER_BIT
+	ComputeShaderBit        gl.Bitfield = gl43.COMPUTE_SHADER_BIT
+	AllShaderBits           gl.Bitfield = gl43.ALL_SHADER_BITS
+)
+
+// ShaderStageBit - the pipeline stage bit for a shader type, or 0 if
+// the type isn't a shader type.
+func ShaderStageBit(shaderType gl.Enum) gl.Bitfield {
+	switch shaderType {
+	case gl.VERTEX_SHADER:
+		return VertexShaderBit
+	case gl.FRAGMENT_SHADER:
+		return FragmentShaderBit
+	case gl.GEOMETRY_SHADER:
+		return GeometryShaderBit
+	case TessControlShader:
+		return TessControlShaderBit
+	case TessEvaluationShader:
+		return TessEvaluationShaderBit
+	case ComputeShader:
+		return ComputeShaderBit
+	}
+	return 0
+}
+
+// ShaderStageBits - the combined stage bits for a list of shader files,
+// worked out from their extensions.  Handy for handing a separable program
+// to ProgramPipeline.UseProgramStages.
+func ShaderStageBits(shaderFiles []string) gl.Bitfield {
+	var bits gl.Bitfield
+	for _, shader := range shaderFiles {
+		bits |= ShaderStageBit(ShaderTypeFromFile(shader))
+	}
+	return bits
+}
+
+// Sets the number of vertices per patch for tessellated draws.
+func SetPatchVertices(n gl.Int) {
+	gl43.PatchParameteri(gl43.PATCH_VERTICES, gl43.Int(n))
+}
+
+// ************************************ //
+// *          Compute shaders         * //
+// ************************************ //
+
+// Returns the local work group size declared by a linked compute program
+// (its layout(local_size_x = ...) qualifier).
+func ComputeWorkGroupSize(program gl.Uint) [3]gl.Int {
+	var size [3]gl43.Int
+	gl43.GetProgramiv(gl43.Uint(program), gl43.COMPUTE_WORK_GROUP_SIZE, &size[0])
+	return [3]gl.Int{gl.Int(size[0]), gl.Int(size[1]), gl.Int(size[2])}
+}
+
+// Launches the currently bound compute program over the given number of
+// work groups.
+func DispatchCompute(groupsX, groupsY, groupsZ gl.Uint) {
+	gl43.DispatchCompute(gl43.Uint(groupsX), gl43.Uint(groupsY), gl43.Uint(groupsZ))
+}
+
+// DispatchComputeFor - binds program and launches enough work groups to
+// cover nx * ny * nz invocations, using the program's own work group size.
+// Returns the number of groups dispatched in each dimension.
+func DispatchComputeFor(program gl.Uint, nx, ny, nz gl.Uint) [3]gl.Uint {
+	size := ComputeWorkGroupSize(program)
+	var groups [3]gl.Uint
+	for i, n := range []gl.Uint{nx, ny, nz} {
+		local := gl.Uint(size[i])
+		if local == 0 {
+			local = 1
+		}
+		groups[i] = (n + local - 1) / local
+	}
+	gl.UseProgram(program)
+	DispatchCompute(groups[0], groups[1], groups[2])
+	return groups
+}
+
+// Waits for compute writes to be visible to the operations given in
+// barriers, e.g. gl43.SHADER_STORAGE_BARRIER_BIT before drawing from a
+// buffer a compute shader just filled.
+func MemoryBarrier(barriers gl.Bitfield) {
+	gl43.MemoryBarrier(gl43.Bitfield(barriers))
+}
+
+// ************************************ //
+// *   Separable programs/pipelines   * //
+// ************************************ //
+
+// CreateSeparableProgram - like CreateShaderProgram, but the program is
+// linked with GL_PROGRAM_SEPARABLE set so its stages can be mixed and
+// matched with other separable programs in a ProgramPipeline.
+func CreateSeparableProgram(shaderFiles []string) gl.Uint {
+	return linkShaderProgram(shaderFiles, nil, true, false)
+}
+
+// ProgramPipeline - wraps a GL program pipeline object
+type ProgramPipeline struct {
+	id gl.Uint
+}
+
+// Creates a new, empty program pipeline
+func NewProgramPipeline() *ProgramPipeline {
+	p := new(ProgramPipeline)
+	var id gl43.Uint
+	gl43.GenProgramPipelines(1, &id)
+	p.id = gl.Uint(id)
+	return p
+}
+
+// The pipeline's GL name
+func (p *ProgramPipeline) Id() gl.Uint {
+	return p.id
+}
+
+// Use the given stages of a separable program in this pipeline
+func (p *ProgramPipeline) UseProgramStages(stages gl.Bitfield, program gl.Uint) {
+	gl43.UseProgramStages(gl43.Uint(p.id), gl43.Bitfield(stages), gl43.Uint(program))
+}
+
+// Use every stage of a separable program built from shaderFiles.  The stages
+// are worked out from the file extensions.
+func (p *ProgramPipeline) AttachProgram(program gl.Uint, shaderFiles []string) {
+	p.UseProgramStages(ShaderStageBits(shaderFiles), program)
+}
+
+// Sets which program in the pipeline glUniform* calls go to
+func (p *ProgramPipeline) SetActiveProgram(program gl.Uint) {
+	gl43.ActiveShaderProgram(gl43.Uint(p.id), gl43.Uint(program))
+}
+
+// Binds the pipeline.  Note that a program bound with glUseProgram takes
+// precedence, so this unbinds any current program first.
+func (p *ProgramPipeline) Bind() {
+	gl.UseProgram(0)
+	gl43.BindProgramPipeline(gl43.Uint(p.id))
+}
+
+// Unbinds any program pipeline
+func (p *ProgramPipeline) Unbind() {
+	gl43.BindProgramPipeline(0)
+}
+
+// Checks the pipeline's stages can run together with the current GL state,
+// returning the info log as an error if they can't.
+func (p *ProgramPipeline) Validate() error {
+	var result, infoLogLength gl43.Int
+	gl43.ValidateProgramPipeline(gl43.Uint(p.id))
+	gl43.GetProgramPipelineiv(gl43.Uint(p.id), gl43.VALIDATE_STATUS, &result)
+	if result == gl43.TRUE {
+		return nil
+	}
+	gl43.GetProgramPipelineiv(gl43.Uint(p.id), gl43.INFO_LOG_LENGTH, &infoLogLength)
+	if infoLogLength > 0 {
+		errorMsg := gl43.GLStringAlloc(gl43.Sizei(infoLogLength))
+		defer gl43.GLStringFree(errorMsg)
+		gl43.GetProgramPipelineInfoLog(gl43.Uint(p.id), gl43.Sizei(infoLogLength), nil, errorMsg)
+		return errors.New(fmt.Sprintf("ProgramPipeline:Validate: %s", gl43.GoString(errorMsg)))
+	}
+	return errors.New("ProgramPipeline:Validate: Pipeline failed validation")
+}
+
+// Deletes the pipeline.  The programs in it are left alone.
+func (p *ProgramPipeline) Delete() {
+	if p.id != 0 {
+		id := gl43.Uint(p.id)
+		gl43.DeleteProgramPipelines(1, &id)
+		p.id = 0
+	}
+}
@@ -0,0 +1,74 @@
+package goglutils
+
+import (
+	gl "github.com/chsc/gogl/gl33"
+	"testing"
+)
+
+func TestShaderTypeFromFile(t *testing.T) {
+	tests := []struct {
+		file  string
+		stage gl.Enum
+		bit   gl.Bitfield
+	}{
+		{"shaders/basic.vert", gl.VERTEX_SHADER, VertexShaderBit},
+		{"basic.fragmentshader", gl.FRAGMENT_SHADER, FragmentShaderBit},
+		{"fur.gs", gl.GEOMETRY_SHADER, GeometryShaderBit},
+		{"terrain.tesc", TessControlShader, TessControlShaderBit},
+		{"terrain.tcs", TessControlShader, TessControlShaderBit},
+		{"terrain.tese", TessEvaluationShader, TessEvaluationShaderBit},
+		{"terrain.tessevaluation", TessEvaluationShader, TessEvaluationShaderBit},
+		{"particles.comp", ComputeShader, ComputeShaderBit},
+		{"particles.cs", ComputeShader, ComputeShaderBit},
+		{"readme.txt", 0, 0},
+		{"noextension", 0, 0},
+	}
+	for _, test := range tests {
+		stage := ShaderTypeFromFile(test.file)
+		if stage != test.stage {
+			t.Errorf("ShaderTypeFromFile(%s) yields %#x, want %#x", test.file, stage, test.stage)
+		}
+		if bit := ShaderStageBit(stage); bit != test.bit {
+			t.Errorf("ShaderStageBit for %s yields %#x, want %#x", test.file, bit, test.bit)
+		}
+	}
+}
+
+func TestShaderStageBits(t *testing.T) {
+	bits := ShaderStageBits([]string{"a.vert", "a.tesc", "a.tese", "a.frag"})
+	want := VertexShaderBit | TessControlShaderBit | TessEvaluationShaderBit | FragmentShaderBit
+	if bits != want {
+		t.Errorf("ShaderStageBits yields %#x, want %#x", bits, want)
+	}
+	if bits := ShaderStageBits([]string{"a.comp", "notes.txt"}); bits != ComputeShaderBit {
+		t.Errorf("ShaderStageBits should ignore unknown extensions, yields %#x", bits)
+	}
+}
+
+func TestInjectDefines(t *testing.T) {
+	defines := []string{"SKINNED 1", "MAX_LIGHTS 4"}
+	injected := "#define SKINNED 1\n#define MAX_LIGHTS 4\n"
+	tests := []struct {
+		name, source, want string
+	}{
+		{"no version", "void main() {}\n",
+			injected + "#line 1\nvoid main() {}\n"},
+		{"version first", "#version 330\nvoid main() {}\n",
+			"#version 330\n" + injected + "#line 2\nvoid main() {}\n"},
+		{"line comments", "// basic.vert\n\n#version 330 core\nvoid main() {}\n",
+			"// basic.vert\n\n#version 330 core\n" + injected + "#line 4\nvoid main() {}\n"},
+		{"licence block", "/* Copyright\n * someone\n */\n#version 430\nvoid main() {}\n",
+			"/* Copyright\n * someone\n */\n#version 430\n" + injected + "#line 5\nvoid main() {}\n"},
+		{"one line block", "/* licence */ /* more */\n#version 430\n",
+			"/* licence */ /* more */\n#version 430\n" + injected + "#line 3\n"},
+		{"version without newline", "#version 330",
+			"#version 330\n" + injected + "#line 2\n"},
+		{"version commented out", "/*\n#version 330\n*/\nvoid main() {}\n",
+			injected + "#line 1\n/*\n#version 330\n*/\nvoid main() {}\n"},
+	}
+	for _, test := range tests {
+		if got := InjectDefines(test.source, defines); got != test.want {
+			t.Errorf("%s: InjectDefines yields %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/* Shader library
+
+A ShaderLibrary holds a single set of shader files (typically an
+uber-shader) along with the keywords it can be specialised with.  Each
+distinct combination of keywords is a variant; variants are compiled with
+CreateShaderProgramDefines the first time they are asked for and cached
+by a hash of their keywords, so only the combinations actually used get
+built.
+
+	lib := NewShaderLibrary([]string{"uber.vert", "uber.frag"})
+	lib.DeclareFlag("SKINNED")
+	lib.DeclareFlag("NORMAL_MAP")
+	lib.DeclareInt("MAX_LIGHTS", 4)
+	program, err := lib.Program(ShaderKeywords{"SKINNED": 1})
+*/
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ShaderKeywords - keyword values for a variant.  Flags are on when
+// non-zero; int keywords take the value as-is.  Keywords that are left
+// out take their declared default.
+type ShaderKeywords map[string]int
+
+type shaderKeyword struct {
+	flag bool
+	def  int
+}
+
+type ShaderLibrary struct {
+	files    []string
+	keywords map[string]shaderKeyword
+	programs map[uint64]gl.Uint
+	cache    *ProgramBinaryCache
+}
+
+// Creates a library for the given shader files.  No programs are built
+// until they are asked for.
+func NewShaderLibrary(shaderFiles []string) *ShaderLibrary {
+	l := new(ShaderLibrary)
+	l.files = make([]string, len(shaderFiles))
+	copy(l.files, shaderFiles)
+	l.keywords = make(map[string]shaderKeyword)
+	l.programs = make(map[uint64]gl.Uint)
+	return l
+}
+
+// Declare an on/off keyword.  When on, the variant is compiled with
+// "#define NAME 1"; when off, NAME is left undefined.  Flags default to off.
+func (l *ShaderLibrary) DeclareFlag(name string) {
+	l.keywords[name] = shaderKeyword{flag: true}
+}
+
+// Declare an integer keyword.  Every variant is compiled with
+// "#define NAME <value>", using def if the variant doesn't set it.
+func (l *ShaderLibrary) DeclareInt(name string, def int) {
+	l.keywords[name] = shaderKeyword{def: def}
+}
+
+// Route variant builds through a program binary cache.  Pass nil to go
+// back to compiling from source every time.
+func (l *ShaderLibrary) SetBinaryCache(cache *ProgramBinaryCache) {
+	l.cache = cache
+}
+
+// Defines - the #define lines a variant is compiled with, sorted by
+// keyword name.  Returns an error if kw sets a keyword that was never
+// declared.
+func (l *ShaderLibrary) Defines(kw ShaderKeywords) ([]string, error) {
+	for name := range kw {
+		if _, ok := l.keywords[name]; !ok {
+			return nil, errors.New(fmt.Sprintf("ShaderLibrary:Defines: Undeclared keyword %s", name))
+		}
+	}
+	names := make([]string, 0, len(l.keywords))
+	for name := range l.keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defines := make([]string, 0, len(names))
+	for _, name := range names {
+		k := l.keywords[name]
+		value, set := kw[name]
+		if !set {
+			value = k.def
+		}
+		if k.flag {
+			if value != 0 {
+				defines = append(defines, name+" 1")
+			}
+		} else {
+			defines = append(defines, name+" "+strconv.Itoa(value))
+		}
+	}
+	return defines, nil
+}
+
+// VariantHash - the key a variant is cached under.  Two keyword sets
+// that produce the same defines hash the same.
+func (l *ShaderLibrary) VariantHash(kw ShaderKeywords) (uint64, error) {
+	defines, err := l.Defines(kw)
+	if err != nil {
+		return 0, err
+	}
+	return hashDefines(defines), nil
+}
+
+func hashDefines(defines []string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(defines, "\n")))
+	return h.Sum64()
+}
+
+// Program - returns the program for a variant, compiling and linking it
+// if this is the first time it has been asked for.  A variant that fails
+// to build is remembered, so it isn't retried on every call.
+func (l *ShaderLibrary) Program(kw ShaderKeywords) (gl.Uint, error) {
+	defines, err := l.Defines(kw)
+	if err != nil {
+		return 0, err
+	}
+	key := hashDefines(defines)
+	program, ok := l.programs[key]
+	if !ok {
+		if l.cache != nil {
+			program = CreateCachedShaderProgram(l.cache, l.files, defines)
+		} else {
+			program = CreateShaderProgramDefines(l.files, defines)
+		}
+		l.programs[key] = program
+	}
+	if program == 0 {
+		return 0, errors.New(fmt.Sprintf("ShaderLibrary:Program: Could not build variant [%s]", strings.Join(defines, ", ")))
+	}
+	return program, nil
+}
+
+// Warm - builds the given variants up front, so the first frame that
+// uses them doesn't stall.  Every variant is attempted; the first error
+// is returned.
+func (l *ShaderLibrary) Warm(variants []ShaderKeywords) error {
+	var firstErr error
+	for _, kw := range variants {
+		if _, err := l.Program(kw); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Number of variants built (or attempted) so far
+func (l *ShaderLibrary) Len() int {
+	return len(l.programs)
+}
+
+// Deletes every program the library has built and empties the cache.
+func (l *ShaderLibrary) Delete() {
+	for key, program := range l.programs {
+		if program != 0 {
+			gl.DeleteProgram(program)
+		}
+		delete(l.programs, key)
+	}
+}
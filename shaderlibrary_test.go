@@ -0,0 +1,68 @@
+package goglutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testShaderLibrary() *ShaderLibrary {
+	lib := NewShaderLibrary([]string{"uber.vert", "uber.frag"})
+	lib.DeclareFlag("SKINNED")
+	lib.DeclareFlag("NORMAL_MAP")
+	lib.DeclareInt("MAX_LIGHTS", 4)
+	return lib
+}
+
+func TestShaderLibraryDefines(t *testing.T) {
+	lib := testShaderLibrary()
+	tests := []struct {
+		kw   ShaderKeywords
+		want []string
+	}{
+		{nil, []string{"MAX_LIGHTS 4"}},
+		{ShaderKeywords{"SKINNED": 1}, []string{"MAX_LIGHTS 4", "SKINNED 1"}},
+		{ShaderKeywords{"SKINNED": 0, "NORMAL_MAP": 7}, []string{"MAX_LIGHTS 4", "NORMAL_MAP 1"}},
+		{ShaderKeywords{"MAX_LIGHTS": 0}, []string{"MAX_LIGHTS 0"}},
+	}
+	for _, test := range tests {
+		defines, err := lib.Defines(test.kw)
+		if err != nil {
+			t.Errorf("Defines(%v) fails: %v", test.kw, err)
+			continue
+		}
+		if !reflect.DeepEqual(defines, test.want) {
+			t.Errorf("Defines(%v) yields %v, want %v", test.kw, defines, test.want)
+		}
+	}
+	if _, err := lib.Defines(ShaderKeywords{"SHADOWS": 1}); err == nil {
+		t.Errorf("Defines with an undeclared keyword should fail")
+	}
+}
+
+func TestShaderLibraryVariantHash(t *testing.T) {
+	lib := testShaderLibrary()
+	tests := []struct {
+		a, b ShaderKeywords
+		same bool
+	}{
+		{nil, ShaderKeywords{"MAX_LIGHTS": 4}, true},
+		{ShaderKeywords{"SKINNED": 0}, nil, true},
+		{ShaderKeywords{"SKINNED": 1}, ShaderKeywords{"SKINNED": 2}, true},
+		{ShaderKeywords{"SKINNED": 1}, nil, false},
+		{ShaderKeywords{"SKINNED": 1}, ShaderKeywords{"NORMAL_MAP": 1}, false},
+		{ShaderKeywords{"MAX_LIGHTS": 2}, ShaderKeywords{"MAX_LIGHTS": 8}, false},
+	}
+	for _, test := range tests {
+		ha, err := lib.VariantHash(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hb, _ := lib.VariantHash(test.b)
+		if (ha == hb) != test.same {
+			t.Errorf("VariantHash(%v) == VariantHash(%v) is %v, want %v", test.a, test.b, ha == hb, test.same)
+		}
+	}
+	if _, err := lib.VariantHash(ShaderKeywords{"SHADOWS": 1}); err == nil {
+		t.Errorf("VariantHash with an undeclared keyword should fail")
+	}
+}
@@ -0,0 +1,270 @@
+// skeleton - joint hierarchies and CPU skinning
+//
+// A Skeleton is a list of joints, each with a parent earlier in the list,
+// a pose relative to its parent and the inverse bind matrix taking mesh
+// space into the joint's space at bind time.  Skin() deforms a mesh's
+// positions and normals by the joints named in its AttribJoints
+// attribute, weighted by AttribWeights, into target attributes - by
+// linear blending of the joint matrices or by blending them as dual
+// quaternions, which keeps volume at twisting joints.  Nothing here
+// touches the GL, so it does for server-side hit testing as well.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// Joint - one joint of a skeleton
+type Joint struct {
+	Name        string
+	Parent      int  // Number of the parent joint, or -1 for a root
+	Pose        Mat4 // Transform relative to the parent
+	InverseBind Mat4 // Mesh space to joint space in the bind pose
+}
+
+// Skeleton - a joint hierarchy, parents before children
+type Skeleton struct {
+	Joints []Joint
+	names  map[string]int
+}
+
+// SkinningMethod - how the joints a vertex follows are blended
+type SkinningMethod int
+
+const (
+	// Blend the joint matrices.  Handles scaling, but joints that twist
+	// pinch the mesh.
+	LinearBlendSkinning SkinningMethod = iota
+	// Blend the joints as dual quaternions.  Keeps volume, but joints
+	// can't scale.
+	DualQuatSkinning
+)
+
+// NewSkeleton - an empty skeleton
+func NewSkeleton() *Skeleton {
+	return &Skeleton{names: make(map[string]int)}
+}
+
+// AddJoint - adds a joint under parent (-1 for a root) and returns its
+// number.  A nil inverseBind is filled in from the pose the skeleton is
+// in by ComputeInverseBinds() or left as the identity.
+func (s *Skeleton) AddJoint(name string, parent int, pose, inverseBind *Mat4) (int, error) {
+	if parent < -1 || parent >= len(s.Joints) {
+		return -1, errors.New(fmt.Sprintf("Skeleton:AddJoint: Joint %s has parent %d of %d joints", name, parent, len(s.Joints)))
+	}
+	if _, ok := s.names[name]; ok && name != "" {
+		return -1, errors.New(fmt.Sprintf("Skeleton:AddJoint: Already have a joint called %s", name))
+	}
+	j := Joint{Name: name, Parent: parent, Pose: *IdentMat4(), InverseBind: *IdentMat4()}
+	if pose != nil {
+		j.Pose = *pose
+	}
+	if inverseBind != nil {
+		j.InverseBind = *inverseBind
+	}
+	s.Joints = append(s.Joints, j)
+	if name != "" {
+		s.names[name] = len(s.Joints) - 1
+	}
+	return len(s.Joints) - 1, nil
+}
+
+// NumJoints - number of joints
+func (s *Skeleton) NumJoints() int {
+	return len(s.Joints)
+}
+
+// JointIndex - the number of the named joint, or -1
+func (s *Skeleton) JointIndex(name string) int {
+	if j, ok := s.names[name]; ok {
+		return j
+	}
+	return -1
+}
+
+// SetPose - sets joint j's transform relative to its parent
+func (s *Skeleton) SetPose(j int, pose *Mat4) {
+	s.Joints[j].Pose = *pose
+}
+
+// WorldTransforms - each joint's transform into mesh space in the current
+// pose
+func (s *Skeleton) WorldTransforms() []Mat4 {
+	world := make([]Mat4, len(s.Joints))
+	for j := range s.Joints {
+		joint := &s.Joints[j]
+		if joint.Parent < 0 {
+			world[j] = joint.Pose
+		} else {
+			world[j] = *world[joint.Parent].MulM(&joint.Pose)
+		}
+	}
+	return world
+}
+
+// ComputeInverseBinds - makes the current pose the bind pose
+func (s *Skeleton) ComputeInverseBinds() error {
+	for j, w := range s.WorldTransforms() {
+		inv := w.Inverse()
+		if inv == nil {
+			return errors.New(fmt.Sprintf("Skeleton:ComputeInverseBinds: Joint %s can't be inverted", s.Joints[j].Name))
+		}
+		s.Joints[j].InverseBind = *inv
+	}
+	return nil
+}
+
+// SkinMatrices - each joint's transform from the bind pose to the current
+// pose, in mesh space; what a skinning shader wants
+func (s *Skeleton) SkinMatrices() []Mat4 {
+	world := s.WorldTransforms()
+	for j := range world {
+		world[j] = *world[j].MulM(&s.Joints[j].InverseBind)
+	}
+	return world
+}
+
+// Skin - deforms the mesh's positions into positions, and its normals
+// into normals if that isn't nil, by the current pose.  The targets need
+// as many vertices as the mesh and at least 3 components, and shouldn't
+// be the mesh's own attributes or the bind pose is lost.  Vertices with
+// no weight are copied as they are.
+func (s *Skeleton) Skin(m *Mesh, method SkinningMethod, positions, normals *MeshAttribute) error {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return err
+	}
+	srcPos, srcNrm := m.positionAttribute(), m.AttributeByDesc(AttribNormal)
+	joints, weights := m.AttributeByDesc(AttribJoints), m.AttributeByDesc(AttribWeights)
+	switch {
+	case joints == nil || weights == nil:
+		return errors.New(fmt.Sprintf("Skeleton:Skin: Mesh %s has no joints and weights", m.name))
+	case joints.stride != weights.stride:
+		return errors.New(fmt.Sprintf("Skeleton:Skin: Mesh %s has %d joints but %d weights per vertex", m.name, joints.stride, weights.stride))
+	case normals != nil && srcNrm == nil:
+		return errors.New(fmt.Sprintf("Skeleton:Skin: Mesh %s has no normals", m.name))
+	}
+	if positions == nil {
+		return errors.New(fmt.Sprintf("Skeleton:Skin: No target for positions"))
+	}
+	for _, target := range []*MeshAttribute{positions, normals} {
+		if target != nil && (target.stride < 3 || target.VertexCount() != vertices) {
+			return errors.New(fmt.Sprintf("Skeleton:Skin: Target %s has %d vertices of %d, need %d of at least 3", target.desc, target.VertexCount(), target.stride, vertices))
+		}
+	}
+
+	mats := s.SkinMatrices()
+	var dqs []DualQuat
+	if method == DualQuatSkinning {
+		dqs = make([]DualQuat, len(mats))
+		for j := range mats {
+			dqs[j] = DualQuatFromMat4(&mats[j])
+		}
+	}
+
+	// The joint a vertex is rigidly bound to, -1 if it has none, -2 if it
+	// blends several
+	k := joints.stride
+	joint := func(i int) int {
+		return int(math.Floor(float64(joints.data[i]) + 0.5))
+	}
+	rigid := make([]int, vertices)
+	for v := range rigid {
+		rigid[v] = -1
+		for c := 0; c < k; c++ {
+			if weights.data[v*k+c] == 0 {
+				continue
+			}
+			j := joint(v*k + c)
+			if j < 0 || j >= len(mats) {
+				return errors.New(fmt.Sprintf("Skeleton:Skin: Vertex %d uses joint %d of %d", v, j, len(mats)))
+			}
+			if rigid[v] == -1 || rigid[v] == j {
+				rigid[v] = j
+			} else {
+				rigid[v] = -2
+			}
+		}
+	}
+
+	// Transforms vertices first to last with mat
+	apply := func(mat *Mat4, first, last int) {
+		ps, ss := positions.stride, srcPos.stride
+		mat.TransformPoints(positions.data[first*ps:last*ps], ps, srcPos.data[first*ss:last*ss], ss)
+		if normals != nil {
+			ns, ss := normals.stride, srcNrm.stride
+			mat.TransformNormals(normals.data[first*ns:last*ns], ns, srcNrm.data[first*ss:last*ss], ss)
+		}
+	}
+	ident := IdentMat4()
+	for v := 0; v < vertices; {
+		if j := rigid[v]; j != -2 {
+			// A run of vertices following the same joint goes in one batch
+			end := v + 1
+			for end < vertices && rigid[end] == j {
+				end++
+			}
+			if j == -1 {
+				apply(ident, v, end)
+			} else {
+				apply(&mats[j], v, end)
+			}
+			v = end
+			continue
+		}
+
+		total := gl.Float(0)
+		for c := 0; c < k; c++ {
+			total += weights.data[v*k+c]
+		}
+		var blend *Mat4
+		switch method {
+		case DualQuatSkinning:
+			var dq, first DualQuat
+			for c := 0; c < k; c++ {
+				w := weights.data[v*k+c] / total
+				if w == 0 {
+					continue
+				}
+				d := dqs[joint(v*k+c)]
+				if first.Real == (Quat{}) {
+					first = d
+				}
+				// Keep every joint's rotation on the same side as the
+				// first, or they cancel out
+				if d.Real.Dot(first.Real) < 0 {
+					w = -w
+				}
+				dq = DualQuat{dq.Real.Add(d.Real.Scale(w)), dq.Dual.Add(d.Dual.Scale(w))}
+			}
+			blend = dq.Normalize().Mat4()
+		default:
+			blend = new(Mat4)
+			for c := 0; c < k; c++ {
+				w := weights.data[v*k+c] / total
+				if w == 0 {
+					continue
+				}
+				mat := &mats[joint(v*k+c)]
+				for col := range blend {
+					blend[col].X += mat[col].X * w
+					blend[col].Y += mat[col].Y * w
+					blend[col].Z += mat[col].Z * w
+					blend[col].W += mat[col].W * w
+				}
+			}
+		}
+		apply(blend, v, v+1)
+		v++
+	}
+	positions.changed()
+	if normals != nil {
+		normals.changed()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A two joint arm along X, the elbow at x = 1, and a mesh of vertices
+// following the shoulder, the elbow, or both
+func armSkeleton(t *testing.T) (*Skeleton, *Mesh) {
+	s := NewSkeleton()
+	if _, err := s.AddJoint("shoulder", -1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	elbow := IdentMat4().Translate(&Vec4{1, 0, 0, 1})
+	if _, err := s.AddJoint("elbow", 0, elbow, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ComputeInverseBinds(); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMesh("arm")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{
+		0, 0, 0, 0.5, 0, 0, // shoulder
+		2, 0, 0, 2, 1, 0, // elbow
+		1, 1, 0, // both
+	}, 3)
+	m.AddMeshAttribute(AttribNormal, []gl.Float{0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0}, 3)
+	m.AddMeshAttribute(AttribJoints, []gl.Float{0, 0, 0, 1, 1, 0, 1, 0, 0, 1}, 2)
+	m.AddMeshAttribute(AttribWeights, []gl.Float{1, 0, 1, 0, 1, 0, 1, 0, 0.5, 0.5}, 2)
+	return s, m
+}
+
+func TestSkeletonHierarchy(t *testing.T) {
+	s, _ := armSkeleton(t)
+	if s.JointIndex("elbow") != 1 || s.JointIndex("wrist") != -1 {
+		t.Errorf("JointIndex finds elbow at %d, wrist at %d", s.JointIndex("elbow"), s.JointIndex("wrist"))
+	}
+	if _, err := s.AddJoint("hand", 5, nil, nil); err == nil {
+		t.Errorf("AddJoint should fail with a parent that doesn't exist")
+	}
+	if _, err := s.AddJoint("elbow", 0, nil, nil); err == nil {
+		t.Errorf("AddJoint should fail with a name already in use")
+	}
+	// In the bind pose every skin matrix is the identity
+	for j, m := range s.SkinMatrices() {
+		p := Vec3{3, 4, 5}
+		if q := m.MulPoint(&p); !closeVec3(*q, p) {
+			t.Errorf("Joint %d's bind pose skin matrix moves %v to %v", j, p, *q)
+		}
+	}
+}
+
+func TestSkin(t *testing.T) {
+	s, m := armSkeleton(t)
+	// Bend the elbow 90 degrees about Z
+	s.SetPose(1, IdentMat4().Translate(&Vec4{1, 0, 0, 1}).MulM(RotateZ(90)))
+	for _, method := range []SkinningMethod{LinearBlendSkinning, DualQuatSkinning} {
+		pos := NewMeshAttribute("skinned", make([]gl.Float, 15), 3)
+		nrm := NewMeshAttribute("skinnednormal", make([]gl.Float, 15), 3)
+		if err := s.Skin(m, method, pos, nrm); err != nil {
+			t.Fatal(err)
+		}
+		want := []Vec3{{0, 0, 0}, {0.5, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+		for v, w := range want {
+			if p := pos.vec3(v); !closeVec3(p, w) {
+				t.Errorf("Method %d moves vertex %d to %v, want %v", method, v, p, w)
+			}
+		}
+		if n := nrm.vec3(2); !closeVec3(n, Vec3{-1, 0, 0}) {
+			t.Errorf("Method %d turns the elbow's normal to %v", method, n)
+		}
+		if n := nrm.vec3(4); math.Abs(float64(n.Length()-1)) > 1e-5 {
+			t.Errorf("Method %d gives a blended normal of length %v", method, n.Length())
+		}
+	}
+}
+
+// Twisting the elbow half a turn collapses a half and half vertex onto the
+// bone with linear blending, but not with dual quaternions
+func TestSkinTwist(t *testing.T) {
+	s, m := armSkeleton(t)
+	s.SetPose(1, IdentMat4().Translate(&Vec4{1, 0, 0, 1}).MulM(RotateX(180)))
+	radius := func(method SkinningMethod) float64 {
+		pos := NewMeshAttribute("skinned", make([]gl.Float, 15), 3)
+		if err := s.Skin(m, method, pos, nil); err != nil {
+			t.Fatal(err)
+		}
+		p := pos.vec3(4)
+		return math.Hypot(float64(p.Y), float64(p.Z))
+	}
+	if r := radius(LinearBlendSkinning); r > 1e-5 {
+		t.Errorf("Linear blending leaves the twisted vertex %v from the bone, want 0", r)
+	}
+	if r := radius(DualQuatSkinning); math.Abs(r-1) > 1e-5 {
+		t.Errorf("Dual quaternions leave the twisted vertex %v from the bone, want 1", r)
+	}
+}
+
+func TestSkinErrors(t *testing.T) {
+	s, m := armSkeleton(t)
+	if err := s.Skin(m, LinearBlendSkinning, NewMeshAttribute("short", make([]gl.Float, 6), 3), nil); err == nil {
+		t.Errorf("Skin should fail with a target that's too small")
+	}
+	m.AttributeByDesc(AttribJoints).Data()[2] = 7
+	if err := s.Skin(m, LinearBlendSkinning, NewMeshAttribute("skinned", make([]gl.Float, 15), 3), nil); err == nil {
+		t.Errorf("Skin should fail with a vertex using a joint that doesn't exist")
+	}
+}
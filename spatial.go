@@ -0,0 +1,151 @@
+// spatial - dynamic spatial indices for scene objects
+//
+// A SpatialIndex keeps objects, each an id number chosen by the caller,
+// by their bounding boxes, and finds the ones in a box, in a radius, in
+// the view or along a ray.  LooseOctree suits worlds with objects of
+// very different sizes, HashGrid lots of similar sized objects in a world
+// with no fixed edge.
+//
+// ViewFrustum pulls the six clipping planes out of a view-projection
+// matrix, for culling against.
+
+package goglutils
+
+import (
+	"math"
+	"sort"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+// A spatial index of objects by bounding box
+type SpatialIndex interface {
+	// Adds an object, or moves it if the id is already there
+	Insert(id int, box AABB)
+	// Moves an object, returning false if the id isn't there
+	Update(id int, box AABB) bool
+	// Removes an object, returning false if the id isn't there
+	Remove(id int) bool
+	// The object's box
+	Bounds(id int) (AABB, bool)
+	// Number of objects
+	Len() int
+	// Objects whose boxes touch the box
+	QueryAABB(box AABB) []int
+	// Objects whose boxes come within radius of centre
+	QueryRadius(centre Vec3, radius gl.Float) []int
+	// Objects whose boxes are at least partly in view
+	QueryFrustum(f *ViewFrustum) []int
+	// Objects whose boxes the ray passes through before maxT, nearest
+	// first
+	QueryRay(r Ray, maxT gl.Float) []ObjectHit
+}
+
+// An object a ray passes through, and where it enters its box
+type ObjectHit struct {
+	ID int
+	T  gl.Float
+}
+
+// Sorts ray hits nearest first, ties by id so results are repeatable
+func sortObjectHits(hits []ObjectHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].T != hits[j].T {
+			return hits[i].T < hits[j].T
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
+
+// Does a sphere touch a box?
+func sphereOverlapsAABB(centre Vec3, radius gl.Float, box AABB) bool {
+	p := box.ClosestPoint(centre)
+	d := p.Sub(&centre)
+	return d.Dot(d) <= radius*radius
+}
+
+// A plane, the points p where Normal . p + D = 0.  The side the normal
+// points to is in front.
+type Plane struct {
+	Normal Vec3
+	D      gl.Float
+}
+
+// Signed distance from the plane to p, positive in front
+func (pl Plane) Distance(p Vec3) gl.Float {
+	return pl.Normal.Dot(&p) + pl.D
+}
+
+// The planes of a view frustum, facing inwards
+type ViewFrustum struct {
+	Planes [6]Plane // Left, right, bottom, top, near, far
+}
+
+// NewViewFrustum - the frustum of a projection * view matrix (or
+// projection * view * model, to work in model space).  Each plane is a
+// sum or difference of two rows of the matrix (Gribb and Hartmann).
+func NewViewFrustum(viewProj *Mat4) *ViewFrustum {
+	row := func(i int) [4]gl.Float {
+		get := func(c int) gl.Float {
+			return [4]gl.Float{viewProj[c].X, viewProj[c].Y, viewProj[c].Z, viewProj[c].W}[i]
+		}
+		return [4]gl.Float{get(0), get(1), get(2), get(3)}
+	}
+	w := row(3)
+	f := new(ViewFrustum)
+	for k := 0; k < 3; k++ {
+		r := row(k)
+		for s, sign := range [2]gl.Float{1, -1} {
+			p := [4]gl.Float{w[0] + sign*r[0], w[1] + sign*r[1], w[2] + sign*r[2], w[3] + sign*r[3]}
+			l := gl.Float(math.Sqrt(float64(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])))
+			if l > 0 {
+				p = [4]gl.Float{p[0] / l, p[1] / l, p[2] / l, p[3] / l}
+			}
+			f.Planes[k*2+s] = Plane{Vec3{p[0], p[1], p[2]}, p[3]}
+		}
+	}
+	return f
+}
+
+// Is p inside the frustum?
+func (f *ViewFrustum) ContainsPoint(p Vec3) bool {
+	for _, pl := range f.Planes {
+		if pl.Distance(p) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Does the sphere touch the frustum?  Spheres near the frustum's corners
+// may be let through when they are just outside.
+func (f *ViewFrustum) IntersectsSphere(s Sphere) bool {
+	for _, pl := range f.Planes {
+		if pl.Distance(s.Center) < -s.Radius {
+			return false
+		}
+	}
+	return true
+}
+
+// Does the box touch the frustum?  Like IntersectsSphere, boxes just
+// outside near the corners may be let through.
+func (f *ViewFrustum) IntersectsAABB(b AABB) bool {
+	for _, pl := range f.Planes {
+		// The corner furthest along the normal
+		p := b.Min
+		if pl.Normal.X >= 0 {
+			p.X = b.Max.X
+		}
+		if pl.Normal.Y >= 0 {
+			p.Y = b.Max.Y
+		}
+		if pl.Normal.Z >= 0 {
+			p.Z = b.Max.Z
+		}
+		if pl.Distance(p) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package goglutils
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func sortedInts(ids []int) []int {
+	out := append([]int(nil), ids...)
+	sort.Ints(out)
+	return out
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func randomBox(r *rand.Rand) AABB {
+	c := randomVec3(r, 50)
+	size := gl.Float(r.Float64() * 3)
+	if r.Intn(20) == 0 {
+		size *= 10
+	}
+	return AABB{c, c}.Grow(size)
+}
+
+// Checks every query of a spatial index against a plain list of boxes
+func checkSpatialIndex(t *testing.T, name string, idx SpatialIndex) {
+	r := rand.New(rand.NewSource(7))
+	boxes := make(map[int]AABB)
+	for id := 0; id < 400; id++ {
+		boxes[id] = randomBox(r)
+		idx.Insert(id, boxes[id])
+	}
+	for i := 0; i < 200; i++ {
+		id := r.Intn(400)
+		if _, ok := boxes[id]; !ok {
+			continue
+		}
+		if i%3 == 0 {
+			idx.Remove(id)
+			delete(boxes, id)
+			continue
+		}
+		// Small moves and long jumps
+		b := boxes[id]
+		off := randomVec3(r, 0.1)
+		if i%2 == 0 {
+			off = randomVec3(r, 40)
+		}
+		b = AABB{*b.Min.Add(&off), *b.Max.Add(&off)}
+		idx.Update(id, b)
+		boxes[id] = b
+	}
+	if idx.Len() != len(boxes) {
+		t.Fatalf("%s holds %d objects, want %d", name, idx.Len(), len(boxes))
+	}
+	if idx.Update(1000, AABB{}) || idx.Remove(1000) {
+		t.Errorf("%s: updated or removed a missing id", name)
+	}
+
+	brute := func(test func(AABB) bool) []int {
+		var out []int
+		for id, b := range boxes {
+			if test(b) {
+				out = append(out, id)
+			}
+		}
+		sort.Ints(out)
+		return out
+	}
+	for i := 0; i < 50; i++ {
+		q := randomBox(r).Grow(5)
+		if got, want := sortedInts(idx.QueryAABB(q)), brute(q.Overlaps); !equalInts(got, want) {
+			t.Fatalf("%s: box query gives %v, want %v", name, got, want)
+		}
+		c, rad := randomVec3(r, 50), gl.Float(r.Float64()*15)
+		want := brute(func(b AABB) bool { return sphereOverlapsAABB(c, rad, b) })
+		if got := sortedInts(idx.QueryRadius(c, rad)); !equalInts(got, want) {
+			t.Fatalf("%s: radius query gives %v, want %v", name, got, want)
+		}
+
+		ray := Ray{randomVec3(r, 60), randomVec3(r, 1)}
+		hits := idx.QueryRay(ray, 200)
+		var got []int
+		for k, h := range hits {
+			got = append(got, h.ID)
+			if k > 0 && h.T < hits[k-1].T {
+				t.Fatalf("%s: ray hits out of order", name)
+			}
+		}
+		want = brute(func(b AABB) bool {
+			_, ok := b.IntersectRay(ray, 200)
+			return ok
+		})
+		if got = sortedInts(got); !equalInts(got, want) {
+			t.Fatalf("%s: ray query gives %v, want %v", name, got, want)
+		}
+	}
+
+	f := NewViewFrustum(Perspective(DegToRad(60), 1.5, 1, 100).MulM(cameraAt(Vec3{10, 5, 80})))
+	if got, want := sortedInts(idx.QueryFrustum(f)), brute(f.IntersectsAABB); !equalInts(got, want) {
+		t.Errorf("%s: frustum query gives %v, want %v", name, got, want)
+	}
+}
+
+// View matrix of a camera at p looking down -z
+func cameraAt(p Vec3) *Mat4 {
+	view := IdentMat4()
+	view[3] = Vec4{-p.X, -p.Y, -p.Z, 1}
+	return view
+}
+
+func TestLooseOctree(t *testing.T) {
+	checkSpatialIndex(t, "LooseOctree", NewLooseOctree(AABB{Vec3{-50, -50, -50}, Vec3{50, 50, 50}}, 6))
+}
+
+func TestHashGrid(t *testing.T) {
+	checkSpatialIndex(t, "HashGrid", NewHashGrid(4))
+}
+
+func TestViewFrustum(t *testing.T) {
+	f := NewViewFrustum(Perspective(DegToRad(90), 1, 1, 100).MulM(cameraAt(Vec3{0, 0, 10})))
+	if !f.ContainsPoint(Vec3{0, 0, 0}) {
+		t.Errorf("Point in front of the camera should be in view")
+	}
+	if f.ContainsPoint(Vec3{0, 0, 20}) {
+		t.Errorf("Point behind the camera should be out of view")
+	}
+	if f.ContainsPoint(Vec3{0, 0, -95}) {
+		t.Errorf("Point past the far plane should be out of view")
+	}
+	if f.IntersectsSphere(Sphere{Vec3{20, 0, 0}, 1}) {
+		t.Errorf("Sphere off to the side should be out of view")
+	}
+	if !f.IntersectsAABB(AABB{Vec3{-1, -1, 8}, Vec3{1, 1, 9.5}}) {
+		t.Errorf("Box across the near plane should be in view")
+	}
+}
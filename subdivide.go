@@ -0,0 +1,521 @@
+// subdivide - Loop and Catmull-Clark subdivision surfaces
+//
+// Each level splits every face - triangles into four for Loop, n-sided
+// faces into n quads for Catmull-Clark - and smooths the result.  Borders
+// and crease edges (where neighbouring faces meet at more than a given
+// angle) follow the curve rules, so they stay sharp, and vertices where
+// more than two of them meet stay put.
+//
+// Positions are smoothed across the whole surface, with vertices at the
+// same position treated as one.  Every other attribute is smoothed with
+// UV (or normal) seams treated as borders, so each side of a seam keeps
+// its own values.  Morph offsets are smoothed along with whatever they
+// offset.  Vertices keep their numbers, so line and point indices still
+// work; the new vertices are added after them.
+
+package goglutils
+
+import (
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+)
+
+// Subdivision state between levels
+type subdivision struct {
+	faces    [][]int      // Faces, as vertex numbers
+	owners   []*MeshIndex // Index each face came from
+	group    []int        // Position group of each vertex
+	groupPos []float64    // Position of each group, 3 to a group
+	data     []float64    // Every attribute of each vertex, stride to a vertex
+	stride   int
+	posAt    int             // Where the position is in data
+	sharp    map[[2]int]bool // Crease edges, between position groups
+	catmull  bool            // Catmull-Clark rather than Loop
+
+	// Morph offsets, 3 columns each in data after the attributes.  Position
+	// offsets are smoothed by group like positions, in groupMorph.
+	morphs     []*morphDeltas
+	morphPosAt []int // Where each position offset is in data
+	groupMorph []float64
+}
+
+func edgeKey(a, b int) [2]int {
+	if b < a {
+		return [2]int{b, a}
+	}
+	return [2]int{a, b}
+}
+
+// Gathers the mesh's triangles and attributes
+func newSubdivision(m *Mesh, creaseAngle gl.Float) (*subdivision, error) {
+	vertices, err := m.vertexCount()
+	if err != nil {
+		return nil, err
+	}
+	s := new(subdivision)
+	pos := m.positionAttribute()
+	for _, attr := range m.attributes {
+		if attr == pos {
+			s.posAt = s.stride
+		}
+		s.stride += attr.stride
+	}
+	var dense [][]gl.Float
+	for _, mt := range m.morphs {
+		for desc, d := range mt.deltas {
+			if desc == AttribPosition {
+				s.morphPosAt = append(s.morphPosAt, s.stride)
+			}
+			s.morphs = append(s.morphs, d)
+			dense = append(dense, d.dense(vertices))
+			s.stride += 3
+		}
+	}
+	s.data = make([]float64, 0, vertices*s.stride)
+	for v := 0; v < vertices; v++ {
+		for _, attr := range m.attributes {
+			for _, f := range attr.data[v*attr.stride : (v+1)*attr.stride] {
+				s.data = append(s.data, float64(f))
+			}
+		}
+		for _, d := range dense {
+			for _, f := range d[v*3 : v*3+3] {
+				s.data = append(s.data, float64(f))
+			}
+		}
+	}
+
+	groups := make(map[Vec3]int)
+	s.group = make([]int, vertices)
+	for v := 0; v < vertices; v++ {
+		p := pos.vec3(v)
+		g, ok := groups[p]
+		if !ok {
+			g = len(groups)
+			groups[p] = g
+			s.groupPos = append(s.groupPos, float64(p.X), float64(p.Y), float64(p.Z))
+			for _, at := range s.morphPosAt {
+				s.groupMorph = append(s.groupMorph, s.data[v*s.stride+at:v*s.stride+at+3]...)
+			}
+		}
+		s.group[v] = g
+	}
+
+	// Faces degenerate once seams are closed up would break the topology
+	for _, t := range m.meshTriangles() {
+		g0, g1, g2 := s.group[t.v[0]], s.group[t.v[1]], s.group[t.v[2]]
+		if g0 != g1 && g1 != g2 && g0 != g2 {
+			s.faces = append(s.faces, []int{int(t.v[0]), int(t.v[1]), int(t.v[2])})
+			s.owners = append(s.owners, t.index)
+		}
+	}
+
+	// Creases, by the angle between the faces either side
+	s.sharp = make(map[[2]int]bool)
+	ghm, err := NewHalfEdgeMesh(len(groups), s.groupFaces())
+	if err != nil {
+		return nil, err
+	}
+	cosCrease := math.Cos(float64(DegToRad(Clamp(creaseAngle, 0, 180))))
+	for e := 0; e < ghm.NumEdges(); e++ {
+		h := ghm.EdgeHalfEdge(e)
+		if ghm.IsBoundary(h) {
+			continue
+		}
+		n0 := s.faceNormal(ghm, ghm.HalfEdges[h].Face)
+		n1 := s.faceNormal(ghm, ghm.HalfEdges[ghm.HalfEdges[h].Twin].Face)
+		if n0.dot(n1) < cosCrease-1e-9 {
+			s.sharp[edgeKey(ghm.HalfEdges[h].Origin, ghm.Dest(h))] = true
+		}
+	}
+	return s, nil
+}
+
+// The faces, as position groups
+func (s *subdivision) groupFaces() [][]int {
+	faces := make([][]int, len(s.faces))
+	for f, face := range s.faces {
+		faces[f] = make([]int, len(face))
+		for k, v := range face {
+			faces[f][k] = s.group[v]
+		}
+	}
+	return faces
+}
+
+// Unit normal of a face of the group mesh, by Newell's method
+func (s *subdivision) faceNormal(ghm *HalfEdgeMesh, f int) vec3d {
+	var n vec3d
+	vs := ghm.FaceVertices(f)
+	for k, a := range vs {
+		b := vs[(k+1)%len(vs)]
+		pa := vec3d{s.groupPos[a*3], s.groupPos[a*3+1], s.groupPos[a*3+2]}
+		pb := vec3d{s.groupPos[b*3], s.groupPos[b*3+1], s.groupPos[b*3+2]}
+		n = n.add(pa.cross(pb))
+	}
+	return n.normalize()
+}
+
+// Merges pairs of triangles back into quads for Catmull-Clark.  Two
+// triangles are paired when the edge between them is the longest of both
+// and they're close to coplanar.
+func (s *subdivision) recoverQuads() error {
+	hm, err := NewHalfEdgeMesh(len(s.group), s.faces)
+	if err != nil {
+		return err
+	}
+	ghm, err := NewHalfEdgeMesh(len(s.groupPos)/3, s.groupFaces())
+	if err != nil {
+		return err
+	}
+	position := func(v int) vec3d {
+		g := s.group[v]
+		return vec3d{s.groupPos[g*3], s.groupPos[g*3+1], s.groupPos[g*3+2]}
+	}
+	longest := func(f int) int {
+		best, bestLen := -1, -1.0
+		for _, h := range hm.FaceHalfEdges(f) {
+			if l := position(hm.Dest(h)).sub(position(hm.HalfEdges[h].Origin)).length(); l > bestLen {
+				best, bestLen = h, l
+			}
+		}
+		return best
+	}
+
+	paired := make([]bool, len(s.faces))
+	var faces [][]int
+	var owners []*MeshIndex
+	for f := range s.faces {
+		if paired[f] {
+			continue
+		}
+		h := longest(f)
+		t := hm.HalfEdges[h].Twin
+		if t >= 0 {
+			g := hm.HalfEdges[t].Face
+			if !paired[g] && g != f && longest(g) == t && s.owners[f] == s.owners[g] &&
+				s.faceNormal(ghm, f).dot(s.faceNormal(ghm, g)) > 0.9 {
+				paired[f], paired[g] = true, true
+				a, b := hm.HalfEdges[h].Origin, hm.Dest(h)
+				c := hm.HalfEdges[hm.HalfEdges[h].Prev].Origin
+				d := hm.HalfEdges[hm.HalfEdges[t].Prev].Origin
+				faces = append(faces, []int{a, d, b, c})
+				owners = append(owners, s.owners[f])
+				continue
+			}
+		}
+		faces = append(faces, s.faces[f])
+		owners = append(owners, s.owners[f])
+	}
+	s.faces, s.owners = faces, owners
+	return nil
+}
+
+// New values for the vertices, edges and (for Catmull-Clark) faces of hm,
+// from data with stride values per vertex.  sharp says which edges follow
+// the crease rules.
+func subdivisionPoints(hm *HalfEdgeMesh, data []float64, stride int, sharp []bool, catmull bool) (verts, edges, faces []float64) {
+	at := func(v int) []float64 {
+		return data[v*stride : (v+1)*stride]
+	}
+	// out = sum of weight * values
+	accumulate := func(out []float64, values []float64, weight float64) {
+		for i := range out {
+			out[i] += values[i] * weight
+		}
+	}
+
+	if catmull {
+		faces = make([]float64, hm.NumFaces()*stride)
+		for f := 0; f < hm.NumFaces(); f++ {
+			vs := hm.FaceVertices(f)
+			for _, v := range vs {
+				accumulate(faces[f*stride:(f+1)*stride], at(v), 1/float64(len(vs)))
+			}
+		}
+	}
+	facePoint := func(f int) []float64 {
+		return faces[f*stride : (f+1)*stride]
+	}
+
+	edges = make([]float64, hm.NumEdges()*stride)
+	for e := 0; e < hm.NumEdges(); e++ {
+		out := edges[e*stride : (e+1)*stride]
+		h := hm.EdgeHalfEdge(e)
+		he := hm.HalfEdges[h]
+		a, b := he.Origin, hm.Dest(h)
+		switch {
+		case sharp[e]:
+			accumulate(out, at(a), 0.5)
+			accumulate(out, at(b), 0.5)
+		case catmull:
+			accumulate(out, at(a), 0.25)
+			accumulate(out, at(b), 0.25)
+			accumulate(out, facePoint(he.Face), 0.25)
+			accumulate(out, facePoint(hm.HalfEdges[he.Twin].Face), 0.25)
+		default:
+			c := hm.HalfEdges[he.Prev].Origin
+			d := hm.HalfEdges[hm.HalfEdges[he.Twin].Prev].Origin
+			accumulate(out, at(a), 3.0/8)
+			accumulate(out, at(b), 3.0/8)
+			accumulate(out, at(c), 1.0/8)
+			accumulate(out, at(d), 1.0/8)
+		}
+	}
+
+	verts = make([]float64, hm.NumVertices()*stride)
+	for v := 0; v < hm.NumVertices(); v++ {
+		out := verts[v*stride : (v+1)*stride]
+		// The vertex's edges, the neighbours along them and the sharp ones
+		var neighbours, creases []int
+		seen := make(map[int]bool)
+		var incident []int
+		addEdge := func(h, n int) {
+			e := hm.Edge(h)
+			if seen[e] {
+				return
+			}
+			seen[e] = true
+			neighbours = append(neighbours, n)
+			if sharp[e] {
+				creases = append(creases, n)
+			}
+		}
+		for _, h := range hm.Outgoing(v) {
+			addEdge(h, hm.Dest(h))
+			incident = append(incident, hm.HalfEdges[h].Face)
+			if in := hm.HalfEdges[h].Prev; hm.IsBoundary(in) {
+				addEdge(in, hm.HalfEdges[in].Origin)
+			}
+		}
+
+		switch {
+		case len(neighbours) == 0 || len(creases) > 2 || (len(creases) < 2 && hm.IsBoundaryVertex(v)):
+			// Unused, a corner, or a non-manifold mess - stays put
+			copy(out, at(v))
+		case len(creases) == 2:
+			accumulate(out, at(v), 0.75)
+			accumulate(out, at(creases[0]), 0.125)
+			accumulate(out, at(creases[1]), 0.125)
+		case catmull:
+			n := float64(len(neighbours))
+			for _, f := range incident {
+				accumulate(out, facePoint(f), 1/(n*float64(len(incident))))
+			}
+			for _, nb := range neighbours {
+				// Edge midpoints, twice over
+				accumulate(out, at(v), 1/(n*n))
+				accumulate(out, at(nb), 1/(n*n))
+			}
+			accumulate(out, at(v), (n-3)/n)
+		default:
+			n := float64(len(neighbours))
+			beta := 3.0 / (8 * n)
+			if len(neighbours) == 3 {
+				beta = 3.0 / 16
+			}
+			accumulate(out, at(v), 1-n*beta)
+			for _, nb := range neighbours {
+				accumulate(out, at(nb), beta)
+			}
+		}
+	}
+	return verts, edges, faces
+}
+
+// One level of subdivision
+func (s *subdivision) step() error {
+	groups := len(s.groupPos) / 3
+	hm, err := NewHalfEdgeMesh(len(s.group), s.faces)
+	if err != nil {
+		return err
+	}
+	ghm, err := NewHalfEdgeMesh(groups, s.groupFaces())
+	if err != nil {
+		return err
+	}
+
+	// Sharp edges - creases and borders of the surface, plus seams for
+	// everything but positions
+	groupEdge := make(map[[2]int]int)
+	gsharp := make([]bool, ghm.NumEdges())
+	for e := range gsharp {
+		h := ghm.EdgeHalfEdge(e)
+		key := edgeKey(ghm.HalfEdges[h].Origin, ghm.Dest(h))
+		groupEdge[key] = e
+		gsharp[e] = ghm.IsBoundary(h) || s.sharp[key]
+	}
+	vsharp := make([]bool, hm.NumEdges())
+	vgroupEdge := make([]int, hm.NumEdges())
+	for e := range vsharp {
+		h := hm.EdgeHalfEdge(e)
+		ge := groupEdge[edgeKey(s.group[hm.HalfEdges[h].Origin], s.group[hm.Dest(h)])]
+		vgroupEdge[e] = ge
+		vsharp[e] = hm.IsBoundary(h) || gsharp[ge]
+	}
+
+	gv, ge, gf := subdivisionPoints(ghm, s.groupPos, 3, gsharp, s.catmull)
+	vv, ve, vf := subdivisionPoints(hm, s.data, s.stride, vsharp, s.catmull)
+
+	// New vertices: the old ones, then one per edge, then one per face
+	vertices, edges := hm.NumVertices(), hm.NumEdges()
+	data := append(append(vv, ve...), vf...)
+	group := make([]int, 0, len(data)/s.stride)
+	group = append(group, s.group...)
+	for e := 0; e < edges; e++ {
+		group = append(group, groups+vgroupEdge[e])
+	}
+	if s.catmull {
+		for f := 0; f < hm.NumFaces(); f++ {
+			group = append(group, groups+ghm.NumEdges()+f)
+		}
+	}
+	groupPos := append(append(gv, ge...), gf...)
+	for v, g := range group {
+		copy(data[v*s.stride+s.posAt:v*s.stride+s.posAt+3], groupPos[g*3:g*3+3])
+	}
+	groupMorph := s.groupMorph
+	if gs := len(s.morphPosAt) * 3; gs > 0 {
+		mv, me, mf := subdivisionPoints(ghm, s.groupMorph, gs, gsharp, s.catmull)
+		groupMorph = append(append(mv, me...), mf...)
+		for v, g := range group {
+			for i, at := range s.morphPosAt {
+				copy(data[v*s.stride+at:v*s.stride+at+3], groupMorph[g*gs+i*3:g*gs+i*3+3])
+			}
+		}
+	}
+
+	// Creases split in two
+	sharp := make(map[[2]int]bool)
+	for key := range s.sharp {
+		if e, ok := groupEdge[key]; ok {
+			mid := groups + e
+			sharp[edgeKey(key[0], mid)] = true
+			sharp[edgeKey(mid, key[1])] = true
+		}
+	}
+
+	// New faces
+	var faces [][]int
+	var owners []*MeshIndex
+	for f := 0; f < hm.NumFaces(); f++ {
+		hs := hm.FaceHalfEdges(f)
+		edgeVertex := func(h int) int {
+			return vertices + hm.Edge(h)
+		}
+		if s.catmull {
+			centre := vertices + edges + f
+			for k, h := range hs {
+				prev := hs[(k+len(hs)-1)%len(hs)]
+				faces = append(faces, []int{hm.HalfEdges[h].Origin, edgeVertex(h), centre, edgeVertex(prev)})
+				owners = append(owners, s.owners[f])
+			}
+			continue
+		}
+		a, b, c := hm.HalfEdges[hs[0]].Origin, hm.HalfEdges[hs[1]].Origin, hm.HalfEdges[hs[2]].Origin
+		ab, bc, ca := edgeVertex(hs[0]), edgeVertex(hs[1]), edgeVertex(hs[2])
+		faces = append(faces, []int{a, ab, ca}, []int{ab, b, bc}, []int{ca, bc, c}, []int{ab, bc, ca})
+		owners = append(owners, s.owners[f], s.owners[f], s.owners[f], s.owners[f])
+	}
+
+	s.faces, s.owners, s.group, s.groupPos, s.data, s.sharp = faces, owners, group, groupPos, data, sharp
+	s.groupMorph = groupMorph
+	return nil
+}
+
+// Writes the subdivided surface back to the mesh
+func (s *subdivision) store(m *Mesh) {
+	vertices := len(s.data) / s.stride
+	offset := 0
+	for _, attr := range m.attributes {
+		data := make([]gl.Float, vertices*attr.stride)
+		for v := 0; v < vertices; v++ {
+			for c := 0; c < attr.stride; c++ {
+				data[v*attr.stride+c] = gl.Float(s.data[v*s.stride+offset+c])
+			}
+		}
+		// Interpolated directions need to be unit length again
+		switch attr.desc {
+		case AttribNormal, AttribTangent, AttribBitangent:
+			if attr.stride >= 3 {
+				for v := 0; v < vertices; v++ {
+					d := data[v*attr.stride:]
+					n := normalizeOr(Vec3{d[0], d[1], d[2]}, Vec3{0, 0, 1})
+					d[0], d[1], d[2] = n.X, n.Y, n.Z
+					if attr.desc == AttribTangent && attr.stride == 4 {
+						d[3] = gl.Float(math.Copysign(1, float64(d[3])))
+					}
+				}
+			}
+		}
+		attr.data = data
+		attr.changed()
+		offset += attr.stride
+	}
+	for _, d := range s.morphs {
+		dense := make([]gl.Float, vertices*3)
+		for v := 0; v < vertices; v++ {
+			for c := 0; c < 3; c++ {
+				dense[v*3+c] = gl.Float(s.data[v*s.stride+offset+c])
+			}
+		}
+		d.setDense(dense)
+		offset += 3
+	}
+
+	tris := make(map[*MeshIndex][][3]gl.Uint)
+	for f, face := range s.faces {
+		for k := 1; k+1 < len(face); k++ {
+			tris[s.owners[f]] = append(tris[s.owners[f]], [3]gl.Uint{gl.Uint(face[0]), gl.Uint(face[k]), gl.Uint(face[k+1])})
+		}
+	}
+	for _, indx := range m.indices {
+		if isTrianglePrimitive(indx.primitive) {
+			indx.setTriangles(tris[indx])
+		}
+	}
+	m.layoutDirty = true
+}
+
+func (m *Mesh) subdivide(levels int, creaseAngle gl.Float, catmull bool, caller string) error {
+	if levels < 0 {
+		return errors.New(fmt.Sprintf("Mesh:%s: Invalid number of levels %d", caller, levels))
+	}
+	if levels == 0 {
+		return nil
+	}
+	s, err := newSubdivision(m, creaseAngle)
+	if err != nil {
+		return err
+	}
+	s.catmull = catmull
+	if catmull {
+		if err := s.recoverQuads(); err != nil {
+			return err
+		}
+	}
+	for l := 0; l < levels; l++ {
+		if err := s.step(); err != nil {
+			return err
+		}
+	}
+	s.store(m)
+	return nil
+}
+
+// SubdivideLoop - applies levels of Loop subdivision to the mesh's
+// triangles.  Edges between faces meeting at more than creaseAngle
+// degrees stay sharp; pass 180 for none.
+func (m *Mesh) SubdivideLoop(levels int, creaseAngle gl.Float) error {
+	return m.subdivide(levels, creaseAngle, false, "SubdivideLoop")
+}
+
+// SubdivideCatmullClark - applies levels of Catmull-Clark subdivision.
+// Pairs of triangles that make up a quad are merged back into one first,
+// so quad-dominant meshes subdivide as quads.  The result is drawn as
+// triangles, two to a quad.
+func (m *Mesh) SubdivideCatmullClark(levels int, creaseAngle gl.Float) error {
+	return m.subdivide(levels, creaseAngle, true, "SubdivideCatmullClark")
+}
@@ -0,0 +1,130 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func TestSubdivideLoop(t *testing.T) {
+	m := NewIcosphereMesh(1, 0)
+	before := m.TriangleCount()
+	if err := m.SubdivideLoop(1, 180); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.TriangleCount(); n != before*4 {
+		t.Errorf("Loop gives %d triangles, want %d", n, before*4)
+	}
+	pos := m.AttributeByDesc(AttribPosition)
+	for v := 0; v < pos.VertexCount(); v++ {
+		p := pos.vec3(v)
+		if l := p.Length(); l < 0.7 || l > 1 {
+			t.Errorf("Vertex %v is %v from the centre", p, l)
+		}
+	}
+	checkPrimitive(t, m)
+}
+
+func TestSubdivideCreases(t *testing.T) {
+	m := NewCubeMesh(1, 1)
+	if err := m.SubdivideLoop(2, 30); err != nil {
+		t.Fatal(err)
+	}
+	pos := m.AttributeByDesc(AttribPosition)
+	corner := false
+	for v := 0; v < pos.VertexCount(); v++ {
+		p := pos.vec3(v)
+		d := math.Max(math.Abs(float64(p.X)), math.Max(math.Abs(float64(p.Y)), math.Abs(float64(p.Z))))
+		if math.Abs(d-0.5) > 1e-5 {
+			t.Errorf("Vertex %v has left the surface of the cube", p)
+			return
+		}
+		if p == (Vec3{0.5, 0.5, 0.5}) {
+			corner = true
+		}
+	}
+	if !corner {
+		t.Errorf("Cube corners should stay put")
+	}
+}
+
+func TestSubdivideKeepsUVs(t *testing.T) {
+	for _, catmull := range []bool{false, true} {
+		m := NewPlaneMesh(2, 2, 2, 2)
+		var err error
+		if catmull {
+			err = m.SubdivideCatmullClark(2, 180)
+		} else {
+			err = m.SubdivideLoop(2, 180)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos, uv := m.AttributeByDesc(AttribPosition), m.AttributeByDesc(AttribTexCoord)
+		for v := 0; v < pos.VertexCount(); v++ {
+			p, w := pos.vec3(v), uv.vec2(v)
+			if math.Abs(float64(w.X-(p.X/2+0.5))) > 1e-5 || math.Abs(float64(w.Y-(0.5-p.Z/2))) > 1e-5 {
+				t.Errorf("Vertex at %v has UV %v", p, w)
+				break
+			}
+		}
+	}
+}
+
+func TestSubdivideCatmullClark(t *testing.T) {
+	m := NewCubeMesh(1, 1)
+	if err := m.SubdivideCatmullClark(1, 180); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.TriangleCount(); n != 48 {
+		t.Errorf("Catmull-Clark cube has %d triangles, want 48 (24 quads)", n)
+	}
+	if n := m.AttributeByDesc(AttribPosition).VertexCount(); n != 54 {
+		t.Errorf("Catmull-Clark cube has %d vertices, want 54", n)
+	}
+	checkPrimitive(t, m)
+}
+
+func TestSubdivideMorphs(t *testing.T) {
+	for _, catmull := range []bool{false, true} {
+		// Stretch the +x half of the cube, against the same cube stretched
+		m := NewCubeMesh(1, 1)
+		pos := m.AttributeByDesc(AttribPosition)
+		shape := m.Clone("stretched")
+		var list []int
+		var deltas []gl.Float
+		for v := 0; v < pos.VertexCount(); v++ {
+			if pos.vec3(v).X > 0 {
+				list = append(list, v)
+				deltas = append(deltas, 1, 0, 0)
+				shape.AttributeByDesc(AttribPosition).Data()[v*3]++
+			}
+		}
+		stretch, _ := m.AddMorphTarget("stretch")
+		if err := stretch.SetSparseDeltas(AttribPosition, list, deltas); err != nil {
+			t.Fatal(err)
+		}
+		subdivide := func(m *Mesh) error {
+			if catmull {
+				return m.SubdivideCatmullClark(2, 180)
+			}
+			return m.SubdivideLoop(2, 180)
+		}
+		if err := subdivide(m); err != nil {
+			t.Fatal(err)
+		}
+		subdivide(shape)
+		if !stretch.IsSparse(AttribPosition) {
+			t.Errorf("Sparse offsets should stay sparse")
+		}
+		pos, want := m.AttributeByDesc(AttribPosition), shape.AttributeByDesc(AttribPosition)
+		for v := 0; v < pos.VertexCount(); v++ {
+			p, d := pos.vec3(v), stretch.Delta(AttribPosition, v)
+			if got := p.Add(&d); !closeVec3(*got, want.vec3(v)) {
+				t.Errorf("Catmull-Clark %v: morphed vertex %d is at %v, want %v", catmull, v, *got, want.vec3(v))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// vertexformat - describes how a vertex is laid out in a buffer
+//
+// A VertexFormat is a list of elements, each taking one mesh attribute
+// (matched by its description, the "semantic") and storing it as some
+// number of components of some GL type: floats, half floats, normalized
+// or plain bytes/shorts/ints, or packed 2_10_10_10 values.  The format
+// works out offsets and the stride, and can interleave a Mesh's attributes
+// into a single vertex buffer (or split one back out again) in plain Go,
+// as well as set up the matching attribute pointers.
+
+package goglutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gl "github.com/chsc/gogl/gl33"
+	"math"
+)
+
+// Descriptions used for the usual mesh attributes.  Anything that looks
+// for, say, normals, looks for an attribute with AttribNormal as its
+// description.
+const (
+	AttribPosition  = "position"
+	AttribNormal    = "normal"
+	AttribTangent   = "tangent"
+	AttribBitangent = "bitangent"
+	AttribTexCoord  = "texcoord"
+	AttribColor     = "color"
+	AttribJoints    = "joints"
+	AttribWeights   = "weights"
+)
+
+// VertexElement - a single attribute within a vertex
+type VertexElement struct {
+	Semantic   string  // Description of the mesh attribute this comes from
+	Type       gl.Enum // Component type - gl.FLOAT, gl.HALF_FLOAT, gl.UNSIGNED_BYTE, gl.INT_2_10_10_10_REV, ...
+	Count      int     // Number of components, 1-4.  Packed types always have 4.
+	Normalized bool    // Integer types map to [0,1] / [-1,1] rather than their raw value
+	Offset     int     // Byte offset within the vertex, worked out by NewVertexFormat
+	// Shader location.  Unless LocationSet, NewVertexFormat fills it in
+	// the way a mesh without a format picks its locations: a numeric
+	// Semantic gives that number, anything else the element's position in
+	// the format.
+	Location    gl.Uint
+	LocationSet bool // Location was chosen by the caller and is left alone
+}
+
+// VertexFormat - a list of elements and the resulting vertex stride
+type VertexFormat struct {
+	Elements []VertexElement
+	Stride   int
+}
+
+// Size in bytes of a single component of the given type.  Packed types
+// report the size of the whole packed value.
+func ComponentSize(componentType gl.Enum) int {
+	switch componentType {
+	case gl.BYTE, gl.UNSIGNED_BYTE:
+		return 1
+	case gl.SHORT, gl.UNSIGNED_SHORT, gl.HALF_FLOAT:
+		return 2
+	case gl.INT, gl.UNSIGNED_INT, gl.FLOAT, gl.INT_2_10_10_10_REV, gl.UNSIGNED_INT_2_10_10_10_REV:
+		return 4
+	}
+	return 0
+}
+
+func isPackedType(componentType gl.Enum) bool {
+	return componentType == gl.INT_2_10_10_10_REV || componentType == gl.UNSIGNED_INT_2_10_10_10_REV
+}
+
+// Size in bytes of the element within a vertex
+func (e *VertexElement) Size() int {
+	if isPackedType(e.Type) {
+		return 4
+	}
+	return ComponentSize(e.Type) * e.Count
+}
+
+// NewVertexFormat - builds a format from the given elements, packing them
+// one after the other.  Each element starts on a 4-byte boundary, as GL
+// prefers, and Offset is filled in, along with Location if it wasn't set.
+func NewVertexFormat(elements ...VertexElement) (*VertexFormat, error) {
+	f := new(VertexFormat)
+	f.Elements = make([]VertexElement, len(elements))
+	offset := 0
+	for i, e := range elements {
+		if ComponentSize(e.Type) == 0 {
+			return nil, errors.New(fmt.Sprintf("VertexFormat: %s has unsupported component type 0x%x", e.Semantic, e.Type))
+		}
+		if e.Count < 1 || e.Count > 4 || (isPackedType(e.Type) && e.Count != 4) {
+			return nil, errors.New(fmt.Sprintf("VertexFormat: %s has invalid component count %d", e.Semantic, e.Count))
+		}
+		e.Offset = offset
+		if !e.LocationSet {
+			e.Location = defaultAttribLocation(e.Semantic, i)
+		}
+		offset = roundUp(offset+e.Size(), 4)
+		f.Elements[i] = e
+	}
+	f.Stride = offset
+	return f, nil
+}
+
+// Looks up an element by semantic
+func (f *VertexFormat) Element(semantic string) (*VertexElement, bool) {
+	for i := range f.Elements {
+		if f.Elements[i].Semantic == semantic {
+			return &f.Elements[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetupAttribPointers - enables and points each element's attribute
+// location at the currently bound vertex buffer, whose vertices start
+// offset bytes in.  Bind the VAO and vertex buffer first.
+func (f *VertexFormat) SetupAttribPointers(offset int) {
+	f.setupAttribPointers(glVertexArrayAPI{}, offset)
+}
+
+func (f *VertexFormat) setupAttribPointers(arrays vertexArrayAPI, offset int) {
+	for _, e := range f.Elements {
+		arrays.AttribPointer(e.Location, e.Count, e.Type, e.Normalized, f.Stride, offset+e.Offset)
+	}
+}
+
+// Interleave - packs the mesh attributes named by the format's elements
+// into a single buffer, one vertex after the other.  An attribute with
+// fewer components than its element is padded out with (0, 0, 0, 1), one
+// with more is truncated.
+func (f *VertexFormat) Interleave(m *Mesh) ([]byte, error) {
+	attrs := make([]*MeshAttribute, len(f.Elements))
+	vertices := -1
+	for i, e := range f.Elements {
+		attrs[i] = m.AttributeByDesc(e.Semantic)
+		if attrs[i] == nil {
+			return nil, errors.New(fmt.Sprintf("VertexFormat:Interleave: Mesh has no %s attribute", e.Semantic))
+		}
+		count := attrs[i].VertexCount()
+		if vertices >= 0 && count != vertices {
+			return nil, errors.New(fmt.Sprintf("VertexFormat:Interleave: %s has %d vertices, expected %d", e.Semantic, count, vertices))
+		}
+		vertices = count
+	}
+	if vertices < 0 {
+		vertices = 0
+	}
+
+	data := make([]byte, vertices*f.Stride)
+	var values [4]gl.Float
+	for v := 0; v < vertices; v++ {
+		for i, e := range f.Elements {
+			attr := attrs[i]
+			values = [4]gl.Float{0, 0, 0, 1}
+			for c := 0; c < e.Count && c < attr.stride; c++ {
+				values[c] = attr.data[v*attr.stride+c]
+			}
+			packVertexElement(data[v*f.Stride+e.Offset:], &e, values[:e.Count])
+		}
+	}
+	return data, nil
+}
+
+// Deinterleave - the reverse of Interleave.  Unpacks a buffer laid out in
+// this format into mesh attributes, one per element, each with the
+// element's component count.  Attributes the mesh already has are
+// replaced; the rest are added.
+func (f *VertexFormat) Deinterleave(data []byte, m *Mesh) error {
+	if f.Stride == 0 || len(data)%f.Stride != 0 {
+		return errors.New(fmt.Sprintf("VertexFormat:Deinterleave: %d bytes isn't a whole number of %d byte vertices", len(data), f.Stride))
+	}
+	vertices := len(data) / f.Stride
+	for _, e := range f.Elements {
+		values := make([]gl.Float, vertices*e.Count)
+		for v := 0; v < vertices; v++ {
+			unpackVertexElement(values[v*e.Count:(v+1)*e.Count], &e, data[v*f.Stride+e.Offset:])
+		}
+		if err := m.setMeshAttribute(e.Semantic, values, e.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Largest value an integer component can hold, and whether it's signed
+func componentRange(componentType gl.Enum) (float64, bool) {
+	switch componentType {
+	case gl.BYTE:
+		return math.MaxInt8, true
+	case gl.UNSIGNED_BYTE:
+		return math.MaxUint8, false
+	case gl.SHORT:
+		return math.MaxInt16, true
+	case gl.UNSIGNED_SHORT:
+		return math.MaxUint16, false
+	case gl.INT:
+		return math.MaxInt32, true
+	case gl.UNSIGNED_INT:
+		return math.MaxUint32, false
+	}
+	return 0, false
+}
+
+// Converts a float to an integer component, scaling if normalized and
+// clamping to what the type can hold
+func floatToComponent(f gl.Float, max float64, signed, normalized bool) int64 {
+	v := float64(f)
+	if normalized {
+		v *= max
+	}
+	min := 0.0
+	if signed {
+		min = -max
+	}
+	v = math.Max(min, math.Min(max, math.Floor(v+0.5)))
+	return int64(v)
+}
+
+// Converts an integer component back to a float
+func componentToFloat(c int64, max float64, normalized bool) gl.Float {
+	if !normalized {
+		return gl.Float(c)
+	}
+	return gl.Float(math.Max(float64(c)/max, -1))
+}
+
+func packVertexElement(dst []byte, e *VertexElement, values []gl.Float) {
+	if isPackedType(e.Type) {
+		binary.LittleEndian.PutUint32(dst, Pack2101010(values, e.Type == gl.INT_2_10_10_10_REV, e.Normalized))
+		return
+	}
+	size := ComponentSize(e.Type)
+	for c, f := range values {
+		out := dst[c*size:]
+		switch e.Type {
+		case gl.FLOAT:
+			binary.LittleEndian.PutUint32(out, math.Float32bits(float32(f)))
+		case gl.HALF_FLOAT:
+			binary.LittleEndian.PutUint16(out, FloatToHalf(f))
+		default:
+			max, signed := componentRange(e.Type)
+			v := uint64(floatToComponent(f, max, signed, e.Normalized))
+			switch size {
+			case 1:
+				out[0] = byte(v)
+			case 2:
+				binary.LittleEndian.PutUint16(out, uint16(v))
+			case 4:
+				binary.LittleEndian.PutUint32(out, uint32(v))
+			}
+		}
+	}
+}
+
+func unpackVertexElement(dst []gl.Float, e *VertexElement, src []byte) {
+	if isPackedType(e.Type) {
+		unpacked := Unpack2101010(binary.LittleEndian.Uint32(src), e.Type == gl.INT_2_10_10_10_REV, e.Normalized)
+		copy(dst, unpacked[:])
+		return
+	}
+	size := ComponentSize(e.Type)
+	for c := range dst {
+		in := src[c*size:]
+		switch e.Type {
+		case gl.FLOAT:
+			dst[c] = gl.Float(math.Float32frombits(binary.LittleEndian.Uint32(in)))
+		case gl.HALF_FLOAT:
+			dst[c] = HalfToFloat(binary.LittleEndian.Uint16(in))
+		case gl.BYTE:
+			dst[c] = componentToFloat(int64(int8(in[0])), math.MaxInt8, e.Normalized)
+		case gl.UNSIGNED_BYTE:
+			dst[c] = componentToFloat(int64(in[0]), math.MaxUint8, e.Normalized)
+		case gl.SHORT:
+			dst[c] = componentToFloat(int64(int16(binary.LittleEndian.Uint16(in))), math.MaxInt16, e.Normalized)
+		case gl.UNSIGNED_SHORT:
+			dst[c] = componentToFloat(int64(binary.LittleEndian.Uint16(in)), math.MaxUint16, e.Normalized)
+		case gl.INT:
+			dst[c] = componentToFloat(int64(int32(binary.LittleEndian.Uint32(in))), math.MaxInt32, e.Normalized)
+		case gl.UNSIGNED_INT:
+			dst[c] = componentToFloat(int64(binary.LittleEndian.Uint32(in)), math.MaxUint32, e.Normalized)
+		}
+	}
+}
+
+// FloatToHalf - converts a float to IEEE 754 half precision, rounding to
+// nearest even.  Values too big for a half become infinity.
+func FloatToHalf(f gl.Float) uint16 {
+	bits := math.Float32bits(float32(f))
+	sign := uint16(bits>>16) & 0x8000
+	exp := int((bits >> 23) & 0xff)
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		// Infinity or NaN - keep NaNs NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp-127 > 15:
+		return sign | 0x7c00
+	case exp-127 < -25:
+		return sign
+	case exp-127 < -14:
+		// Denormal half: shift the mantissa (with its implicit 1) down
+		mant |= 0x800000
+		shift := uint(-14 - (exp - 127) + 13)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(exp-127+15)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		// Rounding may carry into the exponent, which is what we want -
+		// even up to infinity
+		half++
+	}
+	return sign | uint16(half)
+}
+
+// HalfToFloat - converts an IEEE 754 half to a float
+func HalfToFloat(h uint16) gl.Float {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return gl.Float(math.Float32frombits(sign))
+		}
+		// Denormal
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return gl.Float(f)
+	case 0x1f:
+		return gl.Float(math.Float32frombits(sign | 0x7f800000 | mant<<13))
+	}
+	return gl.Float(math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13))
+}
+
+// Pack2101010 - packs up to four values into a 2_10_10_10_REV word: x, y
+// and z get 10 bits each, from the low bits up, and w gets the top 2.
+// Missing values are taken as (0, 0, 0, 1).
+func Pack2101010(values []gl.Float, signed, normalized bool) uint32 {
+	v := [4]gl.Float{0, 0, 0, 1}
+	copy(v[:], values)
+	var word uint32
+	for c := 0; c < 4; c++ {
+		bits := uint(10)
+		if c == 3 {
+			bits = 2
+		}
+		max := float64(uint32(1)<<bits - 1)
+		if signed {
+			max = float64(uint32(1)<<(bits-1) - 1)
+		}
+		i := floatToComponent(v[c], max, signed, normalized)
+		word |= (uint32(i) & (1<<bits - 1)) << (uint(c) * 10)
+	}
+	return word
+}
+
+// Unpack2101010 - the reverse of Pack2101010
+func Unpack2101010(word uint32, signed, normalized bool) [4]gl.Float {
+	var v [4]gl.Float
+	for c := 0; c < 4; c++ {
+		bits := uint(10)
+		if c == 3 {
+			bits = 2
+		}
+		raw := int64(word>>(uint(c)*10)) & (1<<bits - 1)
+		max := float64(int64(1)<<bits - 1)
+		if signed {
+			max = float64(int64(1)<<(bits-1) - 1)
+			if raw >= 1<<(bits-1) {
+				raw -= 1 << bits
+			}
+		}
+		v[c] = componentToFloat(raw, max, normalized)
+	}
+	return v
+}
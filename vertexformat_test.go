@@ -0,0 +1,133 @@
+package goglutils
+
+import (
+	"math"
+	"testing"
+
+	gl "github.com/chsc/gogl/gl33"
+)
+
+func TestVertexFormatLayout(t *testing.T) {
+	f, err := NewVertexFormat(
+		VertexElement{Semantic: AttribPosition, Type: gl.FLOAT, Count: 3},
+		VertexElement{Semantic: AttribNormal, Type: gl.INT_2_10_10_10_REV, Count: 4, Normalized: true},
+		VertexElement{Semantic: AttribTexCoord, Type: gl.HALF_FLOAT, Count: 2},
+		VertexElement{Semantic: AttribColor, Type: gl.UNSIGNED_BYTE, Count: 3, Normalized: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int{0, 12, 16, 20}
+	for i, e := range f.Elements {
+		if e.Offset != offsets[i] || e.Location != gl.Uint(i) {
+			t.Errorf("%s at offset %d location %d, want %d %d", e.Semantic, e.Offset, e.Location, offsets[i], i)
+		}
+	}
+	if f.Stride != 24 {
+		t.Errorf("Stride is %d, want 24", f.Stride)
+	}
+	f, _ = NewVertexFormat(
+		VertexElement{Semantic: AttribPosition, Type: gl.FLOAT, Count: 3, Location: 0, LocationSet: true},
+		VertexElement{Semantic: AttribNormal, Type: gl.FLOAT, Count: 3, Location: 5, LocationSet: true},
+		VertexElement{Semantic: AttribTexCoord, Type: gl.FLOAT, Count: 2, Location: 7},
+		VertexElement{Semantic: "3", Type: gl.FLOAT, Count: 4},
+	)
+	for i, want := range []gl.Uint{0, 5, 2, 3} {
+		if e := f.Elements[i]; e.Location != want {
+			t.Errorf("%s has location %d, want %d", e.Semantic, e.Location, want)
+		}
+	}
+	if _, err := NewVertexFormat(VertexElement{Semantic: AttribNormal, Type: gl.INT_2_10_10_10_REV, Count: 3}); err == nil {
+		t.Errorf("Packed types need 4 components")
+	}
+	if _, err := NewVertexFormat(VertexElement{Semantic: AttribNormal, Type: gl.TRIANGLES, Count: 3}); err == nil {
+		t.Errorf("Unknown component types should be rejected")
+	}
+}
+
+func TestHalfConversion(t *testing.T) {
+	cases := map[gl.Float]uint16{
+		0: 0x0000, 1: 0x3c00, -2: 0xc000, 0.5: 0x3800, 65504: 0x7bff,
+		1e6: 0x7c00, 5.960464477539063e-08: 0x0001, 6.103515625e-05: 0x0400,
+	}
+	for f, h := range cases {
+		if got := FloatToHalf(f); got != h {
+			t.Errorf("FloatToHalf(%v) yields 0x%04x, want 0x%04x", f, got, h)
+		}
+		if h != 0x7c00 {
+			if got := HalfToFloat(h); got != f {
+				t.Errorf("HalfToFloat(0x%04x) yields %v, want %v", h, got, f)
+			}
+		}
+	}
+	if got := HalfToFloat(FloatToHalf(0.1)); math.Abs(float64(got)-0.1) > 1e-4 {
+		t.Errorf("0.1 round trips to %v", got)
+	}
+	if !math.IsNaN(float64(HalfToFloat(FloatToHalf(gl.Float(math.NaN()))))) {
+		t.Errorf("NaN should stay NaN")
+	}
+}
+
+func TestPack2101010(t *testing.T) {
+	word := Pack2101010([]gl.Float{1, 0, -1}, true, true)
+	// x = 511, y = 0, z = -511 (0x201 in 10 bits), w = 1
+	want := uint32(511) | uint32(0x201)<<20 | uint32(1)<<30
+	if word != want {
+		t.Errorf("Pack2101010 yields 0x%08x, want 0x%08x", word, want)
+	}
+	v := Unpack2101010(word, true, true)
+	if v != [4]gl.Float{1, 0, -1, 1} {
+		t.Errorf("Unpack2101010 yields %v", v)
+	}
+	word = Pack2101010([]gl.Float{1, 0.5, 0, 1}, false, true)
+	if word&0x3ff != 1023 || (word>>10)&0x3ff != 512 || word>>30 != 3 {
+		t.Errorf("Unsigned Pack2101010 yields 0x%08x", word)
+	}
+}
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	m := NewMesh("test")
+	m.AddMeshAttribute(AttribPosition, []gl.Float{0, 1, 2, 3, 4, 5}, 3)
+	m.AddMeshAttribute(AttribNormal, []gl.Float{0, 0, 1, 0, -1, 0}, 3)
+	m.AddMeshAttribute(AttribColor, []gl.Float{1, 0.5, 0, 0, 0, 1}, 3)
+	f, _ := NewVertexFormat(
+		VertexElement{Semantic: AttribPosition, Type: gl.FLOAT, Count: 3},
+		VertexElement{Semantic: AttribNormal, Type: gl.INT_2_10_10_10_REV, Count: 4, Normalized: true},
+		VertexElement{Semantic: AttribColor, Type: gl.UNSIGNED_BYTE, Count: 4, Normalized: true},
+	)
+	data, err := f.Interleave(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2*f.Stride {
+		t.Fatalf("Interleaved %d bytes, want %d", len(data), 2*f.Stride)
+	}
+	// Colour of vertex 0: (255, 128, 0, 255)
+	if c := data[f.Elements[2].Offset:]; c[0] != 255 || c[1] != 128 || c[2] != 0 || c[3] != 255 {
+		t.Errorf("Packed colour is % x", c[:4])
+	}
+
+	out := NewMesh("out")
+	if err := f.Deinterleave(data, out); err != nil {
+		t.Fatal(err)
+	}
+	pos := out.AttributeByDesc(AttribPosition)
+	if pos == nil || pos.Stride() != 3 || pos.Data()[4] != 4 {
+		t.Errorf("Positions didn't round trip")
+	}
+	nrm := out.AttributeByDesc(AttribNormal).Data()
+	want := []gl.Float{0, 0, 1, 1, 0, -1, 0, 1}
+	for i := range want {
+		if nrm[i] != want[i] {
+			t.Errorf("Normals round trip to %v, want %v", nrm, want)
+			break
+		}
+	}
+
+	m.AddMeshAttribute(AttribTexCoord, []gl.Float{0, 0}, 2)
+	f2, _ := NewVertexFormat(VertexElement{Semantic: AttribTexCoord, Type: gl.FLOAT, Count: 2},
+		VertexElement{Semantic: AttribPosition, Type: gl.FLOAT, Count: 3})
+	if _, err := f2.Interleave(m); err == nil {
+		t.Errorf("Interleaving attributes with different vertex counts should fail")
+	}
+}